@@ -0,0 +1,62 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+// Package builtin declares the predeclared identifiers of Go, so that references to them resolve
+// to a definition.  The declarations follow the documentation of the builtin package of the Go
+// standard library.  The types of the declarations only serve as documentation, and use `any`
+// for arguments of arbitrary type.
+package builtin
+
+type bool bool
+type uint8 uint8
+type uint16 uint16
+type uint32 uint32
+type uint64 uint64
+type int8 int8
+type int16 int16
+type int32 int32
+type int64 int64
+type float32 float32
+type float64 float64
+type complex64 complex64
+type complex128 complex128
+type string string
+type int int
+type uint uint
+type uintptr uintptr
+type byte = uint8
+type rune = int32
+type any = interface{}
+
+type error interface {
+	Error() string
+}
+
+const (
+	true  = 0 == 0
+	false = 0 != 0
+)
+
+const iota = 0
+
+var nil any
+
+func append(slice []any, elems ...any) []any
+func copy(dst, src []any) int
+func delete(m map[any]any, key any)
+func len(v any) int
+func cap(v any) int
+func make(t any, size ...int) any
+func new(t any) *any
+func complex(r, i float64) complex128
+func real(c complex128) float64
+func imag(c complex128) float64
+func close(c chan<- any)
+func panic(v any)
+func recover() any
+func print(args ...any)
+func println(args ...any)
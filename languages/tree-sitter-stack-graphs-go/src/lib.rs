@@ -0,0 +1,126 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for Go.
+//!
+//! The rules cover packages, imports, functions, methods, types, struct fields, constants, and
+//! variables.  Packages are found by their import path, so that a reference to an imported
+//! package, such as `shapes.Area` after `import "example.com/shapes"`, resolves to the definition
+//! in any file of that package.
+//!
+//! The import path of the package of a file is provided to the rules in the `GO_IMPORT_PATH`
+//! global variable, see [`IMPORT_PATH_VAR`][].  The [`import_path`][] function computes it from
+//! the `module` directive of the nearest `go.mod` file, and the directory of the file relative to
+//! the module root.  Files in the module cache, such as
+//! `$GOPATH/pkg/mod/example.com/foo@v1.2.3/bar/bar.go`, get the import path that is encoded in
+//! their path, here `example.com/foo/bar`.  Files outside of a module use their directory as
+//! import path.  The rules do not read `go.mod` files themselves, so that the stack graph of a
+//! file only depends on its inputs, and callers that store stack graphs must build a file again
+//! when its import path changes.
+//!
+//! Because files in the module cache get the import path of their package, the modules that a
+//! project requires can be indexed into databases of their own, which are then added to a reader
+//! of the project's database as dependencies.  The [`requirements`][] function reads the
+//! `require` directives of a `go.mod` file, and [`required_module_dirs`][] locates the required
+//! modules in the module cache, which is found by [`module_cache`][].
+//!
+//! Modules that are not worth indexing completely can be indexed as stubs instead.  The
+//! [`stub_source`][] function removes the bodies of all functions and methods from a file, which
+//! keeps the declarations that other packages can refer to, but skips the local variables and
+//! references in the bodies, which are most of a typical stack graph.
+//!
+//! The predeclared identifiers of Go, such as `len` and `error`, are declared in
+//! [`BUILTINS_SOURCE`][], whose stack graph is available from the `builtins` of the language.
+//!
+//! The rules can be used with the tree-sitter Go grammar as follows:
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use std::path::Path;
+//! # use tree_sitter_graph::Variables;
+//! # use tree_sitter_stack_graphs_go::import_path;
+//! # use tree_sitter_stack_graphs_go::IMPORT_PATH_VAR;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_go::language()?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("shapes/shapes.go");
+//! let mut globals = Variables::new();
+//! globals.add(IMPORT_PATH_VAR.into(), import_path(Path::new("shapes/shapes.go")).into())?;
+//! let source = "package shapes\nfunc Area(w, h int) int { return w * h }\n";
+//! language.build_stack_graph_into(&mut graph, file, source, &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use stack_graphs::graph::StackGraph;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+pub use tree_sitter_stack_graphs::go::import_path;
+pub use tree_sitter_stack_graphs::go::module_cache;
+pub use tree_sitter_stack_graphs::go::required_module_dirs;
+pub use tree_sitter_stack_graphs::go::requirements;
+pub use tree_sitter_stack_graphs::go::Requirement;
+pub use tree_sitter_stack_graphs::go::BUILTINS_IMPORT_PATH;
+pub use tree_sitter_stack_graphs::go::IMPORT_PATH_VAR;
+
+/// The stack graph construction rules for Go, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The declarations of the predeclared identifiers of Go, such as `len` and `error`, in Go syntax.
+pub const BUILTINS_SOURCE: &str = include_str!("builtins.go");
+
+/// The path of the file that the declarations of the predeclared identifiers belong to.  The file
+/// is built with the import path [`BUILTINS_IMPORT_PATH`][], in which the rules look up
+/// predeclared identifiers.
+///
+/// [`BUILTINS_IMPORT_PATH`]: constant.BUILTINS_IMPORT_PATH.html
+pub const BUILTINS_PATH: &str = "builtin/builtin.go";
+
+/// The file extensions of Go source files.
+pub const FILE_TYPES: &[&str] = &["go"];
+
+/// Returns the tree-sitter grammar for Go.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_go::language()
+}
+
+/// Returns a stack graph language that uses the bundled rules for Go.  The builtins of the
+/// language contain the stack graph of the predeclared identifiers, which must be added to every
+/// stack graph that contains Go files.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    let mut language = StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)?;
+
+    let mut builtins = StackGraph::new();
+    let file = builtins.get_or_create_file(BUILTINS_PATH);
+    let mut globals = Variables::new();
+    globals
+        .add(IMPORT_PATH_VAR.into(), BUILTINS_IMPORT_PATH.into())
+        .unwrap();
+    language
+        .build_stack_graph_into(&mut builtins, file, BUILTINS_SOURCE, &mut globals)
+        .expect("Cannot build stack graph for bundled builtins");
+    language.builtins_mut().add_from_graph(&builtins).unwrap();
+    Ok(language)
+}
+
+/// Returns the source of a Go file without the bodies of its functions and methods, which can be
+/// used to build a smaller stack graph that only contains the top-level declarations of the file.
+/// The bodies are replaced by whitespace, so that the declarations keep their positions in the
+/// file.  This parses the source with [`grammar`][], and is otherwise the same as
+/// `tree_sitter_stack_graphs::go::stub_source`.
+///
+/// [`grammar`]: fn.grammar.html
+pub fn stub_source(source: &str) -> String {
+    let mut parser = tree_sitter::Parser::new();
+    parser
+        .set_language(grammar())
+        .expect("Cannot use bundled grammar");
+    let tree = parser.parse(source, None).expect("Cannot parse source");
+    tree_sitter_stack_graphs::go::stub_source(&tree, source)
+}
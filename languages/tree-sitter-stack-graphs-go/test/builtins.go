@@ -0,0 +1,11 @@
+package main
+
+func len(s string) int {
+    return 0
+}
+
+func main() {
+    var err error
+    println(len("x"), err)
+    //      ^ defined: 3
+}
@@ -0,0 +1,36 @@
+--- path: example.com/shapes/shapes.go ---
+package shapes
+
+type Rect struct {
+    Width, Height int
+}
+
+func Area(r Rect) int {
+    return r.Width * r.Height
+}
+
+--- path: example.com/shapes/unit.go ---
+package shapes
+
+func Unit() Rect {
+//          ^ defined: 4
+    return Rect{Width: 1, Height: 1}
+    //     ^ defined: 4
+}
+
+--- path: example.com/app/main.go ---
+package main
+
+import (
+    "example.com/shapes"
+    s "example.com/shapes"
+)
+
+func main() {
+    var r shapes.Rect
+    //           ^ defined: 4
+    shapes.Area(r)
+    //     ^ defined: 8
+    s.Area(s.Unit())
+    //       ^ defined: 15
+}
@@ -0,0 +1,21 @@
+package main
+
+func sum(values []int) int {
+    total := 0
+    for _, v := range values {
+        total += v
+        //       ^ defined: 5
+    }
+    return total
+    //     ^ defined: 4
+}
+
+func main() {
+    x := sum(nil)
+    //   ^ defined: 3
+    if y := x; y > 0 {
+        //  ^ defined: 14
+        println(y)
+        //      ^ defined: 16
+    }
+}
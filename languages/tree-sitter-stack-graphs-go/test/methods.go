@@ -0,0 +1,16 @@
+package main
+
+type Counter struct {
+    count int
+}
+
+func (c *Counter) Get() int {
+    return c.count
+    //     ^ defined: 7
+}
+
+func NewCounter() *Counter {
+//                 ^ defined: 3
+    return &Counter{}
+    //      ^ defined: 3
+}
@@ -0,0 +1,195 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use std::path::Path;
+use std::path::PathBuf;
+
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::PathStitcher;
+use stack_graphs::storage::SQLiteReader;
+use stack_graphs::storage::SQLiteWriter;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs_go::import_path;
+use tree_sitter_stack_graphs_go::required_module_dirs;
+use tree_sitter_stack_graphs_go::stub_source;
+use tree_sitter_stack_graphs_go::IMPORT_PATH_VAR;
+
+/// A directory in the temporary directory that is removed when dropped.
+struct TempDir(PathBuf);
+
+impl TempDir {
+    fn new(name: &str) -> TempDir {
+        let path =
+            std::env::temp_dir().join(format!("stack-graphs-go-{}-{}", name, std::process::id()));
+        let _ = std::fs::remove_dir_all(&path);
+        std::fs::create_dir_all(&path).unwrap();
+        TempDir(path)
+    }
+
+    fn write(&self, relative_path: &str, content: &str) -> PathBuf {
+        let path = self.0.join(relative_path);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(&path, content).unwrap();
+        path
+    }
+}
+
+impl Drop for TempDir {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_dir_all(&self.0);
+    }
+}
+
+/// Builds the stack graphs of the given Go files, or of their stubs, and stores them with their
+/// partial paths.
+fn index_files(db_path: &Path, files: &[PathBuf], stubs: bool) {
+    let mut language = tree_sitter_stack_graphs_go::language().unwrap();
+    let mut db = SQLiteWriter::open(db_path).expect("Cannot open database");
+    for path in files {
+        let mut source = std::fs::read_to_string(path).unwrap();
+        if stubs {
+            source = stub_source(&source);
+        }
+        let mut graph = StackGraph::new();
+        let file = graph.get_or_create_file(&path.to_string_lossy());
+        let mut globals = Variables::new();
+        globals
+            .add(IMPORT_PATH_VAR.into(), import_path(path).into())
+            .unwrap();
+        language
+            .build_stack_graph_into(&mut graph, file, &source, &mut globals)
+            .expect("Cannot build stack graph");
+        let mut partials = PartialPaths::new();
+        let mut paths = Vec::new();
+        partials.find_all_partial_paths_in_file(&graph, file, |graph, partials, path| {
+            if !path.is_complete_as_possible(graph) {
+                return;
+            }
+            if !path.is_productive(partials) {
+                return;
+            }
+            paths.push(path);
+        });
+        db.store_result_for_file(&graph, file, "tag", "info", &mut partials, &paths)
+            .expect("Cannot store file");
+    }
+    db.flush().expect("Cannot flush database");
+}
+
+/// Writes an application module that requires the `example.com/shapes` module, and the required
+/// module to the module cache.  Returns the paths of the file of the required module, and of the
+/// main file of the application.
+fn write_project(root: &TempDir) -> (PathBuf, PathBuf) {
+    let shapes = root.write(
+        "pkg/mod/example.com/shapes@v1.0.0/shapes.go",
+        "package shapes\n\nfunc Area(w, h int) int {\n\tarea := w * h\n\treturn area\n}\n",
+    );
+    root.write(
+        "app/go.mod",
+        "module example.com/app\n\nrequire example.com/shapes v1.0.0\n",
+    );
+    let main = root.write(
+        "app/main.go",
+        "package main\n\nimport \"example.com/shapes\"\n\nfunc main() {\n\tshapes.Area(1, 2)\n}\n",
+    );
+    (shapes, main)
+}
+
+/// Indexes the required module, as stubs if requested, and the application into databases of
+/// their own, and records the database of the required module as a dependency.  Returns the
+/// database of the application.
+fn index_project(root: &TempDir, module_dirs: &[PathBuf], main: &Path, stubs: bool) -> PathBuf {
+    let dependency_db = root.0.join("shapes.sqlite");
+    let module_files = module_dirs
+        .iter()
+        .flat_map(|dir| std::fs::read_dir(dir).unwrap())
+        .map(|entry| entry.unwrap().path())
+        .filter(|path| path.extension().map_or(false, |ext| ext == "go"))
+        .collect::<Vec<_>>();
+    index_files(&dependency_db, &module_files, stubs);
+    let db = root.0.join("app.sqlite");
+    index_files(&db, &[main.to_path_buf()], false);
+    let mut writer = SQLiteWriter::open(&db).expect("Cannot open database");
+    writer
+        .record_dependency(&dependency_db.to_string_lossy())
+        .expect("Cannot record dependency");
+    db
+}
+
+/// Returns the names of the files that the references to `Area` in the given file resolve to,
+/// using the dependencies that are recorded in the database.
+fn resolve_area(db: &Path, file: &Path) -> Vec<String> {
+    let mut reader = SQLiteReader::open(db).expect("Cannot open database");
+    for dependency in reader
+        .recorded_dependencies()
+        .expect("Cannot list dependencies")
+    {
+        reader
+            .add_dependency(dependency)
+            .expect("Cannot add dependency");
+    }
+    reader
+        .load_paths_for_file_and_dependencies(&file.to_string_lossy())
+        .expect("Cannot load paths");
+    let (graph, partials, db) = reader.get();
+    let references = graph
+        .iter_nodes()
+        .filter(|node| graph[*node].is_reference())
+        .filter(|node| {
+            graph[*node]
+                .symbol()
+                .map_or(false, |symbol| graph[symbol] == "Area")
+        })
+        .collect::<Vec<_>>();
+    assert!(!references.is_empty());
+    let mut paths = Paths::new();
+    PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references)
+        .into_iter()
+        .filter_map(|path| graph[path.end_node].file())
+        .map(|file| graph[file].name().to_string())
+        .collect()
+}
+
+#[test]
+fn can_resolve_references_into_required_modules() {
+    let root = TempDir::new("dependencies");
+    let (shapes, main) = write_project(&root);
+    let module_dirs =
+        required_module_dirs(&root.0.join("app"), &root.0.join("pkg").join("mod")).unwrap();
+    assert_eq!(vec![shapes.parent().unwrap().to_path_buf()], module_dirs);
+    let db = index_project(&root, &module_dirs, &main, false);
+    assert_eq!(
+        vec![shapes.to_string_lossy().to_string()],
+        resolve_area(&db, &main)
+    );
+}
+
+#[test]
+fn can_resolve_references_into_stubbed_modules() {
+    let root = TempDir::new("stubbed-dependencies");
+    let (shapes, main) = write_project(&root);
+    let module_dirs =
+        required_module_dirs(&root.0.join("app"), &root.0.join("pkg").join("mod")).unwrap();
+    let db = index_project(&root, &module_dirs, &main, true);
+    assert_eq!(
+        vec![shapes.to_string_lossy().to_string()],
+        resolve_area(&db, &main)
+    );
+
+    let mut reader =
+        SQLiteReader::open(root.0.join("shapes.sqlite")).expect("Cannot open database");
+    reader
+        .load_graph_for_file(&shapes.to_string_lossy())
+        .expect("Cannot load graph");
+    let (graph, _, _) = reader.get();
+    assert!(!graph
+        .iter_nodes()
+        .filter_map(|node| graph[node].symbol())
+        .any(|symbol| graph[symbol] == "area"));
+}
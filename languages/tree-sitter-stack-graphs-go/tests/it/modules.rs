@@ -0,0 +1,63 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use tree_sitter_stack_graphs_go::required_module_dirs;
+use tree_sitter_stack_graphs_go::requirements;
+use tree_sitter_stack_graphs_go::Requirement;
+
+fn requirement(path: &str, version: &str) -> Requirement {
+    Requirement {
+        path: path.to_string(),
+        version: version.to_string(),
+    }
+}
+
+#[test]
+fn can_read_requirements() {
+    let go_mod = r#"
+module example.com/app
+
+go 1.19
+
+require example.com/shapes v1.2.3
+
+require (
+    github.com/BurntSushi/toml v1.2.0
+    golang.org/x/text v0.3.7 // indirect
+)
+"#;
+    assert_eq!(
+        vec![
+            requirement("example.com/shapes", "v1.2.3"),
+            requirement("github.com/BurntSushi/toml", "v1.2.0"),
+            requirement("golang.org/x/text", "v0.3.7"),
+        ],
+        requirements(go_mod)
+    );
+}
+
+#[test]
+fn can_find_required_modules_in_module_cache() {
+    let root = std::env::temp_dir().join(format!("stack-graphs-go-{}", std::process::id()));
+    let _ = std::fs::remove_dir_all(&root);
+    let module_root = root.join("app");
+    let module_cache = root.join("pkg").join("mod");
+    std::fs::create_dir_all(&module_root).unwrap();
+    std::fs::create_dir_all(module_cache.join("github.com/!burnt!sushi/toml@v1.2.0")).unwrap();
+    std::fs::write(
+        module_root.join("go.mod"),
+        "module example.com/app\n\nrequire (\n\tgithub.com/BurntSushi/toml v1.2.0\n\texample.com/missing v0.1.0\n)\n",
+    )
+    .unwrap();
+
+    let dirs = required_module_dirs(&module_root, &module_cache);
+    std::fs::remove_dir_all(&root).unwrap();
+    assert_eq!(
+        vec![module_cache.join("github.com/!burnt!sushi/toml@v1.2.0")],
+        dirs.unwrap()
+    );
+}
@@ -0,0 +1,40 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use stack_graphs::graph::StackGraph;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs_go::stub_source;
+use tree_sitter_stack_graphs_go::IMPORT_PATH_VAR;
+
+#[test]
+fn stubs_keep_declarations_at_their_position() {
+    let source = "package shapes\n\ntype Rect struct{ W, H int }\n\nfunc Area(r Rect) int {\n\tw := r.W\n\treturn w * r.H\n}\n\nfunc (r Rect) Name() string { return \"rect\" }\n";
+    let expected = "package shapes\n\ntype Rect struct{ W, H int }\n\nfunc Area(r Rect) int {\n         \n               \n}\n\nfunc (r Rect) Name() string {               }\n";
+    assert_eq!(expected, stub_source(source));
+}
+
+#[test]
+fn can_build_stack_graphs_of_stubs() {
+    let source = "package shapes\n\nfunc Area(w, h int) int {\n\tarea := w * h\n\treturn area\n}\n";
+    let mut language = tree_sitter_stack_graphs_go::language().unwrap();
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("shapes/shapes.go");
+    let mut globals = Variables::new();
+    globals
+        .add(IMPORT_PATH_VAR.into(), "example.com/shapes".into())
+        .unwrap();
+    language
+        .build_stack_graph_into(&mut graph, file, &stub_source(source), &mut globals)
+        .expect("Cannot build stack graph of stub");
+    let symbols = graph
+        .nodes_for_file(file)
+        .filter_map(|n| graph[n].symbol())
+        .map(|s| graph[s].to_string())
+        .collect::<Vec<_>>();
+    assert!(symbols.contains(&"Area".to_string()));
+    assert!(!symbols.contains(&"area".to_string()));
+}
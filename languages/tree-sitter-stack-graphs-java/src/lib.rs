@@ -0,0 +1,48 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for Java.
+//!
+//! The rules cover packages, single-type and on-demand imports, classes, interfaces, enums,
+//! fields, methods, and local variables.  Types are found by their package-qualified name, so a
+//! file can use the types of its own package, and the types that it imports from other packages,
+//! regardless of the file that they are declared in.
+//!
+//! The rules can be used with the tree-sitter Java grammar as follows:
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use tree_sitter_graph::Variables;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_java::language()?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("p/A.java");
+//! let mut globals = Variables::new();
+//! let source = "package p;\nclass A { int x; int get() { return x; } }\n";
+//! language.build_stack_graph_into(&mut graph, file, source, &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// The stack graph construction rules for Java, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The file extensions of Java source files.
+pub const FILE_TYPES: &[&str] = &["java"];
+
+/// Returns the tree-sitter grammar for Java.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_java::language()
+}
+
+/// Returns a stack graph language that uses the bundled rules for Java.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)
+}
@@ -0,0 +1,57 @@
+// --- path: com/example/shapes/Shape.java ---
+package com.example.shapes;
+
+public interface Shape {
+  double area();
+  String name();
+}
+
+// --- path: com/example/shapes/Square.java ---
+package com.example.shapes;
+
+public class Square implements Shape {
+  //                           ^ defined: 4
+  private double side;
+
+  public Square(double side) {
+    this.side = side;
+    //          ^ defined: 16
+  }
+
+  public double area() {
+    return side * side;
+    //     ^ defined: 14
+  }
+
+  public double twice() {
+    log(name());
+    //  ^ defined: 6
+    return area() + area();
+    //     ^ defined: 21
+  }
+
+  public static Square unit() {
+    //          ^ defined: 12
+    return new Square(1);
+    //         ^ defined: 12
+  }
+}
+
+// --- path: com/example/app/Main.java ---
+package com.example.app;
+
+import com.example.shapes.Square;
+import com.example.shapes.*;
+
+class Main {
+  void run(Shape shape) {
+    //     ^ defined: 4
+    Square square = Square.unit();
+    //^ defined: 12
+    //                     ^ defined: 33
+    double a = 2;
+    log(shape, a);
+    //  ^ defined: 47
+    //         ^ defined: 52
+  }
+}
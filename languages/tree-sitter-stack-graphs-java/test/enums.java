@@ -0,0 +1,19 @@
+// --- path: p/Color.java ---
+package p;
+
+public enum Color {
+  RED,
+  GREEN;
+}
+
+// --- path: p/Use.java ---
+package p;
+
+class Use {
+  Color pick() {
+//^ defined: 4
+    return Color.GREEN;
+    //     ^ defined: 4
+    //           ^ defined: 6
+  }
+}
@@ -0,0 +1,31 @@
+class Locals {
+  int total;
+
+  int sum(int[] values) {
+    int total = 0;
+    for (int i = 0; i < values.length; i++) {
+      //            ^ defined: 6
+      //                ^ defined: 4
+      total = total + values[i];
+      //      ^ defined: 5
+      //                     ^ defined: 6
+    }
+    for (int value : values) {
+      //             ^ defined: 4
+      total = total + value;
+      //              ^ defined: 13
+    }
+    try {
+      return total;
+      //     ^ defined: 5
+    } catch (Exception e) {
+      throw e;
+      //    ^ defined: 21
+    }
+  }
+
+  int field() {
+    return total;
+    //     ^ defined: 2
+  }
+}
@@ -0,0 +1,50 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for JavaScript.
+//!
+//! The rules cover function, class, and variable declarations, lexical scoping of blocks and
+//! functions, ES module `import` and `export` statements, and CommonJS `require` calls.  Modules
+//! are named after their path without the file extension, and only relative imports are resolved.
+//! The grammar includes JSX, so the same rules are used for `.jsx` files.
+//!
+//! The rules can be used with the tree-sitter JavaScript grammar as follows:
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use tree_sitter_graph::Variables;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_javascript::language()?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("test.js");
+//! let mut globals = Variables::new();
+//! language.build_stack_graph_into(&mut graph, file, "let x = 1;\nlog(x);\n", &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// The stack graph construction rules for JavaScript, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The file extensions of JavaScript source files, including ES and CommonJS modules and JSX.
+pub const FILE_TYPES: &[&str] = &["js", "mjs", "cjs", "jsx"];
+
+/// The interpreters that identify JavaScript scripts in a shebang line.
+pub const INTERPRETERS: &[&str] = &["node"];
+
+/// Returns the tree-sitter grammar for JavaScript.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_javascript::language()
+}
+
+/// Returns a stack graph language that uses the bundled rules for JavaScript.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)
+}
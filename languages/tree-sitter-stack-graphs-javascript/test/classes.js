@@ -0,0 +1,24 @@
+class Base {
+  greet() {
+    return 1;
+  }
+}
+
+class Derived extends Base {
+  //                  ^ defined: 1
+  wave(times) {
+    return times;
+    //     ^ defined: 9
+  }
+}
+
+const d = new Derived();
+//            ^ defined: 7
+
+log(Derived.wave);
+//  ^ defined: 7
+//          ^ defined: 9
+log(Derived.greet);
+//          ^ defined: 2
+log(d);
+//  ^ defined: 15
@@ -0,0 +1,22 @@
+function outer(a, b = 1, ...rest) {
+  function inner(c) {
+    return a + c;
+    //     ^ defined: 1
+    //         ^ defined: 2
+  }
+  return inner(b, rest);
+  //     ^ defined: 2
+  //           ^ defined: 1
+  //              ^ defined: 1
+}
+
+const arrow = (x) => x;
+//                   ^ defined: 13
+
+const single = y => y;
+//                  ^ defined: 16
+
+outer(arrow, single);
+//^ defined: 1
+//    ^ defined: 13
+//           ^ defined: 16
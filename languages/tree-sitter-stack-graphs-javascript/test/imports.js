@@ -0,0 +1,39 @@
+// --- path: lib.js ---
+export function helper() {
+  return 1;
+}
+
+export const value = 2;
+
+class Thing {
+  run() {
+  }
+}
+
+export { Thing, Thing as Other };
+//       ^ defined: 8
+//              ^ defined: 8
+
+export default helper;
+//             ^ defined: 2
+
+// --- path: main.js ---
+import { helper, value as renamed } from "./lib";
+import Default from "./lib.js";
+import * as lib from "./lib";
+
+log(helper());
+//  ^ defined: 2
+log(renamed);
+//  ^ defined: 6
+log(Default);
+//  ^ defined: 2
+lib.Other.run;
+//  ^ defined: 8
+//        ^ defined: 9
+
+// --- path: sub/common.js ---
+const lib = require("../lib");
+
+lib.value;
+//  ^ defined: 6
@@ -0,0 +1,9 @@
+function Button(props) {
+  return <button>{props}</button>;
+  //              ^ defined: 1
+}
+
+function App() {
+  return <Button />;
+  //      ^ defined: 1
+}
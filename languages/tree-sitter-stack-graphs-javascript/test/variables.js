@@ -0,0 +1,37 @@
+let x = 1;
+var y = x;
+//      ^ defined: 1
+
+{
+  let x = 2;
+  x;
+//^ defined: 6
+  y;
+//^ defined: 2
+}
+
+log(x);
+//  ^ defined: 1
+
+for (let i = 0; i < y; i++) {
+  //            ^ defined: 16
+  //                ^ defined: 2
+  i;
+//^ defined: 16
+}
+
+for (const item of [x, y]) {
+  item;
+//^ defined: 23
+}
+
+try {
+  x;
+} catch (err) {
+  err;
+//^ defined: 30
+}
+
+const obj = { x, y };
+//            ^ defined: 1
+//               ^ defined: 2
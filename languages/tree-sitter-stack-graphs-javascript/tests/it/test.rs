@@ -0,0 +1,17 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use std::path::Path;
+use tree_sitter_stack_graphs::test::run_test_dir;
+use tree_sitter_stack_graphs_javascript::language;
+use tree_sitter_stack_graphs_javascript::FILE_TYPES;
+
+#[test]
+fn bundled_rules_pass_all_tests() -> anyhow::Result<()> {
+    let test_dir = Path::new(env!("CARGO_MANIFEST_DIR")).join("test");
+    run_test_dir(&test_dir, FILE_TYPES, |_| language())
+}
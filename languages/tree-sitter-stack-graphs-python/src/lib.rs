@@ -0,0 +1,49 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for Python.
+//!
+//! The rules cover module-level and nested function, class, and variable definitions, imports of
+//! modules and of names from modules, and attribute access on modules and classes.  Modules are
+//! named after the stem of their file name, so packages are not supported yet.
+//!
+//! The rules can be used with the tree-sitter Python grammar as follows:
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use tree_sitter_graph::Variables;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_python::language()?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("test.py");
+//! let mut globals = Variables::new();
+//! language.build_stack_graph_into(&mut graph, file, "x = 1\nprint(x)\n", &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// The stack graph construction rules for Python, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The file extensions of Python source files.
+pub const FILE_TYPES: &[&str] = &["py", "pyi"];
+
+/// The interpreters that identify Python scripts in a shebang line.
+pub const INTERPRETERS: &[&str] = &["python", "python2", "python3"];
+
+/// Returns the tree-sitter grammar for Python.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_python::language()
+}
+
+/// Returns a stack graph language that uses the bundled rules for Python.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)
+}
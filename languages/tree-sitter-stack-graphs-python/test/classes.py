@@ -0,0 +1,18 @@
+class Base:
+    size = 1
+
+    def describe(self):
+        return self
+        #      ^ defined: 4
+
+class Derived(Base):
+#             ^ defined: 1
+    extra = 2
+
+Derived.extra
+#       ^ defined: 10
+Derived.size
+#^ defined: 8
+#       ^ defined: 2
+Derived.describe
+#       ^ defined: 4
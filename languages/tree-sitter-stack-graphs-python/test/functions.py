@@ -0,0 +1,15 @@
+def add(x, y=1):
+    return x + y
+    #      ^ defined: 1
+    #          ^ defined: 1
+
+def twice(x):
+    return add(add(x))
+    #      ^ defined: 1
+    #          ^ defined: 1
+    #              ^ defined: 6
+
+result = twice(2)
+#        ^ defined: 6
+print(result)
+#     ^ defined: 12
@@ -0,0 +1,26 @@
+# --- path: lib.py ---
+def helper():
+    pass
+
+class Thing:
+    value = 1
+
+# --- path: main.py ---
+from lib import helper
+from lib import Thing as Alias
+import lib
+
+helper()
+#^ defined: 2
+lib.helper()
+#   ^ defined: 2
+Alias.value
+#^ defined: 5
+#     ^ defined: 6
+
+# --- path: star.py ---
+from lib import *
+
+Thing.value
+#^ defined: 5
+#     ^ defined: 6
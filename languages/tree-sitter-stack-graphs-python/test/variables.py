@@ -0,0 +1,20 @@
+x = 1
+a, b = x, 2
+#      ^ defined: 1
+
+def f(x):
+    if x:
+    #  ^ defined: 5
+        y = x
+        #   ^ defined: 5
+    else:
+        y = a
+        #   ^ defined: 2
+    return y
+    #      ^ defined: 8, 11
+
+for item in [x, b]:
+#            ^ defined: 1
+#               ^ defined: 2
+    print(item)
+    #     ^ defined: 16
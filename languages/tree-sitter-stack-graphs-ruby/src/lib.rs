@@ -0,0 +1,51 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for Ruby.
+//!
+//! The rules cover modules, classes, methods, constants, and local variables.  Top-level
+//! definitions are global, and classes and modules are open: every `class` or `module` statement
+//! adds a separate definition, and qualified lookups such as `A::B` or `A.m` find the members of
+//! all of them, even if they are spread over several files.
+//!
+//! The rules can be used with the tree-sitter Ruby grammar as follows:
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use tree_sitter_graph::Variables;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_ruby::language()?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("test.rb");
+//! let mut globals = Variables::new();
+//! let source = "class A\n  X = 1\nend\nputs(A::X)\n";
+//! language.build_stack_graph_into(&mut graph, file, source, &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// The stack graph construction rules for Ruby, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The file extensions of Ruby source files.
+pub const FILE_TYPES: &[&str] = &["rb", "rake", "gemspec"];
+
+/// The interpreters that identify Ruby scripts in a shebang line.
+pub const INTERPRETERS: &[&str] = &["ruby"];
+
+/// Returns the tree-sitter grammar for Ruby.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_ruby::language()
+}
+
+/// Returns a stack graph language that uses the bundled rules for Ruby.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)
+}
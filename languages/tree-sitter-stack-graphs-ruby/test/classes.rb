@@ -0,0 +1,34 @@
+# --- path: shape.rb ---
+module Geometry
+  ORIGIN = 0
+
+  class Shape
+    def area
+      ORIGIN
+    # ^ defined: 3
+    end
+  end
+end
+
+# --- path: square.rb ---
+class Square < Geometry::Shape
+  #            ^ defined: 2
+  #                      ^ defined: 5
+  def initialize(side)
+    @side = side
+    #       ^ defined: 17
+  end
+
+  def self.unit
+    Square.new(1)
+  # ^ defined: 14
+  end
+end
+
+# --- path: main.rb ---
+square = Square.unit
+#        ^ defined: 14
+#               ^ defined: 22
+puts(square, Geometry::ORIGIN)
+#    ^ defined: 29
+#                      ^ defined: 3
@@ -0,0 +1,17 @@
+# --- path: a.rb ---
+class Greeter
+  def hello
+  end
+end
+
+# --- path: b.rb ---
+class Greeter
+  def goodbye
+  end
+end
+
+# --- path: c.rb ---
+puts(Greeter.hello, Greeter.goodbye)
+#    ^ defined: 2, 8
+#            ^ defined: 3
+#                           ^ defined: 9
@@ -0,0 +1,87 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Stack graph construction rules for TypeScript and TSX.
+//!
+//! The tree-sitter TypeScript grammar comes in two flavors: one for TypeScript, and one for TSX,
+//! which adds JSX elements but does not support the `<T>expr` type assertion syntax.  This crate
+//! provides a language for each of them, and [`language_for_file_type`][] selects the right one
+//! for a file extension.
+//!
+//! The rules cover the same declarations, imports, and exports as the JavaScript rules, and add
+//! interfaces, type aliases, enums, type parameters, and references to types, including qualified
+//! type names such as `ns.Type` for namespace imports.
+//!
+//! [`language_for_file_type`]: fn.language_for_file_type.html
+//!
+//! ```
+//! # use stack_graphs::graph::StackGraph;
+//! # use tree_sitter_graph::Variables;
+//! # fn main() -> Result<(), Box<dyn std::error::Error>> {
+//! let mut language = tree_sitter_stack_graphs_typescript::language_for_file_type("ts")
+//!     .expect("Unknown file type")?;
+//! let mut graph = StackGraph::new();
+//! let file = graph.get_or_create_file("test.ts");
+//! let mut globals = Variables::new();
+//! let source = "type Id = number;\nlet x: Id = 1;\n";
+//! language.build_stack_graph_into(&mut graph, file, source, &mut globals)?;
+//! # Ok(())
+//! # }
+//! ```
+
+use tree_sitter_stack_graphs::LanguageError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// The stack graph construction rules for TypeScript, in tree-sitter-graph syntax.
+pub const STACK_GRAPHS_TSG_SOURCE: &str = include_str!("stack-graphs.tsg");
+
+/// The stack graph construction rules for TSX, in tree-sitter-graph syntax.  These are the
+/// TypeScript rules, followed by the rules for JSX elements.
+pub const STACK_GRAPHS_TSX_TSG_SOURCE: &str = concat!(
+    include_str!("stack-graphs.tsg"),
+    include_str!("stack-graphs-tsx.tsg")
+);
+
+/// The file extensions of TypeScript source files, including ES and CommonJS modules.
+pub const FILE_TYPES: &[&str] = &["ts", "mts", "cts"];
+
+/// The file extensions of TSX source files.
+pub const TSX_FILE_TYPES: &[&str] = &["tsx"];
+
+/// Returns the tree-sitter grammar for TypeScript.
+pub fn grammar() -> tree_sitter::Language {
+    tree_sitter_typescript::language_typescript()
+}
+
+/// Returns the tree-sitter grammar for TSX.
+pub fn tsx_grammar() -> tree_sitter::Language {
+    tree_sitter_typescript::language_tsx()
+}
+
+/// Returns a stack graph language that uses the bundled rules for TypeScript.
+pub fn language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(grammar(), STACK_GRAPHS_TSG_SOURCE)
+}
+
+/// Returns a stack graph language that uses the bundled rules for TSX.
+pub fn tsx_language() -> Result<StackGraphLanguage, LanguageError> {
+    StackGraphLanguage::from_str(tsx_grammar(), STACK_GRAPHS_TSX_TSG_SOURCE)
+}
+
+/// Returns the stack graph language for files with the given extension, or `None` if the
+/// extension is neither a TypeScript nor a TSX file type.
+pub fn language_for_file_type(
+    file_type: &str,
+) -> Option<Result<StackGraphLanguage, LanguageError>> {
+    if FILE_TYPES.contains(&file_type) {
+        Some(language())
+    } else if TSX_FILE_TYPES.contains(&file_type) {
+        Some(tsx_language())
+    } else {
+        None
+    }
+}
@@ -0,0 +1,26 @@
+class Base {
+  count: number = 0;
+  greet(): void {
+  }
+}
+
+interface Waving {
+  wave(): void;
+}
+
+class Derived extends Base implements Waving {
+  //                  ^ defined: 1
+  wave(): void {
+  }
+}
+
+const d: Derived = new Derived();
+//       ^ defined: 11
+//                     ^ defined: 11
+
+log(Derived.wave);
+//          ^ defined: 13
+log(Derived.greet);
+//          ^ defined: 3
+log(Derived.count);
+//          ^ defined: 2
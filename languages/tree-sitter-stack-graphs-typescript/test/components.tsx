@@ -0,0 +1,14 @@
+interface Props {
+  label: string;
+}
+
+function Button(props: Props) {
+  //                   ^ defined: 1
+  return <button>{props}</button>;
+  //              ^ defined: 5
+}
+
+function App() {
+  return <Button label="ok" />;
+  //      ^ defined: 5
+}
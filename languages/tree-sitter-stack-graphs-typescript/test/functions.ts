@@ -0,0 +1,26 @@
+function outer(a: number, b = 1, ...rest: number[]): number {
+  function inner(c?: number) {
+    return a + c;
+    //     ^ defined: 1
+    //         ^ defined: 2
+  }
+  return inner(b, rest);
+  //     ^ defined: 2
+  //           ^ defined: 1
+  //              ^ defined: 1
+}
+
+const arrow = (x: number) => x;
+//                           ^ defined: 13
+
+function identity<T>(value: T): T {
+  //                        ^ defined: 16
+  //                            ^ defined: 16
+  return value;
+  //     ^ defined: 16
+}
+
+outer(arrow(1), identity(2));
+//^ defined: 1
+//    ^ defined: 13
+//              ^ defined: 16
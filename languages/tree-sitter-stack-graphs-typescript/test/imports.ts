@@ -0,0 +1,26 @@
+// --- path: shapes.ts ---
+export interface Shape {
+  area(): number;
+}
+
+export class Circle implements Shape {
+  //                           ^ defined: 2
+  area(): number {
+    return 3;
+  }
+}
+
+export default Circle;
+//             ^ defined: 6
+
+// --- path: main.ts ---
+import * as shapes from "./shapes";
+import { Circle } from "./shapes";
+import Default from "./shapes";
+
+let s: shapes.Shape = new Circle();
+//            ^ defined: 2
+//                        ^ defined: 6
+log(shapes.Circle, Default);
+//         ^ defined: 6
+//                 ^ defined: 6
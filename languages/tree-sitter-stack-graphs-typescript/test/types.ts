@@ -0,0 +1,29 @@
+interface Shape {
+  area(): number;
+  name: string;
+}
+
+type Id = number;
+type Named = Shape | Id;
+//           ^ defined: 1
+//                   ^ defined: 6
+
+enum Color {
+  Red,
+  Green = 2,
+}
+
+let shapes: Array<Shape> = [];
+//                ^ defined: 1
+let color: Color = Color.Green;
+//         ^ defined: 11
+//                 ^ defined: 11
+//                       ^ defined: 13
+log(Color.Red);
+//        ^ defined: 12
+
+{
+  type Id = string;
+  let local: Id = "";
+  //         ^ defined: 26
+}
@@ -0,0 +1,23 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use std::path::Path;
+use tree_sitter_stack_graphs::test::run_test_dir;
+use tree_sitter_stack_graphs_typescript::language_for_file_type;
+use tree_sitter_stack_graphs_typescript::FILE_TYPES;
+use tree_sitter_stack_graphs_typescript::TSX_FILE_TYPES;
+
+#[test]
+fn bundled_rules_pass_all_tests() -> anyhow::Result<()> {
+    let test_dir = Path::new(env!("CARGO_MANIFEST_DIR")).join("test");
+    run_test_dir(&test_dir, &[FILE_TYPES, TSX_FILE_TYPES].concat(), |path| {
+        let file_type = path.extension().unwrap_or_default().to_string_lossy();
+        language_for_file_type(&file_type)
+            .ok_or_else(|| anyhow::anyhow!("Unknown file type {}", file_type))?
+            .map_err(Into::into)
+    })
+}
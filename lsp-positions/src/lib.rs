@@ -44,6 +44,7 @@ fn utf16_len(string: &str) -> usize {
 /// All of the position information that we have about a character in a source file
 #[repr(C)]
 #[derive(Clone, Debug, Default, Eq, Hash, PartialEq)]
+#[cfg_attr(feature = "serde", derive(serde::Deserialize, serde::Serialize))]
 pub struct Position {
     /// The 0-indexed line number containing the character
     pub line: usize,
@@ -106,6 +107,7 @@ impl PartialOrd<tree_sitter::Point> for Position {
 /// All of the position information that we have about a range of content in a source file
 #[repr(C)]
 #[derive(Clone, Debug, Default, Eq, Hash, PartialEq)]
+#[cfg_attr(feature = "serde", derive(serde::Deserialize, serde::Serialize))]
 pub struct Span {
     pub start: Position,
     pub end: Position,
@@ -141,6 +143,7 @@ impl PartialOrd for Span {
 ///
 /// All offsets are 0-indexed.
 #[derive(Clone, Copy, Debug, Default, Eq, Hash, Ord, PartialEq, PartialOrd)]
+#[cfg_attr(feature = "serde", derive(serde::Deserialize, serde::Serialize))]
 pub struct Offset {
     /// The number of UTF-8-encoded bytes appearing before this character in the string
     pub utf8_offset: usize,
@@ -259,6 +259,9 @@ struct sg_source_info {
     // If you need one of these to make the type checker happy, but you don't have one, just use
     // sg_span::default(), as this will correspond to the all-0s spans which mean "no definiens".
     struct sg_span definiens_span;
+    // The documentation of the source code that this node represents, such as the doc comments
+    // of a definition.
+    sg_string_handle documentation;
 };
 
 // An array of all of the source information in a stack graph.  Source information is associated
@@ -6,6 +6,15 @@
 // ------------------------------------------------------------------------------------------------
 
 //! Defines assertions that can be run against a stack graph.
+//!
+//! There are three kinds of assertions, all of which are anchored at a source position:
+//!
+//!  - `Defined` assertions check that the references at the position resolve to definitions on
+//!    the expected lines.
+//!  - `Defines` assertions check that the position contains definitions of exactly the expected
+//!    symbols.
+//!  - `Refers` assertions check that the position contains references to exactly the expected
+//!    symbols.
 
 use itertools::Itertools;
 use lsp_positions::Position;
@@ -14,6 +23,7 @@ use crate::arena::Handle;
 use crate::graph::File;
 use crate::graph::Node;
 use crate::graph::StackGraph;
+use crate::graph::Symbol;
 use crate::paths::Path;
 use crate::paths::Paths;
 
@@ -24,6 +34,14 @@ pub enum Assertion {
         source: AssertionSource,
         targets: Vec<AssertionTarget>,
     },
+    Defines {
+        source: AssertionSource,
+        symbols: Vec<Handle<Symbol>>,
+    },
+    Refers {
+        source: AssertionSource,
+        symbols: Vec<Handle<Symbol>>,
+    },
 }
 
 /// Source position of an assertion
@@ -34,16 +52,30 @@ pub struct AssertionSource {
 }
 
 impl AssertionSource {
-    fn reference_iter<'a>(
+    /// Returns an iterator over the definitions at the source position.
+    pub fn definitions_iter<'a>(
         &'a self,
         graph: &'a StackGraph,
     ) -> impl Iterator<Item = Handle<Node>> + 'a {
+        self.nodes_iter(graph)
+            .filter(move |n| graph[*n].is_definition())
+    }
+
+    /// Returns an iterator over the references at the source position.
+    pub fn references_iter<'a>(
+        &'a self,
+        graph: &'a StackGraph,
+    ) -> impl Iterator<Item = Handle<Node>> + 'a {
+        self.nodes_iter(graph)
+            .filter(move |n| graph[*n].is_reference())
+    }
+
+    fn nodes_iter<'a>(&'a self, graph: &'a StackGraph) -> impl Iterator<Item = Handle<Node>> + 'a {
         graph.nodes_for_file(self.file).filter(move |n| {
-            graph[*n].is_reference()
-                && graph
-                    .source_info(*n)
-                    .map(|s| s.span.contains(&self.position))
-                    .unwrap_or(false)
+            graph
+                .source_info(*n)
+                .map(|s| s.span.contains(&self.position))
+                .unwrap_or(false)
         })
     }
 }
@@ -78,15 +110,36 @@ pub enum AssertionError {
         missing_targets: Vec<AssertionTarget>,
         unexpected_paths: Vec<Path>,
     },
+    IncorrectDefinedSymbols {
+        source: AssertionSource,
+        missing_symbols: Vec<Handle<Symbol>>,
+        unexpected_symbols: Vec<Handle<Symbol>>,
+    },
+    IncorrectReferencedSymbols {
+        source: AssertionSource,
+        missing_symbols: Vec<Handle<Symbol>>,
+        unexpected_symbols: Vec<Handle<Symbol>>,
+    },
 }
 
 impl Assertion {
+    /// Returns the source position of this assertion.
+    pub fn source(&self) -> &AssertionSource {
+        match self {
+            Assertion::Defined { source, .. } => source,
+            Assertion::Defines { source, .. } => source,
+            Assertion::Refers { source, .. } => source,
+        }
+    }
+
     /// Run this assertion against the given graph, using the given paths object for path search.
     pub fn run(&self, graph: &StackGraph, paths: &mut Paths) -> Result<(), AssertionError> {
         match self {
             Assertion::Defined { source, targets } => {
                 self.run_defined(graph, paths, source, targets)
             }
+            Assertion::Defines { source, symbols } => self.run_defines(graph, source, symbols),
+            Assertion::Refers { source, symbols } => self.run_refers(graph, source, symbols),
         }
     }
 
@@ -97,7 +150,7 @@ impl Assertion {
         source: &AssertionSource,
         expected_targets: &Vec<AssertionTarget>,
     ) -> Result<(), AssertionError> {
-        let references = source.reference_iter(graph).collect::<Vec<_>>();
+        let references = source.references_iter(graph).collect::<Vec<_>>();
         if references.is_empty() {
             return Err(AssertionError::NoReferences {
                 source: source.clone(),
@@ -141,4 +194,68 @@ impl Assertion {
 
         Ok(())
     }
+    fn run_defines(
+        &self,
+        graph: &StackGraph,
+        source: &AssertionSource,
+        expected_symbols: &Vec<Handle<Symbol>>,
+    ) -> Result<(), AssertionError> {
+        let actual_symbols = source
+            .definitions_iter(graph)
+            .filter_map(|d| graph[d].symbol())
+            .collect::<Vec<_>>();
+        let (missing_symbols, unexpected_symbols) =
+            Self::compare_symbols(expected_symbols, &actual_symbols);
+        if !missing_symbols.is_empty() || !unexpected_symbols.is_empty() {
+            return Err(AssertionError::IncorrectDefinedSymbols {
+                source: source.clone(),
+                missing_symbols,
+                unexpected_symbols,
+            });
+        }
+        Ok(())
+    }
+
+    fn run_refers(
+        &self,
+        graph: &StackGraph,
+        source: &AssertionSource,
+        expected_symbols: &Vec<Handle<Symbol>>,
+    ) -> Result<(), AssertionError> {
+        let actual_symbols = source
+            .references_iter(graph)
+            .filter_map(|r| graph[r].symbol())
+            .collect::<Vec<_>>();
+        let (missing_symbols, unexpected_symbols) =
+            Self::compare_symbols(expected_symbols, &actual_symbols);
+        if !missing_symbols.is_empty() || !unexpected_symbols.is_empty() {
+            return Err(AssertionError::IncorrectReferencedSymbols {
+                source: source.clone(),
+                missing_symbols,
+                unexpected_symbols,
+            });
+        }
+        Ok(())
+    }
+
+    /// Returns the expected symbols that are missing from the actual symbols, and the actual
+    /// symbols that were not expected.
+    fn compare_symbols(
+        expected_symbols: &[Handle<Symbol>],
+        actual_symbols: &[Handle<Symbol>],
+    ) -> (Vec<Handle<Symbol>>, Vec<Handle<Symbol>>) {
+        let missing_symbols = expected_symbols
+            .iter()
+            .filter(|s| !actual_symbols.contains(s))
+            .cloned()
+            .unique()
+            .collect::<Vec<_>>();
+        let unexpected_symbols = actual_symbols
+            .iter()
+            .filter(|s| !expected_symbols.contains(s))
+            .cloned()
+            .unique()
+            .collect::<Vec<_>>();
+        (missing_symbols, unexpected_symbols)
+    }
 }
@@ -657,6 +657,9 @@ pub struct sg_source_info {
     /// If you need one of these to make the type checker happy, but you don't have one, just use
     /// sg_span::default(), as this will correspond to the all-0s spans which mean "no definiens".
     pub definiens_span: sg_span,
+    /// The documentation of the source code that this node represents, such as the doc comments
+    /// of a definition.
+    pub documentation: sg_string_handle,
 }
 
 /// All of the position information that we have about a range of content in a source file
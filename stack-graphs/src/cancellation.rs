@@ -0,0 +1,99 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines how long-running operations can be cancelled.
+//!
+//! Operations that can take a long time, such as finding all of the partial paths in a file, take
+//! a [`CancellationFlag`][] that they check periodically.  As soon as the flag reports that the
+//! operation should be cancelled, the operation stops and returns a [`CancellationError`][] that
+//! describes where it was cancelled.
+//!
+//! [`CancellationFlag`]: trait.CancellationFlag.html
+//! [`CancellationError`]: struct.CancellationError.html
+
+use std::sync::atomic::AtomicBool;
+use std::sync::atomic::Ordering;
+use std::time::Duration;
+use std::time::Instant;
+
+/// Determines whether an operation should be cancelled.
+pub trait CancellationFlag {
+    /// Returns an error if the operation should be cancelled.  The `at` argument describes the
+    /// part of the operation that is being performed, and is included in the error.
+    fn check(&self, at: &'static str) -> Result<(), CancellationError>;
+}
+
+/// An error indicating that an operation was cancelled.  The value describes the part of the
+/// operation that was being performed when it was cancelled.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct CancellationError(pub &'static str);
+
+impl std::fmt::Display for CancellationError {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(f, "Cancelled during {}", self.0)
+    }
+}
+
+impl std::error::Error for CancellationError {}
+
+/// A cancellation flag that never cancels.
+pub struct NoCancellation;
+
+impl CancellationFlag for NoCancellation {
+    fn check(&self, _at: &'static str) -> Result<(), CancellationError> {
+        Ok(())
+    }
+}
+
+/// A cancellation flag that cancels once a fixed amount of time has passed since it was created.
+pub struct CancelAfterDuration {
+    deadline: Instant,
+}
+
+impl CancelAfterDuration {
+    pub fn new(limit: Duration) -> CancelAfterDuration {
+        CancelAfterDuration {
+            deadline: Instant::now() + limit,
+        }
+    }
+}
+
+impl CancellationFlag for CancelAfterDuration {
+    fn check(&self, at: &'static str) -> Result<(), CancellationError> {
+        if Instant::now() >= self.deadline {
+            return Err(CancellationError(at));
+        }
+        Ok(())
+    }
+}
+
+/// A cancellation flag that cancels once it has been set.  The flag can be shared with, and set
+/// from, other threads.
+#[derive(Default)]
+pub struct AtomicCancellationFlag {
+    cancelled: AtomicBool,
+}
+
+impl AtomicCancellationFlag {
+    pub fn new() -> AtomicCancellationFlag {
+        AtomicCancellationFlag::default()
+    }
+
+    /// Requests cancellation of the operations that check this flag.
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::Relaxed);
+    }
+}
+
+impl CancellationFlag for AtomicCancellationFlag {
+    fn check(&self, at: &'static str) -> Result<(), CancellationError> {
+        if self.cancelled.load(Ordering::Relaxed) {
+            return Err(CancellationError(at));
+        }
+        Ok(())
+    }
+}
@@ -41,6 +41,28 @@ use crate::paths::Path;
 /// Helps detect cycles in the path-finding algorithm.
 pub struct CycleDetector<P> {
     paths: HashMap<PathKey, SmallVec<[P; 8]>>,
+    stats: CycleDetectorStats,
+}
+
+/// Counts how many paths a [`CycleDetector`][] has accepted or rejected, and why.
+///
+/// [`CycleDetector`]: struct.CycleDetector.html
+#[derive(Clone, Copy, Debug, Default, Eq, PartialEq)]
+pub struct CycleDetectorStats {
+    /// The number of paths that we decided to process.
+    pub processed_paths: usize,
+    /// The number of paths that we skipped because we had already processed an identical path.
+    pub duplicate_paths: usize,
+    /// The number of paths that we skipped because we had already processed too many shorter
+    /// paths with the same start and end nodes.
+    pub similar_paths: usize,
+}
+
+impl CycleDetectorStats {
+    /// Returns the total number of paths that were skipped.
+    pub fn skipped_paths(&self) -> usize {
+        self.duplicate_paths + self.similar_paths
+    }
 }
 
 #[doc(hidden)]
@@ -94,9 +116,15 @@ where
     pub fn new() -> CycleDetector<P> {
         CycleDetector {
             paths: HashMap::new(),
+            stats: CycleDetectorStats::default(),
         }
     }
 
+    /// Returns statistics about the paths that this cycle detector has seen so far.
+    pub fn stats(&self) -> CycleDetectorStats {
+        self.stats
+    }
+
     /// Determines whether we should process this path during the path-finding algorithm.  If our
     /// heuristics decide that this path is a duplicate, or is "non-productive", then we return
     /// `false`, and the path-finding algorithm will skip this path.
@@ -108,7 +136,10 @@ where
         let paths_with_same_nodes = self.paths.entry(key).or_default();
         let index = match paths_with_same_nodes.binary_search_by(cmp) {
             // We've already seen this exact path before; no need to process it again.
-            Ok(_) => return false,
+            Ok(_) => {
+                self.stats.duplicate_paths += 1;
+                return false;
+            }
             // Otherwise add it to the list.
             Err(index) => index,
         };
@@ -120,10 +151,12 @@ where
             .filter(|similar_path| similar_path.is_shorter_than(path))
             .count();
         if similar_path_count > MAX_SIMILAR_PATH_COUNT {
+            self.stats.similar_paths += 1;
             return false;
         }
 
         paths_with_same_nodes.insert(index, path.clone());
+        self.stats.processed_paths += 1;
         true
     }
 }
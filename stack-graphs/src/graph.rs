@@ -126,6 +126,12 @@ impl InternedStringArena {
     }
 }
 
+// The pointer in an InternedStringContent points into a buffer of the InternedStringArena that
+// it was created by.  Those buffers are heap allocated and never resized, and the arena is owned
+// by the same StackGraph as the content, so the pointer remains valid when a StackGraph is moved
+// to another thread.
+unsafe impl Send for InternedStringContent {}
+
 impl InternedStringContent {
     /// Returns the content of this string as a `str`.  This is safe as long as the lifetime of the
     /// InternedStringContent is outlived by the lifetime of the InternedStringArena that holds its
@@ -357,6 +363,12 @@ impl StackGraph {
         self.add_file(name).unwrap_or_else(|handle| handle)
     }
 
+    /// Returns the file with a particular name, if it exists.
+    pub fn get_file<S: AsRef<str> + ?Sized>(&self, name: &S) -> Option<Handle<File>> {
+        let name = name.as_ref();
+        self.file_handles.get(name).copied()
+    }
+
     /// Returns the file with a particular name.  Panics if there is no file with the requested
     /// name.
     pub fn get_file_unchecked<S: AsRef<str> + ?Sized>(&self, name: &S) -> Handle<File> {
@@ -378,6 +390,15 @@ impl StackGraph {
     pub fn iter_files(&self) -> impl Iterator<Item = Handle<File>> + '_ {
         self.files.iter_handles()
     }
+
+    /// Returns the handles of all of the files in this stack graph, sorted by name.  Unlike the
+    /// order of [`iter_files`][Self::iter_files], this order does not depend on the order in
+    /// which the files were added.
+    pub(crate) fn files_in_stable_order(&self) -> Vec<Handle<File>> {
+        let mut files = self.iter_files().collect::<Vec<_>>();
+        files.sort_by(|a, b| self[*a].name().cmp(self[*b].name()));
+        files
+    }
 }
 
 impl Display for File {
@@ -662,6 +683,23 @@ impl StackGraph {
         self.nodes.iter_handles()
     }
 
+    /// Returns the handles of all of the nodes in the graph, sorted by the names of their files
+    /// and their local IDs.  Unlike the order of [`iter_nodes`][Self::iter_nodes], this order
+    /// does not depend on the order in which files and nodes were added, so it can be used to
+    /// produce reproducible output.
+    pub(crate) fn nodes_in_stable_order(&self) -> Vec<Handle<Node>> {
+        let mut nodes = self.iter_nodes().collect::<Vec<_>>();
+        nodes.sort_by(|a, b| self.stable_node_key(*a).cmp(&self.stable_node_key(*b)));
+        nodes
+    }
+
+    /// Returns a key that sorts nodes by the name of their file and their local ID.  The
+    /// singleton nodes, which don't belong to any file, sort before all other nodes.
+    pub(crate) fn stable_node_key(&self, node: Handle<Node>) -> (Option<&str>, u32) {
+        let id = self[node].id();
+        (id.file().map(|file| self[file].name()), id.local_id())
+    }
+
     /// Returns the handle to the node with a particular ID, if it exists.
     pub fn node_for_id(&self, id: NodeID) -> Option<Handle<Node>> {
         if id.file().is_some() {
@@ -722,6 +760,42 @@ impl Handle<Node> {
             graph,
         }
     }
+
+    /// Displays the node, followed by the location of the source code that it represents, if it
+    /// is known, e.g., `[test.py(0) definition a] at 1:1-1:2`.  Lines and columns start at 1.
+    /// This identifies nodes in log and error messages.
+    pub fn display_with_location(self, graph: &StackGraph) -> impl Display + '_ {
+        DisplayNodeWithLocation {
+            handle: self,
+            graph,
+        }
+    }
+}
+
+#[doc(hidden)]
+pub struct DisplayNodeWithLocation<'a> {
+    handle: Handle<Node>,
+    graph: &'a StackGraph,
+}
+
+impl<'a> Display for DisplayNodeWithLocation<'a> {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(f, "{}", self.handle.display(self.graph))?;
+        let span = match self.graph.source_info(self.handle) {
+            Some(source_info) if source_info.span != lsp_positions::Span::default() => {
+                &source_info.span
+            }
+            _ => return Ok(()),
+        };
+        write!(
+            f,
+            " at {}:{}-{}:{}",
+            span.start.line + 1,
+            span.start.column.grapheme_offset + 1,
+            span.end.line + 1,
+            span.end.column.grapheme_offset + 1,
+        )
+    }
 }
 
 impl Index<Handle<Node>> for StackGraph {
@@ -1285,6 +1359,35 @@ pub struct Edge {
     pub precedence: i32,
 }
 
+impl Edge {
+    /// Displays the edge with its source and sink nodes, and its precedence, e.g.,
+    /// `[test.py(0) scope] -1-> [root]`.
+    pub fn display(self, graph: &StackGraph) -> impl Display + '_ {
+        DisplayEdge {
+            wrapped: self,
+            graph,
+        }
+    }
+}
+
+#[doc(hidden)]
+pub struct DisplayEdge<'a> {
+    wrapped: Edge,
+    graph: &'a StackGraph,
+}
+
+impl<'a> Display for DisplayEdge<'a> {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(
+            f,
+            "{} -{}-> {}",
+            self.wrapped.source.display(self.graph),
+            self.wrapped.precedence,
+            self.wrapped.sink.display(self.graph),
+        )
+    }
+}
+
 struct OutgoingEdge {
     sink: Handle<Node>,
     precedence: i32,
@@ -1338,6 +1441,9 @@ pub struct SourceInfo {
     /// If you need one of these to make the type checker happy, but you don't have one, just use
     /// lsp_positions::Span::default(), as this will correspond to the all-0s spans which mean "no definiens".
     pub definiens_span: lsp_positions::Span,
+    /// The documentation of the source code that this node represents, such as the doc comments
+    /// of a definition.  This is shown to the user in things like hover popups.
+    pub documentation: Option<Handle<InternedString>>,
 }
 
 impl StackGraph {
@@ -1537,6 +1643,9 @@ impl StackGraph {
                             .map(|cl| self.add_string(&other[cl]))
                             .into(),
                         definiens_span: source_info.definiens_span.clone(),
+                        documentation: source_info
+                            .documentation
+                            .map(|doc| self.add_string(&other[doc])),
                     };
                 }
                 if let Some(debug_info) = other.debug_info(other_node) {
@@ -197,7 +197,11 @@ impl<'a> Serialize for InStackGraph<'a, &Files> {
         let filter = self.2;
 
         let mut ser = serializer.serialize_seq(None)?;
-        for file in graph.iter_files().filter(|f| filter.include_file(graph, f)) {
+        for file in graph
+            .files_in_stable_order()
+            .into_iter()
+            .filter(|f| filter.include_file(graph, f))
+        {
             ser.serialize_element(&self.with_idx(file))?;
         }
         ser.end()
@@ -228,9 +232,9 @@ impl Serialize for InStackGraph<'_, &Nodes> {
         let filter = self.2;
 
         let mut nodes = serializer.serialize_seq(None)?;
-        for node in self
-            .1
-            .iter_nodes()
+        for node in graph
+            .nodes_in_stable_order()
+            .into_iter()
             .filter(|n| filter.include_node(graph, &n))
         {
             nodes.serialize_element(&self.with_idx(node))?;
@@ -352,12 +356,18 @@ impl Serialize for InStackGraph<'_, &SourceInfo> {
         if source_info.syntax_type.is_some() {
             len += 1;
         }
+        if source_info.documentation.is_some() {
+            len += 1;
+        }
 
         let mut ser = serializer.serialize_struct("source_info", len)?;
         ser.serialize_field("span", &self.with(&source_info.span))?;
         if let Some(syntax_type) = source_info.syntax_type {
             ser.serialize_field("syntax_type", &graph[syntax_type])?;
         }
+        if let Some(documentation) = source_info.documentation {
+            ser.serialize_field("documentation", &graph[documentation])?;
+        }
         ser.end()
     }
 }
@@ -406,12 +416,17 @@ impl Serialize for InStackGraph<'_, &Edges> {
         let filter = self.2;
 
         let mut ser = serializer.serialize_seq(None)?;
-        for source in self.1.iter_nodes() {
-            for edge in self
-                .1
+        for source in graph.nodes_in_stable_order() {
+            let mut edges = graph
                 .outgoing_edges(source)
                 .filter(|e| filter.include_edge(graph, &e.source, &e.sink))
-            {
+                .collect::<Vec<_>>();
+            edges.sort_by(|a, b| {
+                graph
+                    .stable_node_key(a.sink)
+                    .cmp(&graph.stable_node_key(b.sink))
+            });
+            for edge in edges {
                 ser.serialize_element(&self.with(&edge))?;
             }
         }
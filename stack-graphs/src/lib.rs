@@ -58,6 +58,7 @@
 pub mod arena;
 pub mod assert;
 pub mod c;
+pub mod cancellation;
 pub mod cycles;
 #[macro_use]
 mod debugging;
@@ -66,7 +67,14 @@ pub mod graph;
 pub mod json;
 pub mod partial;
 pub mod paths;
+#[cfg(feature = "proto")]
+pub mod proto;
+#[cfg(feature = "json")]
+pub mod serde;
+pub mod snapshot;
 pub mod stitching;
+#[cfg(feature = "storage")]
+pub mod storage;
 pub(crate) mod utils;
 #[cfg(feature = "json")]
 pub mod visualization;
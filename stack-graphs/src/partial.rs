@@ -45,6 +45,9 @@ use smallvec::SmallVec;
 use crate::arena::Deque;
 use crate::arena::DequeArena;
 use crate::arena::Handle;
+use crate::cancellation::CancellationError;
+use crate::cancellation::CancellationFlag;
+use crate::cancellation::NoCancellation;
 use crate::cycles::CycleDetector;
 use crate::graph::Edge;
 use crate::graph::File;
@@ -972,6 +975,12 @@ impl PartialSymbolStack {
         )
     }
 
+    /// Returns the symbol stack variable at the end of this partial symbol stack.  If the stack
+    /// does not contain a symbol stack variable, returns `None`.
+    pub fn variable(&self) -> Option<SymbolStackVariable> {
+        self.variable.into_option()
+    }
+
     /// Returns an iterator over the contents of this partial symbol stack.
     pub fn iter<'a>(
         &self,
@@ -2426,9 +2435,34 @@ impl PartialPaths {
         &mut self,
         graph: &StackGraph,
         file: Handle<File>,
-        mut visit: F,
+        visit: F,
     ) where
         F: FnMut(&StackGraph, &mut PartialPaths, PartialPath),
+    {
+        // NoCancellation never cancels, so this cannot fail.
+        let _ = self.find_all_partial_paths_in_file_with_cancellation(
+            graph,
+            file,
+            &NoCancellation,
+            visit,
+        );
+    }
+
+    /// Finds all partial paths in a file, calling the `visit` closure for each one, like
+    /// [`find_all_partial_paths_in_file`][].  The cancellation flag is checked before each path is
+    /// processed, and an error is returned as soon as it indicates that the search should be
+    /// cancelled.
+    ///
+    /// [`find_all_partial_paths_in_file`]: #method.find_all_partial_paths_in_file
+    pub fn find_all_partial_paths_in_file_with_cancellation<F>(
+        &mut self,
+        graph: &StackGraph,
+        file: Handle<File>,
+        cancellation_flag: &dyn CancellationFlag,
+        mut visit: F,
+    ) -> Result<(), CancellationError>
+    where
+        F: FnMut(&StackGraph, &mut PartialPaths, PartialPath),
     {
         let mut cycle_detector = CycleDetector::new();
         let mut queue = VecDeque::new();
@@ -2445,12 +2479,14 @@ impl PartialPaths {
                 .map(|node| PartialPath::from_node(graph, self, node).unwrap()),
         );
         while let Some(path) = queue.pop_front() {
+            cancellation_flag.check("finding partial paths in file")?;
             if !cycle_detector.should_process_path(&path, |probe| probe.cmp(graph, self, &path)) {
                 continue;
             }
             path.extend_from_file(graph, self, file, &mut queue);
             visit(graph, self, path);
         }
+        Ok(())
     }
 }
 
@@ -0,0 +1,744 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines a protobuf encoding of stack graphs, partial paths, and query results.
+//!
+//! The encoding is a compact alternative to JSON for exchanging data between tools.  The schema
+//! is defined in `proto/stack_graphs.proto`, and the message types in this module implement it.
+//! Graphs and partial paths are converted from and to the mirrors in the [`serde`][crate::serde]
+//! module, which can be loaded back into a stack graph.
+//!
+//! Every top-level message records the [`SCHEMA_VERSION`][] it was encoded with, and decoding
+//! fails for messages with a different version.
+
+use prost::Message;
+use std::convert::TryFrom;
+use thiserror::Error;
+
+use crate::graph;
+use crate::json::Filter;
+use crate::partial;
+use crate::serde;
+
+/// The version of the schema that messages are encoded with.
+pub const SCHEMA_VERSION: u32 = 1;
+
+/// An error that can occur when decoding a protobuf message.
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error(transparent)]
+    Decode(#[from] prost::DecodeError),
+    #[error("unsupported schema version {0}, expected {}", SCHEMA_VERSION)]
+    UnsupportedVersion(u32),
+    #[error("missing field `{0}`")]
+    MissingField(&'static str),
+    #[error("invalid node kind {0}")]
+    InvalidNodeKind(i32),
+}
+
+fn check_version(version: u32) -> Result<(), Error> {
+    if version != SCHEMA_VERSION {
+        return Err(Error::UnsupportedVersion(version));
+    }
+    Ok(())
+}
+
+fn required<T>(value: Option<T>, field: &'static str) -> Result<T, Error> {
+    value.ok_or(Error::MissingField(field))
+}
+
+/// Encodes the files, nodes, and edges of a stack graph that are allowed by the filter.
+pub fn encode_graph(graph: &graph::StackGraph, filter: &dyn Filter) -> Vec<u8> {
+    StackGraph::from(&serde::StackGraph::from_graph(graph, filter)).encode_to_vec()
+}
+
+/// Decodes a stack graph, which can be loaded into a `StackGraph` using
+/// [`load_into`][serde::StackGraph::load_into].
+pub fn decode_graph(bytes: &[u8]) -> Result<serde::StackGraph, Error> {
+    serde::StackGraph::try_from(StackGraph::decode(bytes)?)
+}
+
+/// Encodes a list of partial paths.
+pub fn encode_partial_paths(
+    graph: &graph::StackGraph,
+    partials: &mut partial::PartialPaths,
+    paths: &[partial::PartialPath],
+) -> Vec<u8> {
+    PartialPaths {
+        version: SCHEMA_VERSION,
+        paths: paths
+            .iter()
+            .map(|path| {
+                PartialPath::from(&serde::PartialPath::from_partial_path(
+                    graph, partials, path,
+                ))
+            })
+            .collect(),
+    }
+    .encode_to_vec()
+}
+
+/// Decodes a list of partial paths, which can be loaded into a stack graph that contains their
+/// nodes using [`to_partial_path`][serde::PartialPath::to_partial_path].
+pub fn decode_partial_paths(bytes: &[u8]) -> Result<Vec<serde::PartialPath>, Error> {
+    let message = PartialPaths::decode(bytes)?;
+    check_version(message.version)?;
+    message
+        .paths
+        .into_iter()
+        .map(serde::PartialPath::try_from)
+        .collect()
+}
+
+/// Encodes a list of query results.
+pub fn encode_query_results(results: &[QueryResult]) -> Vec<u8> {
+    QueryResults {
+        version: SCHEMA_VERSION,
+        results: results.to_vec(),
+    }
+    .encode_to_vec()
+}
+
+/// Decodes a list of query results.
+pub fn decode_query_results(bytes: &[u8]) -> Result<Vec<QueryResult>, Error> {
+    let message = QueryResults::decode(bytes)?;
+    check_version(message.version)?;
+    Ok(message.results)
+}
+
+//-------------------------------------------------------------------------------------------------
+// Stack graphs
+
+#[derive(Clone, PartialEq, Message)]
+pub struct StackGraph {
+    #[prost(uint32, tag = "1")]
+    pub version: u32,
+    #[prost(string, repeated, tag = "2")]
+    pub files: Vec<String>,
+    #[prost(message, repeated, tag = "3")]
+    pub nodes: Vec<Node>,
+    #[prost(message, repeated, tag = "4")]
+    pub edges: Vec<Edge>,
+}
+
+impl From<&serde::StackGraph> for StackGraph {
+    fn from(graph: &serde::StackGraph) -> StackGraph {
+        StackGraph {
+            version: SCHEMA_VERSION,
+            files: graph.files.clone(),
+            nodes: graph.nodes.iter().map(Node::from).collect(),
+            edges: graph.edges.iter().map(Edge::from).collect(),
+        }
+    }
+}
+
+impl TryFrom<StackGraph> for serde::StackGraph {
+    type Error = Error;
+
+    fn try_from(graph: StackGraph) -> Result<serde::StackGraph, Error> {
+        check_version(graph.version)?;
+        Ok(serde::StackGraph {
+            files: graph.files,
+            nodes: graph
+                .nodes
+                .into_iter()
+                .map(serde::Node::try_from)
+                .collect::<Result<_, _>>()?,
+            edges: graph
+                .edges
+                .into_iter()
+                .map(serde::Edge::try_from)
+                .collect::<Result<_, _>>()?,
+        })
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct NodeID {
+    #[prost(string, optional, tag = "1")]
+    pub file: Option<String>,
+    #[prost(uint32, tag = "2")]
+    pub local_id: u32,
+}
+
+impl From<&serde::NodeID> for NodeID {
+    fn from(id: &serde::NodeID) -> NodeID {
+        NodeID {
+            file: id.file.clone(),
+            local_id: id.local_id,
+        }
+    }
+}
+
+impl From<NodeID> for serde::NodeID {
+    fn from(id: NodeID) -> serde::NodeID {
+        serde::NodeID {
+            file: id.file,
+            local_id: id.local_id,
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq, prost::Enumeration)]
+#[repr(i32)]
+pub enum NodeKind {
+    Unspecified = 0,
+    DropScopes = 1,
+    PopScopedSymbol = 2,
+    PopSymbol = 3,
+    PushScopedSymbol = 4,
+    PushSymbol = 5,
+    Scope = 6,
+}
+
+/// A node in a stack graph.  Which of the fields are used depends on the kind of node.
+#[derive(Clone, PartialEq, Message)]
+pub struct Node {
+    #[prost(enumeration = "NodeKind", tag = "1")]
+    pub kind: i32,
+    #[prost(message, optional, tag = "2")]
+    pub id: Option<NodeID>,
+    #[prost(string, tag = "3")]
+    pub symbol: String,
+    #[prost(message, optional, tag = "4")]
+    pub scope: Option<NodeID>,
+    #[prost(bool, tag = "5")]
+    pub is_definition: bool,
+    #[prost(bool, tag = "6")]
+    pub is_reference: bool,
+    #[prost(bool, tag = "7")]
+    pub is_exported: bool,
+    #[prost(message, optional, tag = "8")]
+    pub source_info: Option<SourceInfo>,
+    #[prost(message, repeated, tag = "9")]
+    pub debug_info: Vec<DebugEntry>,
+}
+
+impl From<&serde::Node> for Node {
+    fn from(node: &serde::Node) -> Node {
+        let mut result = Node::default();
+        let (id, source_info, debug_info) = match node {
+            serde::Node::DropScopes {
+                id,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::DropScopes);
+                (id, source_info, debug_info)
+            }
+            serde::Node::PopScopedSymbol {
+                id,
+                symbol,
+                is_definition,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::PopScopedSymbol);
+                result.symbol = symbol.clone();
+                result.is_definition = *is_definition;
+                (id, source_info, debug_info)
+            }
+            serde::Node::PopSymbol {
+                id,
+                symbol,
+                is_definition,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::PopSymbol);
+                result.symbol = symbol.clone();
+                result.is_definition = *is_definition;
+                (id, source_info, debug_info)
+            }
+            serde::Node::PushScopedSymbol {
+                id,
+                symbol,
+                scope,
+                is_reference,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::PushScopedSymbol);
+                result.symbol = symbol.clone();
+                result.scope = Some(scope.into());
+                result.is_reference = *is_reference;
+                (id, source_info, debug_info)
+            }
+            serde::Node::PushSymbol {
+                id,
+                symbol,
+                is_reference,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::PushSymbol);
+                result.symbol = symbol.clone();
+                result.is_reference = *is_reference;
+                (id, source_info, debug_info)
+            }
+            serde::Node::Scope {
+                id,
+                is_exported,
+                source_info,
+                debug_info,
+            } => {
+                result.set_kind(NodeKind::Scope);
+                result.is_exported = *is_exported;
+                (id, source_info, debug_info)
+            }
+        };
+        result.id = Some(id.into());
+        result.source_info = source_info.as_ref().map(SourceInfo::from);
+        result.debug_info = debug_info
+            .iter()
+            .flat_map(|debug_info| debug_info.entries.iter())
+            .map(|entry| DebugEntry {
+                key: entry.key.clone(),
+                value: entry.value.clone(),
+            })
+            .collect();
+        result
+    }
+}
+
+impl TryFrom<Node> for serde::Node {
+    type Error = Error;
+
+    fn try_from(node: Node) -> Result<serde::Node, Error> {
+        let kind = NodeKind::from_i32(node.kind).ok_or(Error::InvalidNodeKind(node.kind))?;
+        let id = required(node.id, "id")?.into();
+        let symbol = node.symbol;
+        let source_info = node.source_info.map(serde::SourceInfo::from);
+        let debug_info = if node.debug_info.is_empty() {
+            None
+        } else {
+            Some(serde::DebugInfo {
+                entries: node
+                    .debug_info
+                    .into_iter()
+                    .map(|entry| serde::DebugEntry {
+                        key: entry.key,
+                        value: entry.value,
+                    })
+                    .collect(),
+            })
+        };
+        Ok(match kind {
+            NodeKind::Unspecified => return Err(Error::InvalidNodeKind(node.kind)),
+            NodeKind::DropScopes => serde::Node::DropScopes {
+                id,
+                source_info,
+                debug_info,
+            },
+            NodeKind::PopScopedSymbol => serde::Node::PopScopedSymbol {
+                id,
+                symbol,
+                is_definition: node.is_definition,
+                source_info,
+                debug_info,
+            },
+            NodeKind::PopSymbol => serde::Node::PopSymbol {
+                id,
+                symbol,
+                is_definition: node.is_definition,
+                source_info,
+                debug_info,
+            },
+            NodeKind::PushScopedSymbol => serde::Node::PushScopedSymbol {
+                id,
+                symbol,
+                scope: required(node.scope, "scope")?.into(),
+                is_reference: node.is_reference,
+                source_info,
+                debug_info,
+            },
+            NodeKind::PushSymbol => serde::Node::PushSymbol {
+                id,
+                symbol,
+                is_reference: node.is_reference,
+                source_info,
+                debug_info,
+            },
+            NodeKind::Scope => serde::Node::Scope {
+                id,
+                is_exported: node.is_exported,
+                source_info,
+                debug_info,
+            },
+        })
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct SourceInfo {
+    #[prost(message, optional, tag = "1")]
+    pub span: Option<Span>,
+    #[prost(string, optional, tag = "2")]
+    pub syntax_type: Option<String>,
+    #[prost(string, optional, tag = "3")]
+    pub containing_line: Option<String>,
+    #[prost(message, optional, tag = "4")]
+    pub definiens_span: Option<Span>,
+    #[prost(string, optional, tag = "5")]
+    pub documentation: Option<String>,
+}
+
+impl From<&serde::SourceInfo> for SourceInfo {
+    fn from(source_info: &serde::SourceInfo) -> SourceInfo {
+        SourceInfo {
+            span: Some((&source_info.span).into()),
+            syntax_type: source_info.syntax_type.clone(),
+            containing_line: source_info.containing_line.clone(),
+            definiens_span: Some((&source_info.definiens_span).into()),
+            documentation: source_info.documentation.clone(),
+        }
+    }
+}
+
+impl From<SourceInfo> for serde::SourceInfo {
+    fn from(source_info: SourceInfo) -> serde::SourceInfo {
+        serde::SourceInfo {
+            span: source_info.span.unwrap_or_default().into(),
+            syntax_type: source_info.syntax_type,
+            containing_line: source_info.containing_line,
+            definiens_span: source_info.definiens_span.unwrap_or_default().into(),
+            documentation: source_info.documentation,
+        }
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct Span {
+    #[prost(message, optional, tag = "1")]
+    pub start: Option<Position>,
+    #[prost(message, optional, tag = "2")]
+    pub end: Option<Position>,
+}
+
+impl From<&lsp_positions::Span> for Span {
+    fn from(span: &lsp_positions::Span) -> Span {
+        Span {
+            start: Some((&span.start).into()),
+            end: Some((&span.end).into()),
+        }
+    }
+}
+
+impl From<Span> for lsp_positions::Span {
+    fn from(span: Span) -> lsp_positions::Span {
+        lsp_positions::Span {
+            start: span.start.unwrap_or_default().into(),
+            end: span.end.unwrap_or_default().into(),
+        }
+    }
+}
+
+/// A position in a source file.  Lines and offsets are 0-indexed.
+#[derive(Clone, PartialEq, Message)]
+pub struct Position {
+    #[prost(uint64, tag = "1")]
+    pub line: u64,
+    #[prost(uint64, tag = "2")]
+    pub utf8_offset: u64,
+    #[prost(uint64, tag = "3")]
+    pub utf16_offset: u64,
+    #[prost(uint64, tag = "4")]
+    pub grapheme_offset: u64,
+    #[prost(uint64, tag = "5")]
+    pub containing_line_start: u64,
+    #[prost(uint64, tag = "6")]
+    pub containing_line_end: u64,
+    #[prost(uint64, tag = "7")]
+    pub trimmed_line_start: u64,
+    #[prost(uint64, tag = "8")]
+    pub trimmed_line_end: u64,
+}
+
+impl From<&lsp_positions::Position> for Position {
+    fn from(position: &lsp_positions::Position) -> Position {
+        Position {
+            line: position.line as u64,
+            utf8_offset: position.column.utf8_offset as u64,
+            utf16_offset: position.column.utf16_offset as u64,
+            grapheme_offset: position.column.grapheme_offset as u64,
+            containing_line_start: position.containing_line.start as u64,
+            containing_line_end: position.containing_line.end as u64,
+            trimmed_line_start: position.trimmed_line.start as u64,
+            trimmed_line_end: position.trimmed_line.end as u64,
+        }
+    }
+}
+
+impl From<Position> for lsp_positions::Position {
+    fn from(position: Position) -> lsp_positions::Position {
+        lsp_positions::Position {
+            line: position.line as usize,
+            column: lsp_positions::Offset {
+                utf8_offset: position.utf8_offset as usize,
+                utf16_offset: position.utf16_offset as usize,
+                grapheme_offset: position.grapheme_offset as usize,
+            },
+            containing_line: position.containing_line_start as usize
+                ..position.containing_line_end as usize,
+            trimmed_line: position.trimmed_line_start as usize..position.trimmed_line_end as usize,
+        }
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct DebugEntry {
+    #[prost(string, tag = "1")]
+    pub key: String,
+    #[prost(string, tag = "2")]
+    pub value: String,
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct Edge {
+    #[prost(message, optional, tag = "1")]
+    pub source: Option<NodeID>,
+    #[prost(message, optional, tag = "2")]
+    pub sink: Option<NodeID>,
+    #[prost(int32, tag = "3")]
+    pub precedence: i32,
+}
+
+impl From<&serde::Edge> for Edge {
+    fn from(edge: &serde::Edge) -> Edge {
+        Edge {
+            source: Some((&edge.source).into()),
+            sink: Some((&edge.sink).into()),
+            precedence: edge.precedence,
+        }
+    }
+}
+
+impl TryFrom<Edge> for serde::Edge {
+    type Error = Error;
+
+    fn try_from(edge: Edge) -> Result<serde::Edge, Error> {
+        Ok(serde::Edge {
+            source: required(edge.source, "source")?.into(),
+            sink: required(edge.sink, "sink")?.into(),
+            precedence: edge.precedence,
+        })
+    }
+}
+
+//-------------------------------------------------------------------------------------------------
+// Partial paths
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialPaths {
+    #[prost(uint32, tag = "1")]
+    pub version: u32,
+    #[prost(message, repeated, tag = "2")]
+    pub paths: Vec<PartialPath>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialPath {
+    #[prost(message, optional, tag = "1")]
+    pub start_node: Option<NodeID>,
+    #[prost(message, optional, tag = "2")]
+    pub end_node: Option<NodeID>,
+    #[prost(message, optional, tag = "3")]
+    pub symbol_stack_precondition: Option<PartialSymbolStack>,
+    #[prost(message, optional, tag = "4")]
+    pub symbol_stack_postcondition: Option<PartialSymbolStack>,
+    #[prost(message, optional, tag = "5")]
+    pub scope_stack_precondition: Option<PartialScopeStack>,
+    #[prost(message, optional, tag = "6")]
+    pub scope_stack_postcondition: Option<PartialScopeStack>,
+    #[prost(message, repeated, tag = "7")]
+    pub edges: Vec<PartialPathEdge>,
+}
+
+impl From<&serde::PartialPath> for PartialPath {
+    fn from(path: &serde::PartialPath) -> PartialPath {
+        PartialPath {
+            start_node: Some((&path.start_node).into()),
+            end_node: Some((&path.end_node).into()),
+            symbol_stack_precondition: Some((&path.symbol_stack_precondition).into()),
+            symbol_stack_postcondition: Some((&path.symbol_stack_postcondition).into()),
+            scope_stack_precondition: Some((&path.scope_stack_precondition).into()),
+            scope_stack_postcondition: Some((&path.scope_stack_postcondition).into()),
+            edges: path
+                .edges
+                .iter()
+                .map(|edge| PartialPathEdge {
+                    source: Some((&edge.source).into()),
+                    precedence: edge.precedence,
+                })
+                .collect(),
+        }
+    }
+}
+
+impl TryFrom<PartialPath> for serde::PartialPath {
+    type Error = Error;
+
+    fn try_from(path: PartialPath) -> Result<serde::PartialPath, Error> {
+        Ok(serde::PartialPath {
+            start_node: required(path.start_node, "start_node")?.into(),
+            end_node: required(path.end_node, "end_node")?.into(),
+            symbol_stack_precondition: required(
+                path.symbol_stack_precondition,
+                "symbol_stack_precondition",
+            )?
+            .into(),
+            symbol_stack_postcondition: required(
+                path.symbol_stack_postcondition,
+                "symbol_stack_postcondition",
+            )?
+            .into(),
+            scope_stack_precondition: required(
+                path.scope_stack_precondition,
+                "scope_stack_precondition",
+            )?
+            .into(),
+            scope_stack_postcondition: required(
+                path.scope_stack_postcondition,
+                "scope_stack_postcondition",
+            )?
+            .into(),
+            edges: path
+                .edges
+                .into_iter()
+                .map(|edge| {
+                    Ok(serde::PartialPathEdge {
+                        source: required(edge.source, "source")?.into(),
+                        precedence: edge.precedence,
+                    })
+                })
+                .collect::<Result<_, Error>>()?,
+        })
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialSymbolStack {
+    #[prost(message, repeated, tag = "1")]
+    pub symbols: Vec<PartialScopedSymbol>,
+    #[prost(uint32, optional, tag = "2")]
+    pub variable: Option<u32>,
+}
+
+impl From<&serde::PartialSymbolStack> for PartialSymbolStack {
+    fn from(stack: &serde::PartialSymbolStack) -> PartialSymbolStack {
+        PartialSymbolStack {
+            symbols: stack
+                .symbols
+                .iter()
+                .map(|symbol| PartialScopedSymbol {
+                    symbol: symbol.symbol.clone(),
+                    scopes: symbol.scopes.as_ref().map(PartialScopeStack::from),
+                })
+                .collect(),
+            variable: stack.variable,
+        }
+    }
+}
+
+impl From<PartialSymbolStack> for serde::PartialSymbolStack {
+    fn from(stack: PartialSymbolStack) -> serde::PartialSymbolStack {
+        serde::PartialSymbolStack {
+            symbols: stack
+                .symbols
+                .into_iter()
+                .map(|symbol| serde::PartialScopedSymbol {
+                    symbol: symbol.symbol,
+                    scopes: symbol.scopes.map(serde::PartialScopeStack::from),
+                })
+                .collect(),
+            variable: stack.variable,
+        }
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialScopedSymbol {
+    #[prost(string, tag = "1")]
+    pub symbol: String,
+    #[prost(message, optional, tag = "2")]
+    pub scopes: Option<PartialScopeStack>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialScopeStack {
+    #[prost(message, repeated, tag = "1")]
+    pub scopes: Vec<NodeID>,
+    #[prost(uint32, optional, tag = "2")]
+    pub variable: Option<u32>,
+}
+
+impl From<&serde::PartialScopeStack> for PartialScopeStack {
+    fn from(stack: &serde::PartialScopeStack) -> PartialScopeStack {
+        PartialScopeStack {
+            scopes: stack.scopes.iter().map(NodeID::from).collect(),
+            variable: stack.variable,
+        }
+    }
+}
+
+impl From<PartialScopeStack> for serde::PartialScopeStack {
+    fn from(stack: PartialScopeStack) -> serde::PartialScopeStack {
+        serde::PartialScopeStack {
+            scopes: stack.scopes.into_iter().map(serde::NodeID::from).collect(),
+            variable: stack.variable,
+        }
+    }
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct PartialPathEdge {
+    #[prost(message, optional, tag = "1")]
+    pub source: Option<NodeID>,
+    #[prost(int32, tag = "2")]
+    pub precedence: i32,
+}
+
+//-------------------------------------------------------------------------------------------------
+// Query results
+
+#[derive(Clone, PartialEq, Message)]
+pub struct QueryResults {
+    #[prost(uint32, tag = "1")]
+    pub version: u32,
+    #[prost(message, repeated, tag = "2")]
+    pub results: Vec<QueryResult>,
+}
+
+/// The definitions that a reference resolves to.
+#[derive(Clone, PartialEq, Message)]
+pub struct QueryResult {
+    #[prost(message, optional, tag = "1")]
+    pub reference: Option<Location>,
+    #[prost(message, repeated, tag = "2")]
+    pub definitions: Vec<Location>,
+    /// The ranking scores of the definitions, in the same order.  Higher scores indicate more
+    /// likely definitions.
+    #[prost(double, repeated, tag = "3")]
+    pub scores: Vec<f64>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+pub struct Location {
+    #[prost(string, tag = "1")]
+    pub file: String,
+    #[prost(message, optional, tag = "2")]
+    pub span: Option<Span>,
+}
+
+impl Location {
+    pub fn new(file: &str, span: &lsp_positions::Span) -> Location {
+        Location {
+            file: file.to_string(),
+            span: Some(span.into()),
+        }
+    }
+}
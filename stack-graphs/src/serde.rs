@@ -0,0 +1,782 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines serializable mirrors of stack graphs and partial paths.
+//!
+//! Stack graphs and partial paths refer to files, symbols, and nodes using handles into arenas,
+//! which only make sense in the context of the `StackGraph` and `PartialPaths` instances that own
+//! those arenas.  The types in this module refer to files and symbols by name, and to nodes by
+//! file name and local ID, which makes them independent of any particular instance.  That lets us
+//! serialize them, store them, and later load them into a different stack graph.
+//!
+//! Each type can be created from its counterpart using a `from_*` function, and loaded back into
+//! a stack graph using a `load_into` or `to_*` method.
+
+use lsp_positions::Span;
+use serde::Deserialize;
+use serde::Serialize;
+use serde_json::json;
+use serde_json::Value;
+use thiserror::Error;
+
+use crate::arena::Handle;
+use crate::graph;
+use crate::json::Filter;
+use crate::json::JsonError;
+use crate::partial;
+
+/// An error that can occur when loading serialized data into a stack graph.
+#[derive(Debug, Error, PartialEq, Eq)]
+pub enum Error {
+    #[error("failed to load file `{0}`")]
+    FileNotFound(String),
+    #[error("file `{0}` is already present in the stack graph")]
+    FileAlreadyPresent(String),
+    #[error("invalid node ID {0}")]
+    InvalidNodeID(u32),
+    #[error("node `{0}` is already present in the stack graph")]
+    NodeAlreadyPresent(NodeID),
+    #[error("node `{0}` not found")]
+    NodeNotFound(NodeID),
+    #[error("invalid variable {0}")]
+    InvalidVariable(u32),
+}
+
+//-------------------------------------------------------------------------------------------------
+// Stack graphs
+
+/// The files, nodes, and edges of (part of) a stack graph.
+#[derive(Clone, Debug, Default, Deserialize, Eq, PartialEq, Serialize)]
+pub struct StackGraph {
+    pub files: Vec<String>,
+    pub nodes: Vec<Node>,
+    pub edges: Vec<Edge>,
+}
+
+impl StackGraph {
+    /// Returns the serializable form of the files, nodes, and edges of a stack graph that are
+    /// allowed by the filter.  Files are sorted by name, nodes by their IDs, and edges by the IDs
+    /// of their source and sink nodes, so that the result does not depend on the order in which
+    /// the stack graph was built.
+    pub fn from_graph(graph: &graph::StackGraph, filter: &dyn Filter) -> StackGraph {
+        let mut result = StackGraph::default();
+        for file in graph.files_in_stable_order() {
+            if !filter.include_file(graph, &file) {
+                continue;
+            }
+            result.files.push(graph[file].name().to_string());
+            for node in graph.nodes_for_file(file) {
+                if !filter.include_node(graph, &node) {
+                    continue;
+                }
+                result.nodes.push(Node::from_node(graph, node));
+                for edge in graph.outgoing_edges(node) {
+                    if !filter.include_edge(graph, &edge.source, &edge.sink) {
+                        continue;
+                    }
+                    result.edges.push(Edge {
+                        source: NodeID::from_node_id(graph, graph[edge.source].id()),
+                        sink: NodeID::from_node_id(graph, graph[edge.sink].id()),
+                        precedence: edge.precedence,
+                    });
+                }
+            }
+        }
+        result
+            .edges
+            .sort_by(|a, b| (&a.source, &a.sink).cmp(&(&b.source, &b.sink)));
+        result
+    }
+
+    /// Parses a stack graph from JSON.  Besides the serialized form of this type, this accepts
+    /// the output of [`StackGraph::to_json`][graph::StackGraph::to_json], which includes the
+    /// singleton nodes, lists debug info entries directly, and leaves out the line ranges of
+    /// source positions.
+    pub fn from_json(json: &str) -> Result<StackGraph, JsonError> {
+        let mut value: Value = serde_json::from_str(json)?;
+        if let Some(nodes) = value.get_mut("nodes").and_then(Value::as_array_mut) {
+            nodes.retain(|node| {
+                let node_type = node.get("type").and_then(Value::as_str);
+                node_type != Some("root") && node_type != Some("jump_to_scope")
+            });
+            for node in nodes {
+                if let Some(debug_info) = node.get_mut("debug_info") {
+                    if debug_info.is_array() {
+                        *debug_info = json!({ "entries": debug_info.take() });
+                    }
+                }
+                if let Some(span) = node.pointer_mut("/source_info/span") {
+                    for position in ["start", "end"] {
+                        if let Some(position) =
+                            span.get_mut(position).and_then(Value::as_object_mut)
+                        {
+                            for range in ["containing_line", "trimmed_line"] {
+                                position
+                                    .entry(range)
+                                    .or_insert_with(|| json!({ "start": 0, "end": 0 }));
+                            }
+                        }
+                    }
+                }
+            }
+        }
+        Ok(serde_json::from_value(value)?)
+    }
+
+    /// Renames a file, and updates the IDs of all nodes that belong to it.  This allows reusing
+    /// the graph of a file for another file with the same content.
+    pub fn rename_file(&mut self, from: &str, to: &str) {
+        for file in &mut self.files {
+            if file == from {
+                *file = to.to_string();
+            }
+        }
+        for node in &mut self.nodes {
+            node.rename_file(from, to);
+        }
+        for edge in &mut self.edges {
+            edge.source.rename_file(from, to);
+            edge.sink.rename_file(from, to);
+        }
+    }
+
+    /// Loads the files, nodes, and edges into a stack graph.  Fails if any of the files are
+    /// already present in the stack graph.
+    pub fn load_into(&self, graph: &mut graph::StackGraph) -> Result<(), Error> {
+        for file in &self.files {
+            graph
+                .add_file(file)
+                .map_err(|_| Error::FileAlreadyPresent(file.clone()))?;
+        }
+        for node in &self.nodes {
+            node.load_into(graph)?;
+        }
+        for edge in &self.edges {
+            let source = edge.source.to_node(graph)?;
+            let sink = edge.sink.to_node(graph)?;
+            graph.add_edge(source, sink, edge.precedence);
+        }
+        Ok(())
+    }
+}
+
+/// Identifies a node by the name of its file and its local ID.  The singleton _root_ and _jump to
+/// scope_ nodes do not have a file.
+#[derive(Clone, Debug, Deserialize, Eq, Hash, Ord, PartialEq, PartialOrd, Serialize)]
+pub struct NodeID {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub file: Option<String>,
+    pub local_id: u32,
+}
+
+impl NodeID {
+    pub fn from_node_id(graph: &graph::StackGraph, id: graph::NodeID) -> NodeID {
+        NodeID {
+            file: id.file().map(|file| graph[file].name().to_string()),
+            local_id: id.local_id(),
+        }
+    }
+
+    /// Returns the node ID in the given stack graph, which must already contain this node's
+    /// file.
+    pub fn to_node_id(&self, graph: &graph::StackGraph) -> Result<graph::NodeID, Error> {
+        match &self.file {
+            Some(file) => {
+                let file = graph
+                    .get_file(file)
+                    .ok_or_else(|| Error::FileNotFound(file.clone()))?;
+                Ok(graph::NodeID::new_in_file(file, self.local_id))
+            }
+            None => {
+                let root = graph::NodeID::root();
+                let jump_to = graph::NodeID::jump_to();
+                if self.local_id == root.local_id() {
+                    Ok(root)
+                } else if self.local_id == jump_to.local_id() {
+                    Ok(jump_to)
+                } else {
+                    Err(Error::InvalidNodeID(self.local_id))
+                }
+            }
+        }
+    }
+
+    fn rename_file(&mut self, from: &str, to: &str) {
+        if self.file.as_deref() == Some(from) {
+            self.file = Some(to.to_string());
+        }
+    }
+
+    /// Returns the node in the given stack graph, which must already contain this node.
+    pub fn to_node(&self, graph: &graph::StackGraph) -> Result<Handle<graph::Node>, Error> {
+        let id = self.to_node_id(graph)?;
+        graph
+            .node_for_id(id)
+            .ok_or_else(|| Error::NodeNotFound(self.clone()))
+    }
+}
+
+impl std::fmt::Display for NodeID {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match &self.file {
+            Some(file) => write!(f, "{}({})", file, self.local_id),
+            None => write!(f, "[{}]", self.local_id),
+        }
+    }
+}
+
+/// A node in a stack graph, along with its source and debug info.
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum Node {
+    DropScopes {
+        id: NodeID,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+    PopScopedSymbol {
+        id: NodeID,
+        symbol: String,
+        is_definition: bool,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+    PopSymbol {
+        id: NodeID,
+        symbol: String,
+        is_definition: bool,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+    PushScopedSymbol {
+        id: NodeID,
+        symbol: String,
+        scope: NodeID,
+        is_reference: bool,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+    PushSymbol {
+        id: NodeID,
+        symbol: String,
+        is_reference: bool,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+    Scope {
+        id: NodeID,
+        is_exported: bool,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        source_info: Option<SourceInfo>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        debug_info: Option<DebugInfo>,
+    },
+}
+
+impl Node {
+    fn rename_file(&mut self, from: &str, to: &str) {
+        match self {
+            Node::DropScopes { id, .. }
+            | Node::PopScopedSymbol { id, .. }
+            | Node::PopSymbol { id, .. }
+            | Node::PushSymbol { id, .. }
+            | Node::Scope { id, .. } => id.rename_file(from, to),
+            Node::PushScopedSymbol { id, scope, .. } => {
+                id.rename_file(from, to);
+                scope.rename_file(from, to);
+            }
+        }
+    }
+
+    /// Returns the serializable form of a node.  Panics if the node is the singleton _root_ or
+    /// _jump to scope_ node, which are implicitly part of every stack graph.
+    pub fn from_node(graph: &graph::StackGraph, handle: Handle<graph::Node>) -> Node {
+        let id = NodeID::from_node_id(graph, graph[handle].id());
+        let source_info = graph
+            .source_info(handle)
+            .map(|si| SourceInfo::from_source_info(graph, si));
+        let debug_info = graph
+            .debug_info(handle)
+            .map(|di| DebugInfo::from_debug_info(graph, di))
+            .filter(|di| !di.entries.is_empty());
+        match &graph[handle] {
+            graph::Node::DropScopes(_) => Node::DropScopes {
+                id,
+                source_info,
+                debug_info,
+            },
+            graph::Node::PopScopedSymbol(node) => Node::PopScopedSymbol {
+                id,
+                symbol: graph[node.symbol].to_string(),
+                is_definition: node.is_definition,
+                source_info,
+                debug_info,
+            },
+            graph::Node::PopSymbol(node) => Node::PopSymbol {
+                id,
+                symbol: graph[node.symbol].to_string(),
+                is_definition: node.is_definition,
+                source_info,
+                debug_info,
+            },
+            graph::Node::PushScopedSymbol(node) => Node::PushScopedSymbol {
+                id,
+                symbol: graph[node.symbol].to_string(),
+                scope: NodeID::from_node_id(graph, node.scope),
+                is_reference: node.is_reference,
+                source_info,
+                debug_info,
+            },
+            graph::Node::PushSymbol(node) => Node::PushSymbol {
+                id,
+                symbol: graph[node.symbol].to_string(),
+                is_reference: node.is_reference,
+                source_info,
+                debug_info,
+            },
+            graph::Node::Scope(node) => Node::Scope {
+                id,
+                is_exported: node.is_exported,
+                source_info,
+                debug_info,
+            },
+            graph::Node::JumpTo(_) | graph::Node::Root(_) => {
+                panic!("Cannot serialize singleton node {}", id)
+            }
+        }
+    }
+
+    fn id(&self) -> &NodeID {
+        match self {
+            Node::DropScopes { id, .. } => id,
+            Node::PopScopedSymbol { id, .. } => id,
+            Node::PopSymbol { id, .. } => id,
+            Node::PushScopedSymbol { id, .. } => id,
+            Node::PushSymbol { id, .. } => id,
+            Node::Scope { id, .. } => id,
+        }
+    }
+
+    fn source_info(&self) -> Option<&SourceInfo> {
+        match self {
+            Node::DropScopes { source_info, .. } => source_info.as_ref(),
+            Node::PopScopedSymbol { source_info, .. } => source_info.as_ref(),
+            Node::PopSymbol { source_info, .. } => source_info.as_ref(),
+            Node::PushScopedSymbol { source_info, .. } => source_info.as_ref(),
+            Node::PushSymbol { source_info, .. } => source_info.as_ref(),
+            Node::Scope { source_info, .. } => source_info.as_ref(),
+        }
+    }
+
+    fn debug_info(&self) -> Option<&DebugInfo> {
+        match self {
+            Node::DropScopes { debug_info, .. } => debug_info.as_ref(),
+            Node::PopScopedSymbol { debug_info, .. } => debug_info.as_ref(),
+            Node::PopSymbol { debug_info, .. } => debug_info.as_ref(),
+            Node::PushScopedSymbol { debug_info, .. } => debug_info.as_ref(),
+            Node::PushSymbol { debug_info, .. } => debug_info.as_ref(),
+            Node::Scope { debug_info, .. } => debug_info.as_ref(),
+        }
+    }
+
+    /// Adds this node to a stack graph, which must already contain the node's file.
+    pub fn load_into(&self, graph: &mut graph::StackGraph) -> Result<Handle<graph::Node>, Error> {
+        let id = self.id().to_node_id(graph)?;
+        let handle = match self {
+            Node::DropScopes { .. } => graph.add_drop_scopes_node(id),
+            Node::PopScopedSymbol {
+                symbol,
+                is_definition,
+                ..
+            } => {
+                let symbol = graph.add_symbol(symbol);
+                graph.add_pop_scoped_symbol_node(id, symbol, *is_definition)
+            }
+            Node::PopSymbol {
+                symbol,
+                is_definition,
+                ..
+            } => {
+                let symbol = graph.add_symbol(symbol);
+                graph.add_pop_symbol_node(id, symbol, *is_definition)
+            }
+            Node::PushScopedSymbol {
+                symbol,
+                scope,
+                is_reference,
+                ..
+            } => {
+                let symbol = graph.add_symbol(symbol);
+                let scope = scope.to_node_id(graph)?;
+                graph.add_push_scoped_symbol_node(id, symbol, scope, *is_reference)
+            }
+            Node::PushSymbol {
+                symbol,
+                is_reference,
+                ..
+            } => {
+                let symbol = graph.add_symbol(symbol);
+                graph.add_push_symbol_node(id, symbol, *is_reference)
+            }
+            Node::Scope { is_exported, .. } => graph.add_scope_node(id, *is_exported),
+        }
+        .ok_or_else(|| Error::NodeAlreadyPresent(self.id().clone()))?;
+        if let Some(source_info) = self.source_info() {
+            source_info.load_into(graph, handle);
+        }
+        if let Some(debug_info) = self.debug_info() {
+            debug_info.load_into(graph, handle);
+        }
+        Ok(handle)
+    }
+}
+
+/// Information about the source code that a node represents.
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct SourceInfo {
+    pub span: Span,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub syntax_type: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub containing_line: Option<String>,
+    #[serde(default, skip_serializing_if = "is_default_span")]
+    pub definiens_span: Span,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub documentation: Option<String>,
+}
+
+fn is_default_span(span: &Span) -> bool {
+    *span == Span::default()
+}
+
+impl SourceInfo {
+    fn from_source_info(graph: &graph::StackGraph, source_info: &graph::SourceInfo) -> SourceInfo {
+        SourceInfo {
+            span: source_info.span.clone(),
+            syntax_type: source_info.syntax_type.map(|s| graph[s].to_string()),
+            containing_line: source_info
+                .containing_line
+                .into_option()
+                .map(|s| graph[s].to_string()),
+            definiens_span: source_info.definiens_span.clone(),
+            documentation: source_info.documentation.map(|s| graph[s].to_string()),
+        }
+    }
+
+    fn load_into(&self, graph: &mut graph::StackGraph, node: Handle<graph::Node>) {
+        let syntax_type = self.syntax_type.as_ref().map(|s| graph.add_string(s));
+        let containing_line = self.containing_line.as_ref().map(|s| graph.add_string(s));
+        let documentation = self.documentation.as_ref().map(|s| graph.add_string(s));
+        let source_info = graph.source_info_mut(node);
+        source_info.span = self.span.clone();
+        source_info.syntax_type = syntax_type;
+        source_info.containing_line = containing_line.into();
+        source_info.definiens_span = self.definiens_span.clone();
+        source_info.documentation = documentation;
+    }
+}
+
+/// Debug info about a node, as key-value pairs of strings.
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct DebugInfo {
+    pub entries: Vec<DebugEntry>,
+}
+
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct DebugEntry {
+    pub key: String,
+    pub value: String,
+}
+
+impl DebugInfo {
+    fn from_debug_info(graph: &graph::StackGraph, debug_info: &graph::DebugInfo) -> DebugInfo {
+        DebugInfo {
+            entries: debug_info
+                .iter()
+                .map(|e| DebugEntry {
+                    key: graph[e.key].to_string(),
+                    value: graph[e.value].to_string(),
+                })
+                .collect(),
+        }
+    }
+
+    fn load_into(&self, graph: &mut graph::StackGraph, node: Handle<graph::Node>) {
+        for entry in &self.entries {
+            let key = graph.add_string(&entry.key);
+            let value = graph.add_string(&entry.value);
+            graph.debug_info_mut(node).add(key, value);
+        }
+    }
+}
+
+/// An edge between two nodes.
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct Edge {
+    pub source: NodeID,
+    pub sink: NodeID,
+    #[serde(default)]
+    pub precedence: i32,
+}
+
+//-------------------------------------------------------------------------------------------------
+// Partial paths
+
+/// A partial path, whose nodes are identified by file name and local ID.
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct PartialPath {
+    pub start_node: NodeID,
+    pub end_node: NodeID,
+    pub symbol_stack_precondition: PartialSymbolStack,
+    pub symbol_stack_postcondition: PartialSymbolStack,
+    pub scope_stack_precondition: PartialScopeStack,
+    pub scope_stack_postcondition: PartialScopeStack,
+    pub edges: Vec<PartialPathEdge>,
+}
+
+impl PartialPath {
+    pub fn from_partial_path(
+        graph: &graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+        path: &partial::PartialPath,
+    ) -> PartialPath {
+        PartialPath {
+            start_node: NodeID::from_node_id(graph, graph[path.start_node].id()),
+            end_node: NodeID::from_node_id(graph, graph[path.end_node].id()),
+            symbol_stack_precondition: PartialSymbolStack::from_partial_symbol_stack(
+                graph,
+                partials,
+                &path.symbol_stack_precondition,
+            ),
+            symbol_stack_postcondition: PartialSymbolStack::from_partial_symbol_stack(
+                graph,
+                partials,
+                &path.symbol_stack_postcondition,
+            ),
+            scope_stack_precondition: PartialScopeStack::from_partial_scope_stack(
+                graph,
+                partials,
+                &path.scope_stack_precondition,
+            ),
+            scope_stack_postcondition: PartialScopeStack::from_partial_scope_stack(
+                graph,
+                partials,
+                &path.scope_stack_postcondition,
+            ),
+            edges: path
+                .edges
+                .iter(partials)
+                .map(|edge| PartialPathEdge {
+                    source: NodeID::from_node_id(graph, edge.source_node_id),
+                    precedence: edge.precedence,
+                })
+                .collect(),
+        }
+    }
+
+    /// Renames a file in the IDs of all nodes that the path refers to.
+    pub fn rename_file(&mut self, from: &str, to: &str) {
+        self.start_node.rename_file(from, to);
+        self.end_node.rename_file(from, to);
+        self.symbol_stack_precondition.rename_file(from, to);
+        self.symbol_stack_postcondition.rename_file(from, to);
+        self.scope_stack_precondition.rename_file(from, to);
+        self.scope_stack_postcondition.rename_file(from, to);
+        for edge in &mut self.edges {
+            edge.source.rename_file(from, to);
+        }
+    }
+
+    /// Returns the partial path in the given stack graph, which must already contain all of the
+    /// nodes that the path refers to.
+    pub fn to_partial_path(
+        &self,
+        graph: &mut graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+    ) -> Result<partial::PartialPath, Error> {
+        let mut edges = partial::PartialPathEdgeList::empty();
+        for edge in &self.edges {
+            let edge = partial::PartialPathEdge {
+                source_node_id: edge.source.to_node_id(graph)?,
+                precedence: edge.precedence,
+            };
+            edges.push_back(partials, edge);
+        }
+        Ok(partial::PartialPath {
+            start_node: self.start_node.to_node(graph)?,
+            end_node: self.end_node.to_node(graph)?,
+            symbol_stack_precondition: self
+                .symbol_stack_precondition
+                .to_partial_symbol_stack(graph, partials)?,
+            symbol_stack_postcondition: self
+                .symbol_stack_postcondition
+                .to_partial_symbol_stack(graph, partials)?,
+            scope_stack_precondition: self
+                .scope_stack_precondition
+                .to_partial_scope_stack(graph, partials)?,
+            scope_stack_postcondition: self
+                .scope_stack_postcondition
+                .to_partial_scope_stack(graph, partials)?,
+            edges,
+        })
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct PartialSymbolStack {
+    pub symbols: Vec<PartialScopedSymbol>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub variable: Option<u32>,
+}
+
+impl PartialSymbolStack {
+    fn rename_file(&mut self, from: &str, to: &str) {
+        for symbol in &mut self.symbols {
+            if let Some(scopes) = &mut symbol.scopes {
+                scopes.rename_file(from, to);
+            }
+        }
+    }
+
+    fn from_partial_symbol_stack(
+        graph: &graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+        stack: &partial::PartialSymbolStack,
+    ) -> PartialSymbolStack {
+        let symbols = stack.iter(partials).collect::<Vec<_>>();
+        PartialSymbolStack {
+            symbols: symbols
+                .into_iter()
+                .map(|symbol| {
+                    PartialScopedSymbol::from_partial_scoped_symbol(graph, partials, symbol)
+                })
+                .collect(),
+            variable: stack.variable().map(|v| v.into()),
+        }
+    }
+
+    fn to_partial_symbol_stack(
+        &self,
+        graph: &mut graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+    ) -> Result<partial::PartialSymbolStack, Error> {
+        let mut stack = match self.variable {
+            Some(variable) => partial::PartialSymbolStack::from_variable(
+                partial::SymbolStackVariable::new(variable)
+                    .ok_or(Error::InvalidVariable(variable))?,
+            ),
+            None => partial::PartialSymbolStack::empty(),
+        };
+        for symbol in &self.symbols {
+            let symbol = symbol.to_partial_scoped_symbol(graph, partials)?;
+            stack.push_back(partials, symbol);
+        }
+        Ok(stack)
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct PartialScopedSymbol {
+    pub symbol: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub scopes: Option<PartialScopeStack>,
+}
+
+impl PartialScopedSymbol {
+    fn from_partial_scoped_symbol(
+        graph: &graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+        symbol: partial::PartialScopedSymbol,
+    ) -> PartialScopedSymbol {
+        PartialScopedSymbol {
+            symbol: graph[symbol.symbol].to_string(),
+            scopes: symbol.scopes.into_option().map(|scopes| {
+                PartialScopeStack::from_partial_scope_stack(graph, partials, &scopes)
+            }),
+        }
+    }
+
+    fn to_partial_scoped_symbol(
+        &self,
+        graph: &mut graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+    ) -> Result<partial::PartialScopedSymbol, Error> {
+        let scopes = match &self.scopes {
+            Some(scopes) => Some(scopes.to_partial_scope_stack(graph, partials)?),
+            None => None,
+        };
+        Ok(partial::PartialScopedSymbol {
+            symbol: graph.add_symbol(&self.symbol),
+            scopes: scopes.into(),
+        })
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct PartialScopeStack {
+    pub scopes: Vec<NodeID>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub variable: Option<u32>,
+}
+
+impl PartialScopeStack {
+    fn rename_file(&mut self, from: &str, to: &str) {
+        for scope in &mut self.scopes {
+            scope.rename_file(from, to);
+        }
+    }
+
+    fn from_partial_scope_stack(
+        graph: &graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+        stack: &partial::PartialScopeStack,
+    ) -> PartialScopeStack {
+        PartialScopeStack {
+            scopes: stack
+                .iter_scopes(partials)
+                .map(|node| NodeID::from_node_id(graph, graph[node].id()))
+                .collect(),
+            variable: stack.variable().map(|v| v.into()),
+        }
+    }
+
+    fn to_partial_scope_stack(
+        &self,
+        graph: &graph::StackGraph,
+        partials: &mut partial::PartialPaths,
+    ) -> Result<partial::PartialScopeStack, Error> {
+        let mut stack = match self.variable {
+            Some(variable) => partial::PartialScopeStack::from_variable(
+                partial::ScopeStackVariable::new(variable)
+                    .ok_or(Error::InvalidVariable(variable))?,
+            ),
+            None => partial::PartialScopeStack::empty(),
+        };
+        for scope in &self.scopes {
+            let scope = scope.to_node(graph)?;
+            stack.push_back(partials, scope);
+        }
+        Ok(stack)
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Eq, PartialEq, Serialize)]
+pub struct PartialPathEdge {
+    pub source: NodeID,
+    #[serde(default)]
+    pub precedence: i32,
+}
@@ -0,0 +1,94 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Renders stack graphs in a canonical textual format, suitable for snapshot testing.
+//!
+//! The rendering lists every node and every edge of the included files on a separate line.  Nodes
+//! are sorted by file name and local ID, and edges are sorted by their source and sink nodes, so
+//! that the rendering of a graph does not depend on the order in which its nodes and edges were
+//! added.  This makes it possible to commit the rendering of a graph as a “golden” file, and to
+//! detect any unintended changes to the graph by comparing against it.
+
+use std::fmt::Write as _;
+
+use crate::arena::Handle;
+use crate::graph::File;
+use crate::graph::Node;
+use crate::graph::StackGraph;
+
+impl StackGraph {
+    /// Returns the canonical textual rendering of the nodes and edges in the files for which the
+    /// filter returns `true`.  Edges are included if their source node is, which means that edges
+    /// to the singleton _root_ and _jump to scope_ nodes are included as well.
+    pub fn to_snapshot(&self, filter: &dyn Fn(&StackGraph, &Handle<File>) -> bool) -> String {
+        let mut nodes = Vec::new();
+        let mut edges = Vec::new();
+        for node in self.iter_nodes() {
+            let file = match self[node].file() {
+                Some(file) => file,
+                None => continue,
+            };
+            if !filter(self, &file) {
+                continue;
+            }
+            nodes.push((self.stable_node_key(node), self.snapshot_node_line(node)));
+            for edge in self.outgoing_edges(node) {
+                // Nodes are described in full in their own lines, so edges only show node IDs.
+                let mut line = format!(
+                    "{:#} -> {:#}",
+                    self[edge.source].display(self),
+                    self[edge.sink].display(self)
+                );
+                if edge.precedence != 0 {
+                    write!(&mut line, " precedence {}", edge.precedence).unwrap();
+                }
+                edges.push((
+                    (
+                        self.stable_node_key(edge.source),
+                        self.stable_node_key(edge.sink),
+                    ),
+                    line,
+                ));
+            }
+        }
+        nodes.sort();
+        edges.sort();
+
+        let mut result = String::new();
+        result.push_str("nodes:\n");
+        for (_, line) in nodes {
+            writeln!(&mut result, "  {}", line).unwrap();
+        }
+        result.push_str("edges:\n");
+        for (_, line) in edges {
+            writeln!(&mut result, "  {}", line).unwrap();
+        }
+        result
+    }
+
+    fn snapshot_node_line(&self, node: Handle<Node>) -> String {
+        let mut line = format!("{}", self[node].display(self));
+        if let Some(source_info) = self.source_info(node) {
+            let span = &source_info.span;
+            if *span != lsp_positions::Span::default() {
+                write!(
+                    &mut line,
+                    " at {}:{}-{}:{}",
+                    span.start.line + 1,
+                    span.start.column.grapheme_offset + 1,
+                    span.end.line + 1,
+                    span.end.column.grapheme_offset + 1,
+                )
+                .unwrap();
+            }
+            if let Some(syntax_type) = source_info.syntax_type {
+                write!(&mut line, " syntax_type {}", &self[syntax_type]).unwrap();
+            }
+        }
+        line
+    }
+}
@@ -49,6 +49,7 @@ use crate::arena::ListArena;
 use crate::arena::ListCell;
 use crate::arena::SupplementalArena;
 use crate::cycles::CycleDetector;
+use crate::cycles::CycleDetectorStats;
 use crate::graph::Node;
 use crate::graph::StackGraph;
 use crate::graph::Symbol;
@@ -424,6 +425,127 @@ impl<'a> Display for DisplaySymbolStackKey<'a> {
     }
 }
 
+//-------------------------------------------------------------------------------------------------
+// Limits
+
+/// Bounds the total amount of work that a path stitcher is allowed to perform.
+///
+/// Unlike `set_max_work_per_phase`, which only controls how much work we perform _during each
+/// phase_, these limits apply to the path-stitching algorithm as a whole.  Once a limit is
+/// reached, the stitcher stops processing paths, and the paths that it has found so far should be
+/// treated as a possibly incomplete set of results.
+///
+/// By default, none of the limits are set, and the algorithm runs until there is no more work to
+/// do.
+#[derive(Clone, Copy, Debug, Default, Eq, PartialEq)]
+pub struct StitchingLimits {
+    /// The maximum number of phases to process, not counting the initial phase that is performed
+    /// when the stitcher is created.
+    pub max_phases: Option<usize>,
+    /// The maximum number of (possibly incomplete) paths to process, across all phases.  Each
+    /// processed path causes us to visit its end node and look for extensions.
+    pub max_processed_paths: Option<usize>,
+    /// The maximum number of complete paths to produce.  This limit is enforced by the
+    /// `find_all_complete_paths_with_limits` methods, since the stitchers themselves leave it up
+    /// to you to decide which paths count as results.
+    pub max_complete_paths: Option<usize>,
+}
+
+/// Identifies which of the [`StitchingLimits`][] caused a path stitcher to stop early.
+///
+/// [`StitchingLimits`]: struct.StitchingLimits.html
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum StitchingLimit {
+    Phases,
+    ProcessedPaths,
+    CompletePaths,
+}
+
+impl std::fmt::Display for StitchingLimit {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            Self::Phases => write!(f, "maximum number of phases"),
+            Self::ProcessedPaths => write!(f, "maximum number of processed paths"),
+            Self::CompletePaths => write!(f, "maximum number of complete paths"),
+        }
+    }
+}
+
+/// The result of running a path-stitching algorithm to completion, or until it reached one of its
+/// [`StitchingLimits`][].
+///
+/// [`StitchingLimits`]: struct.StitchingLimits.html
+pub struct StitchingResult<P> {
+    /// The complete paths that were found.
+    pub paths: Vec<P>,
+    /// The limit that caused the algorithm to stop early, if any.
+    pub limit_reached: Option<StitchingLimit>,
+}
+
+impl<P> StitchingResult<P> {
+    /// Returns whether the algorithm stopped before processing all of the paths that it could
+    /// have, which means that there might be additional complete paths that are missing from the
+    /// result.
+    pub fn is_possibly_incomplete(&self) -> bool {
+        self.limit_reached.is_some()
+    }
+}
+
+/// Keeps track of how much work a path stitcher has performed, compared to its limits.
+struct Progress {
+    limits: StitchingLimits,
+    phases: usize,
+    processed_paths: usize,
+    limit_reached: Option<StitchingLimit>,
+}
+
+impl Progress {
+    fn new() -> Progress {
+        Progress {
+            limits: StitchingLimits::default(),
+            phases: 0,
+            processed_paths: 0,
+            limit_reached: None,
+        }
+    }
+
+    /// Records the start of a new phase, returning whether we're allowed to process it.
+    fn start_phase(&mut self, has_work: bool) -> bool {
+        if self.limit_reached.is_some() {
+            return false;
+        }
+        if let Some(max_phases) = self.limits.max_phases {
+            if self.phases >= max_phases {
+                if has_work {
+                    self.limit_reached = Some(StitchingLimit::Phases);
+                }
+                return false;
+            }
+        }
+        self.phases += 1;
+        true
+    }
+
+    /// Returns whether we're allowed to process another path.  This is checked before the cycle
+    /// detector sees the path, so that paths that we will never process do not show up in its
+    /// statistics.
+    fn can_process_path(&mut self) -> bool {
+        if let Some(max_processed_paths) = self.limits.max_processed_paths {
+            if self.processed_paths >= max_processed_paths {
+                self.limit_reached = Some(StitchingLimit::ProcessedPaths);
+                return false;
+            }
+        }
+        true
+    }
+
+    /// Records that we're processing a path.  Paths that the cycle detector rejects are not
+    /// counted.
+    fn process_path(&mut self) {
+        self.processed_paths += 1;
+    }
+}
+
 //-------------------------------------------------------------------------------------------------
 // Stitching partial paths together
 
@@ -459,6 +581,7 @@ pub struct PathStitcher {
     next_iteration: VecDeque<Path>,
     cycle_detector: CycleDetector<Path>,
     max_work_per_phase: usize,
+    progress: Progress,
     #[cfg(feature = "copious-debugging")]
     phase_number: usize,
 }
@@ -505,6 +628,7 @@ impl PathStitcher {
             cycle_detector: CycleDetector::new(),
             // By default, there's no artificial bound on the amount of work done per phase
             max_work_per_phase: usize::MAX,
+            progress: Progress::new(),
             #[cfg(feature = "copious-debugging")]
             phase_number: 1,
         }
@@ -539,6 +663,29 @@ impl PathStitcher {
         self.max_work_per_phase = max_work_per_phase;
     }
 
+    /// Returns statistics about the paths that the cycle detector has accepted or rejected so
+    /// far.  Rejected paths are either exact duplicates of paths that we've already processed, or
+    /// are "similar" to too many shorter paths with the same start and end nodes, which typically
+    /// means that they are going around a cycle in the graph.
+    pub fn cycle_detector_stats(&self) -> CycleDetectorStats {
+        self.cycle_detector.stats()
+    }
+
+    /// Sets the limits on the total amount of work that this stitcher can perform.  Once a limit
+    /// is reached, any remaining work is discarded, and [`limit_reached`][] tells you which limit
+    /// caused us to stop.
+    ///
+    /// [`limit_reached`]: #method.limit_reached
+    pub fn set_limits(&mut self, limits: StitchingLimits) {
+        self.progress.limits = limits;
+    }
+
+    /// Returns the limit that caused this stitcher to stop early, if any.  If this returns
+    /// `Some`, then the paths found by this stitcher are possibly incomplete.
+    pub fn limit_reached(&self) -> Option<StitchingLimit> {
+        self.progress.limit_reached
+    }
+
     /// Attempts to extend one path as part of the path-stitching algorithm.  When calling this
     /// function, you are responsible for ensuring that `db` already contains all of the possible
     /// partial paths that we might want to extend `path` with.
@@ -618,14 +765,25 @@ impl PathStitcher {
     ) {
         copious_debugging!("==> Start phase {}", self.phase_number);
         self.queue.extend(self.next_iteration.drain(..));
+        if !self.progress.start_phase(!self.queue.is_empty()) {
+            copious_debugging!("    Limit reached");
+            self.queue.clear();
+            return;
+        }
         let mut work_performed = 0;
         while let Some(path) = self.queue.pop_front() {
+            if !self.progress.can_process_path() {
+                copious_debugging!("    Limit reached");
+                self.queue.clear();
+                break;
+            }
             if !self
                 .cycle_detector
                 .should_process_path(&path, |probe| probe.cmp(graph, paths, &path))
             {
                 continue;
             }
+            self.progress.process_path();
             work_performed += self.stitch_path(graph, paths, partials, db, &path);
             if work_performed >= self.max_work_per_phase {
                 break;
@@ -656,19 +814,63 @@ impl PathStitcher {
         db: &mut Database,
         starting_nodes: I,
     ) -> Vec<Path>
+    where
+        I: IntoIterator<Item = Handle<Node>>,
+    {
+        Self::find_all_complete_paths_with_limits(
+            graph,
+            paths,
+            partials,
+            db,
+            starting_nodes,
+            StitchingLimits::default(),
+        )
+        .paths
+    }
+
+    /// Returns the complete paths that are reachable from a set of starting nodes, building them
+    /// up by stitching together partial paths from this database, and stopping early if any of
+    /// the given limits are reached.
+    ///
+    /// As with [`find_all_complete_paths`][], your database must already contain all partial
+    /// paths that might be needed.  The result tells you whether the search was cut short, in
+    /// which case there might be additional complete paths that we did not find.
+    ///
+    /// [`find_all_complete_paths`]: #method.find_all_complete_paths
+    pub fn find_all_complete_paths_with_limits<I>(
+        graph: &StackGraph,
+        paths: &mut Paths,
+        partials: &mut PartialPaths,
+        db: &mut Database,
+        starting_nodes: I,
+        limits: StitchingLimits,
+    ) -> StitchingResult<Path>
     where
         I: IntoIterator<Item = Handle<Node>>,
     {
         let mut result = Vec::new();
         let mut stitcher = PathStitcher::new(graph, paths, partials, db, starting_nodes);
+        stitcher.set_limits(limits);
         while !stitcher.is_complete() {
             let complete_paths = stitcher
                 .previous_phase_paths()
                 .filter(|path| path.is_complete(graph));
             result.extend(complete_paths.cloned());
+            if let Some(max_complete_paths) = limits.max_complete_paths {
+                if result.len() > max_complete_paths {
+                    result.truncate(max_complete_paths);
+                    return StitchingResult {
+                        paths: result,
+                        limit_reached: Some(StitchingLimit::CompletePaths),
+                    };
+                }
+            }
             stitcher.process_next_phase(graph, paths, partials, db);
         }
-        result
+        StitchingResult {
+            paths: result,
+            limit_reached: stitcher.limit_reached(),
+        }
     }
 }
 
@@ -707,6 +909,7 @@ pub struct ForwardPartialPathStitcher {
     next_iteration: VecDeque<PartialPath>,
     cycle_detector: CycleDetector<PartialPath>,
     max_work_per_phase: usize,
+    progress: Progress,
     #[cfg(feature = "copious-debugging")]
     phase_number: usize,
 }
@@ -758,6 +961,7 @@ impl ForwardPartialPathStitcher {
             cycle_detector: CycleDetector::new(),
             // By default, there's no artificial bound on the amount of work done per phase
             max_work_per_phase: usize::MAX,
+            progress: Progress::new(),
             #[cfg(feature = "copious-debugging")]
             phase_number: 1,
         }
@@ -783,6 +987,7 @@ impl ForwardPartialPathStitcher {
             cycle_detector: CycleDetector::new(),
             // By default, there's no artificial bound on the amount of work done per phase
             max_work_per_phase: usize::MAX,
+            progress: Progress::new(),
             #[cfg(feature = "copious-debugging")]
             phase_number: 1,
         }
@@ -817,6 +1022,29 @@ impl ForwardPartialPathStitcher {
         self.max_work_per_phase = max_work_per_phase;
     }
 
+    /// Returns statistics about the partial paths that the cycle detector has accepted or
+    /// rejected so far.  Rejected partial paths are either exact duplicates of partial paths that
+    /// we've already processed, or are "similar" to too many shorter partial paths with the same
+    /// start and end nodes, which typically means that they are going around a cycle in the graph.
+    pub fn cycle_detector_stats(&self) -> CycleDetectorStats {
+        self.cycle_detector.stats()
+    }
+
+    /// Sets the limits on the total amount of work that this stitcher can perform.  Once a limit
+    /// is reached, any remaining work is discarded, and [`limit_reached`][] tells you which limit
+    /// caused us to stop.
+    ///
+    /// [`limit_reached`]: #method.limit_reached
+    pub fn set_limits(&mut self, limits: StitchingLimits) {
+        self.progress.limits = limits;
+    }
+
+    /// Returns the limit that caused this stitcher to stop early, if any.  If this returns
+    /// `Some`, then the paths found by this stitcher are possibly incomplete.
+    pub fn limit_reached(&self) -> Option<StitchingLimit> {
+        self.progress.limit_reached
+    }
+
     /// Attempts to extend one partial path as part of the algorithm.  When calling this function,
     /// you are responsible for ensuring that `db` already contains all of the possible partial
     /// paths that we might want to extend `partial_path` with.
@@ -909,12 +1137,22 @@ impl ForwardPartialPathStitcher {
     ) {
         copious_debugging!("==> Start phase {}", self.phase_number);
         self.queue.extend(self.next_iteration.drain(..));
+        if !self.progress.start_phase(!self.queue.is_empty()) {
+            copious_debugging!("    Limit reached");
+            self.queue.clear();
+            return;
+        }
         let mut work_performed = 0;
         while let Some(partial_path) = self.queue.pop_front() {
             copious_debugging!(
                 "--> Candidate partial path {}",
                 partial_path.display(graph, partials)
             );
+            if !self.progress.can_process_path() {
+                copious_debugging!("    Limit reached");
+                self.queue.clear();
+                break;
+            }
             if !self
                 .cycle_detector
                 .should_process_path(&partial_path, |probe| {
@@ -924,6 +1162,7 @@ impl ForwardPartialPathStitcher {
                 copious_debugging!("    Cycle detected");
                 continue;
             }
+            self.progress.process_path();
             work_performed += self.stitch_partial_path(graph, partials, db, &partial_path);
             if work_performed >= self.max_work_per_phase {
                 break;
@@ -953,19 +1192,62 @@ impl ForwardPartialPathStitcher {
         db: &mut Database,
         starting_nodes: I,
     ) -> Vec<PartialPath>
+    where
+        I: IntoIterator<Item = Handle<Node>>,
+    {
+        Self::find_all_complete_partial_paths_with_limits(
+            graph,
+            partials,
+            db,
+            starting_nodes,
+            StitchingLimits::default(),
+        )
+        .paths
+    }
+
+    /// Returns the complete partial paths that are reachable from a set of starting nodes,
+    /// building them up by stitching together partial paths from this database, and stopping
+    /// early if any of the given limits are reached.
+    ///
+    /// As with [`find_all_complete_partial_paths`][], your database must already contain all
+    /// partial paths that might be needed.  The result tells you whether the search was cut
+    /// short, in which case there might be additional complete partial paths that we did not
+    /// find.
+    ///
+    /// [`find_all_complete_partial_paths`]: #method.find_all_complete_partial_paths
+    pub fn find_all_complete_partial_paths_with_limits<I>(
+        graph: &StackGraph,
+        partials: &mut PartialPaths,
+        db: &mut Database,
+        starting_nodes: I,
+        limits: StitchingLimits,
+    ) -> StitchingResult<PartialPath>
     where
         I: IntoIterator<Item = Handle<Node>>,
     {
         let mut result = Vec::new();
         let mut stitcher =
             ForwardPartialPathStitcher::from_nodes(graph, partials, db, starting_nodes);
+        stitcher.set_limits(limits);
         while !stitcher.is_complete() {
             let complete_partial_paths = stitcher
                 .previous_phase_partial_paths()
                 .filter(|partial_path| partial_path.is_complete(graph));
             result.extend(complete_partial_paths.cloned());
+            if let Some(max_complete_paths) = limits.max_complete_paths {
+                if result.len() > max_complete_paths {
+                    result.truncate(max_complete_paths);
+                    return StitchingResult {
+                        paths: result,
+                        limit_reached: Some(StitchingLimit::CompletePaths),
+                    };
+                }
+            }
             stitcher.process_next_phase(graph, partials, db);
         }
-        result
+        StitchingResult {
+            paths: result,
+            limit_reached: stitcher.limit_reached(),
+        }
     }
 }
@@ -0,0 +1,1642 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines a SQLite database that stores stack graphs and partial paths for individual files.
+//!
+//! Indexing a file produces its stack graph and the partial paths within it, which only depend on
+//! the content of that file.  We store both, keyed by the file's name, together with a _tag_ that
+//! identifies the version of the file that they were computed from.  Indexers can use the tag to
+//! skip files that have not changed.  If tags identify the content of files, the stored data of a
+//! file can also be reused for other files with the same content, see
+//! [`SQLiteWriter::copy_file`][].  Files that failed to index are recorded as well, together with
+//! a structured description of the failure, so that the status of every file can be reported.  To
+//! answer queries, a [`SQLiteReader`][] loads the stored graphs and partial paths back into a stack
+//! graph and a partial path database, which can then be used for path stitching.
+//!
+//! Paths that leave a file do so through the root node, and continue with a partial path of
+//! another file that starts at the root node.  The database indexes those _root paths_ by the
+//! symbol at the top of their symbol stack precondition, so that a reader only has to load the
+//! files that can contain the continuation of a path, instead of the whole database.  Every file
+//! also stores a bloom filter of the symbols that it references or defines, which lets readers
+//! skip most files that cannot contain a reference to a symbol without loading them.
+//!
+//! The symbols, syntax types, and spans of all definitions are stored in a separate table, which
+//! can be searched by name without loading any graphs.  This supports workspace-wide symbol
+//! search, see [`SQLiteReader::find_definitions`][].  Symbols are indexed ignoring the case of
+//! ASCII letters, so that symbols that start with a query are found without scanning the table.
+//!
+//! Graphs and partial paths are stored using their serializable mirrors from the [`serde`][]
+//! module, encoded as JSON and compressed with zstd, which makes them several times smaller.
+//! They are decompressed transparently when they are loaded.
+//!
+//! Resolving references requires path stitching across files, which is the most expensive part
+//! of answering a query.  Indexers can therefore store the definitions that the references of a
+//! file resolve to, see [`SQLiteWriter::store_resolutions_for_file`][], after which queries can
+//! look them up in both directions.  Resolutions depend on the contents of other files, so all
+//! stored resolutions are removed whenever the stored graph or partial paths of any file change.
+//! Storing a file again with an identical graph and identical partial paths, as happens when
+//! unchanged files are indexed again, keeps them, and only updates the status of the file.
+//!
+//! Together with the number of references, definitions, and lines that are recorded for every
+//! file, the stored resolutions also measure how well the references of each file resolve, see
+//! [`SQLiteReader::coverage_all`][].
+//!
+//! Databases use write-ahead logging, so that readers can read a database while a writer is
+//! writing to it.  Readers that stay open, e.g., in a server, can discard data that another
+//! connection changed, and keep it while only other files change, see
+//! [`SQLiteReader::clear_if_changed`][].
+//!
+//! References often resolve to definitions in other projects, such as libraries.  A reader can
+//! consult the databases of those projects as read-only _dependencies_, see
+//! [`SQLiteReader::add_dependency`][].  Writers can record the databases of dependencies, so
+//! that tools find them when they read the database, see [`SQLiteWriter::record_dependency`][].
+//! Files are loaded from whichever database contains them, so that paths are stitched across
+//! databases as if all files were stored in one.
+//!
+//! A database, together with its dependencies, can hold the files of several projects, such as
+//! repositories, or the sub-projects of a monorepo.  Writers store every file with the project
+//! that it belongs to, see [`SQLiteWriter::set_project`][], and the project is recorded with its
+//! root paths and definitions as well.  Files with the same path in different databases, e.g.,
+//! because they store relative paths, are told apart by their project, and readers load the one
+//! of their own project, see [`SQLiteReader::set_project`][].  Only a file that is stored for the
+//! same project in more than one database cannot be loaded, and fails with
+//! [`StorageError::AmbiguousFile`][].  The data of files is only reused for files of the same
+//! project, see [`SQLiteWriter::file_with_tag`][].  Paths between files of all projects still
+//! meet at the one root node, so a reference resolves to the matching definitions in every
+//! project, as it would if all files were stored in one database.
+//!
+//! The database records the version of its schema.  Writers migrate databases created by an older
+//! version of this crate to the current schema when they open them, if possible.  Readers never
+//! change the database, and fail with [`StorageError::IncorrectVersion`][] if its schema is not
+//! the current one, as do writers for databases that cannot be migrated, which must be recreated.
+//!
+//! [`SQLiteReader`]: struct.SQLiteReader.html
+//! [`SQLiteReader::add_dependency`]: struct.SQLiteReader.html#method.add_dependency
+//! [`SQLiteReader::clear_if_changed`]: struct.SQLiteReader.html#method.clear_if_changed
+//! [`SQLiteReader::coverage_all`]: struct.SQLiteReader.html#method.coverage_all
+//! [`SQLiteReader::set_project`]: struct.SQLiteReader.html#method.set_project
+//! [`SQLiteWriter::copy_file`]: struct.SQLiteWriter.html#method.copy_file
+//! [`SQLiteWriter::file_with_tag`]: struct.SQLiteWriter.html#method.file_with_tag
+//! [`SQLiteWriter::record_dependency`]: struct.SQLiteWriter.html#method.record_dependency
+//! [`SQLiteWriter::set_project`]: struct.SQLiteWriter.html#method.set_project
+//! [`SQLiteWriter::store_resolutions_for_file`]: struct.SQLiteWriter.html#method.store_resolutions_for_file
+//! [`SQLiteReader::find_definitions`]: struct.SQLiteReader.html#method.find_definitions
+//! [`serde`]: ../serde/index.html
+//! [`StorageError::AmbiguousFile`]: enum.StorageError.html#variant.AmbiguousFile
+//! [`StorageError::IncorrectVersion`]: enum.StorageError.html#variant.IncorrectVersion
+
+use std::collections::BTreeSet;
+use std::collections::HashMap;
+use std::collections::HashSet;
+use std::ops::RangeInclusive;
+use std::path::Path;
+use std::time::Duration;
+use std::time::SystemTime;
+use std::time::UNIX_EPOCH;
+
+use rusqlite::params;
+use rusqlite::Connection;
+use rusqlite::OpenFlags;
+use rusqlite::OptionalExtension;
+use rusqlite::Rows;
+use rusqlite::Transaction;
+use thiserror::Error;
+
+use crate::arena::Handle;
+use crate::graph::File;
+use crate::graph::StackGraph;
+use crate::partial::PartialPath;
+use crate::partial::PartialPaths;
+use crate::serde;
+use crate::stitching::Database;
+
+/// The version of the database schema.  Databases with an older version are migrated when they
+/// are opened by a writer, if there is a migration path for their version, see `MIGRATIONS`.
+/// Databases with a newer version cannot be opened.
+const VERSION: usize = 1;
+
+/// How long a connection waits for a lock that is held by another connection, before the
+/// operation fails.
+const BUSY_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// The zstd compression level of stored graphs and partial paths.  Low levels compress almost as
+/// well as higher ones for JSON, and are much faster.
+const COMPRESSION_LEVEL: i32 = 3;
+
+/// The oldest schema version that can be migrated to the current version.
+const OLDEST_MIGRATABLE_VERSION: usize = 1;
+
+/// The migrations between schema versions.  The migration at index `i` upgrades a database from
+/// version `OLDEST_MIGRATABLE_VERSION + i` to the next version.  Every change to the schema must
+/// increment `VERSION` and add a migration here, or increment `OLDEST_MIGRATABLE_VERSION` if
+/// the stored data cannot be migrated, and files must be indexed again.
+/// Migrations are only needed for schema versions that have been released.
+const MIGRATIONS: &[fn(&Transaction) -> Result<()>] = &[];
+
+const SCHEMA: &str = r#"
+    CREATE TABLE metadata (
+        version INTEGER NOT NULL
+    );
+    CREATE TABLE files (
+        file             TEXT PRIMARY KEY,
+        project          TEXT NOT NULL,
+        tag              TEXT NOT NULL,
+        indexed_at       INTEGER NOT NULL,
+        info             TEXT NOT NULL,
+        node_count       INTEGER NOT NULL,
+        path_count       INTEGER NOT NULL,
+        reference_count  INTEGER NOT NULL DEFAULT 0,
+        definition_count INTEGER NOT NULL DEFAULT 0,
+        line_count       INTEGER NOT NULL DEFAULT 0,
+        symbol_filter    BLOB,
+        error            TEXT,
+        error_phase      TEXT,
+        error_kind       TEXT,
+        error_location   TEXT
+    );
+    CREATE INDEX idx_files_tag ON files (tag);
+    CREATE TABLE graphs (
+        id    INTEGER PRIMARY KEY AUTOINCREMENT,
+        file  TEXT NOT NULL UNIQUE,
+        value BLOB NOT NULL
+    );
+    CREATE TABLE file_paths (
+        file  TEXT NOT NULL,
+        value BLOB NOT NULL
+    );
+    CREATE INDEX idx_file_paths_file ON file_paths (file);
+    CREATE TABLE root_path_symbols (
+        file    TEXT NOT NULL,
+        project TEXT NOT NULL,
+        symbol  TEXT NOT NULL
+    );
+    CREATE INDEX idx_root_path_symbols_file ON root_path_symbols (file);
+    CREATE INDEX idx_root_path_symbols_symbol ON root_path_symbols (symbol);
+    CREATE TABLE definitions (
+        file        TEXT NOT NULL,
+        project     TEXT NOT NULL,
+        symbol      TEXT NOT NULL COLLATE NOCASE,
+        syntax_type TEXT,
+        span        BLOB NOT NULL
+    );
+    CREATE INDEX idx_definitions_file ON definitions (file);
+    CREATE INDEX idx_definitions_symbol ON definitions (symbol);
+    CREATE TABLE dependencies (
+        path TEXT PRIMARY KEY
+    );
+    CREATE TABLE resolved_files (
+        file TEXT PRIMARY KEY
+    );
+    CREATE TABLE resolutions (
+        reference_file      TEXT NOT NULL,
+        reference_local_id  INTEGER NOT NULL,
+        definition_file     TEXT NOT NULL,
+        definition_local_id INTEGER NOT NULL,
+        path_length         INTEGER NOT NULL,
+        score               REAL NOT NULL
+    );
+    CREATE INDEX idx_resolutions_reference ON resolutions (reference_file, reference_local_id);
+    CREATE INDEX idx_resolutions_definition ON resolutions (definition_file, definition_local_id);
+"#;
+
+/// An error that can occur while reading from or writing to a database.
+#[derive(Debug, Error)]
+pub enum StorageError {
+    #[error("database does not exist: {0}")]
+    MissingDatabase(String),
+    #[error("database has version {0}, but version {1} is required")]
+    IncorrectVersion(usize, usize),
+    #[error("file not found in database: {0}")]
+    MissingFile(String),
+    #[error("file is stored in more than one database: {0}")]
+    AmbiguousFile(String),
+    #[error("cannot compress or decompress stored data: {0}")]
+    Compression(std::io::Error),
+    #[error(transparent)]
+    Rusqlite(#[from] rusqlite::Error),
+    #[error(transparent)]
+    Serde(#[from] serde::Error),
+    #[error(transparent)]
+    SerdeJson(#[from] serde_json::Error),
+}
+
+pub type Result<T> = std::result::Result<T, StorageError>;
+
+/// Creates the schema in a new database.
+fn init_schema(conn: &Connection) -> Result<()> {
+    conn.execute_batch(SCHEMA)?;
+    conn.execute("INSERT INTO metadata (version) VALUES (?)", [VERSION])?;
+    Ok(())
+}
+
+/// Checks that an existing database has the schema version that we support, without changing it.
+fn check_schema(conn: &Connection) -> Result<()> {
+    let version: usize = conn.query_row("SELECT version FROM metadata", [], |r| r.get(0))?;
+    if version != VERSION {
+        return Err(StorageError::IncorrectVersion(version, VERSION));
+    }
+    Ok(())
+}
+
+/// Checks that an existing database has the schema version that we support, and migrates it to
+/// that version if it is older.  All migrations are applied in a single transaction, so that a
+/// failed migration leaves the database unchanged.
+fn upgrade_schema(conn: &mut Connection) -> Result<()> {
+    let version: usize = conn.query_row("SELECT version FROM metadata", [], |r| r.get(0))?;
+    if version == VERSION {
+        return Ok(());
+    }
+    if version > VERSION || version < OLDEST_MIGRATABLE_VERSION {
+        return Err(StorageError::IncorrectVersion(version, VERSION));
+    }
+    let tx = conn.transaction()?;
+    for migration in &MIGRATIONS[version - OLDEST_MIGRATABLE_VERSION..] {
+        migration(&tx)?;
+    }
+    tx.execute("UPDATE metadata SET version = ?", [VERSION])?;
+    tx.commit()?;
+    Ok(())
+}
+
+/// Encodes a value as JSON, and compresses it for storage.
+fn encode<T: ::serde::Serialize>(value: &T) -> Result<Vec<u8>> {
+    compress(&serde_json::to_vec(value)?)
+}
+
+/// Decompresses a stored value, and decodes it from JSON.
+fn decode<T: ::serde::de::DeserializeOwned>(value: &[u8]) -> Result<T> {
+    let value = zstd::decode_all(value).map_err(StorageError::Compression)?;
+    Ok(serde_json::from_slice(&value)?)
+}
+
+fn compress(value: &[u8]) -> Result<Vec<u8>> {
+    zstd::encode_all(value, COMPRESSION_LEVEL).map_err(StorageError::Compression)
+}
+
+/// A database entry describing an indexed file.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct FileEntry {
+    pub path: String,
+    /// The project that the file belongs to, see [`SQLiteWriter::set_project`][].
+    ///
+    /// [`SQLiteWriter::set_project`]: struct.SQLiteWriter.html#method.set_project
+    pub project: String,
+    pub tag: String,
+}
+
+/// A database entry describing the outcome of the last attempt to index a file.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct FileStatus {
+    pub path: String,
+    /// The project that the file belongs to, see [`SQLiteWriter::set_project`][].
+    ///
+    /// [`SQLiteWriter::set_project`]: struct.SQLiteWriter.html#method.set_project
+    pub project: String,
+    pub tag: String,
+    /// The time at which the file was indexed, in seconds since the Unix epoch.
+    pub indexed_at: u64,
+    /// A description of how the file was indexed, such as the language and the versions of the
+    /// grammar and rules that were used.  The contents are determined by the indexer.
+    pub info: String,
+    /// The number of nodes in the file's stack graph.
+    pub node_count: usize,
+    /// The number of partial paths stored for the file.
+    pub path_count: usize,
+    /// The failure that occurred while indexing the file, if indexing failed.
+    pub error: Option<FileFailure>,
+}
+
+/// A database entry describing a definition, as returned by a symbol search.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct DefinitionEntry {
+    pub path: String,
+    /// The project of the file that contains the definition.
+    pub project: String,
+    pub symbol: String,
+    /// The kind of syntax entity of the definition, if it is known.
+    pub syntax_type: Option<String>,
+    /// The span of the definition in its file, which is the default span if the definition has no
+    /// source information.
+    pub span: lsp_positions::Span,
+}
+
+/// A database entry describing a definition that a reference resolves to.  Nodes are identified
+/// by the name of their file and their local ID.
+#[derive(Clone, Debug, PartialEq)]
+pub struct Resolution {
+    pub reference_file: String,
+    pub reference_local_id: u32,
+    pub definition_file: String,
+    pub definition_local_id: u32,
+    /// The length of the path from the reference to the definition.
+    pub path_length: usize,
+    /// The ranking score of the definition, which is determined by the indexer.
+    pub score: f64,
+}
+
+/// A database entry describing how well the references in a successfully indexed file resolve.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct FileCoverage {
+    pub path: String,
+    /// The number of references in the file.
+    pub reference_count: usize,
+    /// The number of references in the file that resolve to at least one definition, or `None` if
+    /// the resolutions of the file are not stored.
+    pub resolved_count: Option<usize>,
+    /// The number of definitions with a symbol in the file.
+    pub definition_count: usize,
+    /// The number of source lines covered by the file's stack graph, i.e., up to the last line on
+    /// which a node with source information ends.
+    pub line_count: usize,
+}
+
+impl FileCoverage {
+    /// Returns the percentage of references that resolve, or `None` if it is not known, or if
+    /// the file has no references.
+    pub fn resolved_percentage(&self) -> Option<f64> {
+        let resolved_count = self.resolved_count?;
+        if self.reference_count == 0 {
+            return None;
+        }
+        Some(100.0 * resolved_count as f64 / self.reference_count as f64)
+    }
+
+    /// Returns the number of definitions per thousand lines, or `None` if the file has no lines.
+    pub fn definitions_per_kloc(&self) -> Option<f64> {
+        if self.line_count == 0 {
+            return None;
+        }
+        Some(1000.0 * self.definition_count as f64 / self.line_count as f64)
+    }
+}
+
+/// A database entry describing why indexing a file failed.  The phases and kinds of errors are
+/// determined by the indexer.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub struct FileFailure {
+    /// The indexing phase during which the failure occurred, such as parsing or executing rules.
+    pub phase: String,
+    /// The class of the error, such as a parse error or a timeout.
+    pub kind: String,
+    /// The error message.
+    pub message: String,
+    /// The location in the source or the rules that the error refers to, if it is known.
+    pub location: Option<String>,
+}
+
+impl FileStatus {
+    /// Returns whether the file was indexed successfully.
+    pub fn is_success(&self) -> bool {
+        self.error.is_none()
+    }
+}
+
+fn now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or_default()
+}
+
+//-------------------------------------------------------------------------------------------------
+// Writer
+
+/// Writes stack graphs and partial paths of individual files to a database.
+///
+/// By default, every write is committed on its own.  Writing many files is much faster if the
+/// writes are committed together in batches, see [`set_batch_size`][Self::set_batch_size].  A
+/// failed write never affects the other writes in its batch.
+pub struct SQLiteWriter {
+    conn: Connection,
+    /// The project that stored files belong to.
+    project: String,
+    batch_size: usize,
+    /// The number of writes in the current batch that are not committed yet.
+    pending_writes: usize,
+}
+
+impl SQLiteWriter {
+    /// Opens a new in-memory database.
+    pub fn open_in_memory() -> Result<Self> {
+        let conn = Connection::open_in_memory()?;
+        init_schema(&conn)?;
+        Ok(Self::new(conn))
+    }
+
+    /// Opens the database at the given path, creating it if it does not exist yet.
+    ///
+    /// The database is switched to write-ahead logging, so that readers can read the database
+    /// while a writer is writing to it.
+    pub fn open<P: AsRef<Path>>(path: P) -> Result<Self> {
+        let is_new = !path.as_ref().exists();
+        let mut conn = Connection::open(path)?;
+        conn.busy_timeout(BUSY_TIMEOUT)?;
+        conn.pragma_update_and_check(None, "journal_mode", "WAL", |r| r.get::<_, String>(0))?;
+        if is_new {
+            init_schema(&conn)?;
+        } else {
+            upgrade_schema(&mut conn)?;
+        }
+        Ok(Self::new(conn))
+    }
+
+    fn new(conn: Connection) -> Self {
+        Self {
+            conn,
+            project: String::new(),
+            batch_size: 1,
+            pending_writes: 0,
+        }
+    }
+
+    /// Sets the project that the files that are stored afterwards belong to, e.g., a repository,
+    /// or a sub-project of a monorepo.  The project is stored with every file, and with its root
+    /// paths and definitions, so that the files of several projects can be kept in one database,
+    /// and it tells apart files that are stored in more than one database under the same name,
+    /// see [`SQLiteReader::set_project`][].  Files belong to the unnamed project, which is the
+    /// empty string, if no project is set.
+    ///
+    /// [`SQLiteReader::set_project`]: struct.SQLiteReader.html#method.set_project
+    pub fn set_project(&mut self, project: &str) {
+        self.project = project.to_string();
+    }
+
+    /// Sets the number of writes that are committed together in a single transaction.  Writes are
+    /// only visible to other connections, and durable, once their batch is committed, which
+    /// happens when it is full, when [`flush`][Self::flush] is called, or when the writer is
+    /// dropped.  A batch size of 1, which is the default, commits every write on its own.
+    pub fn set_batch_size(&mut self, batch_size: usize) -> Result<()> {
+        self.batch_size = batch_size.max(1);
+        if self.pending_writes >= self.batch_size {
+            self.flush()?;
+        }
+        Ok(())
+    }
+
+    /// Commits the writes in the current batch, if any.
+    pub fn flush(&mut self) -> Result<()> {
+        if !self.conn.is_autocommit() {
+            self.conn.execute_batch("COMMIT")?;
+        }
+        self.pending_writes = 0;
+        Ok(())
+    }
+
+    /// Starts a new batch, if writes are batched and no batch is in progress.  Every write runs in
+    /// a savepoint within the batch, so that a failed write is rolled back on its own.
+    fn begin_batch(&mut self) -> Result<()> {
+        if self.batch_size > 1 && self.conn.is_autocommit() {
+            self.conn.execute_batch("BEGIN")?;
+        }
+        Ok(())
+    }
+
+    /// Counts a completed write, and commits the current batch if it is full.
+    fn end_write(&mut self) -> Result<()> {
+        if self.conn.is_autocommit() {
+            return Ok(());
+        }
+        self.pending_writes += 1;
+        if self.pending_writes >= self.batch_size {
+            self.flush()?;
+        }
+        Ok(())
+    }
+
+    /// Returns the tag of the stored version of the given file, if the file is in the database and
+    /// was indexed successfully.
+    pub fn file_tag(&self, file: &str) -> Result<Option<String>> {
+        let tag = self
+            .conn
+            .query_row(
+                "SELECT tag FROM files WHERE file = ? AND error IS NULL",
+                [file],
+                |r| r.get(0),
+            )
+            .optional()?;
+        Ok(tag)
+    }
+
+    /// Returns the name of a successfully indexed file of the writer's project with the given tag,
+    /// if there is any.  If tags identify the content of files, the stored data of that file can
+    /// be reused for other files with the same content, see [`copy_file`][Self::copy_file].
+    /// Files of other projects are never returned, because their graphs may depend on their
+    /// project.
+    pub fn file_with_tag(&self, tag: &str) -> Result<Option<String>> {
+        let file = self
+            .conn
+            .query_row(
+                "SELECT file FROM files WHERE tag = ? AND project = ? AND error IS NULL LIMIT 1",
+                [tag, &self.project],
+                |r| r.get(0),
+            )
+            .optional()?;
+        Ok(file)
+    }
+
+    /// Stores the data of a successfully indexed file for another file of the writer's project,
+    /// replacing any data that was previously stored for the target, as if the target was indexed
+    /// and resulted in the same stack graph and partial paths.  This avoids indexing files with the same content more
+    /// than once, but is only correct if the graphs of files do not depend on their names.
+    pub fn copy_file(&mut self, source: &str, target: &str) -> Result<()> {
+        let mut file_graph: serde::StackGraph = self
+            .conn
+            .query_row("SELECT value FROM graphs WHERE file = ?", [source], |r| {
+                r.get::<_, Vec<u8>>(0)
+            })
+            .optional()?
+            .map(|value| decode(&value))
+            .transpose()?
+            .ok_or_else(|| StorageError::MissingFile(source.to_string()))?;
+        file_graph.rename_file(source, target);
+        let file_graph = encode(&file_graph)?;
+        let mut file_paths = Vec::new();
+        {
+            let mut stmt = self
+                .conn
+                .prepare_cached("SELECT value FROM file_paths WHERE file = ?")?;
+            let mut rows = stmt.query([source])?;
+            while let Some(row) = rows.next()? {
+                let value: Vec<u8> = row.get(0)?;
+                let mut path: serde::PartialPath = decode(&value)?;
+                path.rename_file(source, target);
+                file_paths.push(encode(&path)?);
+            }
+        }
+
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        let identical =
+            has_identical_content(&tx, target, &self.project, &file_graph, &file_paths)?;
+        if !identical {
+            invalidate_resolutions(&tx)?;
+            tx.execute("DELETE FROM graphs WHERE file = ?", [target])?;
+            tx.execute("DELETE FROM file_paths WHERE file = ?", [target])?;
+            tx.execute("DELETE FROM root_path_symbols WHERE file = ?", [target])?;
+            tx.execute("DELETE FROM definitions WHERE file = ?", [target])?;
+        }
+        tx.execute(
+            "INSERT OR REPLACE INTO files (file, project, tag, indexed_at, info, node_count, path_count, reference_count, definition_count, line_count, symbol_filter, error) SELECT ?, ?, tag, ?, info, node_count, path_count, reference_count, definition_count, line_count, symbol_filter, NULL FROM files WHERE file = ?",
+            params![target, self.project, now(), source],
+        )?;
+        if !identical {
+            tx.execute(
+                "INSERT INTO graphs (file, value) VALUES (?, ?)",
+                params![target, file_graph],
+            )?;
+            {
+                let mut stmt = tx.prepare("INSERT INTO file_paths (file, value) VALUES (?, ?)")?;
+                for path in &file_paths {
+                    stmt.execute(params![target, path])?;
+                }
+            }
+            tx.execute(
+                "INSERT INTO root_path_symbols (file, project, symbol) SELECT ?, ?, symbol FROM root_path_symbols WHERE file = ?",
+                params![target, self.project, source],
+            )?;
+            tx.execute(
+                "INSERT INTO definitions (file, project, symbol, syntax_type, span) SELECT ?, ?, symbol, syntax_type, span FROM definitions WHERE file = ?",
+                params![target, self.project, source],
+            )?;
+        }
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+
+    /// Removes all data for the given file from the database.
+    pub fn clean_file(&mut self, file: &str) -> Result<()> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        tx.execute("DELETE FROM files WHERE file = ?", [file])?;
+        // Files without a graph, such as failed files, cannot affect any resolutions.
+        if tx.execute("DELETE FROM graphs WHERE file = ?", [file])? > 0 {
+            invalidate_resolutions(&tx)?;
+        }
+        tx.execute("DELETE FROM file_paths WHERE file = ?", [file])?;
+        tx.execute("DELETE FROM root_path_symbols WHERE file = ?", [file])?;
+        tx.execute("DELETE FROM definitions WHERE file = ?", [file])?;
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+
+    /// Removes all data for all files from the database.  Returns the number of files that were
+    /// removed.
+    pub fn clean_all(&mut self) -> Result<usize> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        let count = tx.execute("DELETE FROM files", [])?;
+        tx.execute("DELETE FROM graphs", [])?;
+        tx.execute("DELETE FROM file_paths", [])?;
+        tx.execute("DELETE FROM root_path_symbols", [])?;
+        tx.execute("DELETE FROM definitions", [])?;
+        invalidate_resolutions(&tx)?;
+        tx.commit()?;
+        self.end_write()?;
+        Ok(count)
+    }
+
+    /// Removes all data for the files whose names match the given pattern from the database.
+    /// Patterns use the syntax of SQLite's `GLOB` operator, where `*` and `?` also match path
+    /// separators.  Returns the number of files that were removed.
+    pub fn clean_files_matching(&mut self, pattern: &str) -> Result<usize> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        let count = tx.execute("DELETE FROM files WHERE file GLOB ?", [pattern])?;
+        if tx.execute("DELETE FROM graphs WHERE file GLOB ?", [pattern])? > 0 {
+            invalidate_resolutions(&tx)?;
+        }
+        tx.execute("DELETE FROM file_paths WHERE file GLOB ?", [pattern])?;
+        tx.execute("DELETE FROM root_path_symbols WHERE file GLOB ?", [pattern])?;
+        tx.execute("DELETE FROM definitions WHERE file GLOB ?", [pattern])?;
+        tx.commit()?;
+        self.end_write()?;
+        Ok(count)
+    }
+
+    /// Records the database at the given path as a dependency of this database, e.g., the index of
+    /// a library that the project uses, so that tools that read this database know to add it
+    /// with [`SQLiteReader::add_dependency`][].  Recording a dependency that is already recorded
+    /// has no effect.  The path is stored as given, so it should be absolute.
+    ///
+    /// [`SQLiteReader::add_dependency`]: struct.SQLiteReader.html#method.add_dependency
+    pub fn record_dependency(&mut self, path: &str) -> Result<()> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        let count = tx.execute(
+            "INSERT OR IGNORE INTO dependencies (path) VALUES (?)",
+            [path],
+        )?;
+        // References may resolve differently with the new dependency.
+        if count > 0 {
+            invalidate_resolutions(&tx)?;
+        }
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+
+    /// Records that indexing the given file failed, replacing any data that was previously stored
+    /// for it.
+    pub fn store_error_for_file(
+        &mut self,
+        file: &str,
+        tag: &str,
+        info: &str,
+        failure: &FileFailure,
+    ) -> Result<()> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        if tx.execute("DELETE FROM graphs WHERE file = ?", [file])? > 0 {
+            invalidate_resolutions(&tx)?;
+        }
+        tx.execute("DELETE FROM file_paths WHERE file = ?", [file])?;
+        tx.execute("DELETE FROM root_path_symbols WHERE file = ?", [file])?;
+        tx.execute("DELETE FROM definitions WHERE file = ?", [file])?;
+        tx.execute(
+            "INSERT OR REPLACE INTO files (file, project, tag, indexed_at, info, node_count, path_count, error, error_phase, error_kind, error_location) VALUES (?, ?, ?, ?, ?, 0, 0, ?, ?, ?, ?)",
+            params![
+                file,
+                self.project,
+                tag,
+                now(),
+                info,
+                failure.message,
+                failure.phase,
+                failure.kind,
+                failure.location,
+            ],
+        )?;
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+
+    /// Stores the stack graph and partial paths of a file, replacing any data that was previously
+    /// stored for it.  The graph must contain the file, and all of the given partial paths must
+    /// belong to that file.  The `info` string is stored in the file's status, and should describe
+    /// how the file was indexed.
+    pub fn store_result_for_file<'a, IP>(
+        &mut self,
+        graph: &StackGraph,
+        file: Handle<File>,
+        tag: &str,
+        info: &str,
+        partials: &mut PartialPaths,
+        paths: IP,
+    ) -> Result<()>
+    where
+        IP: IntoIterator<Item = &'a PartialPath>,
+    {
+        let file_name = graph[file].name();
+        let file_graph =
+            serde::StackGraph::from_graph(graph, &|_: &StackGraph, f: &Handle<File>| *f == file);
+        let file_graph = encode(&file_graph)?;
+        let mut file_paths = Vec::new();
+        let mut root_path_symbols = BTreeSet::new();
+        for path in paths {
+            if graph[path.start_node].is_root() {
+                root_path_symbols.insert(root_path_symbol(graph, partials, path));
+            }
+            let path = serde::PartialPath::from_partial_path(graph, partials, path);
+            file_paths.push(encode(&path)?);
+        }
+
+        let symbols = graph
+            .nodes_for_file(file)
+            .filter_map(|node| graph[node].symbol())
+            .map(|symbol| &graph[symbol])
+            .collect::<HashSet<_>>();
+        let mut symbol_filter = SymbolFilter::new(symbols.len());
+        for symbol in symbols {
+            symbol_filter.insert(symbol);
+        }
+
+        let mut definitions = Vec::new();
+        for node in graph.nodes_for_file(file) {
+            if !graph[node].is_definition() {
+                continue;
+            }
+            let symbol = match graph[node].symbol() {
+                Some(symbol) => &graph[symbol],
+                None => continue,
+            };
+            let source_info = graph.source_info(node);
+            let syntax_type = source_info
+                .and_then(|si| si.syntax_type)
+                .map(|st| &graph[st]);
+            let span = source_info.map(|si| si.span.clone()).unwrap_or_default();
+            definitions.push((symbol, syntax_type, serde_json::to_vec(&span)?));
+        }
+
+        let node_count = graph.nodes_for_file(file).count();
+        let path_count = file_paths.len();
+        let counts = FileCounts::new(graph, file);
+
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        let identical =
+            has_identical_content(&tx, file_name, &self.project, &file_graph, &file_paths)?;
+        if !identical {
+            invalidate_resolutions(&tx)?;
+            tx.execute("DELETE FROM graphs WHERE file = ?", [file_name])?;
+            tx.execute("DELETE FROM file_paths WHERE file = ?", [file_name])?;
+            tx.execute("DELETE FROM root_path_symbols WHERE file = ?", [file_name])?;
+            tx.execute("DELETE FROM definitions WHERE file = ?", [file_name])?;
+        }
+        tx.execute(
+            "INSERT OR REPLACE INTO files (file, project, tag, indexed_at, info, node_count, path_count, reference_count, definition_count, line_count, symbol_filter, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)",
+            params![
+                file_name,
+                self.project,
+                tag,
+                now(),
+                info,
+                node_count,
+                path_count,
+                counts.reference_count,
+                counts.definition_count,
+                counts.line_count,
+                symbol_filter.bits
+            ],
+        )?;
+        if !identical {
+            tx.execute(
+                "INSERT INTO graphs (file, value) VALUES (?, ?)",
+                params![file_name, file_graph],
+            )?;
+            {
+                let mut stmt = tx.prepare("INSERT INTO file_paths (file, value) VALUES (?, ?)")?;
+                for path in &file_paths {
+                    stmt.execute(params![file_name, path])?;
+                }
+            }
+            {
+                let mut stmt = tx.prepare(
+                    "INSERT INTO root_path_symbols (file, project, symbol) VALUES (?, ?, ?)",
+                )?;
+                for symbol in root_path_symbols {
+                    stmt.execute(params![file_name, self.project, symbol])?;
+                }
+            }
+            {
+                let mut stmt = tx.prepare(
+                    "INSERT INTO definitions (file, project, symbol, syntax_type, span) VALUES (?, ?, ?, ?, ?)",
+                )?;
+                for (symbol, syntax_type, span) in definitions {
+                    stmt.execute(params![file_name, self.project, symbol, syntax_type, span])?;
+                }
+            }
+        }
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+
+    /// Stores the definitions that the references in a file resolve to, replacing any resolutions
+    /// that were previously stored for it.  The resolutions must include all references in the
+    /// file, and only references in the file.  They remain valid until the stored graph or partial
+    /// paths of any file change, or a dependency is recorded.
+    pub fn store_resolutions_for_file(
+        &mut self,
+        file: &str,
+        resolutions: &[Resolution],
+    ) -> Result<()> {
+        self.begin_batch()?;
+        let tx = self.conn.savepoint()?;
+        tx.execute("DELETE FROM resolutions WHERE reference_file = ?", [file])?;
+        {
+            let mut stmt = tx.prepare(
+                "INSERT INTO resolutions (reference_file, reference_local_id, definition_file, definition_local_id, path_length, score) VALUES (?, ?, ?, ?, ?, ?)",
+            )?;
+            for resolution in resolutions {
+                stmt.execute(params![
+                    resolution.reference_file,
+                    resolution.reference_local_id,
+                    resolution.definition_file,
+                    resolution.definition_local_id,
+                    resolution.path_length,
+                    resolution.score,
+                ])?;
+            }
+        }
+        tx.execute(
+            "INSERT OR REPLACE INTO resolved_files (file) VALUES (?)",
+            [file],
+        )?;
+        tx.commit()?;
+        self.end_write()?;
+        Ok(())
+    }
+}
+
+/// The number of references, definitions, and source lines of a file, as they are recorded in the
+/// files table.
+struct FileCounts {
+    reference_count: usize,
+    definition_count: usize,
+    line_count: usize,
+}
+
+impl FileCounts {
+    fn new(graph: &StackGraph, file: Handle<File>) -> FileCounts {
+        let reference_count = graph
+            .nodes_for_file(file)
+            .filter(|node| graph[*node].is_reference())
+            .count();
+        let definition_count = graph
+            .nodes_for_file(file)
+            .filter(|node| graph[*node].is_definition() && graph[*node].symbol().is_some())
+            .count();
+        let line_count = graph
+            .nodes_for_file(file)
+            .filter_map(|node| graph.source_info(node))
+            .map(|source_info| source_info.span.end.line + 1)
+            .max()
+            .unwrap_or(0);
+        FileCounts {
+            reference_count,
+            definition_count,
+            line_count,
+        }
+    }
+}
+
+impl Drop for SQLiteWriter {
+    fn drop(&mut self) {
+        // Errors cannot be reported here.  Callers that need to know whether their writes were
+        // committed must call flush.
+        let _ = self.flush();
+    }
+}
+
+/// Removes all stored resolutions.  This is necessary whenever a file changes, because the
+/// references in any file can resolve to definitions in the changed file.
+fn invalidate_resolutions(tx: &Connection) -> Result<()> {
+    tx.execute("DELETE FROM resolutions", [])?;
+    tx.execute("DELETE FROM resolved_files", [])?;
+    Ok(())
+}
+
+/// Returns whether the stored graph and partial paths of a file are identical to the given
+/// encoded ones, and stored for the same project, in which case storing them again cannot change
+/// how any reference resolves.  Encoding is deterministic, so this is the case when an unchanged
+/// file is indexed again.  The partial paths can be in any order.
+fn has_identical_content(
+    tx: &Connection,
+    file: &str,
+    project: &str,
+    file_graph: &[u8],
+    file_paths: &[Vec<u8>],
+) -> Result<bool> {
+    let stored_project = tx
+        .query_row("SELECT project FROM files WHERE file = ?", [file], |r| {
+            r.get::<_, String>(0)
+        })
+        .optional()?;
+    if stored_project.as_deref() != Some(project) {
+        return Ok(false);
+    }
+    let stored_graph = tx
+        .query_row("SELECT value FROM graphs WHERE file = ?", [file], |r| {
+            r.get::<_, Vec<u8>>(0)
+        })
+        .optional()?;
+    if stored_graph.as_deref() != Some(file_graph) {
+        return Ok(false);
+    }
+    let mut stmt = tx.prepare_cached("SELECT value FROM file_paths WHERE file = ?")?;
+    let mut stored_paths = stmt
+        .query_map([file], |r| r.get::<_, Vec<u8>>(0))?
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+    let mut file_paths = file_paths.iter().collect::<Vec<_>>();
+    stored_paths.sort();
+    file_paths.sort();
+    Ok(stored_paths.iter().eq(file_paths))
+}
+
+/// Returns the symbol that a root path is indexed by, which is the symbol at the top of its symbol
+/// stack precondition.  Root paths that do not require any symbol are indexed by the empty string,
+/// and are candidates for every symbol.
+fn root_path_symbol(graph: &StackGraph, partials: &mut PartialPaths, path: &PartialPath) -> String {
+    path.symbol_stack_precondition
+        .iter(partials)
+        .next()
+        .map(|symbol| graph[symbol.symbol].to_string())
+        .unwrap_or_default()
+}
+
+/// The worst rank of a symbol that starts with the search query, ignoring the case of ASCII
+/// letters.  Symbols with a better rank can be found using the symbol index of the definitions.
+const PREFIX_MATCH_RANK: u8 = 2;
+
+/// Returns how well a symbol matches a symbol search query, where lower is better, or `None` if
+/// it does not match at all.  See [`SQLiteReader::find_definitions`][] for the ranking.
+fn symbol_match_rank(symbol: &str, query: &str) -> Option<u8> {
+    if symbol == query {
+        return Some(0);
+    }
+    let symbol_lower = symbol.to_ascii_lowercase();
+    let query_lower = query.to_ascii_lowercase();
+    if symbol_lower == query_lower {
+        Some(1)
+    } else if symbol_lower.starts_with(&query_lower) {
+        Some(2)
+    } else if symbol_lower.contains(&query_lower) {
+        Some(3)
+    } else {
+        let mut symbol_chars = symbol_lower.chars();
+        if query_lower.chars().all(|q| symbol_chars.any(|c| c == q)) {
+            Some(4)
+        } else {
+            None
+        }
+    }
+}
+
+/// Returns the smallest string that is greater than every string that starts with the given
+/// prefix, or `None` if there is no such string.  Strings compare like their UTF-8 encodings,
+/// which is the order of their code points.
+fn prefix_upper_bound(prefix: &str) -> Option<String> {
+    let mut chars = prefix.chars().collect::<Vec<_>>();
+    while let Some(c) = chars.pop() {
+        let next = match c as u32 + 1 {
+            0xD800 => Some('\u{E000}'),
+            next => char::from_u32(next),
+        };
+        if let Some(next) = next {
+            chars.push(next);
+            return Some(chars.into_iter().collect());
+        }
+    }
+    None
+}
+
+/// Adds the definitions in the given rows whose symbols match the query with a rank in the given
+/// range to the matches, together with their rank.
+fn collect_definition_matches(
+    mut rows: Rows<'_>,
+    query: &str,
+    ranks: RangeInclusive<u8>,
+    matches: &mut Vec<(u8, DefinitionEntry)>,
+) -> Result<()> {
+    while let Some(row) = rows.next()? {
+        let symbol: String = row.get(1)?;
+        let rank = match symbol_match_rank(&symbol, query) {
+            Some(rank) if ranks.contains(&rank) => rank,
+            _ => continue,
+        };
+        let span: Vec<u8> = row.get(3)?;
+        matches.push((
+            rank,
+            DefinitionEntry {
+                path: row.get(0)?,
+                project: row.get(4)?,
+                symbol,
+                syntax_type: row.get(2)?,
+                span: serde_json::from_slice(&span)?,
+            },
+        ));
+    }
+    Ok(())
+}
+
+//-------------------------------------------------------------------------------------------------
+// Symbol filters
+
+/// A bloom filter of the symbols that are referenced or defined in a file.  A filter can claim
+/// that a file contains a symbol that it does not contain, but never the other way around.
+struct SymbolFilter {
+    bits: Vec<u8>,
+}
+
+impl SymbolFilter {
+    /// The number of bits per symbol and the number of bits that are set for every symbol.  These
+    /// give a false positive rate of about 1%.
+    const BITS_PER_SYMBOL: usize = 10;
+    const HASH_COUNT: u64 = 7;
+
+    /// Creates an empty filter that is large enough for the given number of symbols.
+    fn new(symbol_count: usize) -> SymbolFilter {
+        let byte_count = (symbol_count * Self::BITS_PER_SYMBOL + 7) / 8;
+        SymbolFilter {
+            bits: vec![0; byte_count.max(8)],
+        }
+    }
+
+    fn insert(&mut self, symbol: &str) {
+        for bit in self.bit_indices(symbol) {
+            self.bits[bit / 8] |= 1 << (bit % 8);
+        }
+    }
+
+    fn may_contain(&self, symbol: &str) -> bool {
+        self.bit_indices(symbol)
+            .all(|bit| self.bits[bit / 8] & (1 << (bit % 8)) != 0)
+    }
+
+    /// Returns the bits that are set for a symbol, which are derived from the two halves of its
+    /// FNV-1a hash.  Filters are stored in the database, so the hash function must not change.
+    fn bit_indices(&self, symbol: &str) -> impl Iterator<Item = usize> {
+        let hash = symbol.bytes().fold(0xcbf29ce484222325u64, |hash, byte| {
+            (hash ^ byte as u64).wrapping_mul(0x100000001b3)
+        });
+        let h1 = hash & 0xffff_ffff;
+        let h2 = (hash >> 32) | 1;
+        let bit_count = (self.bits.len() * 8) as u64;
+        (0..Self::HASH_COUNT)
+            .map(move |i| (h1.wrapping_add(i.wrapping_mul(h2)) % bit_count) as usize)
+    }
+}
+
+//-------------------------------------------------------------------------------------------------
+// Reader
+
+/// Loads stack graphs and partial paths of individual files from a database.  Loaded data is
+/// added to a stack graph and partial path database that are owned by the reader.
+pub struct SQLiteReader {
+    conn: Connection,
+    /// Read-only databases that are consulted for files that are not in this database.
+    dependencies: Vec<Connection>,
+    /// The project whose files are preferred over files with the same path in other projects.
+    project: String,
+    /// The version of the data in the database when the loaded data was loaded.
+    data_version: i64,
+    /// The files whose graphs are loaded, with the project of the loaded file and the row ID of
+    /// the loaded graph, which changes whenever a different graph is stored for the file.
+    loaded_graphs: HashMap<String, (String, i64)>,
+    /// The files whose partial paths are loaded, with the symbols that the paths push onto the
+    /// symbol stack.
+    loaded_paths: HashMap<String, HashSet<String>>,
+    graph: StackGraph,
+    partials: PartialPaths,
+    db: Database,
+}
+
+impl SQLiteReader {
+    /// Opens the existing database at the given path.
+    pub fn open<P: AsRef<Path>>(path: P) -> Result<Self> {
+        if !path.as_ref().exists() {
+            return Err(StorageError::MissingDatabase(
+                path.as_ref().to_string_lossy().to_string(),
+            ));
+        }
+        let conn = Connection::open(path)?;
+        conn.busy_timeout(BUSY_TIMEOUT)?;
+        check_schema(&conn)?;
+        let data_version = data_version(&conn)?;
+        Ok(Self {
+            conn,
+            dependencies: Vec::new(),
+            project: String::new(),
+            data_version,
+            loaded_graphs: HashMap::new(),
+            loaded_paths: HashMap::new(),
+            graph: StackGraph::new(),
+            partials: PartialPaths::new(),
+            db: Database::new(),
+        })
+    }
+
+    /// Adds the existing database at the given path as a read-only dependency, e.g., the index of
+    /// a library that the project uses.  Files that are not in this database are loaded from the
+    /// dependency that contains them, and root paths of dependencies are considered when loading
+    /// the files that a file depends on.  If a file is stored in more than one of the databases,
+    /// the one of the reader's project is loaded, see [`set_project`][Self::set_project].
+    /// Dependencies are never migrated, so they must have the current schema version.  Listings
+    /// and other queries only cover this database.
+    pub fn add_dependency<P: AsRef<Path>>(&mut self, path: P) -> Result<()> {
+        if !path.as_ref().exists() {
+            return Err(StorageError::MissingDatabase(
+                path.as_ref().to_string_lossy().to_string(),
+            ));
+        }
+        let conn = Connection::open_with_flags(
+            path,
+            OpenFlags::SQLITE_OPEN_READ_ONLY | OpenFlags::SQLITE_OPEN_NO_MUTEX,
+        )?;
+        conn.busy_timeout(BUSY_TIMEOUT)?;
+        check_schema(&conn)?;
+        self.dependencies.push(conn);
+        Ok(())
+    }
+
+    /// Sets the project whose files are loaded if files with the same path are stored for several
+    /// projects, see [`SQLiteWriter::set_project`][].  Loading a file that is stored in more than
+    /// one database fails with [`StorageError::AmbiguousFile`][], unless exactly one of them stores
+    /// it for this project.  Files that are found while loading the dependencies of a file are
+    /// loaded for the project of the root paths that lead to them.  A stack graph contains a
+    /// single file for every path, so if several projects have root paths in files with the same
+    /// path, only the first one that is found is loaded.  The reader's project is the unnamed
+    /// project, which is the empty string, if no project is set.
+    ///
+    /// [`SQLiteWriter::set_project`]: struct.SQLiteWriter.html#method.set_project
+    /// [`StorageError::AmbiguousFile`]: enum.StorageError.html#variant.AmbiguousFile
+    pub fn set_project(&mut self, project: &str) {
+        self.project = project.to_string();
+    }
+
+    /// Returns the paths of the dependencies that were recorded in this database with
+    /// [`SQLiteWriter::record_dependency`][], sorted by path.  They are not added to the reader
+    /// automatically, because the caller decides how to handle dependencies that are missing.
+    ///
+    /// [`SQLiteWriter::record_dependency`]: struct.SQLiteWriter.html#method.record_dependency
+    pub fn recorded_dependencies(&self) -> Result<Vec<String>> {
+        let mut stmt = self
+            .conn
+            .prepare("SELECT path FROM dependencies ORDER BY path")?;
+        let paths = stmt
+            .query_map([], |r| r.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(paths)
+    }
+
+    /// Returns the entries for all successfully indexed files in the database, sorted by path.
+    pub fn list_all(&self) -> Result<Vec<FileEntry>> {
+        let mut stmt = self
+            .conn
+            .prepare("SELECT file, project, tag FROM files WHERE error IS NULL ORDER BY file")?;
+        let entries = stmt
+            .query_map([], |r| {
+                Ok(FileEntry {
+                    path: r.get(0)?,
+                    project: r.get(1)?,
+                    tag: r.get(2)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(entries)
+    }
+
+    /// Returns the status of all files in the database, including files that failed to index,
+    /// sorted by path.
+    pub fn status_all(&self) -> Result<Vec<FileStatus>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT file, tag, indexed_at, info, node_count, path_count, error, error_phase, error_kind, error_location, project FROM files ORDER BY file",
+        )?;
+        let entries = stmt
+            .query_map([], |r| {
+                let error = match r.get::<_, Option<String>>(6)? {
+                    Some(message) => Some(FileFailure {
+                        phase: r.get::<_, Option<String>>(7)?.unwrap_or_default(),
+                        kind: r.get::<_, Option<String>>(8)?.unwrap_or_default(),
+                        message,
+                        location: r.get(9)?,
+                    }),
+                    None => None,
+                };
+                Ok(FileStatus {
+                    path: r.get(0)?,
+                    project: r.get(10)?,
+                    tag: r.get(1)?,
+                    indexed_at: r.get(2)?,
+                    info: r.get(3)?,
+                    node_count: r.get(4)?,
+                    path_count: r.get(5)?,
+                    error,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(entries)
+    }
+
+    /// Returns the coverage of all successfully indexed files, sorted by path.  The number of
+    /// resolved references is only known for files whose resolutions are stored.
+    pub fn coverage_all(&self) -> Result<Vec<FileCoverage>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT f.file, f.reference_count, f.definition_count, f.line_count, r.file IS NOT NULL, (SELECT COUNT(DISTINCT reference_local_id) FROM resolutions WHERE reference_file = f.file) FROM files f LEFT JOIN resolved_files r ON r.file = f.file WHERE f.error IS NULL ORDER BY f.file",
+        )?;
+        let entries = stmt
+            .query_map([], |r| {
+                let is_resolved: bool = r.get(4)?;
+                Ok(FileCoverage {
+                    path: r.get(0)?,
+                    reference_count: r.get(1)?,
+                    resolved_count: if is_resolved { Some(r.get(5)?) } else { None },
+                    definition_count: r.get(2)?,
+                    line_count: r.get(3)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(entries)
+    }
+
+    /// Loads the stack graph of the given file, if it is not loaded already, and returns the
+    /// file's handle.
+    pub fn load_graph_for_file(&mut self, file: &str) -> Result<Handle<File>> {
+        let project = self.project.clone();
+        self.load_graph(&project, file)
+    }
+
+    /// Loads the stack graph of the given file of the given project, if no file with the same
+    /// path is loaded already, and returns the file's handle.
+    fn load_graph(&mut self, project: &str, file: &str) -> Result<Handle<File>> {
+        if !self.loaded_graphs.contains_key(file) {
+            let (conn, project) =
+                connection_for_file(&self.conn, &self.dependencies, file, project)?;
+            let (id, value): (i64, Vec<u8>) = conn
+                .query_row("SELECT id, value FROM graphs WHERE file = ?", [file], |r| {
+                    Ok((r.get(0)?, r.get(1)?))
+                })
+                .optional()?
+                .ok_or_else(|| StorageError::MissingFile(file.to_string()))?;
+            let file_graph: serde::StackGraph = decode(&value)?;
+            file_graph.load_into(&mut self.graph)?;
+            self.loaded_graphs.insert(file.to_string(), (project, id));
+        }
+        Ok(self.graph.get_file_unchecked(file))
+    }
+
+    /// Returns the files that contain root paths for the given symbol, i.e., partial paths that
+    /// start at the root node and can continue a path that reaches the root node with the symbol
+    /// at the top of its symbol stack.  Files in dependencies are included.  The result is sorted
+    /// by path.
+    pub fn files_for_symbol(&self, symbol: &str) -> Result<Vec<String>> {
+        let files = self
+            .file_handles_for_symbol(symbol)?
+            .into_iter()
+            .map(|(_, file)| file)
+            .collect::<BTreeSet<_>>();
+        Ok(files.into_iter().collect())
+    }
+
+    /// Returns the project and path of the files that contain root paths for the given symbol,
+    /// sorted by project and path.
+    fn file_handles_for_symbol(&self, symbol: &str) -> Result<BTreeSet<(String, String)>> {
+        let mut handles = BTreeSet::new();
+        for conn in std::iter::once(&self.conn).chain(&self.dependencies) {
+            let mut stmt = conn.prepare_cached(
+                "SELECT DISTINCT project, file FROM root_path_symbols WHERE symbol = ? OR symbol = ''",
+            )?;
+            for handle in stmt.query_map([symbol], |r| Ok((r.get(0)?, r.get(1)?)))? {
+                handles.insert(handle?);
+            }
+        }
+        Ok(handles)
+    }
+
+    /// Returns the successfully indexed files that reference or define the given symbol, sorted by
+    /// path.  The files are found using the symbol filter of every file, without loading their
+    /// graphs, so the result can contain a few files that do not contain the symbol.
+    pub fn files_with_symbol(&self, symbol: &str) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT file, symbol_filter FROM files WHERE error IS NULL ORDER BY file",
+        )?;
+        let mut files = Vec::new();
+        let mut rows = stmt.query([])?;
+        while let Some(row) = rows.next()? {
+            let bits: Vec<u8> = row.get(1)?;
+            if (SymbolFilter { bits }).may_contain(symbol) {
+                files.push(row.get(0)?);
+            }
+        }
+        Ok(files)
+    }
+
+    /// Returns the successfully indexed files whose resolutions are not stored, sorted by path.
+    pub fn unresolved_files(&self) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT file FROM files WHERE error IS NULL AND file NOT IN (SELECT file FROM resolved_files) ORDER BY file",
+        )?;
+        let files = stmt
+            .query_map([], |r| r.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(files)
+    }
+
+    /// Returns the stored resolutions of the references in the given file, or `None` if the
+    /// resolutions of the file are not stored.
+    pub fn resolutions_for_file(&self, file: &str) -> Result<Option<Vec<Resolution>>> {
+        let is_resolved = self
+            .conn
+            .query_row(
+                "SELECT 1 FROM resolved_files WHERE file = ?",
+                [file],
+                |_| Ok(()),
+            )
+            .optional()?
+            .is_some();
+        if !is_resolved {
+            return Ok(None);
+        }
+        self.query_resolutions("WHERE reference_file = ?", params![file])
+            .map(Some)
+    }
+
+    /// Returns the stored resolutions of the references that resolve to the given definition.
+    /// The result is only complete if the resolutions of all files are stored, which can be
+    /// checked with [`unresolved_files`][Self::unresolved_files].
+    pub fn resolutions_for_definition(&self, file: &str, local_id: u32) -> Result<Vec<Resolution>> {
+        self.query_resolutions(
+            "WHERE definition_file = ? AND definition_local_id = ?",
+            params![file, local_id],
+        )
+    }
+
+    fn query_resolutions<P: rusqlite::Params>(
+        &self,
+        condition: &str,
+        params: P,
+    ) -> Result<Vec<Resolution>> {
+        let mut stmt = self.conn.prepare_cached(&format!(
+            "SELECT reference_file, reference_local_id, definition_file, definition_local_id, path_length, score FROM resolutions {} ORDER BY reference_file, reference_local_id, definition_file, definition_local_id",
+            condition
+        ))?;
+        let resolutions = stmt
+            .query_map(params, |r| {
+                Ok(Resolution {
+                    reference_file: r.get(0)?,
+                    reference_local_id: r.get(1)?,
+                    definition_file: r.get(2)?,
+                    definition_local_id: r.get(3)?,
+                    path_length: r.get(4)?,
+                    score: r.get(5)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(resolutions)
+    }
+
+    /// Returns the definitions whose symbols match the given query, for workspace-wide symbol
+    /// search.  A symbol matches if it contains the characters of the query in order, ignoring
+    /// the case of ASCII letters.  The best matches come first: exact matches, then matches that
+    /// only differ in case, prefixes, substrings, and finally other matches, with shorter symbols
+    /// before longer ones.  At most `limit` definitions are returned.  The definitions are found
+    /// without loading any graphs.
+    ///
+    /// Symbols that start with the query are found using the symbol index.  Substring and other
+    /// matches require scanning all definitions, which is only done if there are fewer than
+    /// `limit` symbols that start with the query.
+    pub fn find_definitions(&self, query: &str, limit: usize) -> Result<Vec<DefinitionEntry>> {
+        // The symbol column compares symbols ignoring the case of ASCII letters, so this range
+        // selects the symbols that start with the query in the same way as `symbol_match_rank`.
+        let lower_bound = query.to_ascii_lowercase();
+        let mut matches = Vec::new();
+        match prefix_upper_bound(&lower_bound) {
+            Some(upper_bound) => {
+                let mut stmt = self.conn.prepare_cached(
+                    "SELECT file, symbol, syntax_type, span, project FROM definitions WHERE symbol >= ? AND symbol < ?",
+                )?;
+                let rows = stmt.query(params![lower_bound, upper_bound])?;
+                collect_definition_matches(rows, query, 0..=PREFIX_MATCH_RANK, &mut matches)?;
+            }
+            None => {
+                let mut stmt = self.conn.prepare_cached(
+                    "SELECT file, symbol, syntax_type, span, project FROM definitions WHERE symbol >= ?",
+                )?;
+                let rows = stmt.query([lower_bound])?;
+                collect_definition_matches(rows, query, 0..=PREFIX_MATCH_RANK, &mut matches)?;
+            }
+        }
+        if matches.len() < limit {
+            // SQLite's LIKE ignores the case of ASCII letters, so this selects the same symbols as
+            // `symbol_match_rank`.  The symbols that start with the query were found above.
+            let mut pattern = String::from("%");
+            for c in query.chars() {
+                if c == '%' || c == '_' || c == '\\' {
+                    pattern.push('\\');
+                }
+                pattern.push(c);
+                pattern.push('%');
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "SELECT file, symbol, syntax_type, span, project FROM definitions WHERE symbol LIKE ? ESCAPE '\\'",
+            )?;
+            let rows = stmt.query([pattern])?;
+            collect_definition_matches(rows, query, PREFIX_MATCH_RANK + 1..=u8::MAX, &mut matches)?;
+        }
+        matches.sort_by(|(rank_a, a), (rank_b, b)| {
+            rank_a
+                .cmp(rank_b)
+                .then_with(|| a.symbol.len().cmp(&b.symbol.len()))
+                .then_with(|| a.symbol.cmp(&b.symbol))
+                .then_with(|| a.path.cmp(&b.path))
+                .then_with(|| a.span.start.line.cmp(&b.span.start.line))
+        });
+        Ok(matches
+            .into_iter()
+            .take(limit)
+            .map(|(_, entry)| entry)
+            .collect())
+    }
+
+    /// Loads the partial paths of the given file, and of all files that can contain the
+    /// continuation of those paths at the root node, recursively.  This loads everything that is
+    /// needed to resolve the references in the given file, which is usually much less than the
+    /// whole database.
+    ///
+    /// Files are found using the symbols that their partial paths push onto the symbol stack.  Any
+    /// symbol that is at the top of the symbol stack when a path reaches the root node must have
+    /// been pushed by one of the loaded paths, so the files that have root paths for those symbols
+    /// are a superset of the files that are needed.  Files whose paths were loaded already are
+    /// followed as well, so that files that were stored since they were loaded are found.  Files
+    /// are loaded for the project of their root paths, see [`set_project`][Self::set_project].
+    pub fn load_paths_for_file_and_dependencies(&mut self, file: &str) -> Result<()> {
+        let mut queued_files = vec![(self.project.clone(), file.to_string())];
+        let mut seen_files = HashSet::new();
+        let mut seen_symbols = HashSet::new();
+        while let Some((project, file)) = queued_files.pop() {
+            if !seen_files.insert(file.clone()) {
+                continue;
+            }
+            for symbol in self.load_paths(&project, &file)? {
+                if !seen_symbols.insert(symbol.clone()) {
+                    continue;
+                }
+                for (project, file) in self.file_handles_for_symbol(&symbol)? {
+                    if !seen_files.contains(&file) {
+                        queued_files.push((project, file));
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+
+    /// Loads the partial paths of the given file, and the file's stack graph, if they are not
+    /// loaded already.
+    pub fn load_paths_for_file(&mut self, file: &str) -> Result<()> {
+        let project = self.project.clone();
+        self.load_paths(&project, file)?;
+        Ok(())
+    }
+
+    /// Loads the partial paths of the given file of the given project, if they are not loaded
+    /// already, and returns the symbols that they push onto the symbol stack.  The paths are
+    /// loaded for the same file as the graph, if a file with the same path of another project is
+    /// loaded already.
+    fn load_paths(&mut self, project: &str, file: &str) -> Result<HashSet<String>> {
+        self.load_graph(project, file)?;
+        if let Some(pushed_symbols) = self.loaded_paths.get(file) {
+            return Ok(pushed_symbols.clone());
+        }
+        let mut pushed_symbols = HashSet::new();
+        let project = &self.loaded_graphs[file].0;
+        let (conn, _) = connection_for_file(&self.conn, &self.dependencies, file, project)?;
+        let mut stmt = conn.prepare_cached("SELECT value FROM file_paths WHERE file = ?")?;
+        let values = stmt
+            .query_map([file], |r| r.get::<_, Vec<u8>>(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        for value in values {
+            let path: serde::PartialPath = decode(&value)?;
+            let path = path.to_partial_path(&mut self.graph, &mut self.partials)?;
+            for symbol in path
+                .symbol_stack_postcondition
+                .iter_unordered(&self.partials)
+            {
+                pushed_symbols.insert(self.graph[symbol.symbol].to_string());
+            }
+            self.db
+                .add_partial_path(&self.graph, &mut self.partials, path);
+        }
+        self.loaded_paths
+            .insert(file.to_string(), pushed_symbols.clone());
+        Ok(pushed_symbols)
+    }
+
+    /// Loads the stack graphs and partial paths of all files in the database.
+    pub fn load_all(&mut self) -> Result<()> {
+        for entry in self.list_all()? {
+            self.load_paths(&entry.project, &entry.path)?;
+        }
+        Ok(())
+    }
+
+    /// Discards all loaded graphs and paths if another connection changed the data of any of the
+    /// loaded files since they were loaded, or removed any of them.  Returns whether they were
+    /// discarded.  Changes to other files keep the loaded data, because files that are stored later
+    /// are still found when the files they depend on are loaded, so that readers stay warm while
+    /// unrelated files are indexed.  Readers that are reused for several queries, e.g., by a
+    /// server, should call this before every query, so that results never mix stale and current
+    /// data.
+    pub fn clear_if_changed(&mut self) -> Result<bool> {
+        let data_version = data_version(&self.conn)?;
+        if data_version == self.data_version {
+            return Ok(false);
+        }
+        self.data_version = data_version;
+        if !self.loaded_graphs_changed()? {
+            return Ok(false);
+        }
+        self.loaded_graphs.clear();
+        self.loaded_paths.clear();
+        self.graph = StackGraph::new();
+        self.partials = PartialPaths::new();
+        self.db = Database::new();
+        Ok(true)
+    }
+
+    /// Returns whether the stored graph of any of the loaded files is not the one that was loaded.
+    /// Storing a file with a different graph or different partial paths always stores a new
+    /// graph.
+    fn loaded_graphs_changed(&self) -> Result<bool> {
+        for (file, (project, loaded_id)) in &self.loaded_graphs {
+            let conn = match connection_for_file(&self.conn, &self.dependencies, file, project) {
+                Ok((conn, stored_project)) if stored_project == *project => conn,
+                Ok(_) => return Ok(true),
+                Err(StorageError::MissingFile(_)) | Err(StorageError::AmbiguousFile(_)) => {
+                    return Ok(true)
+                }
+                Err(err) => return Err(err),
+            };
+            let id: Option<i64> = conn
+                .prepare_cached("SELECT id FROM graphs WHERE file = ?")?
+                .query_row([file], |r| r.get(0))
+                .optional()?;
+            if id != Some(*loaded_id) {
+                return Ok(true);
+            }
+        }
+        Ok(false)
+    }
+
+    /// Returns the stack graph, partial paths, and partial path database containing the data that
+    /// has been loaded so far.
+    pub fn get(&mut self) -> (&mut StackGraph, &mut PartialPaths, &mut Database) {
+        (&mut self.graph, &mut self.partials, &mut self.db)
+    }
+}
+
+/// Returns the connection of the database that contains the stack graph of the given file, which
+/// is either the reader's own database or one of its dependencies, together with the project that
+/// the file belongs to.  If more than one of them contains the file, the one that stores it for
+/// the given project is used.  Fails if that does not pick a single database, because their
+/// graphs would be mixed up.
+fn connection_for_file<'a>(
+    conn: &'a Connection,
+    dependencies: &'a [Connection],
+    file: &str,
+    project: &str,
+) -> Result<(&'a Connection, String)> {
+    let mut found = Vec::new();
+    for conn in std::iter::once(conn).chain(dependencies) {
+        let stored_project: Option<String> = conn
+            .prepare_cached(
+                "SELECT files.project FROM graphs JOIN files ON files.file = graphs.file WHERE graphs.file = ?",
+            )?
+            .query_row([file], |r| r.get(0))
+            .optional()?;
+        if let Some(stored_project) = stored_project {
+            found.push((conn, stored_project));
+        }
+    }
+    if found.len() > 1 {
+        found.retain(|(_, stored_project)| stored_project == project);
+        if found.len() != 1 {
+            return Err(StorageError::AmbiguousFile(file.to_string()));
+        }
+    }
+    found
+        .pop()
+        .ok_or_else(|| StorageError::MissingFile(file.to_string()))
+}
+
+/// Returns a number that changes whenever another connection commits changes to the database.
+fn data_version(conn: &Connection) -> Result<i64> {
+    Ok(conn.pragma_query_value(None, "data_version", |r| r.get(0))?)
+}
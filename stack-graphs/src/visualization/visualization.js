@@ -12,6 +12,7 @@ class StackGraph {
     static margin = 6;
     static distx = 15;
     static disty = 15;
+    static step_delay = 750;
 
     constructor(container, graph, paths, metadata) {
         this.metadata = metadata;
@@ -24,7 +25,9 @@ class StackGraph {
         this.current_edge = null;
         this.current_orient = { y: "south", x: "east" };
         this.paths_lock = null;
+        this.paths_timer = null;
         this.render();
+        this.paths_select_from_location();
     }
 
     compute_data() {
@@ -68,7 +71,8 @@ class StackGraph {
                     });
                 }
             }
-            path.derived = { nodes, edges };
+            const steps = node_ids.map((id) => this.node_id_to_str(id));
+            path.derived = { nodes, edges, steps };
             this.compute_path_stacks(path);
             this.N[this.ID[this.node_id_to_str(path.start_node)]].paths.push(path);
         }
@@ -135,6 +139,7 @@ class StackGraph {
         // render UI
         this.render_help();
         this.render_tooltip();
+        this.render_steps();
         this.render_graph()
 
         // pan & zoom
@@ -419,13 +424,15 @@ class StackGraph {
         if (this.paths_lock === null) {
             if (node.paths.length > 0) {
                 this.paths_nolight(node);
-                this.paths_lock = { node, path: 0 };
+                this.paths_lock = { node, path: 0, step: null };
                 this.paths_highlight(node, 0);
                 this.tooltip_update();
             }
         } else if (this.paths_lock.node === node) {
+            this.paths_stop();
             this.paths_nolight(node, this.paths_lock.path);
             this.paths_lock.path += 1;
+            this.paths_lock.step = null;
             if (this.paths_lock.path >= node.paths.length) {
                 this.paths_lock = null;
                 this.paths_highlight(node);
@@ -433,35 +440,114 @@ class StackGraph {
                 this.paths_highlight(node, this.paths_lock.path);
             }
             this.tooltip_update();
+            this.steps_update();
         }
     }
 
     paths_keypress(e) {
         if (this.paths_lock !== null) {
             if (e.keyCode === 27) {
+                this.paths_stop();
                 this.paths_nolight(this.paths_lock.node);
                 this.node_defocus(this.paths_lock.node);
                 this.paths_lock = null;
+                this.steps_update();
                 if (this.current_node !== null) {
                     this.node_focus(this.current_node);
                     this.paths_highlight(this.current_node);
                     this.tooltip_update();
                 }
-            } else if (e.keyCode == 78) {
+            } else if (e.keyCode == 78) { // n
+                this.paths_stop();
                 this.paths_nolight(this.paths_lock.node, this.paths_lock.path);
                 this.paths_lock.path += 1;
+                this.paths_lock.step = null;
                 if (this.paths_lock.path >= this.paths_lock.node.paths.length) {
                     this.paths_lock.path = 0;
                 }
                 this.paths_highlight(this.paths_lock.node, this.paths_lock.path);
+                this.steps_update();
                 if (this.current_node !== null) {
                     this.tooltip_update();
                 }
+            } else if (e.keyCode === 39) { // right arrow
+                this.paths_stop();
+                this.paths_step(1);
+            } else if (e.keyCode === 37) { // left arrow
+                this.paths_stop();
+                this.paths_step(-1);
+            } else if (e.keyCode === 80) { // p
+                if (this.paths_timer !== null) {
+                    this.paths_stop();
+                } else {
+                    this.paths_play();
+                }
             }
         }
     }
 
-    paths_highlight(node, path) {
+    // Selects the paths of the node given by the `path` parameter in the URL of the page, if
+    // any, and starts stepping through the first one.
+    paths_select_from_location() {
+        const node_id = new URLSearchParams(window.location.search).get("path");
+        if (node_id === null) {
+            return;
+        }
+        const node = this.N[this.ID[node_id]];
+        if (node === undefined || node.paths.length === 0) {
+            console.log("No paths start at node ", node_id);
+            return;
+        }
+        this.paths_lock = { node, path: 0, step: null };
+        this.paths_highlight(node, 0);
+        this.paths_play();
+    }
+
+    // Moves the selected path forward or backward by the given number of steps.  Returns
+    // whether there was a step to move to.
+    paths_step(delta) {
+        const lock = this.paths_lock;
+        const steps = lock.node.paths[lock.path].derived.steps;
+        let step = lock.step;
+        if (step === null) {
+            step = (delta > 0) ? -1 : steps.length;
+        }
+        step += delta;
+        if (step < 0 || step >= steps.length) {
+            return false;
+        }
+        this.paths_nolight(lock.node, lock.path);
+        lock.step = step;
+        this.paths_highlight(lock.node, lock.path, step);
+        this.steps_update();
+        return true;
+    }
+
+    paths_play() {
+        const lock = this.paths_lock;
+        if (lock.step !== null && lock.step + 1 >= lock.node.paths[lock.path].derived.steps.length) {
+            lock.step = null;
+        }
+        this.paths_step(1);
+        this.paths_timer = d3.interval(() => {
+            if (!this.paths_step(1)) {
+                this.paths_stop();
+            }
+        }, StackGraph.step_delay);
+    }
+
+    paths_stop() {
+        if (this.paths_timer !== null) {
+            this.paths_timer.stop();
+            this.paths_timer = null;
+        }
+    }
+
+    paths_highlight(node, path, step) {
+        if (step !== undefined) {
+            this.paths_highlight_step(node.paths[path], step);
+            return;
+        }
         const paths = (path !== undefined) ? [node.paths[path]] : node.paths;
         const nodes = {};
         const edges = {};
@@ -495,6 +581,19 @@ class StackGraph {
         }
     }
 
+    // Highlights the part of the path up to and including the given step, and the node of that
+    // step.
+    paths_highlight_step(path, step) {
+        const steps = path.derived.steps;
+        d3.select(this.id_selector(steps[0])).classed("path-node path-endpoint", true);
+        for (let i = 1; i <= step; i++) {
+            const edge_id = this.edge_to_id_str_from_strs(steps[i - 1], steps[i]);
+            d3.select(this.id_selector(edge_id)).classed("path-edge", true);
+            d3.select(this.id_selector(steps[i])).classed("path-node", true);
+        }
+        d3.select(this.id_selector(steps[step])).classed("path-step", true);
+    }
+
     paths_nolight(node, path) {
         const paths = (path !== undefined) ? [node.paths[path]] : node.paths;
         for (let path of paths) {
@@ -502,6 +601,7 @@ class StackGraph {
                 const g = d3.select(this.id_selector(node_id));
                 g.classed("path-node", false);
                 g.classed("path-endpoint", false);
+                g.classed("path-step", false);
             }
             for (let edge_id in path.derived.edges) {
                 const g = d3.select(this.id_selector(edge_id));
@@ -696,6 +796,66 @@ class StackGraph {
             || (this.current_edge !== null && path.derived.edges.hasOwnProperty(this.edge_to_id_str(this.current_edge)));
     }
 
+    // ------------------------------------------------------------------------------------------------
+    // Path Steps
+    //
+
+    render_steps() {
+        d3.select('body').append('div')
+            .attr('id', 'sg-steps');
+    }
+
+    // Shows the node and the stacks at the current step of the selected path, or hides the
+    // steps if the selected path is not being stepped through.
+    steps_update() {
+        const steps = d3.select('#sg-steps');
+        const lock = this.paths_lock;
+        if (lock === null || lock.step === null) {
+            steps.style('visibility', 'hidden');
+            return;
+        }
+        steps.selectAll("*").remove();
+
+        const path = lock.node.paths[lock.path];
+        const node_id = path.derived.steps[lock.step];
+        const node = this.N[this.ID[node_id]];
+        const node_data = path.derived.nodes[node_id];
+        steps.append("div")
+            .attr("class", "sg-steps-header")
+            .text(`step ${lock.step + 1} of ${path.derived.steps.length} (path ${lock.path + 1} of ${lock.node.paths.length})`);
+        const tbody = steps.append("table")
+            .attr("class", "sg-tooltip-table")
+            .append("tbody");
+        function add_row(label, value) {
+            const tr = tbody.append("tr");
+            tr.append("td").attr("class", "sg-tooltip-label").text(label);
+            const td = tr.append("td").attr("class", "sg-tooltip-value");
+            if (Array.isArray(value)) {
+                const ul = td.append("ul").attr("class", "sg-tooltip-list");
+                for (let element of value) {
+                    ul.append("li")
+                        .attr("class", "sg-tooltip-list-element")
+                        .text(Array.isArray(element) ? `${element[0]} / ${element[1].join(", ")}` : element);
+                }
+            } else {
+                td.text(value);
+            }
+        }
+        add_row("node", node_id);
+        add_row("type", node.type);
+        if (node.hasOwnProperty("symbol")) {
+            add_row("symbol", node.symbol);
+        }
+        if (this.node_has_source_info(node)) {
+            add_row("location", this.source_info_to_str(node.source_info));
+        }
+        // stacks are only recorded for the last visit of a node, which is the same for the
+        // acyclic paths that path finding produces
+        add_row("symbol stack", this.symbol_stack_to_array(node_data.symbol_stack));
+        add_row("scope stack", this.scope_stack_to_array(node_data.scope_stack));
+        steps.style('visibility', 'visible');
+    }
+
     // ------------------------------------------------------------------------------------------------
     // Help
     //
@@ -739,6 +899,10 @@ class StackGraph {
             Cycle through selected paths using the key <kbd>n</kbd>.
             Path selection ends after cycling through all paths by clicking the node, or by pressing the <kbd>esc</kbd> key.
         `);
+        help_content.append("p").html(`
+            Step through the selected path using the <kbd>&larr;</kbd> and <kbd>&rarr;</kbd> keys, or play it step by step using the key <kbd>p</kbd>, to see the symbol and scope stacks after every node.
+            Open the page with <code>?path=FILE%23ID</code> appended to its URL to play the first path of a node, such as a reference, right away.
+        `);
 
         help_content.append("p").attr("class", "sg-help-meta").html(`
             Toggle visibility of this help anytime by pressing <kbd>h</kbd>.
@@ -798,7 +962,11 @@ class StackGraph {
     }
 
     edge_to_id_str(edge) {
-        return this.node_id_to_str(edge.source) + "->" + this.node_id_to_str(edge.sink);
+        return this.edge_to_id_str_from_strs(this.node_id_to_str(edge.source), this.node_id_to_str(edge.sink));
+    }
+
+    edge_to_id_str_from_strs(source_id, sink_id) {
+        return source_id + "->" + sink_id;
     }
 
     node_id_to_str(id) {
@@ -682,6 +682,7 @@ fn can_create_source_info() {
             syntax_type,
             containing_line,
             definiens_span: sg_span::default(),
+            documentation: 0,
         },
     }];
     infos[0].source_info.span.start.line = 17;
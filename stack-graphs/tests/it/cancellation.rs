@@ -0,0 +1,59 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use std::time::Duration;
+
+use stack_graphs::cancellation::AtomicCancellationFlag;
+use stack_graphs::cancellation::CancelAfterDuration;
+use stack_graphs::cancellation::CancellationError;
+use stack_graphs::cancellation::CancellationFlag;
+use stack_graphs::cancellation::NoCancellation;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+
+use crate::test_graphs;
+
+fn count_partial_paths(
+    graph: &StackGraph,
+    cancellation_flag: &dyn CancellationFlag,
+) -> Result<usize, CancellationError> {
+    let mut partials = PartialPaths::new();
+    let mut count = 0;
+    for file in graph.iter_files() {
+        partials.find_all_partial_paths_in_file_with_cancellation(
+            graph,
+            file,
+            cancellation_flag,
+            |_, _, _| count += 1,
+        )?;
+    }
+    Ok(count)
+}
+
+#[test]
+fn uncancelled_search_finds_all_partial_paths() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut partials = PartialPaths::new();
+    let mut expected = 0;
+    for file in graph.iter_files() {
+        partials.find_all_partial_paths_in_file(&graph, file, |_, _, _| expected += 1);
+    }
+    assert!(expected > 0);
+    assert_eq!(Ok(expected), count_partial_paths(&graph, &NoCancellation));
+    let flag = CancelAfterDuration::new(Duration::from_secs(3600));
+    assert_eq!(Ok(expected), count_partial_paths(&graph, &flag));
+}
+
+#[test]
+fn can_cancel_partial_path_search() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let flag = AtomicCancellationFlag::new();
+    flag.cancel();
+    assert!(count_partial_paths(&graph, &flag).is_err());
+    let flag = CancelAfterDuration::new(Duration::from_secs(0));
+    assert!(count_partial_paths(&graph, &flag).is_err());
+}
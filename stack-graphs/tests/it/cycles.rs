@@ -0,0 +1,100 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::cycles::CycleDetector;
+use stack_graphs::cycles::CycleDetectorStats;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::paths::Path;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::Database;
+use stack_graphs::stitching::PathStitcher;
+
+use crate::test_graphs;
+use crate::test_graphs::CreateStackGraph;
+
+#[test]
+fn skips_duplicate_paths() {
+    let mut graph = StackGraph::new();
+    let file = graph.file("test");
+    let scope = graph.internal_scope(file, 0);
+
+    let mut paths = Paths::new();
+    let mut cycle_detector = CycleDetector::new();
+    let path = Path::from_node(&graph, &mut paths, scope).unwrap();
+    assert!(cycle_detector.should_process_path(&path, |probe| probe.cmp(&graph, &mut paths, &path)));
+    assert!(
+        !cycle_detector.should_process_path(&path, |probe| probe.cmp(&graph, &mut paths, &path))
+    );
+    assert_eq!(
+        CycleDetectorStats {
+            processed_paths: 1,
+            duplicate_paths: 1,
+            similar_paths: 0,
+        },
+        cycle_detector.stats()
+    );
+}
+
+#[test]
+fn skips_similar_paths_around_a_cycle() {
+    let mut graph = StackGraph::new();
+    let file = graph.file("test");
+    let a = graph.internal_scope(file, 0);
+    let b = graph.internal_scope(file, 1);
+    graph.edge(a, b);
+    graph.edge(b, a);
+
+    // Walk around the cycle, so that each path is one edge longer than the previous one.  Paths
+    // alternate between ending at `b` and ending at `a`, so each of the two (start, end) buckets
+    // sees 10 paths, and accepts the first 5 of them.
+    let mut paths = Paths::new();
+    let mut cycle_detector = CycleDetector::new();
+    let mut path = Path::from_node(&graph, &mut paths, a).unwrap();
+    for _ in 0..20 {
+        cycle_detector.should_process_path(&path, |probe| probe.cmp(&graph, &mut paths, &path));
+        let mut extensions = Vec::new();
+        path.extend(&graph, &mut paths, &mut extensions);
+        assert_eq!(1, extensions.len());
+        path = extensions.pop().unwrap();
+    }
+    assert_eq!(
+        CycleDetectorStats {
+            processed_paths: 10,
+            duplicate_paths: 0,
+            similar_paths: 10,
+        },
+        cycle_detector.stats()
+    );
+}
+
+#[test]
+fn path_stitching_terminates_on_cyclic_imports() {
+    let graph = test_graphs::cyclic_imports_rust::new();
+    let mut paths = Paths::new();
+    let mut partials = PartialPaths::new();
+    let mut db = Database::new();
+    for file in graph.iter_files() {
+        partials.find_all_partial_paths_in_file(&graph, file, |graph, partials, path| {
+            if path.is_complete_as_possible(graph) && path.is_productive(partials) {
+                db.add_partial_path(graph, partials, path);
+            }
+        });
+    }
+
+    let references = graph
+        .iter_nodes()
+        .filter(|handle| graph[*handle].is_reference())
+        .collect::<Vec<_>>();
+    let mut stitcher = PathStitcher::new(&graph, &mut paths, &mut partials, &mut db, references);
+    while !stitcher.is_complete() {
+        stitcher.process_next_phase(&graph, &mut paths, &mut partials, &mut db);
+    }
+    let stats = stitcher.cycle_detector_stats();
+    assert!(stats.processed_paths > 0);
+}
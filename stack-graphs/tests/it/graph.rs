@@ -202,3 +202,45 @@ fn can_add_graph_to_empty_graph() {
         );
     }
 }
+
+#[test]
+fn stack_graphs_can_be_sent_to_other_threads() {
+    fn assert_send<T: Send>(_: &T) {}
+    let graph = test_graphs::simple::new();
+    assert_send(&graph);
+    let handle = std::thread::spawn(move || graph.iter_files().count());
+    assert_eq!(1, handle.join().unwrap());
+}
+
+#[test]
+fn can_display_edges() {
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let scope = graph.internal_scope(file, 0);
+    graph.add_edge(scope, StackGraph::root_node(), 1);
+    let edges = graph
+        .outgoing_edges(scope)
+        .map(|edge| edge.display(&graph).to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(edges, vec!["[test.py(0) scope] -1-> [root]"]);
+}
+
+#[test]
+fn can_display_nodes_with_location() {
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let scope = graph.internal_scope(file, 0);
+    assert_eq!(
+        scope.display_with_location(&graph).to_string(),
+        "[test.py(0) scope]"
+    );
+    let span = &mut graph.source_info_mut(scope).span;
+    span.start.line = 2;
+    span.start.column.grapheme_offset = 4;
+    span.end.line = 2;
+    span.end.column.grapheme_offset = 7;
+    assert_eq!(
+        scope.display_with_location(&graph).to_string(),
+        "[test.py(0) scope] at 3:5-3:8"
+    );
+}
@@ -12,6 +12,7 @@ pub mod test_graphs;
 
 mod arena;
 mod c;
+mod cancellation;
 mod can_create_graph;
 mod can_find_local_nodes;
 mod can_find_node_partial_paths_in_database;
@@ -20,8 +21,17 @@ mod can_find_root_partial_paths_in_database;
 mod can_jump_to_definition;
 mod can_jump_to_definition_with_forward_partial_path_stitching;
 mod can_jump_to_definition_with_forward_path_stitching;
+mod cycles;
 mod graph;
 #[cfg(feature = "json")]
 mod json;
 mod partial;
 mod paths;
+#[cfg(feature = "proto")]
+mod proto;
+#[cfg(feature = "json")]
+mod serde;
+mod snapshot;
+mod stitching_limits;
+#[cfg(feature = "storage")]
+mod storage;
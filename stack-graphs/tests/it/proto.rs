@@ -0,0 +1,97 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use lsp_positions::Span;
+use pretty_assertions::assert_eq;
+use prost::Message;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::proto;
+
+use crate::test_graphs;
+
+fn include_all(_: &StackGraph, _: &Handle<File>) -> bool {
+    true
+}
+
+#[test]
+fn can_round_trip_graph() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let bytes = proto::encode_graph(&graph, &include_all);
+    let decoded = proto::decode_graph(&bytes).expect("Cannot decode graph");
+
+    let mut loaded = StackGraph::new();
+    decoded.load_into(&mut loaded).expect("Cannot load graph");
+    assert_eq!(
+        graph.to_snapshot(&include_all),
+        loaded.to_snapshot(&include_all)
+    );
+}
+
+#[test]
+fn can_round_trip_partial_paths() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut loaded = StackGraph::new();
+    proto::decode_graph(&proto::encode_graph(&graph, &include_all))
+        .expect("Cannot decode graph")
+        .load_into(&mut loaded)
+        .expect("Cannot load graph");
+
+    let mut partials = PartialPaths::new();
+    let mut loaded_partials = PartialPaths::new();
+    for file in graph.iter_files() {
+        let mut paths = Vec::new();
+        partials.find_all_partial_paths_in_file(&graph, file, |graph, partials, path| {
+            if path.is_complete_as_possible(graph) && path.is_productive(partials) {
+                paths.push(path);
+            }
+        });
+        let bytes = proto::encode_partial_paths(&graph, &mut partials, &paths);
+        let decoded = proto::decode_partial_paths(&bytes).expect("Cannot decode paths");
+        assert_eq!(paths.len(), decoded.len());
+        for (path, decoded) in paths.iter().zip(decoded) {
+            let loaded_path = decoded
+                .to_partial_path(&mut loaded, &mut loaded_partials)
+                .expect("Cannot load path");
+            assert_eq!(
+                path.display(&graph, &mut partials).to_string(),
+                loaded_path
+                    .display(&loaded, &mut loaded_partials)
+                    .to_string()
+            );
+        }
+    }
+}
+
+#[test]
+fn can_round_trip_query_results() {
+    let results = vec![proto::QueryResult {
+        reference: Some(proto::Location::new("main.py", &Span::default())),
+        definitions: vec![
+            proto::Location::new("a.py", &Span::default()),
+            proto::Location::new("b.py", &Span::default()),
+        ],
+        scores: vec![0.75, 0.5],
+    }];
+    let bytes = proto::encode_query_results(&results);
+    let decoded = proto::decode_query_results(&bytes).expect("Cannot decode results");
+    assert_eq!(results, decoded);
+}
+
+#[test]
+fn cannot_decode_other_schema_version() {
+    let message = proto::QueryResults {
+        version: proto::SCHEMA_VERSION + 1,
+        results: vec![],
+    };
+    assert!(matches!(
+        proto::decode_query_results(&message.encode_to_vec()),
+        Err(proto::Error::UnsupportedVersion(_))
+    ));
+}
@@ -0,0 +1,189 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPath;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::serde;
+
+use crate::test_graphs;
+use crate::test_graphs::CreateStackGraph;
+
+fn include_all(_: &StackGraph, _: &Handle<File>) -> bool {
+    true
+}
+
+fn partial_paths_in_file(
+    graph: &StackGraph,
+    partials: &mut PartialPaths,
+    file: Handle<File>,
+) -> Vec<PartialPath> {
+    let mut paths = Vec::new();
+    partials.find_all_partial_paths_in_file(graph, file, |graph, partials, path| {
+        if !path.is_complete_as_possible(graph) {
+            return;
+        }
+        if !path.is_productive(partials) {
+            return;
+        }
+        paths.push(path);
+    });
+    paths
+}
+
+#[test]
+fn can_round_trip_graph() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let serialized = serde::StackGraph::from_graph(&graph, &include_all);
+    let json = serde_json::to_string(&serialized).expect("Cannot serialize graph");
+    let deserialized: serde::StackGraph =
+        serde_json::from_str(&json).expect("Cannot deserialize graph");
+    assert_eq!(serialized, deserialized);
+
+    let mut loaded = StackGraph::new();
+    deserialized
+        .load_into(&mut loaded)
+        .expect("Cannot load graph");
+    assert_eq!(
+        graph.to_snapshot(&include_all),
+        loaded.to_snapshot(&include_all)
+    );
+}
+
+#[test]
+fn can_load_graph_from_json_output() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let json = graph
+        .to_json(&include_all)
+        .to_string()
+        .expect("Cannot serialize graph");
+    let deserialized = serde::StackGraph::from_json(&json).expect("Cannot parse graph");
+    assert_eq!(
+        serde::StackGraph::from_graph(&graph, &include_all),
+        deserialized
+    );
+}
+
+/// Creates a graph with a definition and a reference in each of the given files, adding the
+/// files, and the nodes within them, in the given order.
+fn two_files(file_names: &[&str], local_ids: &[u32]) -> StackGraph {
+    let mut graph = StackGraph::new();
+    let root = graph.root_node();
+    let sym_x = graph.symbol("x");
+    for file_name in file_names {
+        let file = graph.file(file_name);
+        for local_id in local_ids {
+            match local_id {
+                1 => {
+                    let def_x = graph.definition(file, 1, sym_x);
+                    graph.edge(root, def_x);
+                }
+                _ => {
+                    let ref_x = graph.reference(file, *local_id, sym_x);
+                    graph.edge(ref_x, root);
+                }
+            }
+        }
+    }
+    graph
+}
+
+#[test]
+fn serialization_does_not_depend_on_construction_order() {
+    let graph = two_files(&["a.py", "b.py"], &[1, 2]);
+    let other = two_files(&["b.py", "a.py"], &[2, 1]);
+    assert_eq!(
+        serde::StackGraph::from_graph(&graph, &include_all),
+        serde::StackGraph::from_graph(&other, &include_all)
+    );
+    assert_eq!(
+        graph
+            .to_json(&include_all)
+            .to_string()
+            .expect("Cannot serialize graph"),
+        other
+            .to_json(&include_all)
+            .to_string()
+            .expect("Cannot serialize graph")
+    );
+}
+
+#[test]
+fn can_load_files_separately() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut loaded = StackGraph::new();
+    for file in graph.iter_files() {
+        serde::StackGraph::from_graph(&graph, &|_: &StackGraph, f: &Handle<File>| *f == file)
+            .load_into(&mut loaded)
+            .expect("Cannot load file");
+    }
+    assert_eq!(
+        graph.to_snapshot(&include_all),
+        loaded.to_snapshot(&include_all)
+    );
+}
+
+#[test]
+fn cannot_load_file_twice() {
+    let graph = test_graphs::simple::new();
+    let serialized = serde::StackGraph::from_graph(&graph, &include_all);
+    let mut loaded = StackGraph::new();
+    serialized
+        .load_into(&mut loaded)
+        .expect("Cannot load graph");
+    assert!(serialized.load_into(&mut loaded).is_err());
+}
+
+#[test]
+fn can_round_trip_partial_paths() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut loaded = StackGraph::new();
+    serde::StackGraph::from_graph(&graph, &include_all)
+        .load_into(&mut loaded)
+        .expect("Cannot load graph");
+
+    let mut partials = PartialPaths::new();
+    let mut loaded_partials = PartialPaths::new();
+    for file in graph.iter_files() {
+        for path in partial_paths_in_file(&graph, &mut partials, file) {
+            let serialized = serde::PartialPath::from_partial_path(&graph, &mut partials, &path);
+            let json = serde_json::to_string(&serialized).expect("Cannot serialize path");
+            let deserialized: serde::PartialPath =
+                serde_json::from_str(&json).expect("Cannot deserialize path");
+            let loaded_path = deserialized
+                .to_partial_path(&mut loaded, &mut loaded_partials)
+                .expect("Cannot load path");
+            assert_eq!(
+                path.display(&graph, &mut partials).to_string(),
+                loaded_path
+                    .display(&loaded, &mut loaded_partials)
+                    .to_string()
+            );
+        }
+    }
+}
+
+#[test]
+fn cannot_load_partial_path_with_missing_nodes() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut partials = PartialPaths::new();
+    let file = graph.iter_files().next().unwrap();
+    let path = partial_paths_in_file(&graph, &mut partials, file)
+        .into_iter()
+        .next()
+        .expect("Missing partial path");
+    let serialized = serde::PartialPath::from_partial_path(&graph, &mut partials, &path);
+
+    let mut empty = StackGraph::new();
+    let mut empty_partials = PartialPaths::new();
+    assert!(serialized
+        .to_partial_path(&mut empty, &mut empty_partials)
+        .is_err());
+}
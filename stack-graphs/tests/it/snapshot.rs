@@ -0,0 +1,83 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::StackGraph;
+
+use crate::test_graphs::CreateStackGraph;
+
+fn include_all(_: &StackGraph, _: &Handle<File>) -> bool {
+    true
+}
+
+#[test]
+fn snapshot_lists_nodes_and_edges() {
+    let mut graph = StackGraph::new();
+    let file = graph.file("test.py");
+    let sym = graph.symbol("x");
+    let root = graph.root_node();
+    let reference = graph.reference(file, 1, sym);
+    let scope = graph.exported_scope(file, 2);
+    let definition = graph.definition(file, 3, sym);
+    graph.edge(reference, scope);
+    graph.edge(scope, root);
+    graph.add_edge(scope, definition, 1);
+
+    let expected = r#"nodes:
+  [test.py(1) reference x]
+  [test.py(2) exported scope]
+  [test.py(3) definition x]
+edges:
+  [test.py(1)] -> [test.py(2)]
+  [test.py(2)] -> [root]
+  [test.py(2)] -> [test.py(3)] precedence 1
+"#;
+    assert_eq!(expected, graph.to_snapshot(&include_all));
+}
+
+#[test]
+fn snapshot_does_not_depend_on_creation_order() {
+    let mut graph1 = StackGraph::new();
+    let file = graph1.file("test.py");
+    let sym = graph1.symbol("x");
+    let a = graph1.internal_scope(file, 1);
+    let b = graph1.internal_scope(file, 10);
+    let c = graph1.internal_scope(file, 2);
+    graph1.edge(a, b);
+    graph1.edge(a, c);
+    let d = graph1.definition(file, 3, sym);
+    graph1.edge(c, d);
+
+    let mut graph2 = StackGraph::new();
+    let file = graph2.file("test.py");
+    let sym = graph2.symbol("x");
+    let d = graph2.definition(file, 3, sym);
+    let c = graph2.internal_scope(file, 2);
+    let b = graph2.internal_scope(file, 10);
+    let a = graph2.internal_scope(file, 1);
+    graph2.edge(c, d);
+    graph2.edge(a, c);
+    graph2.edge(a, b);
+
+    assert_eq!(
+        graph1.to_snapshot(&include_all),
+        graph2.to_snapshot(&include_all)
+    );
+}
+
+#[test]
+fn snapshot_only_includes_filtered_files() {
+    let mut graph = StackGraph::new();
+    let included = graph.file("included.py");
+    let excluded = graph.file("excluded.py");
+    graph.internal_scope(included, 1);
+    graph.internal_scope(excluded, 1);
+    let snapshot = graph.to_snapshot(&|_: &StackGraph, f: &Handle<File>| *f == included);
+    assert_eq!("nodes:\n  [included.py(1) scope]\nedges:\n", snapshot);
+}
@@ -0,0 +1,174 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPath;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::paths::Path;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::Database;
+use stack_graphs::stitching::ForwardPartialPathStitcher;
+use stack_graphs::stitching::PathStitcher;
+use stack_graphs::stitching::StitchingLimit;
+use stack_graphs::stitching::StitchingLimits;
+use stack_graphs::stitching::StitchingResult;
+
+use crate::test_graphs;
+
+fn build_database(graph: &StackGraph, partials: &mut PartialPaths) -> Database {
+    let mut db = Database::new();
+    for file in graph.iter_files() {
+        partials.find_all_partial_paths_in_file(graph, file, |graph, partials, path| {
+            if !path.is_complete_as_possible(graph) {
+                return;
+            }
+            if !path.is_productive(partials) {
+                return;
+            }
+            db.add_partial_path(graph, partials, path);
+        });
+    }
+    db
+}
+
+fn find_complete_paths(graph: &StackGraph, limits: StitchingLimits) -> StitchingResult<Path> {
+    let mut paths = Paths::new();
+    let mut partials = PartialPaths::new();
+    let mut db = build_database(graph, &mut partials);
+    let references = graph
+        .iter_nodes()
+        .filter(|handle| graph[*handle].is_reference());
+    PathStitcher::find_all_complete_paths_with_limits(
+        graph,
+        &mut paths,
+        &mut partials,
+        &mut db,
+        references,
+        limits,
+    )
+}
+
+fn find_complete_partial_paths(
+    graph: &StackGraph,
+    limits: StitchingLimits,
+) -> StitchingResult<PartialPath> {
+    let mut partials = PartialPaths::new();
+    let mut db = build_database(graph, &mut partials);
+    let references = graph
+        .iter_nodes()
+        .filter(|handle| graph[*handle].is_reference());
+    ForwardPartialPathStitcher::find_all_complete_partial_paths_with_limits(
+        graph,
+        &mut partials,
+        &mut db,
+        references,
+        limits,
+    )
+}
+
+#[test]
+fn unlimited_stitching_is_complete() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let result = find_complete_paths(&graph, StitchingLimits::default());
+    assert!(result.paths.len() >= 6);
+    assert_eq!(None, result.limit_reached);
+    assert!(!result.is_possibly_incomplete());
+
+    let result = find_complete_partial_paths(&graph, StitchingLimits::default());
+    assert!(result.paths.len() >= 6);
+    assert_eq!(None, result.limit_reached);
+}
+
+#[test]
+fn can_limit_phases() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let limits = StitchingLimits {
+        max_phases: Some(0),
+        ..StitchingLimits::default()
+    };
+
+    let result = find_complete_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::Phases), result.limit_reached);
+    assert!(result.paths.len() < 6);
+
+    let result = find_complete_partial_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::Phases), result.limit_reached);
+    assert!(result.paths.len() < 6);
+}
+
+#[test]
+fn can_limit_processed_paths() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let limits = StitchingLimits {
+        max_processed_paths: Some(1),
+        ..StitchingLimits::default()
+    };
+
+    let result = find_complete_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::ProcessedPaths), result.limit_reached);
+    assert!(result.paths.len() < 6);
+
+    let result = find_complete_partial_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::ProcessedPaths), result.limit_reached);
+    assert!(result.paths.len() < 6);
+}
+
+#[test]
+fn cycle_detector_does_not_see_paths_beyond_processed_path_limit() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut paths = Paths::new();
+    let mut partials = PartialPaths::new();
+    let mut db = build_database(&graph, &mut partials);
+    let references = graph
+        .iter_nodes()
+        .filter(|handle| graph[*handle].is_reference())
+        .collect::<Vec<_>>();
+    let mut stitcher = PathStitcher::new(&graph, &mut paths, &mut partials, &mut db, references);
+    stitcher.set_limits(StitchingLimits {
+        max_processed_paths: Some(1),
+        ..StitchingLimits::default()
+    });
+    while !stitcher.is_complete() {
+        stitcher.process_next_phase(&graph, &mut paths, &mut partials, &mut db);
+    }
+    let stats = stitcher.cycle_detector_stats();
+    assert_eq!(1, stats.processed_paths + stats.skipped_paths());
+}
+
+#[test]
+fn can_limit_complete_paths() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let limits = StitchingLimits {
+        max_complete_paths: Some(2),
+        ..StitchingLimits::default()
+    };
+
+    let result = find_complete_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::CompletePaths), result.limit_reached);
+    assert_eq!(2, result.paths.len());
+
+    let result = find_complete_partial_paths(&graph, limits);
+    assert_eq!(Some(StitchingLimit::CompletePaths), result.limit_reached);
+    assert_eq!(2, result.paths.len());
+}
+
+#[test]
+fn limits_that_are_not_reached_do_not_mark_results_incomplete() {
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let complete_path_count = find_complete_paths(&graph, StitchingLimits::default())
+        .paths
+        .len();
+    let limits = StitchingLimits {
+        max_phases: Some(1000),
+        max_processed_paths: Some(1000),
+        max_complete_paths: Some(complete_path_count),
+    };
+    let result = find_complete_paths(&graph, limits);
+    assert_eq!(complete_path_count, result.paths.len());
+    assert_eq!(None, result.limit_reached);
+}
@@ -0,0 +1,675 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use std::collections::BTreeSet;
+use std::path::PathBuf;
+
+use pretty_assertions::assert_eq;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::Database;
+use stack_graphs::stitching::PathStitcher;
+use stack_graphs::storage::FileEntry;
+use stack_graphs::storage::FileFailure;
+use stack_graphs::storage::FileStatus;
+use stack_graphs::storage::Resolution;
+use stack_graphs::storage::SQLiteReader;
+use stack_graphs::storage::SQLiteWriter;
+use stack_graphs::storage::StorageError;
+
+use crate::test_graphs;
+
+/// A database file in the temporary directory that is removed when dropped.
+struct TempDatabase(PathBuf);
+
+impl TempDatabase {
+    fn new(name: &str) -> TempDatabase {
+        let path = std::env::temp_dir().join(format!(
+            "stack-graphs-{}-{}.sqlite",
+            name,
+            std::process::id()
+        ));
+        let _ = std::fs::remove_file(&path);
+        TempDatabase(path)
+    }
+}
+
+impl Drop for TempDatabase {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_file(&self.0);
+    }
+}
+
+fn store_graph(db: &mut SQLiteWriter, graph: &StackGraph) {
+    for file in graph.iter_files() {
+        let mut partials = PartialPaths::new();
+        let mut paths = Vec::new();
+        partials.find_all_partial_paths_in_file(graph, file, |graph, partials, path| {
+            if !path.is_complete_as_possible(graph) {
+                return;
+            }
+            if !path.is_productive(partials) {
+                return;
+            }
+            paths.push(path);
+        });
+        db.store_result_for_file(graph, file, "tag", "info", &mut partials, &paths)
+            .expect("Cannot store file");
+    }
+}
+
+fn resolve_all_references(
+    graph: &StackGraph,
+    partials: &mut PartialPaths,
+    db: &mut Database,
+) -> BTreeSet<String> {
+    let references = graph
+        .iter_nodes()
+        .filter(|node| graph[*node].is_reference())
+        .collect::<Vec<_>>();
+    let mut paths = Paths::new();
+    PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references)
+        .into_iter()
+        .map(|path| path.display(graph, &mut paths).to_string())
+        .collect()
+}
+
+#[test]
+fn can_list_stored_files() {
+    let db_path = TempDatabase::new("list");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        assert_eq!(Some("tag".to_string()), db.file_tag("a.py").unwrap());
+        assert_eq!(None, db.file_tag("missing.py").unwrap());
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    let entries = db.list_all().expect("Cannot list files");
+    let expected = ["a.py", "b.py", "main.py"]
+        .iter()
+        .map(|path| FileEntry {
+            path: path.to_string(),
+            project: "".to_string(),
+            tag: "tag".to_string(),
+        })
+        .collect::<Vec<_>>();
+    assert_eq!(expected, entries);
+}
+
+#[test]
+fn can_clean_file() {
+    let db_path = TempDatabase::new("clean");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    store_graph(&mut db, &graph);
+    db.clean_file("a.py").expect("Cannot clean file");
+    assert_eq!(None, db.file_tag("a.py").unwrap());
+    assert_eq!(Some("tag".to_string()), db.file_tag("b.py").unwrap());
+}
+
+#[test]
+fn can_clean_files_matching_pattern() {
+    let db_path = TempDatabase::new("clean-pattern");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    store_graph(&mut db, &graph);
+    assert_eq!(2, db.clean_files_matching("[ab].py").unwrap());
+    assert_eq!(None, db.file_tag("a.py").unwrap());
+    assert_eq!(None, db.file_tag("b.py").unwrap());
+    assert_eq!(Some("tag".to_string()), db.file_tag("main.py").unwrap());
+    assert_eq!(0, db.clean_files_matching("*.rs").unwrap());
+}
+
+#[test]
+fn can_clean_all_files() {
+    let db_path = TempDatabase::new("clean-all");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        assert_eq!(3, db.clean_all().unwrap());
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    assert!(db.list_all().unwrap().is_empty());
+}
+
+fn parse_failure() -> FileFailure {
+    FileFailure {
+        phase: "parsing".to_string(),
+        kind: "parse error".to_string(),
+        message: "unexpected token".to_string(),
+        location: Some("3:7".to_string()),
+    }
+}
+
+#[test]
+fn can_report_file_status() {
+    let db_path = TempDatabase::new("status");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        db.store_error_for_file("b.py", "tag2", "info", &parse_failure())
+            .expect("Cannot store error");
+        assert_eq!(None, db.file_tag("b.py").unwrap());
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    let statuses = db.status_all().expect("Cannot get status");
+    assert_eq!(
+        vec!["a.py", "b.py", "main.py"],
+        statuses.iter().map(|s| s.path.as_str()).collect::<Vec<_>>()
+    );
+    let a = &statuses[0];
+    assert!(a.is_success());
+    assert_eq!("info", a.info);
+    assert!(a.node_count > 0);
+    assert!(a.path_count > 0);
+    assert_eq!(
+        FileStatus {
+            path: "b.py".to_string(),
+            project: "".to_string(),
+            tag: "tag2".to_string(),
+            indexed_at: statuses[1].indexed_at,
+            info: "info".to_string(),
+            node_count: 0,
+            path_count: 0,
+            error: Some(parse_failure()),
+        },
+        statuses[1]
+    );
+
+    let entries = db.list_all().expect("Cannot list files");
+    assert_eq!(
+        vec!["a.py", "main.py"],
+        entries.iter().map(|e| e.path.as_str()).collect::<Vec<_>>()
+    );
+}
+
+#[test]
+fn stitching_loaded_paths_finds_same_results() {
+    let db_path = TempDatabase::new("stitch");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let mut partials = PartialPaths::new();
+    let mut db = Database::new();
+    for file in graph.iter_files() {
+        partials.find_all_partial_paths_in_file(&graph, file, |graph, partials, path| {
+            if !path.is_complete_as_possible(graph) {
+                return;
+            }
+            if !path.is_productive(partials) {
+                return;
+            }
+            db.add_partial_path(graph, partials, path);
+        });
+    }
+    let expected = resolve_all_references(&graph, &mut partials, &mut db);
+
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader.load_all().expect("Cannot load database");
+    let (loaded, partials, db) = reader.get();
+    let actual = resolve_all_references(loaded, partials, db);
+    assert!(!actual.is_empty());
+    assert_eq!(expected, actual);
+}
+
+#[test]
+fn can_find_files_for_symbol() {
+    let db_path = TempDatabase::new("symbols");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    assert_eq!(vec!["a.py"], db.files_for_symbol("a").unwrap());
+    assert_eq!(vec!["main.py"], db.files_for_symbol("__main__").unwrap());
+    assert!(db.files_for_symbol("missing").unwrap().is_empty());
+}
+
+#[test]
+fn can_find_files_with_symbol() {
+    let db_path = TempDatabase::new("symbol-filters");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    // Symbol filters can report false positives, so we only check that no file is missing.
+    let files = db.files_with_symbol("x").unwrap();
+    assert!(files.contains(&"a.py".to_string()));
+    let files = db.files_with_symbol("bar").unwrap();
+    assert!(files.contains(&"b.py".to_string()));
+    assert!(files.contains(&"main.py".to_string()));
+}
+
+#[test]
+fn loading_dependencies_finds_same_results() {
+    let db_path = TempDatabase::new("dependencies");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader.load_all().expect("Cannot load database");
+    let (loaded, partials, db) = reader.get();
+    let expected = resolve_all_references(loaded, partials, db);
+
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader
+        .load_paths_for_file_and_dependencies("main.py")
+        .expect("Cannot load dependencies");
+    let (loaded, partials, db) = reader.get();
+    let actual = resolve_all_references(loaded, partials, db);
+    assert!(!actual.is_empty());
+    assert_eq!(expected, actual);
+}
+
+#[test]
+fn can_resolve_references_into_dependency_databases() {
+    let db_path = TempDatabase::new("dependent");
+    let dependency_path = TempDatabase::new("dependency");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader.load_all().expect("Cannot load database");
+    let (loaded, partials, db) = reader.get();
+    let expected = resolve_all_references(loaded, partials, db);
+
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        db.clean_file("a.py").expect("Cannot clean file");
+        let mut dependency = SQLiteWriter::open(&dependency_path.0).expect("Cannot open database");
+        store_graph(&mut dependency, &graph);
+        dependency.clean_file("b.py").expect("Cannot clean file");
+        dependency.clean_file("main.py").expect("Cannot clean file");
+    }
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader
+        .add_dependency(&dependency_path.0)
+        .expect("Cannot add dependency");
+    reader
+        .load_paths_for_file_and_dependencies("main.py")
+        .expect("Cannot load dependencies");
+    let (loaded, partials, db) = reader.get();
+    let actual = resolve_all_references(loaded, partials, db);
+    assert!(!actual.is_empty());
+    assert_eq!(expected, actual);
+}
+
+#[test]
+fn can_record_dependencies() {
+    let db_path = TempDatabase::new("recorded-dependencies");
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        db.record_dependency("/deps/b.sqlite")
+            .expect("Cannot record dependency");
+        db.record_dependency("/deps/a.sqlite")
+            .expect("Cannot record dependency");
+        db.record_dependency("/deps/b.sqlite")
+            .expect("Cannot record dependency twice");
+    }
+    let reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    assert_eq!(
+        vec!["/deps/a.sqlite".to_string(), "/deps/b.sqlite".to_string()],
+        reader
+            .recorded_dependencies()
+            .expect("Cannot list dependencies")
+    );
+}
+
+#[test]
+fn files_in_several_databases_are_ambiguous() {
+    let db_path = TempDatabase::new("overlapping-dependent");
+    let dependency_path = TempDatabase::new("overlapping-dependency");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        db.clean_file("a.py").expect("Cannot clean file");
+        let mut dependency = SQLiteWriter::open(&dependency_path.0).expect("Cannot open database");
+        store_graph(&mut dependency, &graph);
+        dependency.clean_file("main.py").expect("Cannot clean file");
+    }
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader
+        .add_dependency(&dependency_path.0)
+        .expect("Cannot add dependency");
+    reader
+        .load_graph_for_file("main.py")
+        .expect("Cannot load file that is only in the database");
+    reader
+        .load_graph_for_file("a.py")
+        .expect("Cannot load file that is only in the dependency");
+    match reader.load_graph_for_file("b.py") {
+        Err(StorageError::AmbiguousFile(file)) => assert_eq!("b.py", file),
+        Err(err) => panic!("Unexpected error {}", err),
+        Ok(_) => panic!("Loading a file that is in both databases succeeded"),
+    }
+}
+
+#[test]
+fn files_in_several_databases_are_loaded_for_project() {
+    let db_path = TempDatabase::new("projects-dependent");
+    let dependency_path = TempDatabase::new("projects-dependency");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        db.set_project("app");
+        store_graph(&mut db, &graph);
+        let mut dependency = SQLiteWriter::open(&dependency_path.0).expect("Cannot open database");
+        dependency.set_project("lib");
+        store_graph(&mut dependency, &graph);
+        dependency.clean_file("main.py").expect("Cannot clean file");
+    }
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader
+        .add_dependency(&dependency_path.0)
+        .expect("Cannot add dependency");
+    match reader.load_graph_for_file("b.py") {
+        Err(StorageError::AmbiguousFile(file)) => assert_eq!("b.py", file),
+        Err(err) => panic!("Unexpected error {}", err),
+        Ok(_) => panic!("Loading a file of another project succeeded"),
+    }
+    reader.set_project("lib");
+    reader
+        .load_paths_for_file_and_dependencies("b.py")
+        .expect("Cannot load file of the project");
+    reader.set_project("app");
+    reader
+        .load_paths_for_file_and_dependencies("main.py")
+        .expect("Cannot load file that is only in one database");
+
+    let entries = reader.list_all().expect("Cannot list files");
+    assert!(entries.iter().all(|entry| entry.project == "app"));
+}
+
+#[test]
+fn can_find_definitions_by_name() {
+    let db_path = TempDatabase::new("definitions");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    let symbols = |query: &str, limit: usize| {
+        db.find_definitions(query, limit)
+            .unwrap()
+            .into_iter()
+            .map(|d| (d.symbol, d.path))
+            .collect::<Vec<_>>()
+    };
+    assert_eq!(
+        vec![
+            ("a".to_string(), "a.py".to_string()),
+            ("A".to_string(), "b.py".to_string()),
+            ("bar".to_string(), "b.py".to_string()),
+            ("__main__".to_string(), "main.py".to_string()),
+        ],
+        symbols("a", 10)
+    );
+    assert_eq!(
+        vec![("__main__".to_string(), "main.py".to_string())],
+        symbols("mn", 10)
+    );
+    assert_eq!(vec![("A".to_string(), "b.py".to_string())], symbols("A", 1));
+    assert_eq!(2, symbols("a", 2).len());
+    assert!(symbols("missing", 10).is_empty());
+}
+
+#[test]
+fn can_store_resolutions() {
+    let db_path = TempDatabase::new("resolutions");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let resolution = Resolution {
+        reference_file: "main.py".to_string(),
+        reference_local_id: 10,
+        definition_file: "a.py".to_string(),
+        definition_local_id: 5,
+        path_length: 7,
+        score: 0.5,
+    };
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        db.store_resolutions_for_file("main.py", &[resolution.clone()])
+            .expect("Cannot store resolutions");
+    }
+    {
+        let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+        assert_eq!(vec!["a.py", "b.py"], db.unresolved_files().unwrap());
+        assert_eq!(
+            Some(vec![resolution.clone()]),
+            db.resolutions_for_file("main.py").unwrap()
+        );
+        assert_eq!(None, db.resolutions_for_file("a.py").unwrap());
+        assert_eq!(
+            vec![resolution.clone()],
+            db.resolutions_for_definition("a.py", 5).unwrap()
+        );
+        assert!(db.resolutions_for_definition("a.py", 0).unwrap().is_empty());
+    }
+    {
+        // Storing files with identical graphs and paths keeps all resolutions.
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    {
+        let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+        assert_eq!(
+            Some(vec![resolution.clone()]),
+            db.resolutions_for_file("main.py").unwrap()
+        );
+    }
+    {
+        // Removing a file with a graph removes all resolutions.
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        db.clean_file("b.py").expect("Cannot remove file");
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    assert_eq!(None, db.resolutions_for_file("main.py").unwrap());
+    assert_eq!(2, db.unresolved_files().unwrap().len());
+}
+
+#[test]
+fn can_report_coverage() {
+    let db_path = TempDatabase::new("coverage");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let main = graph.get_file("main.py").unwrap();
+    let reference_count = graph
+        .nodes_for_file(main)
+        .filter(|node| graph[*node].is_reference())
+        .count();
+    let resolution = Resolution {
+        reference_file: "main.py".to_string(),
+        reference_local_id: 10,
+        definition_file: "a.py".to_string(),
+        definition_local_id: 5,
+        path_length: 7,
+        score: 0.5,
+    };
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        // Both resolutions of the reference count once.
+        let other_resolution = Resolution {
+            definition_local_id: 6,
+            ..resolution.clone()
+        };
+        db.store_resolutions_for_file("main.py", &[resolution, other_resolution])
+            .expect("Cannot store resolutions");
+    }
+    let db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    let coverage = db.coverage_all().unwrap();
+    assert_eq!(
+        vec!["a.py", "b.py", "main.py"],
+        coverage.iter().map(|c| c.path.as_str()).collect::<Vec<_>>()
+    );
+    assert_eq!(None, coverage[0].resolved_count);
+    assert_eq!(None, coverage[0].resolved_percentage());
+    let main_coverage = &coverage[2];
+    assert_eq!(reference_count, main_coverage.reference_count);
+    assert_eq!(Some(1), main_coverage.resolved_count);
+    assert_eq!(1, main_coverage.definition_count);
+    // The test graphs have no source information.
+    assert_eq!(0, main_coverage.line_count);
+    assert_eq!(None, main_coverage.definitions_per_kloc());
+}
+
+#[test]
+fn cannot_open_missing_database_for_reading() {
+    let db_path = TempDatabase::new("missing");
+    assert!(SQLiteReader::open(&db_path.0).is_err());
+}
+
+#[test]
+fn batched_writes_are_visible_after_flush() {
+    let db_path = TempDatabase::new("batch");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    db.set_batch_size(10).expect("Cannot set batch size");
+    store_graph(&mut db, &graph);
+    let reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    assert_eq!(0, reader.list_all().unwrap().len());
+    db.flush().expect("Cannot flush database");
+    assert_eq!(3, reader.list_all().unwrap().len());
+}
+
+#[test]
+fn reader_can_discard_data_changed_by_writer() {
+    let db_path = TempDatabase::new("changes");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    store_graph(&mut db, &graph);
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader.load_all().expect("Cannot load database");
+    assert!(!reader.clear_if_changed().unwrap());
+    db.clean_file("main.py").expect("Cannot clean file");
+    assert!(reader.clear_if_changed().unwrap());
+    assert!(reader.get().0.get_file("main.py").is_none());
+    assert!(!reader.clear_if_changed().unwrap());
+}
+
+#[test]
+fn reader_keeps_data_while_other_files_change() {
+    let db_path = TempDatabase::new("unrelated_changes");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    store_graph(&mut db, &graph);
+    let mut reader = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    reader
+        .load_paths_for_file("a.py")
+        .expect("Cannot load file");
+    db.clean_file("b.py").expect("Cannot clean file");
+    assert!(!reader.clear_if_changed().unwrap());
+    // Storing identical data does not change the stored graph.
+    store_graph(&mut db, &graph);
+    assert!(!reader.clear_if_changed().unwrap());
+    assert!(reader.get().0.get_file("a.py").is_some());
+    db.clean_file("a.py").expect("Cannot clean file");
+    assert!(reader.clear_if_changed().unwrap());
+    assert!(reader.get().0.get_file("a.py").is_none());
+}
+
+#[test]
+fn reader_does_not_change_database_with_older_version() {
+    let db_path = TempDatabase::new("older");
+    SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    let conn = rusqlite::Connection::open(&db_path.0).expect("Cannot open database");
+    conn.execute("UPDATE metadata SET version = version - 1", [])
+        .expect("Cannot downgrade database");
+    let version: usize = conn
+        .query_row("SELECT version FROM metadata", [], |r| r.get(0))
+        .unwrap();
+    assert!(matches!(
+        SQLiteReader::open(&db_path.0),
+        Err(StorageError::IncorrectVersion(v, _)) if v == version
+    ));
+    assert_eq!(
+        version,
+        conn.query_row("SELECT version FROM metadata", [], |r| r.get::<_, usize>(0))
+            .unwrap()
+    );
+}
+
+#[test]
+fn can_copy_file() {
+    let db_path = TempDatabase::new("copy");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+        assert!(db.file_with_tag("tag").unwrap().is_some());
+        assert_eq!(None, db.file_with_tag("other tag").unwrap());
+        db.copy_file("a.py", "copy/a.py").expect("Cannot copy file");
+        assert_eq!(Some("tag".to_string()), db.file_tag("copy/a.py").unwrap());
+        assert!(db.copy_file("missing.py", "copy/missing.py").is_err());
+    }
+    let mut db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    let coverage = db.coverage_all().unwrap();
+    let original = coverage.iter().find(|c| c.path == "a.py").unwrap();
+    let copy = coverage.iter().find(|c| c.path == "copy/a.py").unwrap();
+    assert_eq!(original.reference_count, copy.reference_count);
+    assert_eq!(original.definition_count, copy.definition_count);
+    db.load_all().expect("Cannot load graphs");
+    let (loaded, _, _) = db.get();
+    let original = loaded.get_file("a.py").unwrap();
+    let copy = loaded.get_file("copy/a.py").expect("Missing copied file");
+    assert_eq!(
+        loaded.nodes_for_file(original).count(),
+        loaded.nodes_for_file(copy).count()
+    );
+}
+
+#[test]
+fn stored_graphs_are_compressed() {
+    let db_path = TempDatabase::new("compressed");
+    let graph = test_graphs::class_field_through_function_parameter::new();
+    {
+        let mut db = SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+        store_graph(&mut db, &graph);
+    }
+    {
+        let conn = rusqlite::Connection::open(&db_path.0).expect("Cannot open database");
+        let value: Vec<u8> = conn
+            .query_row("SELECT value FROM graphs WHERE file = 'main.py'", [], |r| {
+                r.get(0)
+            })
+            .unwrap();
+        assert!(serde_json::from_slice::<serde_json::Value>(&value).is_err());
+        let value = zstd::decode_all(&value[..]).expect("Cannot decompress graph");
+        assert!(serde_json::from_slice::<serde_json::Value>(&value).is_ok());
+    }
+    let mut db = SQLiteReader::open(&db_path.0).expect("Cannot open database");
+    db.load_all().expect("Cannot load graphs");
+    let (loaded, _, _) = db.get();
+    assert_eq!(graph.iter_nodes().count(), loaded.iter_nodes().count());
+}
+
+#[test]
+fn cannot_open_database_with_newer_version() {
+    let db_path = TempDatabase::new("newer");
+    SQLiteWriter::open(&db_path.0).expect("Cannot open database");
+    {
+        let conn = rusqlite::Connection::open(&db_path.0).expect("Cannot open database");
+        conn.execute("UPDATE metadata SET version = version + 1", [])
+            .expect("Cannot upgrade database");
+    }
+    assert!(SQLiteWriter::open(&db_path.0).is_err());
+    assert!(SQLiteReader::open(&db_path.0).is_err());
+}
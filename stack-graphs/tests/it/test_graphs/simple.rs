@@ -77,6 +77,7 @@ pub fn new() -> StackGraph {
         syntax_type: str_var.into(),
         containing_line: str_line0.into(),
         definiens_span: Span::default(),
+        documentation: None,
     };
     *graph.source_info_mut(ref_x) = SourceInfo {
         span: Span {
@@ -104,6 +105,7 @@ pub fn new() -> StackGraph {
         syntax_type: str_var.into(),
         containing_line: str_line1.into(),
         definiens_span: Span::default(),
+        documentation: None,
     };
 
     let str_dsl_var = graph.add_string("dsl_var");
@@ -0,0 +1,95 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use clap::ValueHint;
+use std::path::Path;
+
+use crate::database::DatabaseArgs;
+
+/// Remove files from a database, so that they are indexed again
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Source files, directories, or glob patterns to remove.  Existing paths remove the file, or
+    /// all files in the directory.  Other arguments are glob patterns, relative to the current
+    /// directory, where `*` and `?` also match path separators.
+    #[clap(value_name = "SOURCE_PATH", value_hint = ValueHint::AnyPath, required_unless_present = "all")]
+    source_paths: Vec<String>,
+
+    /// Remove all files from the database.
+    #[clap(long, short = 'a')]
+    all: bool,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        if self.all && !self.source_paths.is_empty() {
+            return Err(anyhow!("Cannot give source paths together with --all"));
+        }
+        let mut db = self.database.open_writer()?;
+        let mut removed = 0;
+        if self.all {
+            removed += db.clean_all()?;
+        }
+        for source_path in &self.source_paths {
+            for pattern in Self::patterns_for_source_path(source_path)? {
+                removed += db.clean_files_matching(&pattern)?;
+            }
+        }
+        println!(
+            "Removed {} file{}",
+            removed,
+            if removed == 1 { "" } else { "s" }
+        );
+        Ok(())
+    }
+
+    /// Returns the patterns that match the files in the database for a source path argument.
+    /// Files are stored in the database under their canonical path, so existing paths are
+    /// canonicalized, and relative patterns are resolved against the current directory.
+    fn patterns_for_source_path(source_path: &str) -> anyhow::Result<Vec<String>> {
+        let path = Path::new(source_path);
+        if path.exists() {
+            let path = std::fs::canonicalize(path)?;
+            let name = escape_pattern(&path.to_string_lossy());
+            if path.is_dir() {
+                return Ok(vec![format!("{}{}*", name, std::path::MAIN_SEPARATOR)]);
+            }
+            return Ok(vec![name]);
+        }
+        if path.is_absolute() {
+            return Ok(vec![source_path.to_string()]);
+        }
+        let cwd = std::env::current_dir()?;
+        let cwd = std::fs::canonicalize(cwd)?;
+        Ok(vec![format!(
+            "{}{}{}",
+            escape_pattern(&cwd.to_string_lossy()),
+            std::path::MAIN_SEPARATOR,
+            source_path
+        )])
+    }
+}
+
+/// Escapes the characters that have a special meaning in glob patterns.
+fn escape_pattern(name: &str) -> String {
+    let mut result = String::with_capacity(name.len());
+    for c in name.chars() {
+        match c {
+            '*' | '?' | '[' => {
+                result.push('[');
+                result.push(c);
+                result.push(']');
+            }
+            _ => result.push(c),
+        }
+    }
+    result
+}
@@ -0,0 +1,112 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use stack_graphs::graph::StackGraph;
+use std::io::BufWriter;
+use std::io::Write;
+
+use crate::subgraph::node_properties;
+use crate::subgraph::Subgraph;
+use crate::subgraph::SubgraphArgs;
+
+/// The number of nodes or edges that are created by a single statement.  Batches keep the
+/// statements small enough for Neo4j to handle, while avoiding a transaction per node.
+const BATCH_SIZE: usize = 1000;
+
+/// Writes the nodes and edges of the graph as Cypher statements, which can be run against a
+/// Neo4j database, e.g., with `cypher-shell`.  Nodes are created with the `StackGraphNode` label
+/// and their properties, and a `node_id` property that is only used to create the edges.  Edges
+/// are created as `EDGE` relationships, with their precedence as property.  Because the node IDs
+/// are only unique within a single export, the statements should be run against an empty
+/// database.
+pub(crate) fn export(graph: &StackGraph, args: &SubgraphArgs) -> anyhow::Result<()> {
+    let subgraph = Subgraph::new(graph, args)?;
+    let project_root = args.export.project_root()?;
+    let output_path = &args.export.output;
+    let output = std::fs::File::create(output_path)
+        .with_context(|| format!("Failed to create {}", output_path.display()))?;
+    let mut output = BufWriter::new(output);
+
+    writeln!(
+        output,
+        "CREATE INDEX stack_graph_node_id IF NOT EXISTS FOR (n:StackGraphNode) ON (n.node_id);"
+    )?;
+    let ids = subgraph.node_ids();
+    for nodes in subgraph.nodes.chunks(BATCH_SIZE) {
+        writeln!(output, "UNWIND [")?;
+        for (i, node) in nodes.iter().enumerate() {
+            let mut properties = serde_json::Map::new();
+            properties.insert("node_id".to_string(), ids[node].clone().into());
+            for (name, value) in node_properties(graph, *node, &project_root) {
+                properties.insert(name.to_string(), value);
+            }
+            let separator = if i + 1 < nodes.len() { "," } else { "" };
+            writeln!(output, "  {}{}", map_literal(&properties), separator)?;
+        }
+        writeln!(output, "] AS properties")?;
+        writeln!(output, "CREATE (n:StackGraphNode) SET n = properties;")?;
+    }
+    for edges in subgraph.edges.chunks(BATCH_SIZE) {
+        writeln!(output, "UNWIND [")?;
+        for (i, edge) in edges.iter().enumerate() {
+            let separator = if i + 1 < edges.len() { "," } else { "" };
+            writeln!(
+                output,
+                "  {{source: {}, sink: {}, precedence: {}}}{}",
+                string_literal(&ids[&edge.source]),
+                string_literal(&ids[&edge.sink]),
+                edge.precedence,
+                separator
+            )?;
+        }
+        writeln!(output, "] AS edge")?;
+        writeln!(
+            output,
+            "MATCH (source:StackGraphNode {{node_id: edge.source}}), (sink:StackGraphNode {{node_id: edge.sink}})"
+        )?;
+        writeln!(
+            output,
+            "CREATE (source)-[:EDGE {{precedence: edge.precedence}}]->(sink);"
+        )?;
+    }
+
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", output_path.display()))?;
+    println!(
+        "{} nodes and {} edges written to {}",
+        subgraph.nodes.len(),
+        subgraph.edges.len(),
+        output_path.display()
+    );
+    Ok(())
+}
+
+/// Returns a Cypher map literal with the given properties.
+fn map_literal(properties: &serde_json::Map<String, serde_json::Value>) -> String {
+    let entries = properties
+        .iter()
+        .map(|(name, value)| {
+            let value = match value {
+                serde_json::Value::String(value) => string_literal(value),
+                value => value.to_string(),
+            };
+            format!("{}: {}", name, value)
+        })
+        .collect::<Vec<_>>();
+    format!("{{{}}}", entries.join(", "))
+}
+
+/// Returns a Cypher string literal, in single quotes.
+fn string_literal(value: &str) -> String {
+    let value = value
+        .replace('\\', "\\\\")
+        .replace('\'', "\\'")
+        .replace('\n', "\\n");
+    format!("'{}'", value)
+}
@@ -0,0 +1,135 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context;
+use anyhow::Result;
+use clap::Args;
+use clap::ValueHint;
+use stack_graphs::storage::SQLiteReader;
+use stack_graphs::storage::SQLiteWriter;
+use stack_graphs::storage::StorageError;
+use std::collections::HashSet;
+use std::path::Path;
+use std::path::PathBuf;
+
+#[derive(Args, Clone)]
+pub struct DatabaseArgs {
+    /// The database to use for storing indexing results.
+    #[clap(
+        long,
+        short = 'D',
+        value_name = "DATABASE_PATH",
+        value_hint = ValueHint::FilePath,
+        default_value = "stack-graphs.sqlite"
+    )]
+    database: PathBuf,
+
+    /// Delete and recreate the database if it was created by an incompatible version, whose
+    /// schema cannot be migrated automatically.  All indexing results are lost, and files must be
+    /// indexed again.  Only applies to commands that write to the database.
+    #[clap(long)]
+    force_recreate: bool,
+
+    /// A database of a dependency, e.g., a library, that is consulted when resolving references
+    /// into files that are not in the database.  Dependencies are only read, and must have been
+    /// created by the same version.  Can be given multiple times.  If a file is stored in more
+    /// than one of the databases, e.g., because they were indexed from checkouts at the same
+    /// path, the one of the project given with --project is loaded.
+    #[clap(
+        long = "dependency",
+        value_name = "DATABASE_PATH",
+        value_hint = ValueHint::FilePath
+    )]
+    dependencies: Vec<PathBuf>,
+
+    /// The project, e.g., a repository, that indexed files belong to.  Queries load the files of
+    /// this project if files with the same path are stored for several projects in the database
+    /// and its dependencies.
+    #[clap(long, value_name = "NAME", default_value = "")]
+    project: String,
+}
+
+impl DatabaseArgs {
+    /// Opens the database for writing, creating it if it does not exist yet.
+    pub fn open_writer(&self) -> Result<SQLiteWriter> {
+        match SQLiteWriter::open(&self.database) {
+            Err(StorageError::IncorrectVersion(..)) if self.force_recreate => {
+                std::fs::remove_file(&self.database).with_context(|| {
+                    format!("Failed to remove database {}", self.database.display())
+                })?;
+                // Remove the write-ahead log as well, so that it is not applied to the new
+                // database.
+                for suffix in ["-wal", "-shm"] {
+                    let mut path = self.database.clone().into_os_string();
+                    path.push(suffix);
+                    let _ = std::fs::remove_file(path);
+                }
+                SQLiteWriter::open(&self.database)
+            }
+            result => result,
+        }
+        .map(|mut writer| {
+            writer.set_project(&self.project);
+            writer
+        })
+        .with_context(|| format!("Failed to open database {}", self.database.display()))
+    }
+
+    /// Opens the existing database for reading, together with its dependencies, which are the
+    /// databases given with --dependency, and the ones that are recorded in the database, e.g.,
+    /// for the required modules of Go projects.
+    pub fn open_reader(&self) -> Result<SQLiteReader> {
+        let mut reader = SQLiteReader::open(&self.database)
+            .with_context(|| format!("Failed to open database {}", self.database.display()))?;
+        reader.set_project(&self.project);
+        let mut dependencies = self.dependencies.clone();
+        dependencies.extend(
+            reader
+                .recorded_dependencies()?
+                .into_iter()
+                .map(PathBuf::from),
+        );
+        let mut added = HashSet::new();
+        for dependency in &dependencies {
+            // A recorded dependency can also be given on the command line, and its files must
+            // not be found twice.
+            let canonical_path = std::fs::canonicalize(dependency).unwrap_or(dependency.clone());
+            if !added.insert(canonical_path) {
+                continue;
+            }
+            reader.add_dependency(dependency).with_context(|| {
+                format!(
+                    "Failed to open dependency database {}",
+                    dependency.display()
+                )
+            })?;
+        }
+        Ok(reader)
+    }
+
+    /// Returns the arguments for the database of a dependency with the given relative path, such
+    /// as `example.com/shapes@v1.2.3`.  Dependency databases are stored in a directory next to
+    /// the database, which is named after it.  The files of the dependency belong to the project
+    /// with the relative path as its name.
+    pub fn for_dependency(&self, relative_path: &Path) -> DatabaseArgs {
+        let mut dir = self.database.clone().into_os_string();
+        dir.push(".deps");
+        let mut database = PathBuf::from(dir).join(relative_path).into_os_string();
+        database.push(".sqlite");
+        DatabaseArgs {
+            database: database.into(),
+            force_recreate: self.force_recreate,
+            dependencies: Vec::new(),
+            project: relative_path.to_string_lossy().to_string(),
+        }
+    }
+
+    /// Returns the path of the database.
+    pub fn path(&self) -> &Path {
+        &self.database
+    }
+}
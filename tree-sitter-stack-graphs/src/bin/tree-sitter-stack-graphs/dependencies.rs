@@ -0,0 +1,174 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use clap::Args;
+use serde_json::json;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
+use std::collections::BTreeSet;
+use std::io::BufWriter;
+use std::io::Write;
+
+use crate::export::ExportArgs;
+use crate::scip::relative_file_path;
+use crate::scip::relative_path;
+
+#[derive(Args)]
+pub(crate) struct DependenciesArgs {
+    #[clap(flatten)]
+    pub(crate) export: ExportArgs,
+
+    /// Format of the dependency graph.  The JSON format lists the files or packages as nodes,
+    /// and the dependencies as edges, with the number of references that make them up.  The DOT
+    /// format can be rendered with Graphviz.
+    #[clap(long, arg_enum, default_value = "json")]
+    pub(crate) format: DependenciesFormat,
+
+    /// Aggregate the dependencies between files into dependencies between packages, i.e., the
+    /// directories that contain the files.
+    #[clap(long)]
+    pub(crate) packages: bool,
+}
+
+#[derive(clap::ArgEnum, Clone, Copy)]
+pub(crate) enum DependenciesFormat {
+    Json,
+    Dot,
+}
+
+/// The files or packages of a project, and the dependencies between them.
+struct DependencyGraph {
+    nodes: BTreeSet<String>,
+    /// The number of references from one node to definitions in another node, for every pair of
+    /// nodes that depend on each other.
+    edges: BTreeMap<(String, String), usize>,
+}
+
+impl DependencyGraph {
+    /// Aggregates the resolved references in the graph into dependencies between the files that
+    /// contain them, or between the packages of these files.  Files outside of the project root
+    /// are left out, as are references to definitions in the same file or package.
+    fn new(
+        graph: &StackGraph,
+        references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+        args: &DependenciesArgs,
+    ) -> anyhow::Result<DependencyGraph> {
+        let project_root = args.export.project_root()?;
+        let node_name = |relative_path: String| {
+            if args.packages {
+                package(&relative_path).to_string()
+            } else {
+                relative_path
+            }
+        };
+        let nodes = graph
+            .iter_files()
+            .filter_map(|file| relative_file_path(graph, file, &project_root))
+            .map(node_name)
+            .collect();
+        let mut edges = BTreeMap::new();
+        for (reference, definitions) in references {
+            let from = match relative_path(graph, *reference, &project_root) {
+                Some(from) => node_name(from),
+                None => continue,
+            };
+            for definition in definitions {
+                let to = match relative_path(graph, *definition, &project_root) {
+                    Some(to) => node_name(to),
+                    None => continue,
+                };
+                if from != to {
+                    *edges.entry((from.clone(), to)).or_default() += 1;
+                }
+            }
+        }
+        Ok(DependencyGraph { nodes, edges })
+    }
+
+    fn to_json(&self) -> serde_json::Value {
+        json!({
+            "nodes": self.nodes,
+            "edges": self
+                .edges
+                .iter()
+                .map(|((from, to), references)| {
+                    json!({
+                        "from": from,
+                        "to": to,
+                        "references": references,
+                    })
+                })
+                .collect::<Vec<_>>(),
+        })
+    }
+
+    fn write_dot(&self, output: &mut impl Write) -> std::io::Result<()> {
+        writeln!(output, "digraph dependencies {{")?;
+        for node in &self.nodes {
+            writeln!(output, "  {};", dot_id(node))?;
+        }
+        for ((from, to), references) in &self.edges {
+            writeln!(
+                output,
+                "  {} -> {} [label=\"{}\"];",
+                dot_id(from),
+                dot_id(to),
+                references
+            )?;
+        }
+        writeln!(output, "}}")
+    }
+}
+
+/// Returns the package of a file, i.e., the directory that contains it, relative to the project
+/// root.  Files in the project root are in the `.` package.
+fn package(relative_path: &str) -> &str {
+    match relative_path.rsplit_once('/') {
+        Some((package, _)) => package,
+        None => ".",
+    }
+}
+
+/// Returns a name as a quoted DOT identifier.
+fn dot_id(name: &str) -> String {
+    format!("\"{}\"", name.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+/// Writes the dependencies between the files, or packages, in the graph to a JSON or DOT file.
+/// A file depends on another file if any of its references resolve to a definition in the other
+/// file.
+pub(crate) fn export(
+    graph: &StackGraph,
+    references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+    args: &DependenciesArgs,
+) -> anyhow::Result<()> {
+    let dependencies = DependencyGraph::new(graph, references, args)?;
+    let output_path = &args.export.output;
+    let output = std::fs::File::create(output_path)
+        .with_context(|| format!("Failed to create {}", output_path.display()))?;
+    let mut output = BufWriter::new(output);
+    match args.format {
+        DependenciesFormat::Json => {
+            serde_json::to_writer_pretty(&mut output, &dependencies.to_json())?;
+            writeln!(output)?;
+        }
+        DependenciesFormat::Dot => dependencies.write_dot(&mut output)?,
+    }
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", output_path.display()))?;
+    println!(
+        "{} nodes and {} dependencies written to {}",
+        dependencies.nodes.len(),
+        dependencies.edges.len(),
+        output_path.display()
+    );
+    Ok(())
+}
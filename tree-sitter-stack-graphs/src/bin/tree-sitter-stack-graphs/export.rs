@@ -0,0 +1,161 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use clap::Args;
+use clap::Subcommand;
+use clap::ValueHint;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::paths::Path;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::Database;
+use stack_graphs::stitching::PathStitcher;
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+
+use crate::cypher;
+use crate::database::DatabaseArgs;
+use crate::dependencies;
+use crate::dependencies::DependenciesArgs;
+use crate::graphml;
+use crate::lsif;
+use crate::mermaid;
+use crate::scip;
+use crate::subgraph::SubgraphArgs;
+use crate::tags;
+
+/// Export the resolved references in the database to other code navigation formats
+///
+/// The references can also be exported as a dependency graph of the files or packages they
+/// connect, to visualize the coupling between modules, and the stack graph itself can be exported
+/// to graph formats, to visualize and explore it with other tools.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    #[clap(subcommand)]
+    format: Format,
+}
+
+#[derive(Subcommand)]
+enum Format {
+    /// Export a SCIP index, which can be uploaded to Sourcegraph.
+    Scip(ExportArgs),
+    /// Export an LSIF dump, in JSON lines format.
+    Lsif(ExportArgs),
+    /// Export the definitions as a ctags file.
+    Ctags(ExportArgs),
+    /// Export the definitions as an etags file, as used by Emacs.
+    Etags(ExportArgs),
+    /// Export the dependencies between files, or packages, as a JSON or DOT graph.
+    Dependencies(DependenciesArgs),
+    /// Export the nodes and edges of the stack graph as a Mermaid flowchart, which can be
+    /// embedded in Markdown.  Best suited for small graphs, such as the graph of a single file.
+    Mermaid(SubgraphArgs),
+    /// Export the nodes and edges of the stack graph as a GraphML file, with their properties as
+    /// attributes, which can be loaded into graph analysis tools, such as Gephi or yEd.
+    Graphml(SubgraphArgs),
+    /// Export the nodes and edges of the stack graph as Cypher statements, which create them in
+    /// a Neo4j database when run with, e.g., cypher-shell.
+    Cypher(SubgraphArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct ExportArgs {
+    /// The file to write the export to.
+    #[clap(long, short = 'o', value_name = "OUTPUT_PATH", value_hint = ValueHint::FilePath, parse(from_os_str))]
+    pub(crate) output: PathBuf,
+
+    /// The root directory of the project.  Files are exported with paths relative to the root,
+    /// and files outside of it are skipped.  Defaults to the current directory.
+    #[clap(long, value_name = "ROOT_PATH", value_hint = ValueHint::DirPath, parse(from_os_str))]
+    pub(crate) project_root: Option<PathBuf>,
+}
+
+impl ExportArgs {
+    /// Returns the canonical path of the project root, which can be compared with the names of
+    /// the files in the database.
+    pub(crate) fn project_root(&self) -> anyhow::Result<PathBuf> {
+        let project_root = match &self.project_root {
+            Some(project_root) => project_root.clone(),
+            None => std::env::current_dir()?,
+        };
+        std::fs::canonicalize(&project_root)
+            .with_context(|| format!("Failed to resolve {}", project_root.display()))
+    }
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let mut reader = self.database.open_reader()?;
+        reader.load_all()?;
+        let (graph, partials, db) = reader.get();
+        match &self.format {
+            Format::Scip(args) => {
+                let references = resolve_all_references(graph, partials, db);
+                scip::export(graph, &references, args)
+            }
+            Format::Lsif(args) => {
+                let references = resolve_all_references(graph, partials, db);
+                lsif::export(graph, &references, args)
+            }
+            Format::Ctags(args) => tags::export_ctags(graph, args),
+            Format::Etags(args) => tags::export_etags(graph, args),
+            Format::Dependencies(args) => {
+                let references = resolve_all_references(graph, partials, db);
+                dependencies::export(graph, &references, args)
+            }
+            Format::Mermaid(args) => mermaid::export(graph, args),
+            Format::Graphml(args) => graphml::export(graph, args),
+            Format::Cypher(args) => cypher::export(graph, args),
+        }
+    }
+}
+
+/// Resolves all references in the graph, and returns the definitions of every reference that
+/// could be resolved.  Shadowed paths are not valid results, and are not included.
+pub(crate) fn resolve_all_references(
+    graph: &StackGraph,
+    partials: &mut PartialPaths,
+    db: &mut Database,
+) -> BTreeMap<Handle<Node>, Vec<Handle<Node>>> {
+    let references = graph
+        .iter_nodes()
+        .filter(|n| graph[*n].is_reference())
+        .collect::<Vec<_>>();
+    let mut paths = Paths::new();
+    let results =
+        PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references);
+
+    // Paths can only shadow paths from the same reference, so we only compare those.
+    let mut paths_by_reference = BTreeMap::<_, Vec<Path>>::new();
+    for path in results {
+        paths_by_reference
+            .entry(path.start_node)
+            .or_default()
+            .push(path);
+    }
+    paths_by_reference
+        .into_iter()
+        .map(|(reference, results)| {
+            let mut definitions = (0..results.len())
+                .filter(|j| {
+                    !(0..results.len())
+                        .any(|i| i != *j && results[i].shadows(&mut paths, &results[*j]))
+                })
+                .map(|j| results[j].end_node)
+                .collect::<Vec<_>>();
+            definitions.sort();
+            definitions.dedup();
+            (reference, definitions)
+        })
+        .collect()
+}
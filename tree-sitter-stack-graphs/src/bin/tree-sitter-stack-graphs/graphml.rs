@@ -0,0 +1,117 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use stack_graphs::graph::StackGraph;
+use std::io::BufWriter;
+use std::io::Write;
+
+use crate::subgraph::node_properties;
+use crate::subgraph::Subgraph;
+use crate::subgraph::SubgraphArgs;
+
+/// The attributes of the nodes, with their GraphML types.  These are the properties returned by
+/// `node_properties`.
+static NODE_ATTRIBUTES: &[(&str, &str)] = &[
+    ("kind", "string"),
+    ("label", "string"),
+    ("symbol", "string"),
+    ("file", "string"),
+    ("local_id", "int"),
+    ("line", "int"),
+    ("column", "int"),
+    ("syntax_type", "string"),
+    ("definiens_line", "int"),
+    ("definiens_column", "int"),
+    ("is_definition", "boolean"),
+    ("is_reference", "boolean"),
+    ("is_exported", "boolean"),
+];
+
+/// Writes the nodes and edges of the graph as a GraphML file, which can be loaded into graph
+/// analysis tools, such as Gephi or yEd.  Nodes have the attributes in `NODE_ATTRIBUTES`, and
+/// edges have their precedence as attribute.
+pub(crate) fn export(graph: &StackGraph, args: &SubgraphArgs) -> anyhow::Result<()> {
+    let subgraph = Subgraph::new(graph, args)?;
+    let project_root = args.export.project_root()?;
+    let output_path = &args.export.output;
+    let output = std::fs::File::create(output_path)
+        .with_context(|| format!("Failed to create {}", output_path.display()))?;
+    let mut output = BufWriter::new(output);
+
+    writeln!(output, r#"<?xml version="1.0" encoding="UTF-8"?>"#)?;
+    writeln!(
+        output,
+        r#"<graphml xmlns="http://graphml.graphdrawing.org/xmlns" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">"#
+    )?;
+    for (name, attr_type) in NODE_ATTRIBUTES {
+        writeln!(
+            output,
+            r#"  <key id="{}" for="node" attr.name="{}" attr.type="{}"/>"#,
+            name, name, attr_type
+        )?;
+    }
+    writeln!(
+        output,
+        r#"  <key id="precedence" for="edge" attr.name="precedence" attr.type="int"/>"#
+    )?;
+    writeln!(
+        output,
+        r#"  <graph id="stack-graph" edgedefault="directed">"#
+    )?;
+    let ids = subgraph.node_ids();
+    for node in &subgraph.nodes {
+        writeln!(output, r#"    <node id="{}">"#, ids[node])?;
+        for (name, value) in node_properties(graph, *node, &project_root) {
+            let value = match value {
+                serde_json::Value::String(value) => value,
+                value => value.to_string(),
+            };
+            writeln!(
+                output,
+                r#"      <data key="{}">{}</data>"#,
+                name,
+                escape(&value)
+            )?;
+        }
+        writeln!(output, "    </node>")?;
+    }
+    for (i, edge) in subgraph.edges.iter().enumerate() {
+        writeln!(
+            output,
+            r#"    <edge id="e{}" source="{}" target="{}">"#,
+            i, ids[&edge.source], ids[&edge.sink]
+        )?;
+        writeln!(
+            output,
+            r#"      <data key="precedence">{}</data>"#,
+            edge.precedence
+        )?;
+        writeln!(output, "    </edge>")?;
+    }
+    writeln!(output, "  </graph>")?;
+    writeln!(output, "</graphml>")?;
+
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", output_path.display()))?;
+    println!(
+        "{} nodes and {} edges written to {}",
+        subgraph.nodes.len(),
+        subgraph.edges.len(),
+        output_path.display()
+    );
+    Ok(())
+}
+
+/// Escapes the characters that have a special meaning in XML text.
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
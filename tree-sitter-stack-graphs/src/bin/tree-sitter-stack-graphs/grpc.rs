@@ -0,0 +1,295 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use prost::Message;
+use stack_graphs::proto::QueryResults;
+use stack_graphs::proto::SCHEMA_VERSION;
+use std::convert::Infallible;
+use std::future::Future;
+use std::net::SocketAddr;
+use std::path::PathBuf;
+use std::pin::Pin;
+use std::sync::Arc;
+use std::task::Context;
+use std::task::Poll;
+use tonic::body::BoxBody;
+use tonic::codegen::empty_body;
+use tonic::codegen::http;
+use tonic::codegen::Body;
+use tonic::codegen::StdError;
+use tonic::server::UnaryService;
+use tonic::Status;
+
+use crate::query::SourcePosition;
+use crate::serve::QueryService;
+
+/// The full name of the service, as defined in `proto/query_service.proto`.
+const SERVICE_NAME: &str = "stack_graphs.QueryService";
+
+// The message types below implement the request and response messages of
+// `proto/query_service.proto`.  Query results use the message types of the stack-graphs crate.
+
+#[derive(Clone, PartialEq, Message)]
+struct IndexRequest {
+    #[prost(string, repeated, tag = "1")]
+    paths: Vec<String>,
+    #[prost(bool, tag = "2")]
+    force: bool,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct IndexResponse {
+    #[prost(uint64, tag = "1")]
+    indexed: u64,
+    #[prost(uint64, tag = "2")]
+    skipped: u64,
+    #[prost(uint64, tag = "3")]
+    failed: u64,
+    #[prost(uint64, tag = "4")]
+    unchanged: u64,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct PositionRequest {
+    #[prost(string, tag = "1")]
+    path: String,
+    #[prost(uint64, tag = "2")]
+    line: u64,
+    #[prost(uint64, tag = "3")]
+    column: u64,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct StatusRequest {
+    #[prost(bool, tag = "1")]
+    failures_only: bool,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct StatusResponse {
+    #[prost(message, repeated, tag = "1")]
+    files: Vec<FileStatus>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct FileStatus {
+    #[prost(string, tag = "1")]
+    path: String,
+    #[prost(string, tag = "2")]
+    tag: String,
+    #[prost(uint64, tag = "3")]
+    indexed_at: u64,
+    #[prost(string, tag = "4")]
+    info: String,
+    #[prost(uint64, tag = "5")]
+    node_count: u64,
+    #[prost(uint64, tag = "6")]
+    path_count: u64,
+    #[prost(message, optional, tag = "7")]
+    failure: Option<FileFailure>,
+    #[prost(string, tag = "8")]
+    project: String,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct FileFailure {
+    #[prost(string, tag = "1")]
+    phase: String,
+    #[prost(string, tag = "2")]
+    kind: String,
+    #[prost(string, tag = "3")]
+    message: String,
+    #[prost(string, optional, tag = "4")]
+    location: Option<String>,
+}
+
+/// Serves the query service on the given address, until the process is stopped.
+pub(crate) async fn serve(service: Arc<QueryService>, address: SocketAddr) -> anyhow::Result<()> {
+    tonic::transport::Server::builder()
+        .add_service(QueryServer(service))
+        .serve(address)
+        .await?;
+    Ok(())
+}
+
+fn index(service: &QueryService, request: IndexRequest) -> Result<IndexResponse, Status> {
+    let paths = request.paths.into_iter().map(PathBuf::from).collect();
+    let totals = service
+        .index(paths, request.force)
+        .map_err(internal_error)?;
+    Ok(IndexResponse {
+        indexed: totals.indexed as u64,
+        skipped: totals.skipped as u64,
+        failed: totals.failed as u64,
+        unchanged: totals.unchanged as u64,
+    })
+}
+
+fn find(
+    service: &QueryService,
+    request: PositionRequest,
+    find_references: bool,
+) -> Result<QueryResults, Status> {
+    let position = source_position(request)?;
+    let results = service
+        .find(&position, find_references)
+        .map_err(internal_error)?;
+    Ok(QueryResults {
+        version: SCHEMA_VERSION,
+        results,
+    })
+}
+
+fn calls(
+    service: &QueryService,
+    request: PositionRequest,
+    incoming: bool,
+) -> Result<QueryResults, Status> {
+    let position = source_position(request)?;
+    let results = service.calls(&position, incoming).map_err(internal_error)?;
+    Ok(QueryResults {
+        version: SCHEMA_VERSION,
+        results,
+    })
+}
+
+fn source_position(request: PositionRequest) -> Result<SourcePosition, Status> {
+    if request.line == 0 || request.column == 0 {
+        return Err(Status::invalid_argument(
+            "Line and column numbers start at 1",
+        ));
+    }
+    Ok(SourcePosition {
+        path: PathBuf::from(request.path),
+        line: request.line as usize,
+        column: request.column as usize,
+    })
+}
+
+fn status(service: &QueryService, request: StatusRequest) -> Result<StatusResponse, Status> {
+    let files = service
+        .status()
+        .map_err(internal_error)?
+        .into_iter()
+        .filter(|s| !request.failures_only || !s.is_success())
+        .map(|status| FileStatus {
+            path: status.path,
+            project: status.project,
+            tag: status.tag,
+            indexed_at: status.indexed_at,
+            info: status.info,
+            node_count: status.node_count as u64,
+            path_count: status.path_count as u64,
+            failure: status.error.map(|failure| FileFailure {
+                phase: failure.phase,
+                kind: failure.kind,
+                message: failure.message,
+                location: failure.location,
+            }),
+        })
+        .collect();
+    Ok(StatusResponse { files })
+}
+
+fn internal_error(err: anyhow::Error) -> Status {
+    Status::internal(format!("{:#}", err))
+}
+
+type BoxFuture<T> = Pin<Box<dyn Future<Output = T> + Send + 'static>>;
+
+/// Dispatches gRPC requests to the methods of the query service.
+#[derive(Clone)]
+struct QueryServer(Arc<QueryService>);
+
+impl tonic::transport::NamedService for QueryServer {
+    const NAME: &'static str = SERVICE_NAME;
+}
+
+impl<B> tonic::codegen::Service<http::Request<B>> for QueryServer
+where
+    B: Body + Send + 'static,
+    B::Error: Into<StdError> + Send + 'static,
+{
+    type Response = http::Response<BoxBody>;
+    type Error = Infallible;
+    type Future = BoxFuture<Result<Self::Response, Self::Error>>;
+
+    fn poll_ready(&mut self, _cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        Poll::Ready(Ok(()))
+    }
+
+    fn call(&mut self, request: http::Request<B>) -> Self::Future {
+        let service = self.0.clone();
+        let method = request
+            .uri()
+            .path()
+            .strip_prefix(&format!("/{}/", SERVICE_NAME))
+            .unwrap_or_default()
+            .to_string();
+        match method.as_str() {
+            "Index" => unary(request, move |r| index(&service, r)),
+            "Definition" => unary(request, move |r| find(&service, r, false)),
+            "References" => unary(request, move |r| find(&service, r, true)),
+            "IncomingCalls" => unary(request, move |r| calls(&service, r, true)),
+            "OutgoingCalls" => unary(request, move |r| calls(&service, r, false)),
+            "Status" => unary(request, move |r| status(&service, r)),
+            _ => Box::pin(async move {
+                Ok(http::Response::builder()
+                    .status(200)
+                    .header("grpc-status", "12")
+                    .header("content-type", "application/grpc")
+                    .body(empty_body())
+                    .unwrap())
+            }),
+        }
+    }
+}
+
+/// Handles a unary request by decoding the request message, passing it to the handler, and
+/// encoding the response message.
+fn unary<B, Req, Res, F>(
+    request: http::Request<B>,
+    handler: F,
+) -> BoxFuture<Result<http::Response<BoxBody>, Infallible>>
+where
+    B: Body + Send + 'static,
+    B::Error: Into<StdError> + Send + 'static,
+    Req: Message + Default + Send + 'static,
+    Res: Message + Send + 'static,
+    F: Fn(Req) -> Result<Res, Status> + Send + Sync + 'static,
+{
+    Box::pin(async move {
+        let codec = tonic::codec::ProstCodec::<Res, Req>::default();
+        let mut grpc = tonic::server::Grpc::new(codec);
+        Ok(grpc.unary(Handler(Arc::new(handler)), request).await)
+    })
+}
+
+struct Handler<F>(Arc<F>);
+
+impl<Req, Res, F> UnaryService<Req> for Handler<F>
+where
+    Req: Send + 'static,
+    Res: Send + 'static,
+    F: Fn(Req) -> Result<Res, Status> + Send + Sync + 'static,
+{
+    type Response = Res;
+    type Future = BoxFuture<Result<tonic::Response<Res>, Status>>;
+
+    fn call(&mut self, request: tonic::Request<Req>) -> Self::Future {
+        let handler = self.0.clone();
+        Box::pin(async move {
+            // Handlers read and write the database, which blocks, so they run on a thread where
+            // blocking is allowed.
+            let request = request.into_inner();
+            tokio::task::spawn_blocking(move || handler(request))
+                .await
+                .map_err(|err| Status::internal(err.to_string()))?
+                .map(tonic::Response::new)
+        })
+    }
+}
@@ -0,0 +1,300 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use lsp_positions::Span;
+use serde_json::json;
+use serde_json::Value;
+use stack_graphs::proto;
+use std::collections::HashMap;
+use std::io::BufRead;
+use std::io::BufReader;
+use std::io::Read;
+use std::io::Write;
+use std::net::TcpListener;
+#[cfg(unix)]
+use std::os::unix::net::UnixListener;
+use std::path::PathBuf;
+use std::sync::Arc;
+
+use crate::lsp::percent_decode;
+use crate::query::SourcePosition;
+use crate::serve::QueryService;
+use crate::status::status_json;
+
+/// Serves JSON responses to HTTP requests on the listener, until the process is stopped.  Every
+/// connection is handled on its own thread, and closed after a single request.
+///
+/// The following endpoints are supported:
+///
+///  - `GET /definition?path=FILE&line=LINE&col=COLUMN` returns the definitions of the references
+///    at the position.
+///  - `GET /references?path=FILE&line=LINE&col=COLUMN` returns the references to the definitions
+///    at the position.
+///  - `GET /incoming-calls?path=FILE&line=LINE&col=COLUMN` returns the calls to the definition at
+///    the position, with the definitions that make them.
+///  - `GET /outgoing-calls?path=FILE&line=LINE&col=COLUMN` returns the calls that the definition
+///    at the position makes, with the definitions they call.
+///  - `GET /files` returns the status of the files in the database.  With `failures_only=true`,
+///    only files that failed to index are returned.
+///
+/// Lines and columns start at 1, and columns count characters, like in source positions given on
+/// the command line.
+pub(crate) fn serve(service: Arc<QueryService>, listener: TcpListener) -> anyhow::Result<()> {
+    serve_connections(service, listener.incoming())
+}
+
+/// Serves JSON responses to HTTP requests on a Unix domain socket, like [`serve`][].  Local
+/// clients avoid the overhead of TCP, and access is controlled by the permissions of the socket.
+#[cfg(unix)]
+pub(crate) fn serve_unix(service: Arc<QueryService>, listener: UnixListener) -> anyhow::Result<()> {
+    serve_connections(service, listener.incoming())
+}
+
+fn serve_connections<S, I>(service: Arc<QueryService>, connections: I) -> anyhow::Result<()>
+where
+    S: Read + Write + Send + 'static,
+    I: Iterator<Item = std::io::Result<S>>,
+{
+    for stream in connections {
+        let stream = match stream {
+            Ok(stream) => stream,
+            Err(err) => {
+                log::warn!("Failed to accept HTTP connection: {}", err);
+                continue;
+            }
+        };
+        let service = service.clone();
+        std::thread::spawn(move || {
+            if let Err(err) = handle_connection(&service, stream) {
+                log::warn!("Failed to handle HTTP request: {:#}", err);
+            }
+        });
+    }
+    Ok(())
+}
+
+fn handle_connection<S: Read + Write>(service: &QueryService, mut stream: S) -> anyhow::Result<()> {
+    let mut input = BufReader::new(&mut stream);
+    let mut request_line = String::new();
+    input.read_line(&mut request_line)?;
+    // We only support GET requests, which have no body, so we can ignore the headers.
+    loop {
+        let mut line = String::new();
+        if input.read_line(&mut line)? == 0 || line.trim_end().is_empty() {
+            break;
+        }
+    }
+    drop(input);
+    let mut parts = request_line.split_whitespace();
+    let method = parts.next().unwrap_or_default();
+    let target = parts.next().unwrap_or_default();
+    let response = if method == "GET" {
+        handle_request(service, target)
+    } else {
+        Err(HttpError::new(
+            HttpError::METHOD_NOT_ALLOWED,
+            format!("Unsupported method {}", method),
+        ))
+    };
+    let (status, body) = match response {
+        Ok(body) => (200, body),
+        Err(err) => (err.status, json!({ "error": err.message })),
+    };
+    write_response(&mut stream, status, &body)
+}
+
+fn handle_request(service: &QueryService, target: &str) -> Result<Value, HttpError> {
+    let (path, query) = target.split_once('?').unwrap_or((target, ""));
+    let params = parse_query(query)?;
+    match path {
+        "/definition" => find(service, &params, false),
+        "/references" => find(service, &params, true),
+        "/incoming-calls" => calls(service, &params, true),
+        "/outgoing-calls" => calls(service, &params, false),
+        "/files" => files(service, &params),
+        _ => Err(HttpError::new(
+            HttpError::NOT_FOUND,
+            format!("Unknown endpoint {}", path),
+        )),
+    }
+}
+
+fn find(
+    service: &QueryService,
+    params: &HashMap<String, String>,
+    find_references: bool,
+) -> Result<Value, HttpError> {
+    let position = position_param(params)?;
+    let results = service.find(&position, find_references)?;
+    Ok(results_json(&results))
+}
+
+/// Returns every call site as the reference of a result, with the calling definition, for
+/// incoming calls, or the called definition, for outgoing calls, as its definition.
+fn calls(
+    service: &QueryService,
+    params: &HashMap<String, String>,
+    incoming: bool,
+) -> Result<Value, HttpError> {
+    let position = position_param(params)?;
+    let results = service.calls(&position, incoming)?;
+    Ok(results_json(&results))
+}
+
+fn results_json(results: &[proto::QueryResult]) -> Value {
+    Value::Array(
+        results
+            .iter()
+            .map(|result| {
+                json!({
+                    "reference": result.reference.as_ref().map(location_json),
+                    "definitions": result.definitions.iter().map(location_json).collect::<Vec<_>>(),
+                    "scores": result.scores,
+                })
+            })
+            .collect(),
+    )
+}
+
+fn files(service: &QueryService, params: &HashMap<String, String>) -> Result<Value, HttpError> {
+    let failures_only = match params.get("failures_only").map(String::as_str) {
+        None | Some("false") => false,
+        Some("true") => true,
+        Some(value) => {
+            return Err(HttpError::new(
+                HttpError::BAD_REQUEST,
+                format!("Invalid value {} for parameter failures_only", value),
+            ))
+        }
+    };
+    Ok(Value::Array(
+        service
+            .status()?
+            .iter()
+            .filter(|s| !failures_only || !s.is_success())
+            .map(status_json)
+            .collect(),
+    ))
+}
+
+/// Returns the file and span of a location as JSON, in the same format as the JSON output of the
+/// query command.
+fn location_json(location: &proto::Location) -> Value {
+    let span = Span::from(location.span.clone().unwrap_or_default());
+    json!({
+        "file": location.file,
+        "span": {
+            "start": {
+                "line": span.start.line + 1,
+                "column": span.start.column.grapheme_offset + 1,
+            },
+            "end": {
+                "line": span.end.line + 1,
+                "column": span.end.column.grapheme_offset + 1,
+            },
+        },
+    })
+}
+
+/// Parses the parameters in the query string of a request.
+fn parse_query(query: &str) -> Result<HashMap<String, String>, HttpError> {
+    let mut params = HashMap::new();
+    for param in query.split('&').filter(|p| !p.is_empty()) {
+        let (name, value) = param.split_once('=').unwrap_or((param, ""));
+        let decode = |s: &str| {
+            percent_decode(&s.replace('+', " "))
+                .map_err(|err| HttpError::new(HttpError::BAD_REQUEST, err.to_string()))
+        };
+        params.insert(decode(name)?, decode(value)?);
+    }
+    Ok(params)
+}
+
+fn position_param(params: &HashMap<String, String>) -> Result<SourcePosition, HttpError> {
+    let path = string_param(params, "path")?;
+    let line = usize_param(params, "line")?;
+    let column = usize_param(params, "col")?;
+    if line == 0 || column == 0 {
+        return Err(HttpError::new(
+            HttpError::BAD_REQUEST,
+            "Line and column numbers start at 1",
+        ));
+    }
+    Ok(SourcePosition {
+        path: PathBuf::from(path),
+        line,
+        column,
+    })
+}
+
+fn string_param<'a>(params: &'a HashMap<String, String>, name: &str) -> Result<&'a str, HttpError> {
+    params.get(name).map(String::as_str).ok_or_else(|| {
+        HttpError::new(
+            HttpError::BAD_REQUEST,
+            format!("Missing parameter {}", name),
+        )
+    })
+}
+
+fn usize_param(params: &HashMap<String, String>, name: &str) -> Result<usize, HttpError> {
+    let value = string_param(params, name)?;
+    value.parse().map_err(|_| {
+        HttpError::new(
+            HttpError::BAD_REQUEST,
+            format!("Invalid value {} for parameter {}", value, name),
+        )
+    })
+}
+
+fn write_response(output: &mut impl Write, status: u16, body: &Value) -> anyhow::Result<()> {
+    let content = serde_json::to_string(body)?;
+    let reason = match status {
+        200 => "OK",
+        HttpError::BAD_REQUEST => "Bad Request",
+        HttpError::NOT_FOUND => "Not Found",
+        HttpError::METHOD_NOT_ALLOWED => "Method Not Allowed",
+        HttpError::INTERNAL_SERVER_ERROR => "Internal Server Error",
+        _ => return Err(anyhow!("Unexpected status {}", status)),
+    };
+    write!(
+        output,
+        "HTTP/1.1 {} {}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        status,
+        reason,
+        content.len(),
+        content
+    )?;
+    output.flush()?;
+    Ok(())
+}
+
+/// An error that is sent in response to a request, with its HTTP status code.
+struct HttpError {
+    status: u16,
+    message: String,
+}
+
+impl HttpError {
+    const BAD_REQUEST: u16 = 400;
+    const NOT_FOUND: u16 = 404;
+    const METHOD_NOT_ALLOWED: u16 = 405;
+    const INTERNAL_SERVER_ERROR: u16 = 500;
+
+    fn new<S: Into<String>>(status: u16, message: S) -> HttpError {
+        HttpError {
+            status,
+            message: message.into(),
+        }
+    }
+}
+
+impl From<anyhow::Error> for HttpError {
+    fn from(err: anyhow::Error) -> HttpError {
+        HttpError::new(HttpError::INTERNAL_SERVER_ERROR, format!("{:#}", err))
+    }
+}
@@ -0,0 +1,100 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use clap::ValueHint;
+use colored::Colorize as _;
+use sha1::Digest as _;
+use sha1::Sha1;
+use stack_graphs::cancellation::NoCancellation;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::proto;
+use stack_graphs::serde;
+use std::path::Path;
+use std::path::PathBuf;
+
+use crate::database::DatabaseArgs;
+use crate::index::IndexedGraph;
+use crate::path_exists;
+
+/// Import serialized stack graphs into a database
+///
+/// The files in the imported graphs are stored with their partial paths, next to the files that
+/// were indexed from source, so that queries can resolve references between them.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Serialized stack graph files.
+    #[clap(value_name = "GRAPH_PATH", required = true, value_hint = ValueHint::FilePath, parse(from_os_str), validator_os = path_exists)]
+    graph_paths: Vec<PathBuf>,
+
+    /// Format of the graph files.  The JSON format is produced by the --save-graph option of the
+    /// test command, and by `StackGraph::to_json` and the `serde` module of the stack-graphs
+    /// crate.  The protobuf format is produced by the `proto` module of the stack-graphs crate.
+    #[clap(long, arg_enum, default_value = "json")]
+    format: ImportFormat,
+
+    /// Import files even if they are already present in the database, and were imported from
+    /// the same graph file content.
+    #[clap(long, short = 'f')]
+    force: bool,
+}
+
+#[derive(clap::ArgEnum, Clone, Copy)]
+enum ImportFormat {
+    Json,
+    Protobuf,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let mut db = self.database.open_writer()?;
+        let mut imported = 0;
+        let mut skipped = 0;
+        for graph_path in &self.graph_paths {
+            let content = std::fs::read(graph_path)
+                .with_context(|| format!("Failed to read {}", graph_path.display()))?;
+            let graph = self
+                .load_graph(&content)
+                .with_context(|| format!("Failed to load {}", graph_path.display()))?;
+            // Files are imported again if the content of the graph file changes.
+            let tag = format!("import:sha1:{:x}", Sha1::digest(&content));
+            let mut indexed = IndexedGraph::new(graph, import_info(graph_path));
+            for file in indexed.graph().iter_files().collect::<Vec<_>>() {
+                let file_name = indexed.graph()[file].name().to_string();
+                if !self.force && db.file_tag(&file_name)?.as_ref() == Some(&tag) {
+                    skipped += 1;
+                    continue;
+                }
+                indexed.add_file(file, tag.clone(), &NoCancellation)?;
+                println!("{} {}", "✓".green(), file_name);
+                imported += 1;
+            }
+            indexed.store(&mut db)?;
+        }
+        println!("{} imported, {} skipped", imported, skipped);
+        Ok(())
+    }
+
+    fn load_graph(&self, content: &[u8]) -> anyhow::Result<StackGraph> {
+        let serialized = match self.format {
+            ImportFormat::Json => serde::StackGraph::from_json(std::str::from_utf8(content)?)?,
+            ImportFormat::Protobuf => proto::decode_graph(content)?,
+        };
+        let mut graph = StackGraph::new();
+        serialized.load_into(&mut graph)?;
+        Ok(graph)
+    }
+}
+
+/// Returns the description of how the files in a graph file were indexed, which is recorded in
+/// the database.
+fn import_info(graph_path: &Path) -> String {
+    format!("imported from {}", graph_path.display())
+}
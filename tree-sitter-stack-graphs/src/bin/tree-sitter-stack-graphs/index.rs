@@ -0,0 +1,1907 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use anyhow::Context as _;
+use clap::ValueHint;
+use colored::Colorize as _;
+use globset::GlobBuilder;
+use globset::GlobSet;
+use globset::GlobSetBuilder;
+use ignore::WalkBuilder;
+use sha1::Digest as _;
+use sha1::Sha1;
+use stack_graphs::arena::Handle;
+use stack_graphs::cancellation::CancelAfterDuration;
+use stack_graphs::cancellation::CancellationError;
+use stack_graphs::cancellation::CancellationFlag;
+use stack_graphs::cancellation::NoCancellation;
+use stack_graphs::graph::File;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::partial::PartialPath;
+use stack_graphs::partial::PartialPaths;
+use stack_graphs::storage::FileFailure;
+use stack_graphs::storage::SQLiteWriter;
+use std::collections::BTreeSet;
+use std::collections::HashMap;
+use std::collections::HashSet;
+use std::io::Read as _;
+use std::path::Path;
+use std::path::PathBuf;
+use std::sync::mpsc;
+use std::sync::Arc;
+use std::sync::Condvar;
+use std::sync::Mutex;
+use std::time::Duration;
+use std::time::Instant;
+use tree_sitter::Tree;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::go;
+use tree_sitter_stack_graphs::injection::Injection;
+use tree_sitter_stack_graphs::loader::Loader;
+use tree_sitter_stack_graphs::BuildStats;
+use tree_sitter_stack_graphs::LoadError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+use tree_sitter_stack_graphs::SyntaxErrorPolicy;
+use tree_sitter_stack_graphs::FILE_PATH_VAR;
+use tree_sitter_stack_graphs::ROOT_PATH_VAR;
+
+use crate::database::DatabaseArgs;
+use crate::loader::LoaderArgs;
+use crate::path_exists;
+use crate::query::resolve_file;
+
+/// Prints a line of the report of an index command, to standard error if the command reports there,
+/// and to standard output otherwise.
+macro_rules! report {
+    ($cmd:expr, $($arg:tt)*) => {
+        if $cmd.report_to_stderr {
+            eprintln!($($arg)*);
+        } else {
+            println!($($arg)*);
+        }
+    };
+}
+
+/// Index source files into a database
+#[derive(clap::Parser, Clone)]
+pub struct Command {
+    #[clap(flatten)]
+    loader: LoaderArgs,
+
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Source file or directory paths.
+    #[clap(value_name = "SOURCE_PATH", required_unless_present = "stdin", conflicts_with = "stdin", value_hint = ValueHint::AnyPath, parse(from_os_str), validator_os = path_exists)]
+    source_paths: Vec<PathBuf>,
+
+    /// Root directory of a project that the source paths belong to.  Can be given multiple times,
+    /// to index several projects, e.g., a service and its libraries, into the same database, so
+    /// that references between them resolve.  The root of a file is provided to the stack graph
+    /// construction rules in the ROOT_PATH global variable, and is the innermost root that
+    /// contains the file.  Source directories are roots as well.  Files are indexed again if their
+    /// root changed, and the rules of their language declare ROOT_PATH or FILE_PATH.
+    #[clap(long = "root", value_name = "ROOT_PATH", value_hint = ValueHint::DirPath, parse(from_os_str), validator_os = path_exists)]
+    roots: Vec<PathBuf>,
+
+    /// Read the content of a single file from standard input, instead of reading source files
+    /// from disk.  This can be used to index unsaved editor buffers.  Requires --path.
+    #[clap(long, requires = "path")]
+    stdin: bool,
+
+    /// The path of the file that is read from standard input.  The path determines the language
+    /// of the file, and the name under which it is stored, but does not have to exist.
+    #[clap(long, value_name = "PATH", value_hint = ValueHint::FilePath, requires = "stdin", parse(from_os_str))]
+    path: Option<PathBuf>,
+
+    /// Index files even if they are already present in the database and have not changed.  Files
+    /// are considered changed if their content, or the stack graph construction rules, changed
+    /// since they were indexed.
+    #[clap(long, short = 'f')]
+    force: bool,
+
+    /// Reuse the stored results of files with the same content, instead of indexing every copy,
+    /// e.g., of vendored or generated files, or of files in other checkouts of the same project.
+    /// Results are only reused for files in languages whose stack graph construction rules do not
+    /// see the path of a file, i.e., do not declare FILE_PATH or ROOT_PATH, and have no injections
+    /// query.  Go files are reused if their import path is the same.  Results are reused across
+    /// all projects that are indexed into the database.
+    #[clap(long)]
+    reuse_identical_files: bool,
+
+    /// How to index files that contain syntax errors.  Files can fail to index, be skipped, be
+    /// indexed without the parts that contain syntax errors, or be indexed as well as possible.
+    /// Files that are indexed despite syntax errors are marked as such in the status of the
+    /// database.
+    #[clap(long, arg_enum, value_name = "POLICY", default_value = "fail")]
+    syntax_errors: SyntaxErrors,
+
+    /// Keep the nodes and edges that were created before the stack graph construction rules
+    /// failed, instead of reporting the file as failed.  Files with partial graphs are reported,
+    /// and marked as such in the status of the database.
+    #[clap(long)]
+    keep_partial_graphs: bool,
+
+    /// Fail files if the stack graph construction rules set attributes that graph nodes or edges
+    /// do not accept, which are usually typos, instead of only logging a warning.
+    #[clap(long)]
+    strict_attributes: bool,
+
+    /// Maximum time, in seconds, to spend on a single file.  Files that take longer are reported
+    /// as failures.
+    #[clap(long, value_name = "SECONDS")]
+    file_timeout: Option<u64>,
+
+    /// Maximum size, in bytes, of files that are indexed.  Larger files, which are often generated
+    /// or minified, are skipped, and recorded as too large in the database.
+    #[clap(long, value_name = "BYTES")]
+    max_file_size: Option<usize>,
+
+    /// Maximum number of syntax nodes in the parse tree of files that are indexed.  Files with
+    /// more nodes are skipped, and recorded as too large in the database.
+    #[clap(long, value_name = "NODES")]
+    max_syntax_nodes: Option<usize>,
+
+    /// Number of worker threads used to index files.  Defaults to the number of available CPUs.
+    #[clap(long, short = 'j', value_name = "JOBS")]
+    jobs: Option<usize>,
+
+    /// Maximum number of workers that compute partial paths at the same time.  Finding partial
+    /// paths is usually the most expensive phase, in time and memory, so a lower number bounds
+    /// the memory used for indexing, while the other workers continue to build stack graphs.
+    /// Defaults to the number of workers.
+    #[clap(long, value_name = "JOBS")]
+    paths_workers: Option<usize>,
+
+    /// Number of files whose results are written to the database in a single transaction.
+    /// Larger batches make writing faster, but results are only saved once their batch is
+    /// complete, so more files have to be indexed again if indexing is interrupted.
+    #[clap(long, value_name = "FILES", default_value = "100")]
+    batch_size: usize,
+
+    /// Print timing and size statistics for every indexed file, and for the whole run.
+    #[clap(long)]
+    stats: bool,
+
+    /// Sample the call stacks of the program while indexing, and write them as a flame graph to
+    /// the given SVG file.  This shows which functions, and which indexing phases, take the most
+    /// time.  Only supported on Unix systems.
+    #[clap(long, value_name = "SVG_PATH", value_hint = ValueHint::FilePath, parse(from_os_str))]
+    cpu_profile: Option<PathBuf>,
+
+    /// After indexing, resolve the references in all files whose resolutions are not stored in
+    /// the database, and store them, so that queries can look them up instead of stitching paths.
+    /// Stored resolutions are removed whenever the graph or partial paths of any file change.
+    #[clap(long)]
+    resolve: bool,
+
+    /// Only index files in source directories whose paths, relative to the source directory,
+    /// match the given glob pattern, e.g., 'src/**'.  Can be given multiple times, in which case
+    /// files must match any of the patterns.
+    #[clap(long, value_name = "GLOB")]
+    include: Vec<String>,
+
+    /// Do not index files in source directories whose paths, relative to the source directory,
+    /// match the given glob pattern, e.g., '**/*.min.js'.  Can be given multiple times.  Exclude
+    /// patterns take precedence over include patterns.
+    #[clap(long, value_name = "GLOB")]
+    exclude: Vec<String>,
+
+    /// Index all files in source directories, including those that are excluded by `.gitignore`,
+    /// `.ignore`, or `.sgignore` files.  Files that are given as source paths are always indexed.
+    #[clap(long)]
+    no_ignore: bool,
+
+    /// Do not index the modules that Go projects require.  By default, the modules that are
+    /// required by the `go.mod` files of the source paths are indexed from the module cache into
+    /// databases of their own, which are stored next to the database, and are consulted as
+    /// dependencies when the database is read.  Modules that are not in the module cache are
+    /// skipped, and can be downloaded with `go mod download`.
+    #[clap(long)]
+    no_go_dependencies: bool,
+
+    /// Index the modules that Go projects require as stubs, which only contain the top-level
+    /// declarations of their files, and not the bodies of functions and methods.  References into
+    /// the modules still resolve, but their databases are much smaller, and are indexed faster.
+    #[clap(long, conflicts_with = "no_go_dependencies")]
+    stub_go_dependencies: bool,
+
+    /// Whether Go files are indexed as stubs, which is set for the modules that Go projects
+    /// require if --stub-go-dependencies is given.
+    #[clap(skip)]
+    stub_go_files: bool,
+
+    /// Hide files that were indexed successfully, or that have not changed since they were last
+    /// indexed.  Skipped files are always shown.
+    #[clap(long)]
+    hide_successes: bool,
+
+    /// Show ignored files in output.
+    #[clap(long)]
+    show_ignored: bool,
+
+    /// Whether the report is printed to standard error instead of standard output, which is set
+    /// when the language server indexes files, because its standard output carries the protocol.
+    #[clap(skip)]
+    report_to_stderr: bool,
+
+    /// The threads that run the indexer and the workers, which a server keeps between runs, so
+    /// that the rules are not loaded again for every run.  Every run starts threads of its own if
+    /// this is not set.
+    #[clap(skip)]
+    index_threads: Option<Arc<IndexThreads>>,
+}
+
+/// How to index files that contain syntax errors.
+#[derive(clap::ArgEnum, Clone, Copy, PartialEq)]
+enum SyntaxErrors {
+    /// Report the file as failed.
+    Fail,
+    /// Store the file without any nodes or partial paths.
+    SkipFile,
+    /// Leave out the nodes that are part of syntax errors.
+    OmitErrors,
+    /// Keep all nodes that the rules create.
+    BestEffort,
+}
+
+impl SyntaxErrors {
+    /// Returns the policy that the languages use to build stack graphs.  Files are only skipped
+    /// after the language reports that they contain syntax errors.
+    fn policy(self) -> SyntaxErrorPolicy {
+        match self {
+            SyntaxErrors::Fail | SyntaxErrors::SkipFile => SyntaxErrorPolicy::Fail,
+            SyntaxErrors::OmitErrors => SyntaxErrorPolicy::OmitErrors,
+            SyntaxErrors::BestEffort => SyntaxErrorPolicy::BestEffort,
+        }
+    }
+
+    /// Describes what happened to a file with syntax errors, which is reported, and recorded in
+    /// the database.
+    fn description(self) -> &'static str {
+        match self {
+            SyntaxErrors::Fail => "syntax errors",
+            SyntaxErrors::SkipFile => "syntax errors, file skipped",
+            SyntaxErrors::OmitErrors => "syntax errors, omitted from graph",
+            SyntaxErrors::BestEffort => "syntax errors, indexed best-effort",
+        }
+    }
+}
+
+/// Counts of indexing outcomes, used for progress reporting.
+#[derive(Default)]
+pub(crate) struct IndexTotals {
+    pub(crate) indexed: usize,
+    /// Files that have not changed since they were last indexed.
+    pub(crate) unchanged: usize,
+    /// Files that were recorded as skipped, e.g., because they are too large.
+    pub(crate) skipped: usize,
+    pub(crate) failed: usize,
+}
+
+/// Timing and size statistics for indexing a file, or the sum of those for several files.
+#[derive(Clone, Default)]
+struct IndexStats {
+    build: BuildStats,
+    partial_paths_time: Duration,
+    partial_path_count: usize,
+    store_time: Duration,
+}
+
+impl IndexStats {
+    fn add(&mut self, other: &IndexStats) {
+        self.build.add(&other.build);
+        self.partial_paths_time += other.partial_paths_time;
+        self.partial_path_count += other.partial_path_count;
+        self.store_time += other.store_time;
+    }
+
+    /// Returns a summary of the time spent in every indexing phase, and its share of the time
+    /// spent in all phases, so that the slowest phase stands out.
+    fn phases(&self) -> String {
+        let phases = [
+            ("parse", self.build.parse_time),
+            ("rules", self.build.execution_time),
+            ("load", self.build.load_time),
+            ("partial paths", self.partial_paths_time),
+            ("storage", self.store_time),
+        ];
+        let total = phases.iter().map(|(_, time)| *time).sum::<Duration>();
+        phases
+            .iter()
+            .map(|(name, time)| {
+                let share = if total.is_zero() {
+                    0.0
+                } else {
+                    100.0 * time.as_secs_f64() / total.as_secs_f64()
+                };
+                format!("{} {:?} ({:.0}%)", name, time, share)
+            })
+            .collect::<Vec<_>>()
+            .join(", ")
+    }
+}
+
+impl std::fmt::Display for IndexStats {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(
+            f,
+            "{} nodes, {} edges, {} partial paths; parse {:?}, rules {:?}, load {:?}, partial paths {:?}, storage {:?}",
+            self.build.node_count,
+            self.build.edge_count,
+            self.partial_path_count,
+            self.build.parse_time,
+            self.build.execution_time,
+            self.build.load_time,
+            self.partial_paths_time,
+            self.store_time,
+        )
+    }
+}
+
+impl Command {
+    /// Creates a command that indexes the given source paths, without reporting the files that
+    /// were indexed successfully.  This is used to index files on behalf of a server.
+    pub(crate) fn new(
+        loader: LoaderArgs,
+        database: DatabaseArgs,
+        source_paths: Vec<PathBuf>,
+        force: bool,
+    ) -> Command {
+        Command {
+            loader,
+            database,
+            source_paths,
+            roots: Vec::new(),
+            stdin: false,
+            path: None,
+            force,
+            reuse_identical_files: false,
+            syntax_errors: SyntaxErrors::Fail,
+            keep_partial_graphs: false,
+            strict_attributes: false,
+            file_timeout: None,
+            max_file_size: None,
+            max_syntax_nodes: None,
+            jobs: None,
+            paths_workers: None,
+            batch_size: 100,
+            stats: false,
+            cpu_profile: None,
+            resolve: false,
+            include: Vec::new(),
+            exclude: Vec::new(),
+            no_ignore: false,
+            // Servers index changed files, and dependencies that were indexed before are still
+            // consulted.
+            no_go_dependencies: true,
+            stub_go_dependencies: false,
+            stub_go_files: false,
+            hide_successes: true,
+            show_ignored: false,
+            report_to_stderr: false,
+            index_threads: None,
+        }
+    }
+
+    /// Runs the indexer and the workers on the given threads, and uses the loaders they keep.
+    pub(crate) fn with_index_threads(mut self, index_threads: Arc<IndexThreads>) -> Command {
+        self.index_threads = Some(index_threads);
+        self
+    }
+
+    /// Prints the report to standard error instead of standard output.
+    pub(crate) fn with_report_to_stderr(mut self) -> Command {
+        self.report_to_stderr = true;
+        self
+    }
+
+    pub fn run(&self) -> anyhow::Result<()> {
+        let totals = self.index()?;
+        if totals.failed > 0 {
+            return Err(anyhow!(
+                "{} file{} failed to index",
+                totals.failed,
+                if totals.failed == 1 { "" } else { "s" }
+            ));
+        }
+        Ok(())
+    }
+
+    /// Returns the canonical paths of the project roots, which are the given roots and the source
+    /// directories.
+    fn roots(&self) -> anyhow::Result<Vec<PathBuf>> {
+        self.roots
+            .iter()
+            .chain(self.source_paths.iter().filter(|p| p.is_dir()))
+            .map(|root| {
+                std::fs::canonicalize(root)
+                    .with_context(|| format!("Failed to resolve {}", root.display()))
+            })
+            .collect()
+    }
+
+    /// Returns a walk over the files in a source directory.  Unless --no-ignore is used, files that
+    /// are excluded by `.gitignore`, `.ignore`, or `.sgignore` files, or by the global Git ignore
+    /// rules, are skipped.
+    fn walk(&self, source_path: &Path) -> ignore::Walk {
+        let mut builder = WalkBuilder::new(source_path);
+        builder
+            .follow_links(true)
+            .sort_by_file_name(|a, b| a.cmp(b))
+            .standard_filters(!self.no_ignore)
+            .hidden(false)
+            .require_git(false);
+        if !self.no_ignore {
+            builder.add_custom_ignore_filename(IGNORE_FILE_NAME);
+        }
+        builder.build()
+    }
+
+    /// Indexes the source paths, and returns the number of files that were indexed, skipped, and
+    /// failed.  Failures are reported, and recorded in the database, but do not make indexing
+    /// fail.
+    pub(crate) fn index(&self) -> anyhow::Result<IndexTotals> {
+        match &self.index_threads {
+            Some(index_threads) => {
+                let cmd = self.clone();
+                index_threads
+                    .run(0, move |loader| cmd.index_with_loader(loader))
+                    .recv()
+                    .map_err(|_| anyhow!("Indexer panicked"))?
+            }
+            None => self.index_with_loader(&mut None),
+        }
+    }
+
+    fn index_with_loader(&self, loader: &mut CachedLoader) -> anyhow::Result<IndexTotals> {
+        // Create a loader up front, or reuse the one of an earlier run, so that configuration
+        // errors are reported once, instead of once for every file.  The indexer uses it to find
+        // the rules of every file.  The workers have their own loaders, because languages cannot
+        // be shared between threads.
+        let rules_hash = self.loader.rules_hash()?;
+        let loader = cached_loader(loader, &self.loader, &rules_hash)
+            .as_mut()
+            .map_err(|err| anyhow!("{:#}", err))?;
+        let index_threads = self
+            .index_threads
+            .clone()
+            .unwrap_or_else(|| Arc::new(IndexThreads::new()));
+        let profiler = match &self.cpu_profile {
+            Some(path) => Some(CpuProfiler::start(path)?),
+            None => None,
+        };
+        let start = Instant::now();
+        let mut db = self.database.open_writer()?;
+        db.set_batch_size(self.batch_size)?;
+        let roots = self.roots()?;
+        let filter = PathFilter::new(&self.include, &self.exclude)?;
+
+        let jobs = match self.jobs {
+            Some(0) => return Err(anyhow!("Number of jobs must be at least 1")),
+            Some(jobs) => jobs,
+            None => std::thread::available_parallelism()
+                .map(|n| n.get())
+                .unwrap_or(1),
+        };
+        let (job_tx, job_rx) = mpsc::channel();
+        let (result_tx, result_rx) = mpsc::channel();
+        let job_rx = Arc::new(Mutex::new(job_rx));
+        let claimed_builtins = Arc::new(Mutex::new(HashSet::new()));
+        let paths_permits = match self.paths_workers {
+            Some(0) => return Err(anyhow!("Number of paths workers must be at least 1")),
+            Some(paths_workers) => Arc::new(Permits::new(paths_workers)),
+            None => Arc::new(Permits::new(jobs)),
+        };
+        let workers = (0..jobs)
+            .map(|i| {
+                let worker = Worker {
+                    loader_args: self.loader.clone(),
+                    syntax_errors: self.syntax_errors,
+                    keep_partial_graphs: self.keep_partial_graphs,
+                    strict_attributes: self.strict_attributes,
+                    file_timeout: self.file_timeout.map(Duration::from_secs),
+                    max_file_size: self.max_file_size,
+                    max_syntax_nodes: self.max_syntax_nodes,
+                    stub_go_files: self.stub_go_files,
+                    jobs: job_rx.clone(),
+                    results: result_tx.clone(),
+                    claimed_builtins: claimed_builtins.clone(),
+                    rules_hash: rules_hash.clone(),
+                    paths_permits: paths_permits.clone(),
+                };
+                // The indexer runs on the first thread.
+                index_threads.run(i + 1, move |loader| worker.run(loader))
+            })
+            .collect::<Vec<_>>();
+        // Drop our own sender, so that the result channel closes once all workers are done.
+        drop(result_tx);
+
+        let mut indexer = Indexer {
+            cmd: self,
+            db: &mut db,
+            jobs: Some(job_tx),
+            results: result_rx,
+            totals: IndexTotals::default(),
+            stats: IndexStats::default(),
+            skipped_stats: IndexStats::default(),
+            loader,
+            rules_hashes: HashMap::new(),
+            roots,
+        };
+        // The path requires, and is required by, --stdin.
+        if let Some(path) = &self.path {
+            indexer.submit_stdin(path);
+        }
+        for source_path in &self.source_paths {
+            if source_path.is_dir() {
+                for entry in self
+                    .walk(source_path)
+                    .filter_map(|e| e.ok())
+                    .filter(|e| e.file_type().map_or(false, |t| t.is_file()))
+                {
+                    let relative_path = entry
+                        .path()
+                        .strip_prefix(source_path)
+                        .unwrap_or(entry.path());
+                    if filter.is_match(relative_path) {
+                        indexer.submit_file(entry.path());
+                    } else if self.show_ignored {
+                        report!(self, "{} {}", "⦵".dimmed(), entry.path().display());
+                    }
+                }
+            } else {
+                indexer.submit_file(source_path);
+            }
+        }
+        indexer.finish();
+        for worker in workers {
+            worker
+                .recv()
+                .map_err(|_| anyhow!("Indexing worker panicked"))?;
+        }
+        // The last batch is committed here, which belongs to the time spent storing files.
+        let flush_start = Instant::now();
+        indexer.db.flush()?;
+        indexer.stats.store_time += flush_start.elapsed();
+
+        let elapsed = start.elapsed();
+        if let Some(profiler) = profiler {
+            profiler.finish()?;
+        }
+
+        let totals = indexer.totals;
+        report!(
+            self,
+            "{} indexed, {} unchanged, {} skipped, {} failed",
+            totals.indexed,
+            totals.unchanged,
+            totals.skipped,
+            totals.failed
+        );
+        if self.stats {
+            report!(self, "Total: {}", indexer.stats);
+            report!(self, "Skipped: {}", indexer.skipped_stats);
+        }
+        // The phase breakdown is printed with CPU profiles as well, because both are used to find
+        // out why indexing is slow.
+        if self.stats || self.cpu_profile.is_some() {
+            let mut stats = indexer.stats.clone();
+            stats.add(&indexer.skipped_stats);
+            report!(self, "Phases: {}", stats.phases());
+            // The phases of different files overlap when several workers are used, so their sum
+            // can exceed the elapsed time.
+            report!(self, "Elapsed: {:?} with {} workers", elapsed, jobs);
+        }
+
+        if !self.no_go_dependencies {
+            self.index_go_dependencies(&mut db)?;
+        }
+
+        if self.resolve {
+            let resolved = self.resolve(&mut db)?;
+            report!(self, "{} resolved", resolved);
+        }
+        Ok(totals)
+    }
+
+    /// Indexes the modules in the module cache that are required by the Go modules that contain
+    /// the source paths, each into a database of its own, and records those databases as
+    /// dependencies of the database.  Dependency databases are indexed like any other database, so
+    /// their files are only indexed again if they, or the rules, changed.  Failures in dependencies
+    /// are reported, but do not count as failures of the database.
+    fn index_go_dependencies(&self, db: &mut SQLiteWriter) -> anyhow::Result<()> {
+        let module_roots = self
+            .source_paths
+            .iter()
+            .filter_map(|path| std::fs::canonicalize(path).ok())
+            .filter_map(|path| go::module_root(&path))
+            .collect::<BTreeSet<_>>();
+        if module_roots.is_empty() {
+            return Ok(());
+        }
+        let module_cache = match go::module_cache() {
+            Some(module_cache) => module_cache,
+            None => {
+                report!(
+                    self,
+                    "No Go module cache found, required modules are not indexed"
+                );
+                return Ok(());
+            }
+        };
+        // The go.mod file of a project also requires the dependencies of its dependencies, so
+        // these are not looked up recursively.
+        let mut module_dirs = BTreeSet::new();
+        for module_root in &module_roots {
+            let dirs = go::required_module_dirs(module_root, &module_cache)
+                .with_context(|| format!("Failed to read {}/go.mod", module_root.display()))?;
+            module_dirs.extend(dirs);
+        }
+        for module_dir in module_dirs {
+            let relative_path = module_dir
+                .strip_prefix(&module_cache)
+                .unwrap_or(&module_dir);
+            let database = self.database.for_dependency(relative_path);
+            if let Some(parent) = database.path().parent() {
+                std::fs::create_dir_all(parent)
+                    .with_context(|| format!("Failed to create {}", parent.display()))?;
+            }
+            report!(self, "Indexing Go module {}", relative_path.display());
+            let mut cmd = Command::new(
+                self.loader.clone(),
+                database.clone(),
+                vec![module_dir.clone()],
+                self.force,
+            );
+            cmd.syntax_errors = self.syntax_errors;
+            cmd.file_timeout = self.file_timeout;
+            cmd.max_file_size = self.max_file_size;
+            cmd.max_syntax_nodes = self.max_syntax_nodes;
+            cmd.jobs = self.jobs;
+            cmd.paths_workers = self.paths_workers;
+            cmd.batch_size = self.batch_size;
+            cmd.stub_go_files = self.stub_go_dependencies;
+            cmd.report_to_stderr = self.report_to_stderr;
+            cmd.index()?;
+            let database_path = std::fs::canonicalize(database.path())?;
+            db.record_dependency(&database_path.to_string_lossy())?;
+        }
+        db.flush()?;
+        Ok(())
+    }
+
+    /// Resolves the references in all files whose resolutions are not stored in the database, and
+    /// stores them.  Returns the number of files that were resolved.
+    fn resolve(&self, db: &mut SQLiteWriter) -> anyhow::Result<usize> {
+        let mut reader = self.database.open_reader()?;
+        let files = reader.unresolved_files()?;
+        for file in &files {
+            let resolutions = resolve_file(&mut reader, file)
+                .with_context(|| format!("Failed to resolve {}", file))?;
+            db.store_resolutions_for_file(file, &resolutions)?;
+        }
+        Ok(files.len())
+    }
+}
+
+/// Threads that run the indexer and the workers of the index command, and keep their loaders
+/// between runs, so that a server only loads the stack graph construction rules, and compiles
+/// their queries, again when the rules change.  Loaders cannot be sent between threads, so they
+/// stay on the thread that created them, and the runs are sent to the threads instead.
+pub(crate) struct IndexThreads {
+    threads: Mutex<Vec<Option<mpsc::Sender<IndexTask>>>>,
+}
+
+type IndexTask = Box<dyn FnOnce(&mut CachedLoader) + Send>;
+
+/// A loader that is kept between runs, with the hash of the rules that it was created with.
+type CachedLoader = Option<(String, anyhow::Result<Loader>)>;
+
+impl IndexThreads {
+    pub(crate) fn new() -> IndexThreads {
+        IndexThreads {
+            threads: Mutex::new(Vec::new()),
+        }
+    }
+
+    /// Runs a task on the thread with the given index, which is started if it is not running yet,
+    /// and returns a receiver for its result.  Receiving fails if the task panicked, in which case the
+    /// thread is replaced by a new one when it runs the next task.
+    fn run<T, F>(&self, index: usize, task: F) -> mpsc::Receiver<T>
+    where
+        T: Send + 'static,
+        F: FnOnce(&mut CachedLoader) -> T + Send + 'static,
+    {
+        let (result_tx, result_rx) = mpsc::channel();
+        let mut task: IndexTask = Box::new(move |loader| {
+            let _ = result_tx.send(task(loader));
+        });
+        let mut threads = self.threads.lock().unwrap();
+        while threads.len() <= index {
+            threads.push(None);
+        }
+        let thread = threads[index].get_or_insert_with(spawn_index_thread);
+        while let Err(mpsc::SendError(unsent)) = thread.send(task) {
+            task = unsent;
+            *thread = spawn_index_thread();
+        }
+        result_rx
+    }
+}
+
+/// Starts a thread that runs the tasks sent to it, until the sender is dropped.
+fn spawn_index_thread() -> mpsc::Sender<IndexTask> {
+    let (task_tx, task_rx) = mpsc::channel::<IndexTask>();
+    std::thread::spawn(move || {
+        let mut loader = None;
+        for task in task_rx {
+            task(&mut loader);
+        }
+    });
+    task_tx
+}
+
+/// Returns the cached loader, after creating it if there is none, if it was created for other
+/// rules, or if it could not be created, because the failure may have been fixed.
+fn cached_loader<'a>(
+    cached: &'a mut CachedLoader,
+    loader_args: &LoaderArgs,
+    rules_hash: &str,
+) -> &'a mut anyhow::Result<Loader> {
+    let reusable = match cached {
+        Some((cached_hash, Ok(_))) => *cached_hash == rules_hash,
+        _ => false,
+    };
+    if !reusable {
+        *cached = Some((rules_hash.to_string(), loader_args.new_loader()));
+    }
+    &mut cached.as_mut().unwrap().1
+}
+
+/// Samples the call stacks of all threads, from the moment it is started until it is finished.
+struct CpuProfiler {
+    path: PathBuf,
+    #[cfg(unix)]
+    guard: pprof::ProfilerGuard<'static>,
+}
+
+impl CpuProfiler {
+    /// The number of samples per second.
+    #[cfg(unix)]
+    const FREQUENCY: i32 = 997;
+
+    #[cfg(unix)]
+    fn start(path: &Path) -> anyhow::Result<CpuProfiler> {
+        let guard =
+            pprof::ProfilerGuard::new(Self::FREQUENCY).context("Failed to start profiler")?;
+        Ok(CpuProfiler {
+            path: path.to_path_buf(),
+            guard,
+        })
+    }
+
+    #[cfg(not(unix))]
+    fn start(_path: &Path) -> anyhow::Result<CpuProfiler> {
+        Err(anyhow!("CPU profiles are only supported on Unix systems"))
+    }
+
+    /// Stops sampling, and writes the samples as a flame graph.
+    #[cfg(unix)]
+    fn finish(self) -> anyhow::Result<()> {
+        let report = self
+            .guard
+            .report()
+            .build()
+            .context("Failed to build CPU profile")?;
+        let file = std::fs::File::create(&self.path)
+            .with_context(|| format!("Failed to create {}", self.path.display()))?;
+        report
+            .flamegraph(file)
+            .with_context(|| format!("Failed to write {}", self.path.display()))?;
+        println!("CPU profile written to {}", self.path.display());
+        Ok(())
+    }
+
+    #[cfg(not(unix))]
+    fn finish(self) -> anyhow::Result<()> {
+        Ok(())
+    }
+}
+
+/// A counting semaphore, which limits the number of threads that can do something at the same
+/// time.
+struct Permits {
+    available: Mutex<usize>,
+    released: Condvar,
+}
+
+impl Permits {
+    fn new(count: usize) -> Permits {
+        Permits {
+            available: Mutex::new(count),
+            released: Condvar::new(),
+        }
+    }
+
+    /// Waits until a permit is available, and takes it.  The permit is returned when the guard is
+    /// dropped.
+    fn acquire(&self) -> PermitGuard {
+        let mut available = self.available.lock().unwrap();
+        while *available == 0 {
+            available = self.released.wait(available).unwrap();
+        }
+        *available -= 1;
+        PermitGuard(self)
+    }
+}
+
+struct PermitGuard<'a>(&'a Permits);
+
+impl Drop for PermitGuard<'_> {
+    fn drop(&mut self) {
+        *self.0.available.lock().unwrap() += 1;
+        self.0.released.notify_one();
+    }
+}
+
+/// A file that must be indexed by a worker.
+struct IndexJob {
+    source_path: PathBuf,
+    file_name: String,
+    tag: String,
+    path_globals: PathGlobals,
+    /// The content of the file, if it was not read from disk.
+    source: Option<String>,
+}
+
+/// The global variables of a file that are derived from its path.
+#[derive(Clone, Default)]
+struct PathGlobals {
+    /// The root directory of the project that contains the file, if it is known.
+    root: Option<PathBuf>,
+    /// The import path of the package of the file, if it is a Go file.
+    go_import_path: Option<String>,
+}
+
+/// Include and exclude patterns for the files in source directories.
+struct PathFilter {
+    /// The include patterns, or `None` if all files are included.
+    include: Option<GlobSet>,
+    exclude: GlobSet,
+}
+
+impl PathFilter {
+    fn new(include: &[String], exclude: &[String]) -> anyhow::Result<PathFilter> {
+        let include = if include.is_empty() {
+            None
+        } else {
+            Some(glob_set(include)?)
+        };
+        Ok(PathFilter {
+            include,
+            exclude: glob_set(exclude)?,
+        })
+    }
+
+    /// Returns whether a file, with the given path relative to its source directory, is indexed.
+    fn is_match(&self, path: &Path) -> bool {
+        if self.exclude.is_match(path) {
+            return false;
+        }
+        self.include
+            .as_ref()
+            .map_or(true, |include| include.is_match(path))
+    }
+}
+
+fn glob_set(patterns: &[String]) -> anyhow::Result<GlobSet> {
+    let mut builder = GlobSetBuilder::new();
+    for pattern in patterns {
+        let glob = GlobBuilder::new(pattern)
+            .literal_separator(true)
+            .build()
+            .with_context(|| format!("Invalid glob pattern {}", pattern))?;
+        builder.add(glob);
+    }
+    Ok(builder.build()?)
+}
+
+/// The name of the ignore files that only apply to this tool, and that use the same syntax as
+/// `.gitignore` files.
+const IGNORE_FILE_NAME: &str = ".sgignore";
+
+/// The tag of files that were read from standard input.  Their content may differ from the file
+/// on disk, so they are never considered unchanged.
+const STDIN_TAG: &str = "stdin";
+
+/// A stack graph computed by a worker, together with the partial paths of the files in it that
+/// must be stored.
+pub(crate) struct IndexedGraph {
+    graph: StackGraph,
+    partials: PartialPaths,
+    info: String,
+    files: Vec<IndexedFile>,
+}
+
+struct IndexedFile {
+    file: Handle<File>,
+    tag: String,
+    paths: Vec<PartialPath>,
+}
+
+/// A message from a worker to the thread that writes to the database.
+enum WorkerResult {
+    /// The builtins of a language, which are stored once per run.
+    Builtins(IndexedGraph),
+    /// A file that was indexed, with notes about problems that did not prevent it from being
+    /// indexed, such as syntax errors.
+    Indexed(IndexJob, IndexedGraph, IndexStats, Vec<String>),
+    /// A file that was not indexed, e.g., because it is too large, with the reason.  The file is
+    /// stored without any nodes, so that it is recorded as skipped.
+    Skipped(IndexJob, IndexedGraph, IndexStats, String),
+    Ignored(IndexJob),
+    Failed(IndexJob, IndexFailure),
+}
+
+/// A failure to index a file, together with the phase during which it occurred and the class of
+/// error, which are recorded in the database.
+struct IndexFailure {
+    phase: &'static str,
+    kind: &'static str,
+    location: Option<String>,
+    error: anyhow::Error,
+}
+
+impl IndexFailure {
+    fn new(phase: &'static str, kind: &'static str, error: anyhow::Error) -> IndexFailure {
+        IndexFailure {
+            phase,
+            kind,
+            location: None,
+            error,
+        }
+    }
+
+    /// Classifies an error that occurred while building the stack graph of a file.  Parse errors
+    /// report the position of the first error in the source.  Errors in the rules do not report
+    /// a location, because tree-sitter-graph does not expose it.
+    fn from_load_error(err: LoadError, source: &str) -> IndexFailure {
+        let (phase, kind) = match &err {
+            LoadError::ParseError | LoadError::ParseErrors(_) => ("parsing", "parse error"),
+            LoadError::ExecutionError(_)
+            | LoadError::PartialGraph(_, _)
+            | LoadError::ReservedGlobal(_) => ("executing rules", "execution error"),
+            LoadError::Cancelled(_) => ("building graph", "timeout"),
+            _ => ("building graph", "invalid graph"),
+        };
+        let mut failure = IndexFailure::new(phase, kind, anyhow!("{}", err));
+        if let LoadError::ParseErrors(parse_errors) = &err {
+            if let Some(parse_error) = parse_errors.errors().first() {
+                let position = parse_error.node().start_position();
+                failure.location = Some(format!("{}:{}", position.row + 1, position.column + 1));
+                failure.error = anyhow!("{}: {}", err, parse_error.display(source, false));
+            }
+        }
+        failure
+    }
+
+    fn to_file_failure(&self) -> FileFailure {
+        FileFailure {
+            phase: self.phase.to_string(),
+            kind: self.kind.to_string(),
+            message: format!("{:#}", self.error),
+            location: self.location.clone(),
+        }
+    }
+}
+
+/// What to do with a file that is submitted for indexing.
+enum PreparedJob {
+    /// The file must be indexed.
+    Index(IndexJob),
+    /// The file has not changed since it was last indexed.
+    Unchanged,
+    /// The stored results of the given file, which has the same content, were copied.
+    Copied(String),
+}
+
+/// Dispatches files to the workers, and stores and reports their results.  Only the indexer writes
+/// to the database.
+struct Indexer<'a> {
+    cmd: &'a Command,
+    db: &'a mut SQLiteWriter,
+    jobs: Option<mpsc::Sender<IndexJob>>,
+    results: mpsc::Receiver<WorkerResult>,
+    totals: IndexTotals,
+    stats: IndexStats,
+    /// The statistics of skipped files, which are reported separately, because they are not
+    /// comparable to those of indexed files.
+    skipped_stats: IndexStats,
+    /// The loader that finds the stack graph construction rules of every file.
+    loader: &'a mut Loader,
+    /// The hashes of the stack graph construction rules, by the paths of the files they are loaded
+    /// from.  The hash of the rules of its language is part of the tag of every file.
+    rules_hashes: HashMap<Vec<PathBuf>, String>,
+    /// The canonical paths of the project roots.
+    roots: Vec<PathBuf>,
+}
+
+impl<'a> Indexer<'a> {
+    /// Submits a file for indexing, unless it is unchanged since it was last indexed.  Results
+    /// that are already available are processed, so that they don't accumulate in memory.
+    fn submit_file(&mut self, source_path: &Path) {
+        match self.prepare_job(source_path) {
+            Ok(PreparedJob::Index(job)) => {
+                if let Some(jobs) = &self.jobs {
+                    // Sending only fails if all workers are gone, and their panics are reported
+                    // when they are joined.
+                    let _ = jobs.send(job);
+                }
+            }
+            Ok(PreparedJob::Unchanged) => {
+                self.totals.unchanged += 1;
+                if !self.cmd.hide_successes {
+                    report!(
+                        self.cmd,
+                        "{} {} (unchanged)",
+                        "✓".dimmed(),
+                        source_path.display()
+                    );
+                }
+            }
+            Ok(PreparedJob::Copied(original)) => {
+                self.totals.indexed += 1;
+                if !self.cmd.hide_successes {
+                    report!(
+                        self.cmd,
+                        "{} {} (same as {})",
+                        "✓".green(),
+                        source_path.display(),
+                        original
+                    );
+                }
+            }
+            Err(err) => {
+                self.totals.failed += 1;
+                report!(
+                    self.cmd,
+                    "{} {}: {:?}",
+                    "✗".red(),
+                    source_path.display(),
+                    err
+                );
+            }
+        }
+        while let Ok(result) = self.results.try_recv() {
+            self.process_result(result);
+        }
+    }
+
+    /// Submits the content of standard input for indexing, as the content of the file at the given
+    /// path.  The content is always indexed, because we cannot tell whether it has changed.
+    fn submit_stdin(&mut self, path: &Path) {
+        match self.prepare_stdin_job(path) {
+            Ok(job) => {
+                if let Some(jobs) = &self.jobs {
+                    let _ = jobs.send(job);
+                }
+            }
+            Err(err) => {
+                self.totals.failed += 1;
+                report!(self.cmd, "{} {}: {:?}", "✗".red(), path.display(), err);
+            }
+        }
+    }
+
+    fn prepare_stdin_job(&self, path: &Path) -> anyhow::Result<IndexJob> {
+        let mut source = String::new();
+        std::io::stdin()
+            .read_to_string(&mut source)
+            .context("Failed to read standard input")?;
+        // Files are stored under their canonical path, but a virtual path may not exist, in which
+        // case we can only make it absolute.
+        let source_path = match std::fs::canonicalize(path) {
+            Ok(source_path) => source_path,
+            Err(_) => std::env::current_dir()?.join(path),
+        };
+        Ok(IndexJob {
+            file_name: source_path.to_string_lossy().to_string(),
+            path_globals: self.path_globals(&source_path),
+            source_path,
+            tag: STDIN_TAG.to_string(),
+            source: Some(source),
+        })
+    }
+
+    /// Waits for the workers to index all submitted files.
+    fn finish(&mut self) {
+        // Closing the job channel makes the workers stop once they are out of work.
+        self.jobs = None;
+        while let Ok(result) = self.results.recv() {
+            self.process_result(result);
+        }
+    }
+
+    fn prepare_job(&mut self, source_path: &Path) -> anyhow::Result<PreparedJob> {
+        let source_path = std::fs::canonicalize(source_path)?;
+        let file_name = source_path.to_string_lossy().to_string();
+        let path_globals = self.path_globals(&source_path);
+        let content = std::fs::read(&source_path)?;
+        let text = std::str::from_utf8(&content).ok();
+        let rules_hash = self.rules_hash_for_file(&source_path, text)?;
+        let seen_path = if self.rules_see_path(&source_path, text)? {
+            Some(source_path.as_path())
+        } else {
+            None
+        };
+        let tag = file_tag(
+            &content,
+            &rules_hash,
+            &path_globals,
+            seen_path,
+            self.cmd.stub_go_files,
+        );
+        if !self.cmd.force && self.db.file_tag(&file_name)?.as_ref() == Some(&tag) {
+            return Ok(PreparedJob::Unchanged);
+        }
+        if self.cmd.reuse_identical_files {
+            if let Some(original) = self.db.file_with_tag(&tag)? {
+                if original != file_name {
+                    self.db.copy_file(&original, &file_name)?;
+                    return Ok(PreparedJob::Copied(original));
+                }
+            }
+        }
+        Ok(PreparedJob::Index(IndexJob {
+            path_globals,
+            source_path,
+            file_name,
+            tag,
+            source: None,
+        }))
+    }
+
+    /// Returns the hash of the stack graph construction rules for the given file, which covers the
+    /// rules of its language, and of the languages that can be injected into it.  Files are not
+    /// indexed again when only the rules of other languages change.
+    fn rules_hash_for_file(
+        &mut self,
+        path: &Path,
+        content: Option<&str>,
+    ) -> anyhow::Result<String> {
+        // Files without a language are not stored, so their tag does not matter.
+        let rule_paths = self
+            .loader
+            .rule_paths_for_file(path, content)?
+            .unwrap_or_default();
+        if let Some(rules_hash) = self.rules_hashes.get(&rule_paths) {
+            return Ok(rules_hash.clone());
+        }
+        let rules_hash = self.cmd.loader.rules_hash_for_paths(&rule_paths)?;
+        self.rules_hashes.insert(rule_paths, rules_hash.clone());
+        Ok(rules_hash)
+    }
+
+    /// Returns whether the rules for the given file can see its path, i.e., whether its language
+    /// declares the FILE_PATH or ROOT_PATH global variables, or has an injections query, in which
+    /// case the rules of the injected languages may see it.
+    fn rules_see_path(&mut self, path: &Path, content: Option<&str>) -> anyhow::Result<bool> {
+        Ok(match self.loader.load_for_file(path, content)? {
+            Some(sgl) => {
+                sgl.has_injections_query()
+                    || sgl.declares_global(FILE_PATH_VAR)
+                    || sgl.declares_global(ROOT_PATH_VAR)
+            }
+            None => false,
+        })
+    }
+
+    /// Returns the global variables of the file at the given canonical path that are derived from
+    /// its path.
+    fn path_globals(&self, path: &Path) -> PathGlobals {
+        PathGlobals {
+            root: self.root_of(path),
+            go_import_path: go::import_path_for_file(path),
+        }
+    }
+
+    /// Returns the innermost project root that contains the given canonical path.
+    fn root_of(&self, path: &Path) -> Option<PathBuf> {
+        self.roots
+            .iter()
+            .filter(|root| path.starts_with(root))
+            .max_by_key(|root| root.components().count())
+            .cloned()
+    }
+
+    /// Stores and reports the result of a worker.  Failures are reported, but do not stop
+    /// indexing of the remaining files.
+    fn process_result(&mut self, result: WorkerResult) {
+        match result {
+            WorkerResult::Builtins(mut indexed) => {
+                if let Err(err) = self.store_graph(&mut indexed) {
+                    report!(self.cmd, "{} builtins: {:?}", "✗".red(), err);
+                }
+            }
+            WorkerResult::Indexed(job, mut indexed, mut stats, notes) => {
+                let start = Instant::now();
+                match self.store_graph(&mut indexed) {
+                    Ok(()) => {
+                        stats.store_time = start.elapsed();
+                        self.totals.indexed += 1;
+                        self.stats.add(&stats);
+                        if !notes.is_empty() {
+                            report!(
+                                self.cmd,
+                                "{} {} ({})",
+                                "✓".yellow(),
+                                job.source_path.display(),
+                                notes.join("; ")
+                            );
+                        } else if !self.cmd.hide_successes {
+                            report!(self.cmd, "{} {}", "✓".green(), job.source_path.display());
+                        }
+                        if self.cmd.stats {
+                            report!(self.cmd, "  {}", stats);
+                        }
+                    }
+                    Err(err) => self
+                        .process_failure(job, IndexFailure::new("storing", "database error", err)),
+                }
+            }
+            WorkerResult::Skipped(job, mut indexed, mut stats, reason) => {
+                let start = Instant::now();
+                match self.store_graph(&mut indexed) {
+                    Ok(()) => {
+                        stats.store_time = start.elapsed();
+                        self.totals.skipped += 1;
+                        self.skipped_stats.add(&stats);
+                        report!(
+                            self.cmd,
+                            "{} {} ({})",
+                            "⊘".yellow(),
+                            job.source_path.display(),
+                            reason
+                        );
+                        if self.cmd.stats {
+                            report!(self.cmd, "  {}", stats);
+                        }
+                    }
+                    Err(err) => self
+                        .process_failure(job, IndexFailure::new("storing", "database error", err)),
+                }
+            }
+            WorkerResult::Ignored(job) => {
+                if self.cmd.show_ignored {
+                    report!(self.cmd, "{} {}", "⦵".dimmed(), job.source_path.display());
+                }
+            }
+            WorkerResult::Failed(job, err) => self.process_failure(job, err),
+        }
+    }
+
+    fn process_failure(&mut self, job: IndexJob, failure: IndexFailure) {
+        self.totals.failed += 1;
+        report!(
+            self.cmd,
+            "{} {}: {:?}",
+            "✗".red(),
+            job.source_path.display(),
+            failure.error
+        );
+        // Record the failure, so that it shows up in the status of the database.
+        if let Err(err) =
+            self.db
+                .store_error_for_file(&job.file_name, &job.tag, "", &failure.to_file_failure())
+        {
+            report!(
+                self.cmd,
+                "{} {}: {:?}",
+                "✗".red(),
+                job.source_path.display(),
+                err
+            );
+        }
+    }
+
+    fn store_graph(&mut self, indexed: &mut IndexedGraph) -> anyhow::Result<()> {
+        indexed.store(self.db)
+    }
+}
+
+/// Indexes files on its own thread, and sends the results back to the indexer.
+struct Worker {
+    loader_args: LoaderArgs,
+    syntax_errors: SyntaxErrors,
+    keep_partial_graphs: bool,
+    strict_attributes: bool,
+    file_timeout: Option<Duration>,
+    max_file_size: Option<usize>,
+    max_syntax_nodes: Option<usize>,
+    stub_go_files: bool,
+    jobs: Arc<Mutex<mpsc::Receiver<IndexJob>>>,
+    results: mpsc::Sender<WorkerResult>,
+    /// Names of builtins files that some worker has already sent to the indexer.
+    claimed_builtins: Arc<Mutex<HashSet<String>>>,
+    /// The hash of the rules of all languages, which is part of the tag of builtins.
+    rules_hash: String,
+    /// Limits the number of workers that find partial paths at the same time.
+    paths_permits: Arc<Permits>,
+}
+
+impl Worker {
+    fn run(self, loader: &mut CachedLoader) {
+        let loader = cached_loader(loader, &self.loader_args, &self.rules_hash);
+        loop {
+            // Release the lock before indexing, so that other workers can pick up jobs.
+            let job = match self.jobs.lock().unwrap().recv() {
+                Ok(job) => job,
+                Err(_) => break,
+            };
+            let result = match &mut *loader {
+                Ok(loader) => self.index_file(loader, job),
+                Err(err) => WorkerResult::Failed(
+                    job,
+                    IndexFailure::new("loading language", "language error", anyhow!("{:#}", err)),
+                ),
+            };
+            if self.results.send(result).is_err() {
+                break;
+            }
+        }
+    }
+
+    fn index_file(&self, loader: &mut Loader, mut job: IndexJob) -> WorkerResult {
+        log::debug!("Indexing {}", job.source_path.display());
+        let _span = tracing::info_span!("index_file", file = %job.file_name).entered();
+        let content = match job.source.take() {
+            Some(source) => source.into_bytes(),
+            None => match std::fs::read(&job.source_path)
+                .with_context(|| format!("Failed to read {}", job.source_path.display()))
+            {
+                Ok(content) => content,
+                Err(err) => {
+                    return WorkerResult::Failed(job, IndexFailure::new("reading", "io error", err))
+                }
+            },
+        };
+        let source = match decode_source(content) {
+            Ok(source) => source,
+            Err(reason) => return self.skip_undecodable_file(loader, job, reason),
+        };
+        let sgl = match loader.load_for_file(&job.source_path, Some(&source)) {
+            Ok(Some(sgl)) => sgl,
+            Ok(None) => return WorkerResult::Ignored(job),
+            Err(err) => {
+                return WorkerResult::Failed(
+                    job,
+                    IndexFailure::new("loading language", "language error", err.into()),
+                )
+            }
+        };
+        self.send_builtins(sgl);
+        sgl.set_syntax_error_policy(self.syntax_errors.policy());
+        sgl.set_keep_partial_graphs(self.keep_partial_graphs);
+        sgl.set_strict_attributes(self.strict_attributes);
+        let mut stats = IndexStats::default();
+        if let Some(max_file_size) = self.max_file_size {
+            if source.len() > max_file_size {
+                let reason = format!("too large, {} bytes, file skipped", source.len());
+                return self.skip_file(sgl, job, stats, reason);
+            }
+        }
+
+        // The timeout starts after the language is loaded, so that loading a language for the
+        // first time does not count against the first file that uses it.
+        let timeout_start = Instant::now();
+        let cancellation_flag = self.cancellation_flag(Duration::ZERO);
+        let mut graph = StackGraph::new();
+        let file = graph.get_or_create_file(&job.file_name);
+        let mut globals = file_globals(&self.loader_args, &job.path_globals);
+        let mut notes = Vec::new();
+        // The file is parsed separately from building its stack graph, so that the size of its
+        // syntax tree can be checked first.
+        let parse_start = Instant::now();
+        let tree = match sgl.parse(&source, None) {
+            Ok(tree) => tree,
+            Err(LoadError::ParseErrors(_)) if self.syntax_errors == SyntaxErrors::SkipFile => {
+                stats.build.has_syntax_errors = true;
+                let reason = SyntaxErrors::SkipFile.description().to_string();
+                return self.skip_file(sgl, job, stats, reason);
+            }
+            Err(err) => {
+                return WorkerResult::Failed(job, IndexFailure::from_load_error(err, &source))
+            }
+        };
+        let mut parse_time = parse_start.elapsed();
+        if let Some(max_syntax_nodes) = self.max_syntax_nodes {
+            let syntax_node_count = syntax_node_count(&tree);
+            if syntax_node_count > max_syntax_nodes {
+                let reason = format!(
+                    "too large, {} syntax nodes, file skipped",
+                    syntax_node_count
+                );
+                return self.skip_file(sgl, job, stats, reason);
+            }
+        }
+        // Stubs are parsed again, so that the syntax nodes of the stack graph match the source.
+        let (tree, source) = if self.stub_go_files && job.path_globals.go_import_path.is_some() {
+            let stub = go::stub_source(&tree, &source);
+            let parse_start = Instant::now();
+            let tree = sgl.parse(&stub, None);
+            parse_time += parse_start.elapsed();
+            match tree {
+                Ok(tree) => (tree, stub),
+                Err(err) => {
+                    return WorkerResult::Failed(job, IndexFailure::from_load_error(err, &stub))
+                }
+            }
+        } else {
+            (tree, source)
+        };
+        match sgl.build_stack_graph_from_tree_into_with_cancellation(
+            &mut graph,
+            file,
+            &tree,
+            &source,
+            &mut globals,
+            cancellation_flag.as_ref(),
+        ) {
+            Ok(build_stats) => stats.build = build_stats,
+            Err(LoadError::PartialGraph(err, build_stats)) => {
+                stats.build = build_stats;
+                notes.push(partial_graph_note(&err));
+            }
+            Err(LoadError::Cancelled(err)) => {
+                let err = self.timeout_error(err);
+                return WorkerResult::Failed(
+                    job,
+                    IndexFailure::new("building graph", "timeout", err),
+                );
+            }
+            Err(err) => {
+                return WorkerResult::Failed(job, IndexFailure::from_load_error(err, &source))
+            }
+        }
+        stats.build.parse_time = parse_time;
+        if stats.build.has_syntax_errors {
+            notes.push(self.syntax_errors.description().to_string());
+        }
+        let info = language_info(sgl);
+        let injections = match sgl.find_injections(&source) {
+            Ok(injections) => injections,
+            Err(err) => {
+                return WorkerResult::Failed(job, IndexFailure::from_load_error(err, &source))
+            }
+        };
+        for injection in injections {
+            match self.index_injection(
+                loader,
+                &mut graph,
+                file,
+                &source,
+                &injection,
+                &job.path_globals,
+                &mut notes,
+                cancellation_flag.as_ref(),
+            ) {
+                Ok(build_stats) => stats.build.add(&build_stats),
+                Err(err) => return WorkerResult::Failed(job, err),
+            }
+        }
+        // The notes are recorded in the database, so that they show up in the status of the file.
+        let info = std::iter::once(info)
+            .chain(notes.iter().cloned())
+            .collect::<Vec<_>>();
+        let mut indexed = IndexedGraph::new(graph, info.join("; "));
+        // Time spent waiting for other workers to finish their partial paths does not count
+        // against the timeout.
+        let elapsed = timeout_start.elapsed();
+        let _permit = self.paths_permits.acquire();
+        let cancellation_flag = self.cancellation_flag(elapsed);
+        let start = Instant::now();
+        if let Err(err) = indexed.add_file(file, job.tag.clone(), cancellation_flag.as_ref()) {
+            let err = self.timeout_error(err);
+            return WorkerResult::Failed(
+                job,
+                IndexFailure::new("finding partial paths", "timeout", err),
+            );
+        }
+        stats.partial_paths_time = start.elapsed();
+        stats.partial_path_count = indexed.files.iter().map(|f| f.paths.len()).sum();
+        WorkerResult::Indexed(job, indexed, stats, notes)
+    }
+
+    /// Skips a file whose content is not text, if it is in a language that is loaded.  The language
+    /// is determined without the content of the file.
+    fn skip_undecodable_file(
+        &self,
+        loader: &mut Loader,
+        job: IndexJob,
+        reason: &str,
+    ) -> WorkerResult {
+        let sgl = match loader.load_for_file(&job.source_path, None) {
+            Ok(Some(sgl)) => sgl,
+            Ok(None) => return WorkerResult::Ignored(job),
+            Err(err) => {
+                return WorkerResult::Failed(
+                    job,
+                    IndexFailure::new("loading language", "language error", err.into()),
+                )
+            }
+        };
+        self.send_builtins(sgl);
+        self.skip_file(sgl, job, IndexStats::default(), reason.to_string())
+    }
+
+    /// Stores a file without any nodes or partial paths, so that it is recorded as skipped in the
+    /// database, and only indexed again if it changes.  The reason is recorded in the status of
+    /// the file.
+    fn skip_file(
+        &self,
+        sgl: &StackGraphLanguage,
+        job: IndexJob,
+        stats: IndexStats,
+        reason: String,
+    ) -> WorkerResult {
+        let mut graph = StackGraph::new();
+        let file = graph.get_or_create_file(&job.file_name);
+        let info = format!("{}; {}", language_info(sgl), reason);
+        let mut indexed = IndexedGraph::new(graph, info);
+        // An empty file has no partial paths, so this cannot be cancelled.
+        let _ = indexed.add_file(file, job.tag.clone(), &NoCancellation);
+        WorkerResult::Skipped(job, indexed, stats, reason)
+    }
+
+    /// Builds the stack graph for code in another language that is embedded in a file, into the
+    /// file that contains it.  Injected code in a language that is not loaded is skipped.
+    /// Problems that do not prevent indexing are added to the notes of the file.  Returns the
+    /// statistics of building the stack graph of the injected code.
+    fn index_injection(
+        &self,
+        loader: &mut Loader,
+        graph: &mut StackGraph,
+        file: Handle<File>,
+        host_source: &str,
+        injection: &Injection,
+        path_globals: &PathGlobals,
+        notes: &mut Vec<String>,
+        cancellation_flag: &dyn CancellationFlag,
+    ) -> Result<BuildStats, IndexFailure> {
+        let sgl = match loader.load_for_injection(&injection.language) {
+            Ok(Some(sgl)) => sgl,
+            Ok(None) => {
+                log::debug!(
+                    "Skipping injected {} code in {}",
+                    injection.language,
+                    graph[file]
+                );
+                return Ok(BuildStats::default());
+            }
+            Err(err) => {
+                return Err(IndexFailure::new(
+                    "loading language",
+                    "language error",
+                    err.into(),
+                ))
+            }
+        };
+        self.send_builtins(sgl);
+        sgl.set_syntax_error_policy(self.syntax_errors.policy());
+        sgl.set_keep_partial_graphs(self.keep_partial_graphs);
+        sgl.set_strict_attributes(self.strict_attributes);
+        let source = injection.source(host_source);
+        let mut globals = file_globals(&self.loader_args, path_globals);
+        match sgl.build_stack_graph_into_with_cancellation(
+            graph,
+            file,
+            &source,
+            &mut globals,
+            cancellation_flag,
+        ) {
+            Ok(build_stats) => {
+                if build_stats.has_syntax_errors {
+                    notes.push(format!(
+                        "syntax errors in injected {} code",
+                        injection.language
+                    ));
+                }
+                Ok(build_stats)
+            }
+            Err(LoadError::PartialGraph(err, build_stats)) => {
+                notes.push(format!(
+                    "{} in injected {} code",
+                    partial_graph_note(&err),
+                    injection.language
+                ));
+                Ok(build_stats)
+            }
+            Err(LoadError::Cancelled(err)) => Err(IndexFailure::new(
+                "building graph",
+                "timeout",
+                self.timeout_error(err),
+            )),
+            Err(err) => Err(IndexFailure::from_load_error(err, &source)),
+        }
+    }
+
+    /// Returns a cancellation flag for the rest of the file timeout, given the time that was
+    /// already spent on the file.
+    fn cancellation_flag(&self, elapsed: Duration) -> Box<dyn CancellationFlag> {
+        match self.file_timeout {
+            Some(file_timeout) => Box::new(CancelAfterDuration::new(
+                file_timeout.saturating_sub(elapsed),
+            )),
+            None => Box::new(NoCancellation),
+        }
+    }
+
+    fn timeout_error(&self, err: CancellationError) -> anyhow::Error {
+        let file_timeout = self.file_timeout.unwrap_or_default();
+        anyhow::Error::new(err).context(format!(
+            "Timed out after {} seconds",
+            file_timeout.as_secs()
+        ))
+    }
+
+    /// Sends the builtins of a language to the indexer, unless another worker did so already
+    /// during this run.
+    fn send_builtins(&self, sgl: &StackGraphLanguage) {
+        let builtins = sgl.builtins();
+        let files = {
+            let mut claimed_builtins = self.claimed_builtins.lock().unwrap();
+            builtins
+                .iter_files()
+                .filter(|file| claimed_builtins.insert(builtins[*file].name().to_string()))
+                .map(|file| builtins[file].name().to_string())
+                .collect::<Vec<_>>()
+        };
+        if files.is_empty() {
+            return;
+        }
+
+        let mut graph = StackGraph::new();
+        if graph.add_from_graph(builtins).is_err() {
+            return;
+        }
+        let mut indexed = IndexedGraph::new(graph, language_info(sgl));
+        for file_name in files {
+            let file = indexed.graph.get_file_unchecked(&file_name);
+            // Builtins get their globals from the language, not from their path.
+            let tag = std::fs::read(&file_name)
+                .map(|content| {
+                    file_tag(
+                        &content,
+                        &self.rules_hash,
+                        &PathGlobals::default(),
+                        None,
+                        false,
+                    )
+                })
+                .unwrap_or_default();
+            // Builtins are small, and are not subject to the file timeout.
+            let _ = indexed.add_file(file, tag, &NoCancellation);
+        }
+        let _ = self.results.send(WorkerResult::Builtins(indexed));
+    }
+}
+
+impl IndexedGraph {
+    /// Creates an indexed graph without any files, for a graph that was built using the language
+    /// described by `info`.
+    pub(crate) fn new(graph: StackGraph, info: String) -> IndexedGraph {
+        IndexedGraph {
+            graph,
+            partials: PartialPaths::new(),
+            info,
+            files: Vec::new(),
+        }
+    }
+
+    pub(crate) fn graph(&self) -> &StackGraph {
+        &self.graph
+    }
+
+    /// Computes the partial paths of a file in the graph, which will be stored together with the
+    /// file's graph.
+    pub(crate) fn add_file(
+        &mut self,
+        file: Handle<File>,
+        tag: String,
+        cancellation_flag: &dyn CancellationFlag,
+    ) -> Result<(), CancellationError> {
+        let span = tracing::info_span!(
+            "find_partial_paths",
+            file = %self.graph[file],
+            paths = tracing::field::Empty,
+        )
+        .entered();
+        let mut paths = Vec::new();
+        self.partials
+            .find_all_partial_paths_in_file_with_cancellation(
+                &self.graph,
+                file,
+                cancellation_flag,
+                |graph, partials, path| {
+                    if !path.is_complete_as_possible(graph) {
+                        return;
+                    }
+                    if !path.is_productive(partials) {
+                        return;
+                    }
+                    paths.push(path);
+                },
+            )?;
+        span.record("paths", &paths.len());
+        self.files.push(IndexedFile { file, tag, paths });
+        Ok(())
+    }
+
+    /// Stores the graphs and partial paths of all added files in the database.
+    pub(crate) fn store(&mut self, db: &mut SQLiteWriter) -> anyhow::Result<()> {
+        for file in &self.files {
+            let _span = tracing::info_span!("store", file = %self.graph[file.file]).entered();
+            db.store_result_for_file(
+                &self.graph,
+                file.file,
+                &file.tag,
+                &self.info,
+                &mut self.partials,
+                &file.paths,
+            )?;
+        }
+        Ok(())
+    }
+}
+
+/// Describes a file whose stack graph construction rules failed, but whose partial graph was
+/// kept.
+fn partial_graph_note(err: &tree_sitter_graph::ExecutionError) -> String {
+    format!("partial graph: {}", err)
+}
+
+/// Returns a description of the language that was used to index a file, which is recorded in
+/// the database.
+pub(crate) fn language_info(sgl: &StackGraphLanguage) -> String {
+    format!("tree-sitter ABI {}", sgl.language().version())
+}
+
+/// The number of bytes at the start of a file that are checked for NUL bytes, which text files do
+/// not contain.
+const BINARY_CHECK_LENGTH: usize = 8000;
+
+/// Returns the content of a file as text, or the reason why it is not indexed, if it is binary or
+/// not valid UTF-8.  Parsing such content would only produce syntax errors.
+fn decode_source(content: Vec<u8>) -> Result<String, &'static str> {
+    if content.iter().take(BINARY_CHECK_LENGTH).any(|b| *b == 0) {
+        return Err("binary content, file skipped");
+    }
+    String::from_utf8(content).map_err(|_| "content is not valid UTF-8, file skipped")
+}
+
+/// Returns the number of nodes in a syntax tree, including anonymous nodes.
+fn syntax_node_count(tree: &Tree) -> usize {
+    let mut cursor = tree.walk();
+    let mut count = 1;
+    loop {
+        if cursor.goto_first_child() || cursor.goto_next_sibling() {
+            count += 1;
+            continue;
+        }
+        loop {
+            if !cursor.goto_parent() {
+                return count;
+            }
+            if cursor.goto_next_sibling() {
+                count += 1;
+                break;
+            }
+        }
+    }
+}
+
+/// Returns the global variables for building the stack graph of a file, which are the variables
+/// given on the command line, and the variables that are derived from its path.
+fn file_globals(loader_args: &LoaderArgs, path_globals: &PathGlobals) -> Variables<'static> {
+    let mut globals = loader_args.globals();
+    if let Some(root) = &path_globals.root {
+        // The root path cannot be given on the command line, so the name cannot be taken.
+        let _ = globals.add(
+            ROOT_PATH_VAR.into(),
+            root.to_string_lossy().to_string().into(),
+        );
+    }
+    if let Some(import_path) = &path_globals.go_import_path {
+        // An import path given on the command line takes precedence.
+        let _ = globals.add(go::IMPORT_PATH_VAR.into(), import_path.clone().into());
+    }
+    globals
+}
+
+/// Returns the tag that identifies the current version of a file, which consists of the hash of
+/// its content, the hash of the stack graph construction rules, and the import path of Go files.
+/// Files are indexed again if any of them changes, such as the import path after an edit of the
+/// `go.mod` file, but not if the file is only touched.  Go files that are indexed as stubs are
+/// marked as such, so that they are indexed again when the full file is needed.
+///
+/// The tag is also used to find files whose stored results can be reused, because they have the
+/// same stack graph.  If the rules can see the path of the file, which is then given as
+/// `seen_path`, the tag includes the path and the root of the file, so that the results of one
+/// file are never reused for a file at a different path.
+fn file_tag(
+    content: &[u8],
+    rules_hash: &str,
+    path_globals: &PathGlobals,
+    seen_path: Option<&Path>,
+    stub_go_files: bool,
+) -> String {
+    let mut tag = format!("sha1:{:x} rules:{}", Sha1::digest(content), rules_hash);
+    if let Some(import_path) = &path_globals.go_import_path {
+        tag.push_str(&format!(" go:{}", import_path));
+        if stub_go_files {
+            tag.push_str(" stub");
+        }
+    }
+    if let Some(path) = seen_path {
+        tag.push_str(&format!(" path:{}", path.display()));
+        if let Some(root) = &path_globals.root {
+            tag.push_str(&format!(" root:{}", root.display()));
+        }
+    }
+    tag
+}
+
+/// Returns the files in the database that were indexed with other stack graph construction rules
+/// than the current ones, which are the files whose tag contains a rules hash that differs from
+/// the current hash of the rules for the file.  Servers index these files again when the rules
+/// change.  Files that failed to index are included, because the new rules may succeed.  Files
+/// that no longer exist are left out, and so are files whose tag has no rules hash, such as open
+/// documents of the language server.
+pub(crate) fn files_with_changed_rules(
+    loader_args: &LoaderArgs,
+    database: &DatabaseArgs,
+) -> anyhow::Result<Vec<PathBuf>> {
+    if !database.path().exists() {
+        return Ok(Vec::new());
+    }
+    let mut loader = loader_args.new_loader()?;
+    let reader = database.open_reader()?;
+    let mut rules_hashes = HashMap::<Vec<PathBuf>, String>::new();
+    let mut paths = Vec::new();
+    for status in reader.status_all()? {
+        let stored_hash = match status
+            .tag
+            .split(' ')
+            .find_map(|part| part.strip_prefix("rules:"))
+        {
+            Some(stored_hash) => stored_hash,
+            None => continue,
+        };
+        let path = PathBuf::from(&status.path);
+        let content = match std::fs::read(&path) {
+            Ok(content) => content,
+            Err(_) => continue,
+        };
+        let rule_paths = loader
+            .rule_paths_for_file(&path, std::str::from_utf8(&content).ok())?
+            .unwrap_or_default();
+        let rules_hash = match rules_hashes.get(&rule_paths) {
+            Some(rules_hash) => rules_hash.clone(),
+            None => {
+                let rules_hash = loader_args.rules_hash_for_paths(&rule_paths)?;
+                rules_hashes.insert(rule_paths, rules_hash.clone());
+                rules_hash
+            }
+        };
+        if stored_hash != rules_hash {
+            paths.push(path);
+        }
+    }
+    Ok(paths)
+}
@@ -5,17 +5,26 @@
 // Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
 // ------------------------------------------------------------------------------------------------
 
+use anyhow::anyhow;
 use anyhow::Context;
 use anyhow::Result;
 use clap::Args;
+use serde::Deserialize;
+use sha1::Digest as _;
+use sha1::Sha1;
+use std::collections::BTreeMap;
 use std::path::Path;
 use std::path::PathBuf;
 use tree_sitter::Language;
 use tree_sitter_config::Config as TsConfig;
 use tree_sitter_graph::ast::File as TsgFile;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::is_reserved_global;
+use tree_sitter_stack_graphs::loader::LanguageConfig;
 use tree_sitter_stack_graphs::loader::Loader;
+use tree_sitter_stack_graphs::ROOT_PATH_VAR;
 
-#[derive(Args)]
+#[derive(Args, Clone)]
 pub struct LoaderArgs {
     /// The TSG file to use for stack graph construction.
     #[clap(long, value_name = "TSG_PATH")]
@@ -30,6 +39,25 @@ pub struct LoaderArgs {
     /// See https://tree-sitter.github.io/tree-sitter/syntax-highlighting#basics for details.
     #[clap(long, value_name = "SCOPE")]
     scope: Option<String>,
+
+    /// A TOML file that lists the languages to use.  Every `[[language]]` table names a
+    /// `grammar` directory, and can set the `scope`, `file-types`, `interpreters`, and `tsg`
+    /// file to use for the language.  The `grammar` can also be a compiled grammar, i.e., a `.so`,
+    /// `.dylib`, or `.dll` shared library, in which case `name` can set the name of the language
+    /// in the library.  Relative paths are resolved against the directory of the configuration
+    /// file.  Global variables for the rules of all languages are set in a `[globals]` table, and
+    /// for the rules of one language in a `[language.globals]` table after its `[[language]]`
+    /// table, which takes precedence.
+    #[clap(long, value_name = "CONFIG_PATH", conflicts_with_all = &["grammar", "scope"])]
+    config: Option<PathBuf>,
+
+    /// A global variable for the stack graph construction rules, which lets one set of rules
+    /// support variants of a language, e.g., `--global STRICT_IMPORTS=true`.  Values are strings,
+    /// and rules must declare the variables they use.  Can be given multiple times.  If a name is
+    /// given more than once, the last value is used.  Takes precedence over the globals in the
+    /// configuration file.
+    #[clap(long = "global", value_name = "NAME=VALUE", parse(try_from_str = parse_global))]
+    globals: Vec<(String, String)>,
 }
 
 impl LoaderArgs {
@@ -43,7 +71,10 @@ impl LoaderArgs {
             }
         };
 
-        let loader = if !self.grammar.is_empty() {
+        let loader = if let Some(config_path) = &self.config {
+            let configs = Self::load_language_configs(config_path)?;
+            Loader::from_language_configs(configs, tsg)?
+        } else if !self.grammar.is_empty() {
             Loader::from_paths(self.grammar.clone(), self.scope.clone(), tsg)?
         } else {
             let loader_config = TsConfig::load()?.get()?;
@@ -52,6 +83,153 @@ impl LoaderArgs {
         Ok(loader)
     }
 
+    /// Returns the global variables given on the command line, which are passed to the stack
+    /// graph construction rules of every file.
+    pub fn globals(&self) -> Variables<'static> {
+        let mut globals = Variables::new();
+        for (name, value) in self.global_values() {
+            // Names are unique, so adding cannot fail.
+            let _ = globals.add(name.as_str().into(), value.as_str().into());
+        }
+        globals
+    }
+
+    /// Returns the values of the global variables, where later values override earlier ones.
+    fn global_values(&self) -> BTreeMap<&String, &String> {
+        self.globals
+            .iter()
+            .map(|(name, value)| (name, value))
+            .collect()
+    }
+
+    /// Returns a hash of the stack graph construction rules that are used by the loader, i.e.,
+    /// the TSG file, the configuration file, and the contents of the `queries` directories of the
+    /// grammars, which contain the TSG, builtins, and injections files.  The version of this
+    /// program is included, because it determines how the rules are executed.  Grammars that are
+    /// found through the tree-sitter configuration are included as well, and so are the global
+    /// variables, because the rules can depend on them.
+    pub fn rules_hash(&self) -> Result<String> {
+        let mut hasher = self.common_rules_hasher()?;
+        let grammar_paths = if let Some(config_path) = &self.config {
+            let configs = Self::load_language_configs(config_path)?;
+            for tsg_path in configs.iter().filter_map(|c| c.tsg.as_ref()) {
+                hash_file(&mut hasher, tsg_path)?;
+            }
+            configs.into_iter().map(|c| c.grammar).collect()
+        } else if !self.grammar.is_empty() {
+            self.grammar.clone()
+        } else {
+            let loader_config = TsConfig::load()?.get()?;
+            Loader::config_paths(&loader_config)?
+        };
+        for grammar_path in grammar_paths {
+            // Compiled grammars are files, which are hashed themselves.
+            if grammar_path.is_file() {
+                hash_file(&mut hasher, &grammar_path)?;
+                continue;
+            }
+            let queries_path = grammar_path.join("queries");
+            if let Ok(entries) = std::fs::read_dir(&queries_path) {
+                let mut paths = entries
+                    .filter_map(|e| e.ok())
+                    .map(|e| e.path())
+                    .filter(|p| p.is_file())
+                    .collect::<Vec<_>>();
+                paths.sort();
+                for path in paths {
+                    hash_file(&mut hasher, &path)?;
+                }
+            }
+        }
+        Ok(format!("{:x}", hasher.finalize()))
+    }
+
+    /// Returns a hash of the stack graph construction rules that are loaded from the given files,
+    /// as returned by `Loader::rule_paths_for_file`.  Like [`rules_hash`][], the hash includes the
+    /// version of this program, the global variables, the TSG file, and the configuration file,
+    /// but none of the rules of other languages.
+    ///
+    /// [`rules_hash`]: #method.rules_hash
+    pub fn rules_hash_for_paths(&self, rule_paths: &[PathBuf]) -> Result<String> {
+        let mut hasher = self.common_rules_hasher()?;
+        for path in rule_paths {
+            hash_file(&mut hasher, path)?;
+        }
+        Ok(format!("{:x}", hasher.finalize()))
+    }
+
+    /// Returns glob patterns that match the files that the stack graph construction rules are
+    /// loaded from, i.e., TSG, builtins, and injections files, and the TSG file, configuration
+    /// file, and compiled grammars that are given on the command line.  Editors watch these files
+    /// for the language server, so that it can reload the rules when they change.
+    pub fn rule_file_patterns(&self) -> Vec<String> {
+        let mut patterns = vec![
+            "**/*.tsg".to_string(),
+            "**/queries/builtins.*".to_string(),
+            "**/queries/injections.scm".to_string(),
+        ];
+        let paths = self
+            .tsg
+            .iter()
+            .chain(self.config.iter())
+            .chain(self.grammar.iter().filter(|p| p.is_file()));
+        for path in paths {
+            let path = std::fs::canonicalize(path).unwrap_or_else(|_| path.clone());
+            patterns.push(path.to_string_lossy().to_string());
+        }
+        patterns
+    }
+
+    /// Returns a hasher that has hashed the inputs that apply to the rules of every language.
+    fn common_rules_hasher(&self) -> Result<Sha1> {
+        let mut hasher = Sha1::new();
+        hasher.update(env!("CARGO_PKG_VERSION"));
+        for (name, value) in self.global_values() {
+            hasher.update(format!("{}={}\n", name, value));
+        }
+        if let Some(tsg_path) = &self.tsg {
+            hash_file(&mut hasher, tsg_path)?;
+        }
+        if let Some(config_path) = &self.config {
+            hash_file(&mut hasher, config_path)?;
+        }
+        Ok(hasher)
+    }
+
+    fn load_language_configs(config_path: &Path) -> Result<Vec<LanguageConfig>> {
+        let config_source = std::fs::read_to_string(config_path)
+            .with_context(|| format!("Failed to read {}", config_path.display()))?;
+        let config: ConfigFile = toml::from_str(&config_source)
+            .with_context(|| format!("Failed to parse {}", config_path.display()))?;
+        let base_dir = config_path.parent().unwrap_or(Path::new(""));
+        for name in config
+            .globals
+            .keys()
+            .chain(config.languages.iter().flat_map(|l| l.globals.keys()))
+        {
+            check_global_name(name)
+                .map_err(|err| anyhow!("Invalid global in {}: {}", config_path.display(), err))?;
+        }
+        let globals = config.globals;
+        Ok(config
+            .languages
+            .into_iter()
+            .map(|language| LanguageConfig {
+                grammar: base_dir.join(language.grammar),
+                name: language.name,
+                scope: language.scope,
+                file_types: language.file_types,
+                interpreters: language.interpreters,
+                tsg: language.tsg.map(|tsg| base_dir.join(tsg)),
+                globals: globals
+                    .clone()
+                    .into_iter()
+                    .chain(language.globals)
+                    .collect(),
+            })
+            .collect())
+    }
+
     fn load_tsg_from_path(language: Language, tsg_path: &Path) -> Result<TsgFile> {
         let tsg_source = std::fs::read(tsg_path)
             .with_context(|| format!("Failed to read {}", tsg_path.display()))?;
@@ -61,3 +239,59 @@ impl LoaderArgs {
         return Ok(tsg);
     }
 }
+
+/// Parses a `--global` argument of the form `NAME=VALUE`.
+fn parse_global(arg: &str) -> std::result::Result<(String, String), String> {
+    let (name, value) = arg
+        .split_once('=')
+        .ok_or_else(|| format!("expected NAME=VALUE, got {}", arg))?;
+    if name.is_empty() {
+        return Err(format!("missing variable name in {}", arg));
+    }
+    check_global_name(name)?;
+    Ok((name.to_string(), value.to_string()))
+}
+
+/// Checks that a global variable can be set by the user.  Reserved variables are set for every
+/// file, and `ROOT_PATH` is set from the project root when indexing.
+fn check_global_name(name: &str) -> std::result::Result<(), String> {
+    if is_reserved_global(name) || name == ROOT_PATH_VAR {
+        return Err(format!("{} is reserved, and cannot be set", name));
+    }
+    Ok(())
+}
+
+fn hash_file(hasher: &mut Sha1, path: &Path) -> Result<()> {
+    let content =
+        std::fs::read(path).with_context(|| format!("Failed to read {}", path.display()))?;
+    hasher.update(path.to_string_lossy().as_bytes());
+    hasher.update(&content);
+    Ok(())
+}
+
+/// The contents of a configuration file given with `--config`.
+#[derive(Deserialize)]
+#[serde(deny_unknown_fields)]
+struct ConfigFile {
+    /// Global variables for the rules of all languages.
+    #[serde(default)]
+    globals: BTreeMap<String, String>,
+    #[serde(default, rename = "language")]
+    languages: Vec<ConfigLanguage>,
+}
+
+#[derive(Deserialize)]
+#[serde(deny_unknown_fields, rename_all = "kebab-case")]
+struct ConfigLanguage {
+    grammar: PathBuf,
+    name: Option<String>,
+    scope: Option<String>,
+    file_types: Option<Vec<String>>,
+    #[serde(default)]
+    interpreters: Vec<String>,
+    tsg: Option<PathBuf>,
+    /// Global variables for the rules of this language, which take precedence over the global
+    /// variables for all languages.
+    #[serde(default)]
+    globals: BTreeMap<String, String>,
+}
@@ -0,0 +1,255 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use serde_json::json;
+use serde_json::Value;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
+use std::collections::HashMap;
+use std::io::BufWriter;
+use std::io::Write;
+use std::path::Path;
+
+use crate::export::ExportArgs;
+use crate::lsp::path_to_uri;
+
+/// The version of the LSIF specification that we produce.
+const LSIF_VERSION: &str = "0.5.0";
+
+/// Writes an LSIF dump with the definitions in the graph, and the given resolved references.
+///
+/// Every definition gets a result set, which its range, and the ranges of the references that
+/// resolve to it, point to.  The definition and reference results of a definition are attached to
+/// its result set.  References that resolve to several definitions get their own result set,
+/// whose definition result lists all of them.
+pub(crate) fn export(
+    graph: &StackGraph,
+    references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+    args: &ExportArgs,
+) -> anyhow::Result<()> {
+    let project_root = args.project_root()?;
+    let output = std::fs::File::create(&args.output)
+        .with_context(|| format!("Failed to create {}", args.output.display()))?;
+    let mut lsif = Lsif {
+        output: BufWriter::new(output),
+        next_id: 1,
+    };
+    lsif.vertex(
+        "metaData",
+        json!({
+            "version": LSIF_VERSION,
+            "projectRoot": path_to_uri(&project_root.to_string_lossy()),
+            "positionEncoding": "utf-16",
+            "toolInfo": {
+                "name": env!("CARGO_PKG_NAME"),
+                "version": env!("CARGO_PKG_VERSION"),
+            },
+        }),
+    )?;
+
+    // Collect the definitions and references that we export, by file.
+    let is_exported = |node: Handle<Node>| {
+        graph.source_info(node).is_some()
+            && graph[node]
+                .file()
+                .map(|file| Path::new(graph[file].name()).starts_with(&project_root))
+                .unwrap_or(false)
+    };
+    let mut nodes_by_file = BTreeMap::<Handle<File>, Vec<Handle<Node>>>::new();
+    let mut references_by_definition = HashMap::<Handle<Node>, Vec<Handle<Node>>>::new();
+    let mut result_sets = BTreeMap::new();
+    for node in graph.iter_nodes() {
+        if graph[node].is_definition() && is_exported(node) {
+            nodes_by_file
+                .entry(graph[node].file().unwrap())
+                .or_default()
+                .push(node);
+            result_sets.insert(node, lsif.vertex("resultSet", json!({}))?);
+        }
+    }
+    let mut definitions_by_reference = HashMap::new();
+    for (reference, definitions) in references {
+        let definitions = definitions
+            .iter()
+            .copied()
+            .filter(|definition| result_sets.contains_key(definition))
+            .collect::<Vec<_>>();
+        if definitions.is_empty() || graph[*reference].is_definition() || !is_exported(*reference) {
+            continue;
+        }
+        nodes_by_file
+            .entry(graph[*reference].file().unwrap())
+            .or_default()
+            .push(*reference);
+        for definition in &definitions {
+            references_by_definition
+                .entry(*definition)
+                .or_default()
+                .push(*reference);
+        }
+        definitions_by_reference.insert(*reference, definitions);
+    }
+
+    // Emit the documents, with their ranges.
+    let mut documents = HashMap::new();
+    let mut ranges = HashMap::new();
+    let mut reference_result_sets = Vec::new();
+    for (file, nodes) in &nodes_by_file {
+        let document = lsif.vertex(
+            "document",
+            json!({ "uri": path_to_uri(graph[*file].name()), "languageId": "" }),
+        )?;
+        documents.insert(*file, document);
+        lsif.event("begin", document)?;
+        let mut document_ranges = Vec::new();
+        for node in nodes {
+            let span = &graph.source_info(*node).unwrap().span;
+            let range = lsif.vertex(
+                "range",
+                json!({
+                    "start": { "line": span.start.line, "character": span.start.column.utf16_offset },
+                    "end": { "line": span.end.line, "character": span.end.column.utf16_offset },
+                }),
+            )?;
+            ranges.insert(*node, range);
+            document_ranges.push(range);
+            let result_set = match definitions_by_reference.get(node) {
+                Some(definitions) if definitions.len() > 1 => {
+                    let result_set = lsif.vertex("resultSet", json!({}))?;
+                    reference_result_sets.push((result_set, definitions));
+                    result_set
+                }
+                Some(definitions) => result_sets[&definitions[0]],
+                None => result_sets[node],
+            };
+            lsif.edge("next", range, result_set)?;
+        }
+        lsif.edge_many("contains", document, &document_ranges, None)?;
+        lsif.event("end", document)?;
+    }
+
+    // Emit the results of every definition, and of references with several definitions.
+    let items = |nodes: &[Handle<Node>]| {
+        let mut items = BTreeMap::<usize, Vec<usize>>::new();
+        for node in nodes {
+            items
+                .entry(documents[&graph[*node].file().unwrap()])
+                .or_default()
+                .push(ranges[node]);
+        }
+        items
+    };
+    for (definition, result_set) in &result_sets {
+        let definition_result = lsif.vertex("definitionResult", json!({}))?;
+        lsif.edge("textDocument/definition", *result_set, definition_result)?;
+        for (document, ranges) in items(&[*definition]) {
+            lsif.edge_many(
+                "item",
+                definition_result,
+                &ranges,
+                Some(json!({ "document": document })),
+            )?;
+        }
+        let reference_result = lsif.vertex("referenceResult", json!({}))?;
+        lsif.edge("textDocument/references", *result_set, reference_result)?;
+        for (document, ranges) in items(&[*definition]) {
+            lsif.edge_many(
+                "item",
+                reference_result,
+                &ranges,
+                Some(json!({ "document": document, "property": "definitions" })),
+            )?;
+        }
+        let references = references_by_definition
+            .get(definition)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        for (document, ranges) in items(references) {
+            lsif.edge_many(
+                "item",
+                reference_result,
+                &ranges,
+                Some(json!({ "document": document, "property": "references" })),
+            )?;
+        }
+    }
+    for (result_set, definitions) in reference_result_sets {
+        let definition_result = lsif.vertex("definitionResult", json!({}))?;
+        lsif.edge("textDocument/definition", result_set, definition_result)?;
+        for (document, ranges) in items(definitions.as_slice()) {
+            lsif.edge_many(
+                "item",
+                definition_result,
+                &ranges,
+                Some(json!({ "document": document })),
+            )?;
+        }
+    }
+
+    lsif.output
+        .flush()
+        .with_context(|| format!("Failed to write {}", args.output.display()))?;
+    println!(
+        "{} documents written to {}",
+        documents.len(),
+        args.output.display()
+    );
+    Ok(())
+}
+
+/// Writes LSIF vertices and edges as JSON lines, and assigns their IDs.
+struct Lsif<W: Write> {
+    output: W,
+    next_id: usize,
+}
+
+impl<W: Write> Lsif<W> {
+    fn emit(&mut self, kind: &str, label: &str, mut element: Value) -> anyhow::Result<usize> {
+        let id = self.next_id;
+        self.next_id += 1;
+        element["id"] = json!(id);
+        element["type"] = json!(kind);
+        element["label"] = json!(label);
+        serde_json::to_writer(&mut self.output, &element)?;
+        writeln!(self.output)?;
+        Ok(id)
+    }
+
+    fn vertex(&mut self, label: &str, vertex: Value) -> anyhow::Result<usize> {
+        self.emit("vertex", label, vertex)
+    }
+
+    fn event(&mut self, kind: &str, document: usize) -> anyhow::Result<usize> {
+        self.vertex(
+            "$event",
+            json!({ "kind": kind, "scope": "document", "data": document }),
+        )
+    }
+
+    fn edge(&mut self, label: &str, out_v: usize, in_v: usize) -> anyhow::Result<usize> {
+        self.emit("edge", label, json!({ "outV": out_v, "inV": in_v }))
+    }
+
+    /// Emits an edge to several vertices, with optional extra properties, such as the document
+    /// that the vertices belong to.
+    fn edge_many(
+        &mut self,
+        label: &str,
+        out_v: usize,
+        in_vs: &[usize],
+        properties: Option<Value>,
+    ) -> anyhow::Result<usize> {
+        let mut edge = properties.unwrap_or_else(|| json!({}));
+        edge["outV"] = json!(out_v);
+        edge["inVs"] = json!(in_vs);
+        self.emit("edge", label, edge)
+    }
+}
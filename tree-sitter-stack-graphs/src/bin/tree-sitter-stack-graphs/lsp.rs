@@ -0,0 +1,937 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use lsp_positions::Offset;
+use lsp_positions::Position;
+use lsp_positions::PositionedSubstring;
+use lsp_positions::Span;
+use lsp_positions::SpanCalculator;
+use serde_json::json;
+use serde_json::Value;
+use stack_graphs::arena::Handle;
+use stack_graphs::assert::AssertionSource;
+use stack_graphs::cancellation::NoCancellation;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::storage::SQLiteWriter;
+use std::collections::HashMap;
+use std::io::BufRead;
+use std::io::Read;
+use std::io::Write;
+use std::path::PathBuf;
+use tree_sitter::InputEdit;
+use tree_sitter::Point;
+use tree_sitter::Tree;
+use tree_sitter_stack_graphs::go;
+use tree_sitter_stack_graphs::loader::Loader;
+
+use crate::database::DatabaseArgs;
+use crate::index;
+use crate::index::files_with_changed_rules;
+use crate::index::language_info;
+use crate::index::IndexedGraph;
+use crate::loader::LoaderArgs;
+use crate::query::document_symbols;
+use crate::query::documentation;
+use crate::query::find_completions;
+use crate::query::find_definition_at;
+use crate::query::find_hover_definitions;
+use crate::query::find_incoming_calls;
+use crate::query::find_outgoing_calls;
+use crate::query::find_rename_edits;
+use crate::query::find_results;
+use crate::query::find_target_definitions;
+use crate::query::workspace_edit_json;
+use crate::query::DocumentSymbol;
+
+/// Run a language server on standard input and output
+///
+/// The server answers definition, references, call hierarchy, rename, hover, completion, and
+/// document and workspace symbol requests using the data in the database, which should be created with the index command first.  Open documents are
+/// indexed again whenever they change, so that queries reflect unsaved edits.  The server asks the
+/// editor to watch the files that the stack graph construction rules are loaded from, and checks
+/// whether the rules changed whenever one of them changes, or a document is saved.  If they did,
+/// it reloads them, and indexes all open documents again, as well as the files in the database
+/// that were indexed with the old rules, so that rules can be developed without restarting the
+/// server.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    loader: LoaderArgs,
+
+    #[clap(flatten)]
+    database: DatabaseArgs,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let mut server = Server {
+            database: &self.database,
+            db: self.database.open_writer()?,
+            loader_args: &self.loader,
+            loader: self.loader.new_loader()?,
+            rules_hash: self.loader.rules_hash()?,
+            watch_rules: false,
+            documents: HashMap::new(),
+            shutdown: false,
+        };
+        let stdin = std::io::stdin();
+        let mut input = stdin.lock();
+        let stdout = std::io::stdout();
+        let mut output = stdout.lock();
+        while let Some(message) = read_message(&mut input)? {
+            let method = match message.get("method").and_then(Value::as_str) {
+                Some(method) => method,
+                // Responses to the requests of the server, which only registers the rule file
+                // watchers, and does not need the result.
+                None => continue,
+            };
+            let params = message.get("params").cloned().unwrap_or(Value::Null);
+            if method == "exit" {
+                if !server.shutdown {
+                    return Err(anyhow!(
+                        "Exit notification received before shutdown request"
+                    ));
+                }
+                return Ok(());
+            }
+            match message.get("id") {
+                Some(id) => {
+                    let response = match server.handle_request(method, params) {
+                        Ok(result) => json!({ "jsonrpc": "2.0", "id": id, "result": result }),
+                        Err(err) => json!({
+                            "jsonrpc": "2.0",
+                            "id": id,
+                            "error": { "code": err.code, "message": err.message },
+                        }),
+                    };
+                    write_message(&mut output, &response)?;
+                }
+                None => {
+                    server.handle_notification(method, params);
+                    // Capabilities can only be registered once the client is initialized.
+                    if method == "initialized" && server.watch_rules {
+                        write_message(&mut output, &server.register_rule_watchers())?;
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A document that is open in the editor.  The editor owns its content, which can differ from the
+/// file on disk.
+struct Document {
+    path: PathBuf,
+    text: String,
+    /// The syntax tree of the last version of the text that was indexed, updated with all edits
+    /// since, so that the next version can be parsed incrementally.
+    tree: Option<Tree>,
+}
+
+/// The tag of files that were indexed from an open document.  Their content may differ from the
+/// file on disk, so they are never considered unchanged by the index command.
+const DOCUMENT_TAG: &str = "lsp";
+
+struct Server<'a> {
+    database: &'a DatabaseArgs,
+    db: SQLiteWriter,
+    loader_args: &'a LoaderArgs,
+    loader: Loader,
+    /// The hash of the stack graph construction rules that the loader was created with.
+    rules_hash: String,
+    /// Whether the client can watch the rule files for the server, which it reports when the
+    /// server is initialized.
+    watch_rules: bool,
+    documents: HashMap<String, Document>,
+    shutdown: bool,
+}
+
+impl<'a> Server<'a> {
+    fn handle_request(&mut self, method: &str, params: Value) -> Result<Value, ResponseError> {
+        if self.shutdown {
+            return Err(ResponseError::new(
+                ResponseError::INVALID_REQUEST,
+                "Server is shutting down",
+            ));
+        }
+        match method {
+            "initialize" => {
+                self.watch_rules = params
+                    .pointer("/capabilities/workspace/didChangeWatchedFiles/dynamicRegistration")
+                    .and_then(Value::as_bool)
+                    .unwrap_or(false);
+                Ok(json!({
+                    "capabilities": {
+                        "textDocumentSync": {
+                            "openClose": true,
+                            "change": TEXT_DOCUMENT_SYNC_INCREMENTAL,
+                            "save": true,
+                        },
+                        "definitionProvider": true,
+                        "referencesProvider": true,
+                        "callHierarchyProvider": true,
+                        "renameProvider": true,
+                        "hoverProvider": true,
+                        "completionProvider": {},
+                        "documentSymbolProvider": true,
+                        "workspaceSymbolProvider": true,
+                    },
+                    "serverInfo": {
+                        "name": env!("CARGO_PKG_NAME"),
+                        "version": env!("CARGO_PKG_VERSION"),
+                    },
+                }))
+            }
+            "shutdown" => {
+                self.shutdown = true;
+                Ok(Value::Null)
+            }
+            "textDocument/definition" => self.definition(&params),
+            "textDocument/references" => self.references(&params),
+            "textDocument/prepareCallHierarchy" => self.prepare_call_hierarchy(&params),
+            "callHierarchy/incomingCalls" => self.call_hierarchy_calls(&params, true),
+            "callHierarchy/outgoingCalls" => self.call_hierarchy_calls(&params, false),
+            "textDocument/rename" => self.rename(&params),
+            "textDocument/hover" => self.hover(&params),
+            "textDocument/completion" => self.completion(&params),
+            "textDocument/documentSymbol" => self.document_symbols(&params),
+            "workspace/symbol" => self.workspace_symbols(&params),
+            _ => Err(ResponseError::new(
+                ResponseError::METHOD_NOT_FOUND,
+                format!("Unsupported method {}", method),
+            )),
+        }
+    }
+
+    /// Handles a notification.  Notifications have no response, so failures are only logged.
+    fn handle_notification(&mut self, method: &str, params: Value) {
+        let result = match method {
+            "textDocument/didOpen" => self.did_open(&params),
+            "textDocument/didChange" => self.did_change(&params),
+            "textDocument/didClose" => self.did_close(&params),
+            "textDocument/didSave" | "workspace/didChangeWatchedFiles" => self.reload_rules(),
+            _ => Ok(()),
+        };
+        if let Err(err) = result {
+            log::warn!("{}: {:#}", method, err);
+        }
+    }
+
+    fn did_open(&mut self, params: &Value) -> anyhow::Result<()> {
+        let uri = string_param(params, "/textDocument/uri")?;
+        let text = string_param(params, "/textDocument/text")?;
+        let document = Document {
+            path: uri_to_path(uri)?,
+            text: text.to_string(),
+            tree: None,
+        };
+        self.documents.insert(uri.to_string(), document);
+        self.index_document(uri)
+    }
+
+    fn did_change(&mut self, params: &Value) -> anyhow::Result<()> {
+        let uri = string_param(params, "/textDocument/uri")?;
+        let document = self
+            .documents
+            .get_mut(uri)
+            .ok_or_else(|| anyhow!("Document {} is not open", uri))?;
+        let changes = params
+            .get("contentChanges")
+            .and_then(Value::as_array)
+            .ok_or_else(|| anyhow!("Missing parameter contentChanges"))?;
+        for change in changes {
+            document.apply_change(change)?;
+        }
+        self.index_document(uri)
+    }
+
+    /// Closing a document discards its unsaved changes, so we index the file on disk again, or
+    /// remove it from the database if it does not exist.
+    fn did_close(&mut self, params: &Value) -> anyhow::Result<()> {
+        let uri = string_param(params, "/textDocument/uri")?;
+        let document = match self.documents.remove(uri) {
+            Some(document) => document,
+            None => return Ok(()),
+        };
+        match std::fs::read_to_string(&document.path) {
+            Ok(text) => {
+                let document = Document {
+                    text,
+                    tree: None,
+                    ..document
+                };
+                self.documents.insert(uri.to_string(), document);
+                let result = self.index_document(uri);
+                self.documents.remove(uri);
+                result
+            }
+            Err(_) => {
+                self.db.clean_file(&document.path.to_string_lossy())?;
+                Ok(())
+            }
+        }
+    }
+
+    /// Returns the request that registers watchers for the rule files with the client, so that
+    /// it sends a `workspace/didChangeWatchedFiles` notification when any of them changes.
+    /// Clients that cannot watch files for the server only trigger the check when a document is
+    /// saved.
+    fn register_rule_watchers(&self) -> Value {
+        let watchers = self
+            .loader_args
+            .rule_file_patterns()
+            .into_iter()
+            .map(|pattern| json!({ "globPattern": pattern }))
+            .collect::<Vec<_>>();
+        json!({
+            "jsonrpc": "2.0",
+            "id": RULE_WATCHERS_ID,
+            "method": "client/registerCapability",
+            "params": {
+                "registrations": [{
+                    "id": RULE_WATCHERS_ID,
+                    "method": "workspace/didChangeWatchedFiles",
+                    "registerOptions": { "watchers": watchers },
+                }],
+            },
+        })
+    }
+
+    /// Reloads the stack graph construction rules if they changed, and indexes all open documents
+    /// again with the new rules, as well as the files in the database that were indexed with
+    /// other rules.  Files that were indexed with rules of other languages that did not change
+    /// are kept.
+    fn reload_rules(&mut self) -> anyhow::Result<()> {
+        let rules_hash = self.loader_args.rules_hash()?;
+        if rules_hash == self.rules_hash {
+            return Ok(());
+        }
+        log::info!("Stack graph construction rules changed, reloading");
+        self.loader = self.loader_args.new_loader()?;
+        self.rules_hash = rules_hash;
+        let uris = self.documents.keys().cloned().collect::<Vec<_>>();
+        for uri in uris {
+            // The grammar may have changed as well, so documents are parsed from scratch.
+            self.documents.get_mut(&uri).unwrap().tree = None;
+            if let Err(err) = self.index_document(&uri) {
+                log::warn!("Failed to index {}: {:#}", uri, err);
+            }
+        }
+        // Open documents are tagged without a rules hash, so they are not indexed from disk.
+        let paths = files_with_changed_rules(self.loader_args, self.database)?;
+        if !paths.is_empty() {
+            index::Command::new(
+                self.loader_args.clone(),
+                self.database.clone(),
+                paths,
+                false,
+            )
+            .with_report_to_stderr()
+            .index()?;
+        }
+        Ok(())
+    }
+
+    /// Builds the stack graph and partial paths of an open document, and stores them in the
+    /// database.  The document is parsed incrementally, if it was parsed before.  If the document
+    /// cannot be indexed, for example because it contains syntax errors, the data of the last
+    /// version that could be indexed is kept.
+    fn index_document(&mut self, uri: &str) -> anyhow::Result<()> {
+        let document = self.documents.get_mut(uri).unwrap();
+        let sgl = match self
+            .loader
+            .load_for_file(&document.path, Some(&document.text))?
+        {
+            Some(sgl) => sgl,
+            None => return Ok(()),
+        };
+        let tree = sgl
+            .parse(&document.text, document.tree.as_ref())
+            .map_err(|err| anyhow!("{}", err))?;
+        let mut graph = StackGraph::new();
+        let file = graph.get_or_create_file(&document.path.to_string_lossy());
+        let mut globals = self.loader_args.globals();
+        // The import path is not cached, so that edits of go.mod files are seen when a document is
+        // indexed again.
+        go::add_import_path_global(&mut globals, &document.path);
+        sgl.build_stack_graph_from_tree_into_with_cancellation(
+            &mut graph,
+            file,
+            &tree,
+            &document.text,
+            &mut globals,
+            &NoCancellation,
+        )
+        .map_err(|err| anyhow!("{}", err))?;
+        document.tree = Some(tree);
+        let mut indexed = IndexedGraph::new(graph, language_info(sgl));
+        indexed.add_file(file, DOCUMENT_TAG.to_string(), &NoCancellation)?;
+        indexed.store(&mut self.db)
+    }
+
+    fn definition(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let mut reader = self.database.open_reader()?;
+        let results = find_results(&mut reader, &path, position, false)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+        let nodes = results.iter().filter(|r| !r.shadowed).map(|r| r.definition);
+        Ok(Value::Array(node_locations(graph, nodes)))
+    }
+
+    fn references(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let include_declaration = params
+            .pointer("/context/includeDeclaration")
+            .and_then(Value::as_bool)
+            .unwrap_or(false);
+        let mut reader = self.database.open_reader()?;
+        let results = find_results(&mut reader, &path, position.clone(), true)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+        let mut nodes = results
+            .iter()
+            .filter(|r| !r.shadowed)
+            .map(|r| r.reference)
+            .collect::<Vec<_>>();
+        if include_declaration {
+            if let Some(file) = graph.get_file(&path) {
+                let source = AssertionSource { file, position };
+                nodes.extend(source.definitions_iter(graph));
+            }
+        }
+        Ok(Value::Array(node_locations(graph, nodes)))
+    }
+
+    /// Returns the definitions of the reference at the position, or the definition at the position
+    /// itself, as call hierarchy items.
+    fn prepare_call_hierarchy(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let mut reader = self.database.open_reader()?;
+        let definitions =
+            find_target_definitions(&mut reader, &path, position)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+        let items = definitions
+            .into_iter()
+            .filter_map(|definition| call_hierarchy_item_json(graph, definition))
+            .collect();
+        Ok(Value::Array(items))
+    }
+
+    /// Returns the incoming calls of a call hierarchy item, if `incoming` is set, and its outgoing
+    /// calls otherwise.  The item is found again by the start of its selection range.  Calls
+    /// outside of any definition have no caller that could be shown, and are left out.
+    fn call_hierarchy_calls(
+        &mut self,
+        params: &Value,
+        incoming: bool,
+    ) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(&json!({
+            "textDocument": { "uri": string_param(params, "/item/uri")? },
+            "position": params.pointer("/item/selectionRange/start").cloned().unwrap_or(Value::Null),
+        }))?;
+        let mut reader = self.database.open_reader()?;
+        let definition = match find_definition_at(&mut reader, &path, position)? {
+            Some(definition) => definition,
+            None => return Ok(Value::Array(Vec::new())),
+        };
+        let calls = if incoming {
+            find_incoming_calls(&mut reader, definition)?
+        } else {
+            find_outgoing_calls(&mut reader, definition)?
+        };
+        let (graph, _, _) = reader.get();
+        let calls = calls
+            .into_iter()
+            .filter_map(|call| {
+                let from_ranges = call
+                    .call_sites
+                    .iter()
+                    .filter_map(|n| Some(range_json(&graph.source_info(*n)?.span)))
+                    .collect::<Vec<_>>();
+                if incoming {
+                    Some(json!({
+                        "from": call_hierarchy_item_json(graph, call.caller?)?,
+                        "fromRanges": from_ranges,
+                    }))
+                } else {
+                    Some(json!({
+                        "to": call_hierarchy_item_json(graph, call.callee)?,
+                        "fromRanges": from_ranges,
+                    }))
+                }
+            })
+            .collect();
+        Ok(Value::Array(calls))
+    }
+
+    /// Returns the edits that rename the symbol at the position as a workspace edit.  Renaming
+    /// fails with an error if it could break the code.  See [`find_rename_edits`][].
+    fn rename(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let new_name = string_param(params, "/newName")?;
+        let mut reader = self.database.open_reader()?;
+        match find_rename_edits(&mut reader, &path, position, new_name)? {
+            Some(edits) => Ok(workspace_edit_json(&edits)),
+            None => Ok(Value::Null),
+        }
+    }
+
+    /// Returns the line that declares the highest ranked definition at the position, followed by
+    /// its documentation, as Markdown.
+    fn hover(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let mut reader = self.database.open_reader()?;
+        let definitions = find_hover_definitions(&mut reader, &path, position)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+        let definition = match definitions.first() {
+            Some(definition) => *definition,
+            None => return Ok(Value::Null),
+        };
+        let mut contents = Vec::new();
+        if let Some(line) = graph
+            .source_info(definition)
+            .and_then(|si| si.containing_line.into_option())
+        {
+            contents.push(format!("```\n{}\n```", graph[line].trim()));
+        }
+        if let Some(documentation) = documentation(graph, definition) {
+            contents.push(documentation.to_string());
+        }
+        if contents.is_empty() {
+            return Ok(Value::Null);
+        }
+        Ok(json!({
+            "contents": {
+                "kind": "markdown",
+                "value": contents.join("\n\n"),
+            },
+        }))
+    }
+
+    /// Returns the names that are visible at the position as completion items.  Clients filter
+    /// the items by the prefix that was typed, so all of them are returned.
+    fn completion(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let (path, position) = self.position_param(params)?;
+        let mut reader = self.database.open_reader()?;
+        let definitions = find_completions(&mut reader, &path, position)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+        let items = definitions
+            .into_iter()
+            .filter_map(|definition| {
+                let symbol = graph[definition].symbol()?;
+                let mut item = json!({ "label": &graph[symbol] });
+                if let Some(line) = graph
+                    .source_info(definition)
+                    .and_then(|si| si.containing_line.into_option())
+                {
+                    item["detail"] = graph[line].trim().into();
+                }
+                if let Some(documentation) = documentation(graph, definition) {
+                    item["documentation"] = json!({
+                        "kind": "markdown",
+                        "value": documentation,
+                    });
+                }
+                Some(item)
+            })
+            .collect();
+        Ok(Value::Array(items))
+    }
+
+    fn document_symbols(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let uri = string_param(params, "/textDocument/uri")?;
+        let path = uri_to_path(uri)?;
+        let mut reader = self.database.open_reader()?;
+        let file = reader.load_graph_for_file(&path.to_string_lossy())?;
+        let (graph, _, _) = reader.get();
+        let symbols = document_symbols(graph, file)
+            .iter()
+            .map(|symbol| document_symbol_json(graph, symbol))
+            .collect();
+        Ok(Value::Array(symbols))
+    }
+
+    /// Returns the definitions in all files whose names match the query, best matches first.
+    fn workspace_symbols(&mut self, params: &Value) -> Result<Value, ResponseError> {
+        let query = string_param(params, "/query")?;
+        let reader = self.database.open_reader()?;
+        let symbols = reader
+            .find_definitions(query, WORKSPACE_SYMBOL_LIMIT)?
+            .into_iter()
+            .map(|definition| {
+                let kind = definition
+                    .syntax_type
+                    .as_deref()
+                    .map(symbol_kind)
+                    .unwrap_or(SYMBOL_KIND_VARIABLE);
+                json!({
+                    "name": definition.symbol,
+                    "kind": kind,
+                    "location": {
+                        "uri": path_to_uri(&definition.path),
+                        "range": range_json(&definition.span),
+                    },
+                })
+            })
+            .collect();
+        Ok(Value::Array(symbols))
+    }
+
+    /// Returns the file name and source position of a text document position parameter.  The
+    /// position is computed using the content of the document, if it is open, and the content of
+    /// the file on disk otherwise.
+    fn position_param(&self, params: &Value) -> Result<(String, Position), ResponseError> {
+        let uri = string_param(params, "/textDocument/uri")?;
+        let line = usize_param(params, "/position/line")?;
+        let character = usize_param(params, "/position/character")?;
+        let (path, text) = match self.documents.get(uri) {
+            Some(document) => (document.path.clone(), document.text.clone()),
+            None => {
+                let path = uri_to_path(uri)?;
+                let text = std::fs::read_to_string(&path)
+                    .map_err(|err| anyhow!("Failed to read {}: {}", path.display(), err))?;
+                (path, text)
+            }
+        };
+        let position = to_position(&text, line, character).ok_or_else(|| {
+            ResponseError::new(
+                ResponseError::INVALID_PARAMS,
+                format!("Position {}:{} does not exist", line, character),
+            )
+        })?;
+        Ok((path.to_string_lossy().to_string(), position))
+    }
+}
+
+impl Document {
+    /// Applies a change to the content of the document.  Changes with a range replace that range,
+    /// and are also applied to the syntax tree.  Changes without a range replace the whole
+    /// content.
+    fn apply_change(&mut self, change: &Value) -> anyhow::Result<()> {
+        let text = string_param(change, "/text")?;
+        let range = match change.get("range") {
+            Some(range) => range,
+            None => {
+                self.text = text.to_string();
+                self.tree = None;
+                return Ok(());
+            }
+        };
+        let (start_byte, start_position) = self.byte_offset(range, "/start")?;
+        let (old_end_byte, old_end_position) = self.byte_offset(range, "/end")?;
+        let new_end_position = match text.rfind('\n') {
+            Some(last_newline) => Point {
+                row: start_position.row + text.matches('\n').count(),
+                column: text.len() - last_newline - 1,
+            },
+            None => Point {
+                row: start_position.row,
+                column: start_position.column + text.len(),
+            },
+        };
+        if let Some(tree) = &mut self.tree {
+            tree.edit(&InputEdit {
+                start_byte,
+                old_end_byte,
+                new_end_byte: start_byte + text.len(),
+                start_position,
+                old_end_position,
+                new_end_position,
+            });
+        }
+        self.text.replace_range(start_byte..old_end_byte, text);
+        Ok(())
+    }
+
+    /// Returns the byte offset and the tree-sitter point of an LSP position in the document.
+    fn byte_offset(&self, range: &Value, pointer: &str) -> anyhow::Result<(usize, Point)> {
+        let line = usize_param(range, &format!("{}/line", pointer))?;
+        let character = usize_param(range, &format!("{}/character", pointer))?;
+        let line_content = match PositionedSubstring::lines_iter(&self.text).nth(line) {
+            Some(line_content) => line_content,
+            // The position after a final newline is on a line that has no content.
+            None if line == self.text.matches('\n').count() && character == 0 => {
+                return Ok((
+                    self.text.len(),
+                    Point {
+                        row: line,
+                        column: 0,
+                    },
+                ))
+            }
+            None => return Err(anyhow!("Line {} does not exist", line)),
+        };
+        let column = utf8_column(line_content.content, character)
+            .ok_or_else(|| anyhow!("Position {}:{} does not exist", line, character))?;
+        Ok((
+            line_content.utf8_bounds.start + column,
+            Point { row: line, column },
+        ))
+    }
+}
+
+/// Returns the UTF-8 offset in a line of a character offset, which counts UTF-16 code units.
+fn utf8_column(line: &str, character: usize) -> Option<usize> {
+    Offset::all_chars(line)
+        .find(|offset| offset.utf16_offset >= character)
+        .map(|offset| offset.utf8_offset)
+}
+
+/// Converts an LSP position to a source position.
+fn to_position(source: &str, line: usize, character: usize) -> Option<Position> {
+    let line_content = PositionedSubstring::lines_iter(source).nth(line)?;
+    let column = utf8_column(line_content.content, character)?;
+    let mut span_calculator = SpanCalculator::new(source);
+    Some(span_calculator.for_line_and_column(line, line_content.utf8_bounds.start, column))
+}
+
+pub(crate) fn range_json(span: &Span) -> Value {
+    json!({
+        "start": {
+            "line": span.start.line,
+            "character": span.start.column.utf16_offset,
+        },
+        "end": {
+            "line": span.end.line,
+            "character": span.end.column.utf16_offset,
+        },
+    })
+}
+
+/// Returns the unique LSP locations of the given nodes.  Nodes without source information are
+/// skipped.
+fn node_locations<I>(graph: &StackGraph, nodes: I) -> Vec<Value>
+where
+    I: IntoIterator<Item = Handle<Node>>,
+{
+    let mut locations = Vec::new();
+    for node in nodes {
+        let file = match graph[node].file() {
+            Some(file) => file,
+            None => continue,
+        };
+        let source_info = match graph.source_info(node) {
+            Some(source_info) => source_info,
+            None => continue,
+        };
+        let location = json!({
+            "uri": path_to_uri(graph[file].name()),
+            "range": range_json(&source_info.span),
+        });
+        if !locations.contains(&location) {
+            locations.push(location);
+        }
+    }
+    locations
+}
+
+/// Returns a definition in the outline of a document as a hierarchical LSP document symbol.  We
+/// only know the span of the source node of a definition, so it is used for both the range and the
+/// selection range.
+fn document_symbol_json(graph: &StackGraph, symbol: &DocumentSymbol) -> Value {
+    let node = symbol.definition;
+    let span = &graph.source_info(node).unwrap().span;
+    let kind = graph
+        .source_info(node)
+        .and_then(|si| si.syntax_type)
+        .map(|syntax_type| symbol_kind(&graph[syntax_type]))
+        .unwrap_or(SYMBOL_KIND_VARIABLE);
+    json!({
+        "name": &graph[graph[node].symbol().unwrap()],
+        "kind": kind,
+        "range": range_json(span),
+        "selectionRange": range_json(span),
+        "children": symbol
+            .children
+            .iter()
+            .map(|child| document_symbol_json(graph, child))
+            .collect::<Vec<_>>(),
+    })
+}
+
+/// Returns a definition as an LSP call hierarchy item, or `None` if it has no location.  Like for
+/// document symbols, the span of the source node of the definition is used for both the range and
+/// the selection range.
+fn call_hierarchy_item_json(graph: &StackGraph, definition: Handle<Node>) -> Option<Value> {
+    let file = graph[definition].file()?;
+    let source_info = graph.source_info(definition)?;
+    let kind = source_info
+        .syntax_type
+        .map(|syntax_type| symbol_kind(&graph[syntax_type]))
+        .unwrap_or(SYMBOL_KIND_VARIABLE);
+    Some(json!({
+        "name": &graph[graph[definition].symbol()?],
+        "kind": kind,
+        "uri": path_to_uri(graph[file].name()),
+        "range": range_json(&source_info.span),
+        "selectionRange": range_json(&source_info.span),
+    }))
+}
+
+const TEXT_DOCUMENT_SYNC_INCREMENTAL: usize = 2;
+
+/// The ID of the request that registers the rule file watchers, and of the registration.
+const RULE_WATCHERS_ID: &str = "stack-graphs-rules";
+
+const SYMBOL_KIND_VARIABLE: usize = 13;
+
+/// The maximum number of symbols returned for a workspace symbol request.
+const WORKSPACE_SYMBOL_LIMIT: usize = 100;
+
+/// Returns the LSP symbol kind for the syntax type of a definition.
+fn symbol_kind(syntax_type: &str) -> usize {
+    match syntax_type {
+        "module" => 2,
+        "namespace" => 3,
+        "package" => 4,
+        "class" => 5,
+        "method" => 6,
+        "property" => 7,
+        "field" => 8,
+        "constructor" => 9,
+        "enum" => 10,
+        "interface" => 11,
+        "function" => 12,
+        "constant" => 14,
+        "enum_member" => 22,
+        "struct" => 23,
+        "type_parameter" => 26,
+        _ => SYMBOL_KIND_VARIABLE,
+    }
+}
+
+//-------------------------------------------------------------------------------------------------
+// Messages
+
+/// An error that is sent in response to a request.
+struct ResponseError {
+    code: i64,
+    message: String,
+}
+
+impl ResponseError {
+    const INVALID_REQUEST: i64 = -32600;
+    const METHOD_NOT_FOUND: i64 = -32601;
+    const INVALID_PARAMS: i64 = -32602;
+    const INTERNAL_ERROR: i64 = -32603;
+
+    fn new<S: Into<String>>(code: i64, message: S) -> ResponseError {
+        ResponseError {
+            code,
+            message: message.into(),
+        }
+    }
+}
+
+impl From<anyhow::Error> for ResponseError {
+    fn from(err: anyhow::Error) -> ResponseError {
+        ResponseError::new(ResponseError::INTERNAL_ERROR, format!("{:#}", err))
+    }
+}
+
+impl From<stack_graphs::storage::StorageError> for ResponseError {
+    fn from(err: stack_graphs::storage::StorageError) -> ResponseError {
+        ResponseError::new(ResponseError::INTERNAL_ERROR, err.to_string())
+    }
+}
+
+fn string_param<'a>(params: &'a Value, pointer: &str) -> anyhow::Result<&'a str> {
+    params
+        .pointer(pointer)
+        .and_then(Value::as_str)
+        .ok_or_else(|| anyhow!("Missing parameter {}", pointer))
+}
+
+fn usize_param(params: &Value, pointer: &str) -> anyhow::Result<usize> {
+    params
+        .pointer(pointer)
+        .and_then(Value::as_u64)
+        .map(|value| value as usize)
+        .ok_or_else(|| anyhow!("Missing parameter {}", pointer))
+}
+
+/// Returns the path of a `file:` URI.  Files are stored in the database under their canonical
+/// path, so the path is canonicalized if the file exists.
+fn uri_to_path(uri: &str) -> anyhow::Result<PathBuf> {
+    let encoded = uri
+        .strip_prefix("file://")
+        .ok_or_else(|| anyhow!("Unsupported URI {}", uri))?;
+    let path = PathBuf::from(percent_decode(encoded)?);
+    Ok(std::fs::canonicalize(&path).unwrap_or(path))
+}
+
+/// Decodes the `%XX` escapes in a URI component.  Invalid escapes are kept as they are.
+pub(crate) fn percent_decode(encoded: &str) -> anyhow::Result<String> {
+    let mut bytes = Vec::with_capacity(encoded.len());
+    let mut rest = encoded.as_bytes();
+    while let Some((&byte, tail)) = rest.split_first() {
+        if byte == b'%' && tail.len() >= 2 {
+            if let Ok(decoded) = u8::from_str_radix(&String::from_utf8_lossy(&tail[..2]), 16) {
+                bytes.push(decoded);
+                rest = &tail[2..];
+                continue;
+            }
+        }
+        bytes.push(byte);
+        rest = tail;
+    }
+    Ok(String::from_utf8(bytes)?)
+}
+
+/// Returns the `file:` URI of a path.
+pub(crate) fn path_to_uri(path: &str) -> String {
+    let mut uri = String::from("file://");
+    for byte in path.bytes() {
+        match byte {
+            b'A'..=b'Z' | b'a'..=b'z' | b'0'..=b'9' | b'-' | b'.' | b'_' | b'~' | b'/' => {
+                uri.push(byte as char)
+            }
+            _ => uri.push_str(&format!("%{:02X}", byte)),
+        }
+    }
+    uri
+}
+
+/// Reads a message from the client.  Messages consist of headers, of which we only need the
+/// content length, followed by a JSON body.  Returns `None` at the end of the input.
+fn read_message(input: &mut impl BufRead) -> anyhow::Result<Option<Value>> {
+    let mut content_length = None;
+    loop {
+        let mut line = String::new();
+        if input.read_line(&mut line)? == 0 {
+            return Ok(None);
+        }
+        let line = line.trim_end();
+        if line.is_empty() {
+            break;
+        }
+        if let Some((name, value)) = line.split_once(':') {
+            if name.eq_ignore_ascii_case("Content-Length") {
+                content_length = Some(value.trim().parse::<usize>()?);
+            }
+        }
+    }
+    let content_length =
+        content_length.ok_or_else(|| anyhow!("Message without Content-Length header"))?;
+    let mut content = vec![0; content_length];
+    input.read_exact(&mut content)?;
+    Ok(Some(serde_json::from_slice(&content)?))
+}
+
+fn write_message(output: &mut impl Write, message: &Value) -> anyhow::Result<()> {
+    let content = serde_json::to_string(message)?;
+    write!(
+        output,
+        "Content-Length: {}\r\n\r\n{}",
+        content.len(),
+        content
+    )?;
+    output.flush()?;
+    Ok(())
+}
@@ -5,9 +5,11 @@
 // Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
 // ------------------------------------------------------------------------------------------------
 
-use anyhow::Result;
+use anyhow::anyhow;
 use clap::Parser;
 use clap::Subcommand;
+use std::ffi::OsStr;
+use std::path::PathBuf;
 
 pub(crate) const MAX_PARSE_ERRORS: usize = 5;
 
@@ -16,19 +18,110 @@ pub(crate) const MAX_PARSE_ERRORS: usize = 5;
 struct Cli {
     #[clap(subcommand)]
     command: Commands,
+
+    /// Export tracing spans for parsing, executing the stack graph construction rules, finding
+    /// partial paths, and writing to the database, to the OpenTelemetry collector at the given
+    /// URL, e.g., http://localhost:4317.  The spans record the number of nodes, edges, and
+    /// partial paths of every file.
+    #[clap(long, global = true, value_name = "URL")]
+    otlp_endpoint: Option<String>,
 }
 
+mod clean;
+mod cypher;
+mod database;
+mod dependencies;
+mod export;
+mod graphml;
+mod grpc;
+mod http;
+mod import;
+mod index;
 mod loader;
+mod lsif;
+mod lsp;
+mod mermaid;
+mod query;
+mod report;
+mod scip;
+mod serve;
+mod stats;
+mod status;
+mod subgraph;
+mod tags;
+mod telemetry;
 mod test;
 
 #[derive(Subcommand)]
 enum Commands {
+    Clean(clean::Command),
+    Export(export::Command),
+    Import(import::Command),
+    Index(index::Command),
+    Lsp(lsp::Command),
+    Query(query::Command),
+    Report(report::Command),
+    Serve(serve::Command),
+    Stats(stats::Command),
+    Status(status::Command),
     Test(test::Command),
 }
 
-fn main() -> Result<()> {
+/// Output format of commands that can produce machine-readable output.
+#[derive(clap::ArgEnum, Clone, Copy, PartialEq)]
+pub(crate) enum OutputFormat {
+    Text,
+    Json,
+}
+
+/// Exit code used when test assertions fail.
+const EXIT_TEST_FAILURES: i32 = 1;
+/// Exit code used for all other errors, matching the exit code that clap uses for usage errors.
+const EXIT_ERROR: i32 = 2;
+
+fn main() {
+    // Diagnostics from the library are reported using the log crate.  Warnings are shown by
+    // default, and the level can be changed with the RUST_LOG environment variable.
+    env_logger::Builder::from_env(env_logger::Env::default().default_filter_or("warn")).init();
     let cli = Cli::parse();
-    match &cli.command {
+    let telemetry = match &cli.otlp_endpoint {
+        Some(endpoint) => match telemetry::Telemetry::start(endpoint) {
+            Ok(telemetry) => Some(telemetry),
+            Err(err) => {
+                eprintln!("Error: {:?}", err);
+                std::process::exit(EXIT_ERROR);
+            }
+        },
+        None => None,
+    };
+    let result = match &cli.command {
+        Commands::Clean(cmd) => cmd.run(),
+        Commands::Export(cmd) => cmd.run(),
+        Commands::Import(cmd) => cmd.run(),
+        Commands::Index(cmd) => cmd.run(),
+        Commands::Lsp(cmd) => cmd.run(),
+        Commands::Query(cmd) => cmd.run(),
+        Commands::Report(cmd) => cmd.run(),
+        Commands::Serve(cmd) => cmd.run(),
+        Commands::Stats(cmd) => cmd.run(),
+        Commands::Status(cmd) => cmd.run(),
         Commands::Test(cmd) => cmd.run(),
+    };
+    // Export the remaining spans before exiting, which does not run destructors.
+    drop(telemetry);
+    if let Err(err) = result {
+        eprintln!("Error: {:?}", err);
+        if err.is::<test::TestFailures>() {
+            std::process::exit(EXIT_TEST_FAILURES);
+        }
+        std::process::exit(EXIT_ERROR);
+    }
+}
+
+pub(crate) fn path_exists(path: &OsStr) -> anyhow::Result<PathBuf> {
+    let path = PathBuf::from(path);
+    if !path.exists() {
+        return Err(anyhow!("path does not exist"));
     }
+    Ok(path)
 }
@@ -0,0 +1,112 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use lsp_positions::Span;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::io::BufWriter;
+use std::io::Write;
+
+use crate::scip::relative_file_path;
+use crate::subgraph::node_label;
+use crate::subgraph::Subgraph;
+use crate::subgraph::SubgraphArgs;
+
+/// Writes the nodes and edges of the graph as a Mermaid flowchart, which can be embedded in
+/// Markdown.  The nodes of every file are grouped in a subgraph, and the shape of a node depends
+/// on its kind.
+pub(crate) fn export(graph: &StackGraph, args: &SubgraphArgs) -> anyhow::Result<()> {
+    let subgraph = Subgraph::new(graph, args)?;
+    let project_root = args.export.project_root()?;
+    let output_path = &args.export.output;
+    let output = std::fs::File::create(output_path)
+        .with_context(|| format!("Failed to create {}", output_path.display()))?;
+    let mut output = BufWriter::new(output);
+
+    let ids = subgraph.node_ids();
+    writeln!(output, "flowchart TB")?;
+    let mut current_file = None;
+    for node in &subgraph.nodes {
+        let file = graph[*node].file();
+        if file != current_file {
+            if current_file.is_some() {
+                writeln!(output, "    end")?;
+            }
+            if let Some(file) = file {
+                let name = relative_file_path(graph, file, &project_root)
+                    .unwrap_or_else(|| graph[file].name().to_string());
+                writeln!(
+                    output,
+                    "    subgraph f{} [\"{}\"]",
+                    ids[node],
+                    escape(&name)
+                )?;
+            }
+            current_file = file;
+        }
+        let indent = if file.is_some() { "        " } else { "    " };
+        writeln!(output, "{}{}{}", indent, ids[node], shape(graph, *node))?;
+    }
+    if current_file.is_some() {
+        writeln!(output, "    end")?;
+    }
+    for edge in &subgraph.edges {
+        if edge.precedence == 0 {
+            writeln!(output, "    {} --> {}", ids[&edge.source], ids[&edge.sink])?;
+        } else {
+            writeln!(
+                output,
+                "    {} -->|{}| {}",
+                ids[&edge.source], edge.precedence, ids[&edge.sink]
+            )?;
+        }
+    }
+
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", output_path.display()))?;
+    println!(
+        "{} nodes and {} edges written to {}",
+        subgraph.nodes.len(),
+        subgraph.edges.len(),
+        output_path.display()
+    );
+    Ok(())
+}
+
+/// Returns the shape and label of a node.  The label includes the position of the node in its
+/// file, if it is known.
+fn shape(graph: &StackGraph, node: Handle<Node>) -> String {
+    let mut label = escape(&node_label(graph, node));
+    let span = graph.source_info(node).map(|si| &si.span);
+    if let Some(span) = span.filter(|span| **span != Span::default()) {
+        let start = &span.start;
+        label.push_str(&format!(
+            "<br/>{}:{}",
+            start.line + 1,
+            start.column.grapheme_offset + 1
+        ));
+    }
+    match &graph[node] {
+        Node::DropScopes(_) => format!("{{{{\"{}\"}}}}", label),
+        Node::JumpTo(_) | Node::Root(_) => format!("((\"{}\"))", label),
+        Node::PopScopedSymbol(_) | Node::PopSymbol(_) => format!("[\\\"{}\"\\]", label),
+        Node::PushScopedSymbol(_) | Node::PushSymbol(_) => format!("[/\"{}\"/]", label),
+        Node::Scope(node) if node.is_exported => format!("[[\"{}\"]]", label),
+        Node::Scope(_) => format!("[\"{}\"]", label),
+    }
+}
+
+/// Escapes the characters that Mermaid would interpret in a quoted label.
+fn escape(text: &str) -> String {
+    text.replace('#', "#35;")
+        .replace('"', "#quot;")
+        .replace('<', "#lt;")
+        .replace('>', "#gt;")
+}
@@ -0,0 +1,1308 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use anyhow::Context as _;
+use clap::Args;
+use clap::Subcommand;
+use clap::ValueHint;
+use lsp_positions::Position;
+use lsp_positions::PositionedSubstring;
+use lsp_positions::Span;
+use lsp_positions::SpanCalculator;
+use serde_json::json;
+use stack_graphs::arena::Handle;
+use stack_graphs::assert::AssertionSource;
+use stack_graphs::graph::File;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::NodeID;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::paths::Path;
+use stack_graphs::paths::Paths;
+use stack_graphs::stitching::PathStitcher;
+use stack_graphs::storage::Resolution;
+use stack_graphs::storage::SQLiteReader;
+use std::collections::BTreeMap;
+use std::collections::BTreeSet;
+use std::collections::HashSet;
+use std::collections::VecDeque;
+use std::path::PathBuf;
+use std::str::FromStr;
+
+use crate::database::DatabaseArgs;
+use crate::lsp::path_to_uri;
+use crate::lsp::range_json;
+use crate::OutputFormat;
+
+/// Query the database for definitions, references, calls, documentation, completions, or symbols
+///
+/// Symbols can be listed per file, as an outline, or searched across all files by name.  Symbols
+/// can also be renamed, which computes the text edits that rename a definition and all of its
+/// references, without applying them.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    #[clap(subcommand)]
+    target: Target,
+}
+
+#[derive(Subcommand)]
+enum Target {
+    /// Find the definitions of the reference at a source position.
+    Definition(TargetArgs),
+    /// Find the references to the definition at a source position.
+    References(TargetArgs),
+    /// Find the calls to the definition at a source position, or to the definitions of the
+    /// reference at the position, grouped by the definitions that make them.
+    IncomingCalls(TargetArgs),
+    /// Find the calls that the definition at a source position, or the definitions of the
+    /// reference at the position, make, grouped by the definitions they call.
+    OutgoingCalls(TargetArgs),
+    /// Compute the text edits that rename the definition at a source position, or the definition
+    /// of the reference at the position, and all references to it.
+    Rename(RenameArgs),
+    /// Show the definitions of the reference at a source position, or the definition at the
+    /// position itself, with their documentation.
+    Hover(TargetArgs),
+    /// List the names that are visible at a source position, as rough completion candidates.
+    Completions(TargetArgs),
+    /// List the definitions in a source file as an outline, nested by their containing
+    /// definitions.
+    Symbols(SymbolsArgs),
+    /// Search the definitions in all files by name.  Symbols match if they contain the characters
+    /// of the query in order, ignoring case, and the best matches are listed first.
+    Search(SearchArgs),
+}
+
+#[derive(Args)]
+struct TargetArgs {
+    /// Source position, given as FILE:LINE:COLUMN.  Lines and columns start at 1, and columns
+    /// count characters.
+    #[clap(value_name = "SOURCE_POSITION")]
+    position: SourcePosition,
+
+    /// Output format.  The text format lists the location of each result.  The JSON format
+    /// includes the symbol, the reference and definition spans, the path length, whether the
+    /// path is shadowed by another path, and the ranking score, for every result.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+#[derive(Args)]
+struct RenameArgs {
+    /// Source position, given as FILE:LINE:COLUMN.  Lines and columns start at 1, and columns
+    /// count characters.
+    #[clap(value_name = "SOURCE_POSITION")]
+    position: SourcePosition,
+
+    /// New name of the symbol.
+    #[clap(value_name = "NEW_NAME")]
+    new_name: String,
+
+    /// Output format.  The text format lists the location of each edit.  The JSON format is an
+    /// LSP workspace edit, which maps the URI of every file to its edits.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+#[derive(Args)]
+struct SymbolsArgs {
+    /// Source file.
+    #[clap(value_name = "SOURCE_PATH", value_hint = ValueHint::FilePath, parse(from_os_str))]
+    path: PathBuf,
+
+    /// Output format.  The text format lists the symbol and location of each definition, indented
+    /// by its depth in the outline.  The JSON format includes the symbol, syntax type, and span of
+    /// every definition, with the definitions it contains as its children.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+#[derive(Args)]
+struct SearchArgs {
+    /// Name, or part of a name, to search for.
+    #[clap(value_name = "QUERY")]
+    query: String,
+
+    /// Maximum number of definitions to list.
+    #[clap(long, value_name = "COUNT", default_value = "100")]
+    limit: usize,
+
+    /// Output format.  The text format lists the symbol and location of each definition.  The
+    /// JSON format includes the symbol, syntax type, and span of every definition.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let (args, find_references) = match &self.target {
+            Target::Definition(args) => (args, false),
+            Target::References(args) => (args, true),
+            Target::IncomingCalls(args) => return self.calls(args, true),
+            Target::OutgoingCalls(args) => return self.calls(args, false),
+            Target::Rename(args) => return self.rename(args),
+            Target::Hover(args) => return self.hover(args),
+            Target::Completions(args) => return self.completions(args),
+            Target::Symbols(args) => return self.symbols(args),
+            Target::Search(args) => return self.search(args),
+        };
+        let path = args.position.canonical_path()?;
+        let source = std::fs::read_to_string(&path)
+            .with_context(|| format!("Failed to read {}", args.position.path.display()))?;
+        let position = args.position.to_position(&source)?;
+
+        let mut reader = self.database.open_reader()?;
+        let results = match find_results(
+            &mut reader,
+            &path.to_string_lossy(),
+            position,
+            find_references,
+        )? {
+            Some(results) => results,
+            None if find_references => return Err(anyhow!("No definitions at {}", args.position)),
+            None => return Err(anyhow!("No references at {}", args.position)),
+        };
+        let (graph, _, _) = reader.get();
+
+        match args.format {
+            OutputFormat::Text => {
+                // Shadowed paths are not valid results, so we only list them in the JSON output,
+                // where they are marked as such.
+                let nodes = results.iter().filter(|r| !r.shadowed).map(|r| {
+                    if find_references {
+                        r.reference
+                    } else {
+                        r.definition
+                    }
+                });
+                for location in node_locations(graph, nodes) {
+                    println!("{}", location);
+                }
+            }
+            OutputFormat::Json => {
+                let results = results.iter().map(|r| r.to_json(graph)).collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&results)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn calls(&self, args: &TargetArgs, incoming: bool) -> anyhow::Result<()> {
+        let path = args.position.canonical_path()?;
+        let source = std::fs::read_to_string(&path)
+            .with_context(|| format!("Failed to read {}", args.position.path.display()))?;
+        let position = args.position.to_position(&source)?;
+
+        let mut reader = self.database.open_reader()?;
+        let definitions = find_target_definitions(&mut reader, &path.to_string_lossy(), position)?
+            .ok_or_else(|| anyhow!("No references or definitions at {}", args.position))?;
+        let mut calls = Vec::new();
+        for definition in definitions {
+            if incoming {
+                calls.extend(find_incoming_calls(&mut reader, definition)?);
+            } else {
+                calls.extend(find_outgoing_calls(&mut reader, definition)?);
+            }
+        }
+        let (graph, _, _) = reader.get();
+
+        match args.format {
+            OutputFormat::Text => {
+                for call in calls {
+                    // Incoming calls are listed by caller, and outgoing calls by callee.  Calls
+                    // outside of any definition are listed by file.
+                    let definition = if incoming {
+                        call.caller
+                    } else {
+                        Some(call.callee)
+                    };
+                    match definition {
+                        Some(definition) => {
+                            let symbol = &graph[graph[definition].symbol().unwrap()];
+                            match node_locations(graph, Some(definition)).iter().next() {
+                                Some(location) => println!("{} {}", symbol, location),
+                                None => println!("{}", symbol),
+                            }
+                        }
+                        None => match call.call_sites.first().and_then(|n| graph[*n].file()) {
+                            Some(file) => println!("{}", graph[file]),
+                            None => continue,
+                        },
+                    }
+                    for location in node_locations(graph, call.call_sites) {
+                        println!("    {}", location);
+                    }
+                }
+            }
+            OutputFormat::Json => {
+                let calls = calls.iter().map(|c| c.to_json(graph)).collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&calls)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn rename(&self, args: &RenameArgs) -> anyhow::Result<()> {
+        let path = args.position.canonical_path()?;
+        let source = std::fs::read_to_string(&path)
+            .with_context(|| format!("Failed to read {}", args.position.path.display()))?;
+        let position = args.position.to_position(&source)?;
+
+        let mut reader = self.database.open_reader()?;
+        let edits = find_rename_edits(
+            &mut reader,
+            &path.to_string_lossy(),
+            position,
+            &args.new_name,
+        )?
+        .ok_or_else(|| anyhow!("No references or definitions at {}", args.position))?;
+
+        match args.format {
+            OutputFormat::Text => {
+                for edit in &edits {
+                    println!(
+                        "{}:{}:{}",
+                        edit.file,
+                        edit.span.start.line + 1,
+                        edit.span.start.column.grapheme_offset + 1
+                    );
+                }
+            }
+            OutputFormat::Json => {
+                println!(
+                    "{}",
+                    serde_json::to_string_pretty(&workspace_edit_json(&edits))?
+                );
+            }
+        }
+        Ok(())
+    }
+
+    fn hover(&self, args: &TargetArgs) -> anyhow::Result<()> {
+        let path = args.position.canonical_path()?;
+        let source = std::fs::read_to_string(&path)
+            .with_context(|| format!("Failed to read {}", args.position.path.display()))?;
+        let position = args.position.to_position(&source)?;
+
+        let mut reader = self.database.open_reader()?;
+        let definitions =
+            find_hover_definitions(&mut reader, &path.to_string_lossy(), position)?
+                .ok_or_else(|| anyhow!("No references or definitions at {}", args.position))?;
+        let (graph, _, _) = reader.get();
+
+        match args.format {
+            OutputFormat::Text => {
+                for definition in definitions {
+                    let locations = node_locations(graph, Some(definition));
+                    let location = match locations.iter().next() {
+                        Some(location) => location,
+                        None => continue,
+                    };
+                    println!("{}", location);
+                    if let Some(documentation) = documentation(graph, definition) {
+                        for line in documentation.lines() {
+                            println!("    {}", line);
+                        }
+                    }
+                }
+            }
+            OutputFormat::Json => {
+                let results = definitions
+                    .into_iter()
+                    .map(|definition| {
+                        json!({
+                            "symbol": graph[definition].symbol().map(|s| graph[s].to_string()),
+                            "definition": node_location_json(graph, definition),
+                            "documentation": documentation(graph, definition),
+                        })
+                    })
+                    .collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&results)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn completions(&self, args: &TargetArgs) -> anyhow::Result<()> {
+        let path = args.position.canonical_path()?;
+        let source = std::fs::read_to_string(&path)
+            .with_context(|| format!("Failed to read {}", args.position.path.display()))?;
+        let position = args.position.to_position(&source)?;
+
+        let mut reader = self.database.open_reader()?;
+        let definitions = find_completions(&mut reader, &path.to_string_lossy(), position)?
+            .ok_or_else(|| anyhow!("No scope at {}", args.position))?;
+        let (graph, _, _) = reader.get();
+
+        match args.format {
+            OutputFormat::Text => {
+                for definition in definitions {
+                    let symbol = &graph[graph[definition].symbol().unwrap()];
+                    match node_locations(graph, Some(definition)).iter().next() {
+                        Some(location) => println!("{} {}", symbol, location),
+                        None => println!("{}", symbol),
+                    }
+                }
+            }
+            OutputFormat::Json => {
+                let results = definitions
+                    .into_iter()
+                    .map(|definition| {
+                        json!({
+                            "symbol": graph[definition].symbol().map(|s| graph[s].to_string()),
+                            "definition": node_location_json(graph, definition),
+                            "documentation": documentation(graph, definition),
+                        })
+                    })
+                    .collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&results)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn symbols(&self, args: &SymbolsArgs) -> anyhow::Result<()> {
+        let path = std::fs::canonicalize(&args.path)
+            .with_context(|| format!("Failed to resolve {}", args.path.display()))?;
+        let mut reader = self.database.open_reader()?;
+        let file = reader.load_graph_for_file(&path.to_string_lossy())?;
+        let (graph, _, _) = reader.get();
+        let symbols = document_symbols(graph, file);
+
+        match args.format {
+            OutputFormat::Text => print_document_symbols(graph, &symbols, 0),
+            OutputFormat::Json => {
+                let symbols = symbols.iter().map(|s| s.to_json(graph)).collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&symbols)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn search(&self, args: &SearchArgs) -> anyhow::Result<()> {
+        let reader = self.database.open_reader()?;
+        let definitions = reader.find_definitions(&args.query, args.limit)?;
+        match args.format {
+            OutputFormat::Text => {
+                for definition in definitions {
+                    println!(
+                        "{} {}:{}:{}",
+                        definition.symbol,
+                        definition.path,
+                        definition.span.start.line + 1,
+                        definition.span.start.column.grapheme_offset + 1
+                    );
+                }
+            }
+            OutputFormat::Json => {
+                let results = definitions
+                    .iter()
+                    .map(|definition| {
+                        json!({
+                            "symbol": definition.symbol,
+                            "syntax_type": definition.syntax_type,
+                            "definition": {
+                                "file": definition.path,
+                                "span": span_json(&definition.span),
+                            },
+                        })
+                    })
+                    .collect::<Vec<_>>();
+                println!("{}", serde_json::to_string_pretty(&results)?);
+            }
+        }
+        Ok(())
+    }
+}
+
+fn print_document_symbols(graph: &StackGraph, symbols: &[DocumentSymbol], depth: usize) {
+    for symbol in symbols {
+        let name = &graph[graph[symbol.definition].symbol().unwrap()];
+        let span = &graph.source_info(symbol.definition).unwrap().span;
+        println!(
+            "{:indent$}{} {}:{}",
+            "",
+            name,
+            span.start.line + 1,
+            span.start.column.grapheme_offset + 1,
+            indent = 2 * depth
+        );
+        print_document_symbols(graph, &symbol.children, depth + 1);
+    }
+}
+
+/// A definition in the outline of a file, with the definitions that it contains.
+pub(crate) struct DocumentSymbol {
+    pub(crate) definition: Handle<Node>,
+    pub(crate) children: Vec<DocumentSymbol>,
+}
+
+impl DocumentSymbol {
+    fn to_json(&self, graph: &StackGraph) -> serde_json::Value {
+        json!({
+            "symbol": graph[self.definition].symbol().map(|s| graph[s].to_string()),
+            "syntax_type": graph
+                .source_info(self.definition)
+                .and_then(|si| si.syntax_type)
+                .map(|st| graph[st].to_string()),
+            "definition": node_location_json(graph, self.definition),
+            "children": self.children.iter().map(|c| c.to_json(graph)).collect::<Vec<_>>(),
+        })
+    }
+}
+
+/// Returns the outline of a file, i.e., its definitions, nested by the source code they enclose.
+/// A definition is a child of the innermost definition that encloses it.  See
+/// [`definition_range`][].
+/// Definitions without a symbol or source information are skipped, and siblings are ordered by
+/// their position in the file.
+pub(crate) fn document_symbols(graph: &StackGraph, file: Handle<File>) -> Vec<DocumentSymbol> {
+    let mut definitions = graph
+        .nodes_for_file(file)
+        .filter(|n| graph[*n].is_definition() && graph[*n].symbol().is_some())
+        .filter_map(|n| {
+            let (start, end) = definition_range(graph, n)?;
+            Some((start, end, n))
+        })
+        .collect::<Vec<_>>();
+    // Definitions that start at the same position are ordered outermost first, so that they
+    // become the parents of the others.
+    definitions.sort_by_key(|(start, end, _)| (*start, std::cmp::Reverse(*end)));
+    nest_document_symbols(&definitions, &mut 0, usize::MAX)
+}
+
+/// Returns the definitions, starting at `next`, that end before `parent_end`, with their
+/// children.  The definitions must be sorted by start position.
+fn nest_document_symbols(
+    definitions: &[(usize, usize, Handle<Node>)],
+    next: &mut usize,
+    parent_end: usize,
+) -> Vec<DocumentSymbol> {
+    let mut symbols = Vec::new();
+    while *next < definitions.len() && definitions[*next].1 <= parent_end {
+        let (_, end, definition) = definitions[*next];
+        *next += 1;
+        let children = nest_document_symbols(definitions, next, end);
+        symbols.push(DocumentSymbol {
+            definition,
+            children,
+        });
+    }
+    symbols
+}
+
+/// Finds the definitions whose names are visible at a position in a file, as rough completion
+/// candidates.  The search starts at the scopes that the references at the position point to, or,
+/// if there are none, at the innermost scope node that contains the position.  From there, it
+/// follows edges through scope nodes and the root node, and collects the definitions it reaches,
+/// without pushing or popping any symbols.  This finds the names in the enclosing lexical scopes,
+/// and the names that other files export at the root node, but not members that can only be
+/// reached through another symbol.  Only the files that the partial paths of the file can reach are
+/// loaded, so names from other files are limited to those that the file can use.
+///
+/// Every symbol is returned once, with its nearest definition, and the results are sorted by
+/// symbol.  Returns `None` if there is no scope at the position.
+pub(crate) fn find_completions(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+) -> anyhow::Result<Option<Vec<Handle<Node>>>> {
+    let file = reader.load_graph_for_file(file_name)?;
+    reader.load_paths_for_file_and_dependencies(file_name)?;
+    let (graph, _, _) = reader.get();
+    let source = AssertionSource { file, position };
+
+    let mut queue = source
+        .references_iter(graph)
+        .flat_map(|reference| graph.outgoing_edges(reference).map(|e| e.sink))
+        .collect::<VecDeque<_>>();
+    if queue.is_empty() {
+        // Without a reference, we use the innermost scope that contains the position.
+        let scope = graph
+            .nodes_for_file(file)
+            .filter(|n| matches!(graph[*n], Node::Scope(_)))
+            .filter_map(|n| {
+                let span = &graph.source_info(n)?.span;
+                if !span.contains(&source.position) {
+                    return None;
+                }
+                let (start, end) = byte_range(span);
+                Some((end - start, n))
+            })
+            .min();
+        match scope {
+            Some((_, scope)) => queue.push_back(scope),
+            None => return Ok(None),
+        }
+    }
+
+    let mut seen = queue.iter().copied().collect::<HashSet<_>>();
+    let mut definitions = BTreeMap::new();
+    while let Some(node) = queue.pop_front() {
+        match &graph[node] {
+            Node::Scope(_) | Node::DropScopes(_) | Node::Root(_) => {
+                for edge in graph.outgoing_edges(node) {
+                    if seen.insert(edge.sink) {
+                        queue.push_back(edge.sink);
+                    }
+                }
+            }
+            n if n.is_definition() => {
+                // Nodes are visited in order of distance, so the first definition of a symbol
+                // is the nearest one, which shadows the others.
+                let symbol = graph[n.symbol().unwrap()].to_string();
+                definitions.entry(symbol).or_insert(node);
+            }
+            _ => {}
+        }
+    }
+    Ok(Some(definitions.into_values().collect()))
+}
+
+/// Finds the definitions to show hover information for at a position in a file.  These are the
+/// definitions of the references at the position, ordered by descending ranking score, or, if
+/// there are no references, the definitions at the position itself.  Returns `None` if there are
+/// neither.
+pub(crate) fn find_hover_definitions(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+) -> anyhow::Result<Option<Vec<Handle<Node>>>> {
+    if let Some(results) = find_results(reader, file_name, position.clone(), false)? {
+        return Ok(Some(ranked_definitions(results)));
+    }
+    let (graph, _, _) = reader.get();
+    let file = match graph.get_file(file_name) {
+        Some(file) => file,
+        None => return Ok(None),
+    };
+    let source = AssertionSource { file, position };
+    let definitions = source.definitions_iter(graph).collect::<Vec<_>>();
+    if definitions.is_empty() {
+        return Ok(None);
+    }
+    Ok(Some(definitions))
+}
+
+/// Returns the unique definitions of the results that are not shadowed, ordered by descending
+/// ranking score.
+fn ranked_definitions(mut results: Vec<QueryResult>) -> Vec<Handle<Node>> {
+    results.retain(|r| !r.shadowed);
+    results.sort_by(|a, b| b.score.total_cmp(&a.score));
+    let mut definitions = Vec::new();
+    for result in results {
+        if !definitions.contains(&result.definition) {
+            definitions.push(result.definition);
+        }
+    }
+    definitions
+}
+
+/// Finds the definitions that a call hierarchy or rename query at a position in a file is about.
+/// These are the definitions of the references at the position, ordered by descending ranking score, or, if
+/// there are no references, the innermost definition at the position.  Returns `None` if there are
+/// neither.
+pub(crate) fn find_target_definitions(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+) -> anyhow::Result<Option<Vec<Handle<Node>>>> {
+    if let Some(results) = find_results(reader, file_name, position.clone(), false)? {
+        return Ok(Some(ranked_definitions(results)));
+    }
+    Ok(find_definition_at(reader, file_name, position)?.map(|definition| vec![definition]))
+}
+
+/// Finds the innermost definition at a position in a file, i.e., the definition with the smallest
+/// span that contains the position.  Returns `None` if there is no definition at the position.
+pub(crate) fn find_definition_at(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+) -> anyhow::Result<Option<Handle<Node>>> {
+    let file = reader.load_graph_for_file(file_name)?;
+    let (graph, _, _) = reader.get();
+    let source = AssertionSource { file, position };
+    Ok(source
+        .definitions_iter(graph)
+        .filter_map(|n| {
+            let (start, end) = byte_range(&graph.source_info(n)?.span);
+            Some((end - start, n))
+        })
+        .min()
+        .map(|(_, n)| n))
+}
+
+/// A call from one definition to another that was found by a call hierarchy query.  Stack graphs
+/// do not distinguish calls from other uses of a name, so every reference that resolves to a
+/// definition counts as a call to it.
+pub(crate) struct Call {
+    /// The innermost definition that encloses the call sites, or `None` if they are not enclosed
+    /// by any definition.  See [`definition_range`][].
+    pub(crate) caller: Option<Handle<Node>>,
+    pub(crate) callee: Handle<Node>,
+    /// The references that make the call.
+    pub(crate) call_sites: Vec<Handle<Node>>,
+}
+
+impl Call {
+    fn to_json(&self, graph: &StackGraph) -> serde_json::Value {
+        let definition_json = |definition: Handle<Node>| {
+            json!({
+                "symbol": graph[definition].symbol().map(|s| graph[s].to_string()),
+                "definition": node_location_json(graph, definition),
+            })
+        };
+        json!({
+            "caller": self.caller.map(definition_json),
+            "callee": definition_json(self.callee),
+            "call_sites": self
+                .call_sites
+                .iter()
+                .map(|n| node_location_json(graph, *n))
+                .collect::<Vec<_>>(),
+        })
+    }
+}
+
+/// Finds the calls to a definition, i.e., the references that resolve to it, grouped by the
+/// definitions that enclose them.
+pub(crate) fn find_incoming_calls(
+    reader: &mut SQLiteReader,
+    callee: Handle<Node>,
+) -> anyhow::Result<Vec<Call>> {
+    let (graph, _, _) = reader.get();
+    let file_name = match graph[callee].file() {
+        Some(file) => graph[file].name().to_string(),
+        None => return Ok(Vec::new()),
+    };
+    let position = match graph.source_info(callee) {
+        Some(source_info) => source_info.span.start.clone(),
+        None => return Ok(Vec::new()),
+    };
+    // The query also finds the references to the definitions that contain the callee, which we
+    // leave out.
+    let results = find_results(reader, &file_name, position, true)?.unwrap_or_default();
+    let (graph, _, _) = reader.get();
+    let call_sites = results
+        .iter()
+        .filter(|r| !r.shadowed && r.definition == callee)
+        .map(|r| (r.reference, r.definition));
+    Ok(group_calls(graph, call_sites))
+}
+
+/// Finds the calls that a definition makes, i.e., the definitions that the references it encloses
+/// resolve to.  References that are enclosed by a nested definition belong to that definition
+/// instead.
+pub(crate) fn find_outgoing_calls(
+    reader: &mut SQLiteReader,
+    caller: Handle<Node>,
+) -> anyhow::Result<Vec<Call>> {
+    let (graph, _, _) = reader.get();
+    let file = match graph[caller].file() {
+        Some(file) => file,
+        None => return Ok(Vec::new()),
+    };
+    let file_name = graph[file].name().to_string();
+    let references = graph
+        .nodes_for_file(file)
+        .filter(|n| graph[*n].is_reference() && enclosing_definition(graph, *n) == Some(caller))
+        .collect::<Vec<_>>();
+    if references.is_empty() {
+        return Ok(Vec::new());
+    }
+    let results = resolve_references(reader, &file_name, references)?;
+    let (graph, _, _) = reader.get();
+    let call_sites = results
+        .iter()
+        .filter(|r| !r.shadowed)
+        .map(|r| (r.reference, r.definition));
+    Ok(group_calls(graph, call_sites))
+}
+
+/// Groups call sites, given with the definitions they call, into calls by their enclosing
+/// definition and callee.
+fn group_calls<I>(graph: &StackGraph, call_sites: I) -> Vec<Call>
+where
+    I: IntoIterator<Item = (Handle<Node>, Handle<Node>)>,
+{
+    let mut calls = BTreeMap::<_, BTreeSet<_>>::new();
+    for (call_site, callee) in call_sites {
+        let caller = enclosing_definition(graph, call_site);
+        calls.entry((caller, callee)).or_default().insert(call_site);
+    }
+    calls
+        .into_iter()
+        .map(|((caller, callee), call_sites)| Call {
+            caller,
+            callee,
+            call_sites: call_sites.into_iter().collect(),
+        })
+        .collect()
+}
+
+/// Returns the innermost definition in the file of a node that encloses the node's span, other
+/// than the node itself.  See [`definition_range`][].
+fn enclosing_definition(graph: &StackGraph, node: Handle<Node>) -> Option<Handle<Node>> {
+    let file = graph[node].file()?;
+    let (start, end) = byte_range(&graph.source_info(node)?.span);
+    graph
+        .nodes_for_file(file)
+        .filter(|n| *n != node && graph[*n].is_definition() && graph[*n].symbol().is_some())
+        .filter_map(|n| {
+            let (definition_start, definition_end) = definition_range(graph, n)?;
+            if definition_start <= start && end <= definition_end {
+                Some((definition_end - definition_start, n))
+            } else {
+                None
+            }
+        })
+        .min()
+        .map(|(_, n)| n)
+}
+
+/// Returns the byte range of the source code that a definition encloses.  This is the span of its
+/// definiens, such as the body of a function, if it has one, and the span of its source node
+/// otherwise.
+fn definition_range(graph: &StackGraph, definition: Handle<Node>) -> Option<(usize, usize)> {
+    let source_info = graph.source_info(definition)?;
+    if source_info.definiens_span != Span::default() {
+        Some(byte_range(&source_info.definiens_span))
+    } else {
+        Some(byte_range(&source_info.span))
+    }
+}
+
+/// Returns the start and end byte offsets of a span in its file.
+fn byte_range(span: &Span) -> (usize, usize) {
+    let start = span.start.containing_line.start + span.start.column.utf8_offset;
+    let end = span.end.containing_line.start + span.end.column.utf8_offset;
+    (start, end)
+}
+
+/// An edit that replaces the source code in a span of a file with new text.
+pub(crate) struct TextEdit {
+    pub(crate) file: String,
+    pub(crate) span: Span,
+    pub(crate) new_text: String,
+}
+
+/// Finds the edits that rename the definition at a position in a file, or the definition of the
+/// reference at the position, and all references to it, ordered by file and position.  Returns
+/// `None` if there are no references or definitions at the position.
+///
+/// Renaming fails, instead of returning edits that could break the code, if the reference at the
+/// position resolves to more than one definition, if the span of the definition or of one of its
+/// references does not consist of exactly its symbol, or if the results may be incomplete because
+/// some files in the database failed to index, for example because they exceeded the time limit.
+pub(crate) fn find_rename_edits(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+    new_name: &str,
+) -> anyhow::Result<Option<Vec<TextEdit>>> {
+    if new_name.is_empty() {
+        return Err(anyhow!("New name cannot be empty"));
+    }
+    let failed = reader
+        .status_all()?
+        .iter()
+        .filter(|s| !s.is_success())
+        .count();
+    if failed > 0 {
+        return Err(anyhow!(
+            "Cannot rename, because {} files failed to index, and may contain references",
+            failed
+        ));
+    }
+    let definitions = match find_target_definitions(reader, file_name, position)? {
+        Some(definitions) => definitions,
+        None => return Ok(None),
+    };
+    let definition = match definitions.as_slice() {
+        [definition] => *definition,
+        _ => {
+            return Err(anyhow!(
+                "Cannot rename, because the reference resolves to {} definitions",
+                definitions.len()
+            ))
+        }
+    };
+
+    let (graph, _, _) = reader.get();
+    let definition_file = match graph[definition].file() {
+        Some(file) => graph[file].name().to_string(),
+        None => return Err(anyhow!("Cannot rename a definition that is not in a file")),
+    };
+    let definition_position = match graph.source_info(definition) {
+        Some(source_info) => source_info.span.start.clone(),
+        None => return Err(anyhow!("Cannot rename a definition without a location")),
+    };
+    let results =
+        find_results(reader, &definition_file, definition_position, true)?.unwrap_or_default();
+    let (graph, _, _) = reader.get();
+    let mut nodes = results
+        .iter()
+        .filter(|r| !r.shadowed && r.definition == definition)
+        .map(|r| r.reference)
+        .collect::<Vec<_>>();
+    nodes.push(definition);
+
+    let symbol = &graph[graph[definition].symbol().unwrap()];
+    let mut edits = BTreeMap::new();
+    for node in nodes {
+        let file = graph[node].file().map(|f| graph[f].name().to_string());
+        let source_info = graph.source_info(node);
+        let (file, span) = match (file, source_info) {
+            (Some(file), Some(source_info)) if source_text(graph, node) == Some(symbol) => {
+                (file, source_info.span.clone())
+            }
+            _ => {
+                return Err(anyhow!(
+                    "Cannot rename {}, because the source code at {} is not its name",
+                    symbol,
+                    node_locations(graph, Some(node))
+                        .into_iter()
+                        .next()
+                        .unwrap_or_else(|| "an unknown location".to_string())
+                ))
+            }
+        };
+        edits.insert(
+            (file.clone(), span.clone()),
+            TextEdit {
+                file,
+                span,
+                new_text: new_name.to_string(),
+            },
+        );
+    }
+    Ok(Some(edits.into_values().collect()))
+}
+
+/// Returns the source code in the span of a node, if the span is on a single line and the content
+/// of the line is known.
+fn source_text(graph: &StackGraph, node: Handle<Node>) -> Option<&str> {
+    let source_info = graph.source_info(node)?;
+    let span = &source_info.span;
+    if span.start.line != span.end.line {
+        return None;
+    }
+    let line = &graph[source_info.containing_line.into_option()?];
+    line.get(span.start.column.utf8_offset..span.end.column.utf8_offset)
+}
+
+/// Returns text edits as an LSP workspace edit, which maps the URI of every file to its edits.
+pub(crate) fn workspace_edit_json(edits: &[TextEdit]) -> serde_json::Value {
+    let mut changes = serde_json::Map::new();
+    for edit in edits {
+        let file_edits = changes
+            .entry(path_to_uri(&edit.file))
+            .or_insert_with(|| serde_json::Value::Array(Vec::new()));
+        file_edits.as_array_mut().unwrap().push(json!({
+            "range": range_json(&edit.span),
+            "newText": edit.new_text,
+        }));
+    }
+    json!({ "changes": changes })
+}
+
+/// Returns the documentation of a node, if it has any.
+pub(crate) fn documentation(graph: &StackGraph, node: Handle<Node>) -> Option<&str> {
+    let documentation = graph.source_info(node)?.documentation?;
+    Some(&graph[documentation])
+}
+
+/// Finds the definitions of the references at a position in a file, or the references to the
+/// definitions at the position.  Only the parts of the database that are needed to answer the
+/// query are loaded into the reader.  If the database contains the resolutions of the files that
+/// are needed, they are used instead of stitching paths.  Returns `None` if there are no
+/// references, or no definitions, at the position.
+pub(crate) fn find_results(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    position: Position,
+    find_references: bool,
+) -> anyhow::Result<Option<Vec<QueryResult>>> {
+    let file = reader.load_graph_for_file(file_name)?;
+    let (graph, _, _) = reader.get();
+    let source = AssertionSource { file, position };
+    if !find_references {
+        let references = source.references_iter(graph).collect::<Vec<_>>();
+        if references.is_empty() {
+            return Ok(None);
+        }
+        return Ok(Some(resolve_references(reader, file_name, references)?));
+    }
+
+    let definitions = source.definitions_iter(graph).collect::<HashSet<_>>();
+    if definitions.is_empty() {
+        return Ok(None);
+    }
+    if let Some(results) = find_stored_references(reader, file_name, &definitions)? {
+        return Ok(Some(results));
+    }
+    // References to a definition can only be found in files that contain its symbol, so we only
+    // load those, and the files that their paths can reach via the root node.
+    let (graph, _, _) = reader.get();
+    let symbols = definitions
+        .iter()
+        .filter_map(|n| graph[*n].symbol())
+        .map(|s| graph[s].to_string())
+        .collect::<BTreeSet<_>>();
+    for symbol in symbols {
+        for file in reader.files_with_symbol(&symbol)? {
+            reader.load_paths_for_file_and_dependencies(&file)?;
+        }
+    }
+    let (graph, partials, db) = reader.get();
+
+    let mut paths = Paths::new();
+    let references = graph
+        .iter_nodes()
+        .filter(|n| graph[*n].is_reference())
+        .collect::<Vec<_>>();
+    let mut results =
+        PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references);
+    results.retain(|p| definitions.contains(&p.end_node));
+    Ok(Some(QueryResult::from_paths(graph, &mut paths, results)))
+}
+
+/// Finds the definitions of references in a file.  If the resolutions of the file are stored in
+/// the database, they are used.  Otherwise, only the files that the paths of the file can reach via
+/// the root node are loaded, because the definitions can only be found there, and paths are
+/// stitched.
+fn resolve_references(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    references: Vec<Handle<Node>>,
+) -> anyhow::Result<Vec<QueryResult>> {
+    if let Some(mut resolutions) = reader.resolutions_for_file(file_name)? {
+        let (graph, _, _) = reader.get();
+        let references = references
+            .iter()
+            .map(|n| graph[*n].id().local_id())
+            .collect::<HashSet<_>>();
+        resolutions.retain(|r| references.contains(&r.reference_local_id));
+        return stored_results(reader, resolutions);
+    }
+    reader.load_paths_for_file_and_dependencies(file_name)?;
+    let (graph, partials, db) = reader.get();
+    let mut paths = Paths::new();
+    let results =
+        PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references);
+    Ok(QueryResult::from_paths(graph, &mut paths, results))
+}
+
+/// Finds the references to definitions in a file using the resolutions stored in the database.
+/// Returns `None` if the resolutions of some files are not stored, because they may contain
+/// references to the definitions.
+fn find_stored_references(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    definitions: &HashSet<Handle<Node>>,
+) -> anyhow::Result<Option<Vec<QueryResult>>> {
+    if !reader.unresolved_files()?.is_empty() {
+        return Ok(None);
+    }
+    let (graph, _, _) = reader.get();
+    let definitions = definitions
+        .iter()
+        .map(|n| graph[*n].id().local_id())
+        .collect::<Vec<_>>();
+    let mut resolutions = Vec::new();
+    for local_id in definitions {
+        resolutions.extend(reader.resolutions_for_definition(file_name, local_id)?);
+    }
+    Ok(Some(stored_results(reader, resolutions)?))
+}
+
+/// Returns the query results of stored resolutions, loading the graphs of the files they refer to
+/// if necessary.
+fn stored_results(
+    reader: &mut SQLiteReader,
+    resolutions: Vec<Resolution>,
+) -> anyhow::Result<Vec<QueryResult>> {
+    let mut results = Vec::new();
+    for resolution in resolutions {
+        let reference = stored_node(
+            reader,
+            &resolution.reference_file,
+            resolution.reference_local_id,
+        )?;
+        let definition = stored_node(
+            reader,
+            &resolution.definition_file,
+            resolution.definition_local_id,
+        )?;
+        results.push(QueryResult {
+            reference,
+            definition,
+            path_length: resolution.path_length,
+            shadowed: false,
+            score: resolution.score,
+        });
+    }
+    Ok(results)
+}
+
+/// Returns the handle of a node that is referred to by a stored resolution, loading the graph of
+/// its file if necessary.
+fn stored_node(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+    local_id: u32,
+) -> anyhow::Result<Handle<Node>> {
+    let file = reader.load_graph_for_file(file_name)?;
+    let (graph, _, _) = reader.get();
+    graph
+        .node_for_id(NodeID::new_in_file(file, local_id))
+        .ok_or_else(|| anyhow!("Missing node {} in {}", local_id, file_name))
+}
+
+/// Resolves all references in a file, and returns the definitions they resolve to, so that they
+/// can be stored in the database.  Shadowed results are left out.
+pub(crate) fn resolve_file(
+    reader: &mut SQLiteReader,
+    file_name: &str,
+) -> anyhow::Result<Vec<Resolution>> {
+    let file = reader.load_graph_for_file(file_name)?;
+    reader.load_paths_for_file_and_dependencies(file_name)?;
+    let (graph, partials, db) = reader.get();
+    let references = graph
+        .nodes_for_file(file)
+        .filter(|n| graph[*n].is_reference())
+        .collect::<Vec<_>>();
+    let mut paths = Paths::new();
+    let results =
+        PathStitcher::find_all_complete_paths(graph, &mut paths, partials, db, references);
+    let results = QueryResult::from_paths(graph, &mut paths, results);
+    let node_key = |node: Handle<Node>| {
+        let id = graph[node].id();
+        let file = id.file().map(|f| graph[f].name().to_string());
+        (file, id.local_id())
+    };
+    Ok(results
+        .into_iter()
+        .filter(|r| !r.shadowed)
+        .filter_map(|r| {
+            let (reference_file, reference_local_id) = node_key(r.reference);
+            let (definition_file, definition_local_id) = node_key(r.definition);
+            Some(Resolution {
+                reference_file: reference_file?,
+                reference_local_id,
+                definition_file: definition_file?,
+                definition_local_id,
+                path_length: r.path_length,
+                score: r.score,
+            })
+        })
+        .collect())
+}
+
+/// A path from a reference to a definition that was found by a query.
+pub(crate) struct QueryResult {
+    pub(crate) reference: Handle<Node>,
+    pub(crate) definition: Handle<Node>,
+    pub(crate) path_length: usize,
+    /// Whether the path is shadowed by another path from the same reference.
+    pub(crate) shadowed: bool,
+    /// The ranking score of the result.  See [`QueryResult::score`][].
+    pub(crate) score: f64,
+}
+
+/// The weights of the factors that make up the ranking score of a result.  They add up to 1.
+const PATH_LENGTH_WEIGHT: f64 = 0.5;
+const PRECEDENCE_WEIGHT: f64 = 0.25;
+const SAME_FILE_WEIGHT: f64 = 0.25;
+/// The path length at which the path length factor of the score is halved.
+const PATH_LENGTH_SCALE: f64 = 10.0;
+
+impl QueryResult {
+    fn from_paths(graph: &StackGraph, paths: &mut Paths, results: Vec<Path>) -> Vec<QueryResult> {
+        (0..results.len())
+            .map(|j| {
+                let shadowed =
+                    (0..results.len()).any(|i| i != j && results[i].shadows(paths, &results[j]));
+                let path = &results[j];
+                let precedence = path
+                    .edges
+                    .iter_unordered(paths)
+                    .map(|e| e.precedence)
+                    .sum::<i32>();
+                let same_file = graph[path.start_node].file() == graph[path.end_node].file();
+                QueryResult {
+                    reference: path.start_node,
+                    definition: path.end_node,
+                    path_length: path.edges.len(),
+                    shadowed,
+                    score: Self::score(path.edges.len(), precedence, same_file, shadowed),
+                }
+            })
+            .collect()
+    }
+
+    /// Returns a ranking score between 0 and 1 for a result, which clients can use to order the
+    /// definitions of a reference, or to drop unlikely ones, when several definitions survive
+    /// shadowing.  Shorter paths, paths with a higher total edge precedence, and definitions in
+    /// the same file as the reference score higher.  Shadowed results are not valid, and score 0.
+    /// Scores are only comparable between the results of the same query.
+    fn score(path_length: usize, precedence: i32, same_file: bool, shadowed: bool) -> f64 {
+        if shadowed {
+            return 0.0;
+        }
+        let path_length_factor = 1.0 / (1.0 + path_length as f64 / PATH_LENGTH_SCALE);
+        let precedence_factor = match precedence {
+            p if p > 0 => 1.0,
+            0 => 0.5,
+            _ => 0.0,
+        };
+        let same_file_factor = if same_file { 1.0 } else { 0.0 };
+        PATH_LENGTH_WEIGHT * path_length_factor
+            + PRECEDENCE_WEIGHT * precedence_factor
+            + SAME_FILE_WEIGHT * same_file_factor
+    }
+
+    fn to_json(&self, graph: &StackGraph) -> serde_json::Value {
+        json!({
+            "symbol": graph[self.reference].symbol().map(|s| graph[s].to_string()),
+            "reference": node_location_json(graph, self.reference),
+            "definition": node_location_json(graph, self.definition),
+            "path_length": self.path_length,
+            "shadowed": self.shadowed,
+            "score": self.score,
+        })
+    }
+}
+
+/// Returns the file and span of a node as JSON.  Lines and columns start at 1, and columns count
+/// characters, like in source positions given on the command line.
+pub(crate) fn node_location_json(graph: &StackGraph, node: Handle<Node>) -> serde_json::Value {
+    let file = graph[node].file().map(|f| graph[f].to_string());
+    let span = graph.source_info(node).map(|si| span_json(&si.span));
+    json!({
+        "file": file,
+        "span": span,
+    })
+}
+
+/// Returns a span as JSON, with lines and columns starting at 1.
+pub(crate) fn span_json(span: &Span) -> serde_json::Value {
+    json!({
+        "start": {
+            "line": span.start.line + 1,
+            "column": span.start.column.grapheme_offset + 1,
+        },
+        "end": {
+            "line": span.end.line + 1,
+            "column": span.end.column.grapheme_offset + 1,
+        },
+    })
+}
+
+/// Returns the sorted, unique source locations of the given nodes, formatted as
+/// FILE:LINE:COLUMN.  Nodes without source information are skipped.
+pub(crate) fn node_locations<I>(graph: &StackGraph, nodes: I) -> BTreeSet<String>
+where
+    I: IntoIterator<Item = Handle<Node>>,
+{
+    nodes
+        .into_iter()
+        .filter_map(|node| {
+            let file = graph[node].file()?;
+            let span = &graph.source_info(node)?.span;
+            Some(format!(
+                "{}:{}:{}",
+                graph[file],
+                span.start.line + 1,
+                span.start.column.grapheme_offset + 1
+            ))
+        })
+        .collect()
+}
+
+/// A source position given on the command line.  Lines and columns are 1-based.
+#[derive(Clone, Debug)]
+pub(crate) struct SourcePosition {
+    pub(crate) path: PathBuf,
+    pub(crate) line: usize,
+    pub(crate) column: usize,
+}
+
+impl FromStr for SourcePosition {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let mut parts = s.rsplitn(3, ':');
+        let column = parts.next();
+        let line = parts.next();
+        let path = parts.next();
+        match (path, line, column) {
+            (Some(path), Some(line), Some(column)) if !path.is_empty() => {
+                let line = line
+                    .parse::<usize>()
+                    .with_context(|| format!("Invalid line number {}", line))?;
+                let column = column
+                    .parse::<usize>()
+                    .with_context(|| format!("Invalid column number {}", column))?;
+                if line == 0 || column == 0 {
+                    return Err(anyhow!("Line and column numbers start at 1"));
+                }
+                Ok(SourcePosition {
+                    path: PathBuf::from(path),
+                    line,
+                    column,
+                })
+            }
+            _ => Err(anyhow!("Expected FILE:LINE:COLUMN")),
+        }
+    }
+}
+
+impl std::fmt::Display for SourcePosition {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(f, "{}:{}:{}", self.path.display(), self.line, self.column)
+    }
+}
+
+impl SourcePosition {
+    /// Returns the canonical path of the file, which is the name it is stored under in the
+    /// database.
+    pub(crate) fn canonical_path(&self) -> anyhow::Result<PathBuf> {
+        std::fs::canonicalize(&self.path)
+            .with_context(|| format!("Failed to resolve {}", self.path.display()))
+    }
+
+    pub(crate) fn to_position(&self, source: &str) -> anyhow::Result<Position> {
+        let line = PositionedSubstring::lines_iter(source)
+            .nth(self.line - 1)
+            .ok_or_else(|| {
+                anyhow!(
+                    "Line {} does not exist in {}",
+                    self.line,
+                    self.path.display()
+                )
+            })?;
+        if self.column > line.grapheme_length + 1 {
+            return Err(anyhow!(
+                "Column {} does not exist on line {} in {}",
+                self.column,
+                self.line,
+                self.path.display()
+            ));
+        }
+        let mut span_calculator = SpanCalculator::new(source);
+        Ok(span_calculator.for_line_and_grapheme(
+            self.line - 1,
+            line.utf8_bounds.start,
+            self.column - 1,
+        ))
+    }
+}
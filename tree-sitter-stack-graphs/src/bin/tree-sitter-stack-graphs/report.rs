@@ -0,0 +1,256 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use clap::Args;
+use clap::Subcommand;
+use serde_json::json;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
+use std::collections::HashSet;
+use std::collections::VecDeque;
+
+use crate::database::DatabaseArgs;
+use crate::export::resolve_all_references;
+use crate::query::node_location_json;
+use crate::query::node_locations;
+use crate::query::span_json;
+use crate::OutputFormat;
+
+/// Report on the definitions and references in the database
+///
+/// Reports resolve all references in the database, so they take about as long as exporting it.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    #[clap(subcommand)]
+    report: Report,
+}
+
+#[derive(Subcommand)]
+enum Report {
+    /// List the definitions that no reference resolves to, as candidates for dead code.
+    Unreferenced(UnreferencedArgs),
+    /// List the references that do not resolve to any definition, grouped by symbol and file,
+    /// to find the gaps in the stack graph construction rules.
+    Unresolved(UnresolvedArgs),
+}
+
+#[derive(Args)]
+struct UnreferencedArgs {
+    /// Leave out exported definitions, which can be referenced by code that is not in the
+    /// database.  Definitions are considered exported if they can be reached from the root node
+    /// without pushing any symbols, which is how other files refer to them.
+    #[clap(long)]
+    ignore_exported: bool,
+
+    /// Output format.  The text format lists the symbol and location of every definition.  The
+    /// JSON format includes the symbol, syntax type, and span of every definition.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+#[derive(Args)]
+struct UnresolvedArgs {
+    /// Output format.  The text format lists the symbols, most frequent first, with the location
+    /// of every reference.  The JSON format lists the symbols in the same order, with the spans
+    /// of the references in every file.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let mut reader = self.database.open_reader()?;
+        reader.load_all()?;
+        let (graph, partials, db) = reader.get();
+        let references = resolve_all_references(graph, partials, db);
+        match &self.report {
+            Report::Unreferenced(args) => report_unreferenced(graph, &references, args),
+            Report::Unresolved(args) => report_unresolved(graph, &references, args),
+        }
+    }
+}
+
+fn report_unreferenced(
+    graph: &StackGraph,
+    references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+    args: &UnreferencedArgs,
+) -> anyhow::Result<()> {
+    let referenced = references
+        .values()
+        .flatten()
+        .copied()
+        .collect::<HashSet<_>>();
+    let exported = if args.ignore_exported {
+        exported_definitions(graph)
+    } else {
+        HashSet::new()
+    };
+    let definitions = source_nodes(graph, Node::is_definition)
+        .into_iter()
+        .filter(|n| !referenced.contains(n) && !exported.contains(n))
+        .collect::<Vec<_>>();
+    print_definitions(graph, &definitions, args.format)?;
+    if args.format == OutputFormat::Text {
+        println!("{} unreferenced definitions", definitions.len());
+    }
+    Ok(())
+}
+
+/// The unresolved references of a symbol, grouped by file.
+type UnresolvedReferences<'a> = BTreeMap<&'a str, Vec<Handle<Node>>>;
+
+fn report_unresolved(
+    graph: &StackGraph,
+    references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+    args: &UnresolvedArgs,
+) -> anyhow::Result<()> {
+    let mut total = 0;
+    let mut unresolved = BTreeMap::<&str, UnresolvedReferences>::new();
+    for reference in source_nodes(graph, Node::is_reference) {
+        total += 1;
+        if references.get(&reference).map_or(false, |d| !d.is_empty()) {
+            continue;
+        }
+        let symbol = &graph[graph[reference].symbol().unwrap()];
+        let file = graph[graph[reference].file().unwrap()].name();
+        unresolved
+            .entry(symbol)
+            .or_default()
+            .entry(file)
+            .or_default()
+            .push(reference);
+    }
+    let count = |files: &UnresolvedReferences| files.values().map(Vec::len).sum::<usize>();
+    let mut unresolved = unresolved.into_iter().collect::<Vec<_>>();
+    unresolved.sort_by_key(|(_, files)| std::cmp::Reverse(count(files)));
+
+    match args.format {
+        OutputFormat::Text => {
+            let mut unresolved_total = 0;
+            for (symbol, files) in &unresolved {
+                println!("{} ({})", symbol, count(files));
+                for location in node_locations(graph, files.values().flatten().copied()) {
+                    println!("    {}", location);
+                }
+                unresolved_total += count(files);
+            }
+            println!("{} of {} references unresolved", unresolved_total, total);
+        }
+        OutputFormat::Json => {
+            let unresolved = unresolved
+                .iter()
+                .map(|(symbol, files)| {
+                    let references = files
+                        .iter()
+                        .map(|(file, references)| {
+                            let spans = references
+                                .iter()
+                                .filter_map(|n| graph.source_info(*n))
+                                .map(|si| span_json(&si.span))
+                                .collect::<Vec<_>>();
+                            json!({ "file": file, "spans": spans })
+                        })
+                        .collect::<Vec<_>>();
+                    json!({
+                        "symbol": symbol,
+                        "count": count(files),
+                        "references": references,
+                    })
+                })
+                .collect::<Vec<_>>();
+            println!("{}", serde_json::to_string_pretty(&unresolved)?);
+        }
+    }
+    Ok(())
+}
+
+/// Returns the nodes in the graph that match the filter, and have a symbol and a source location,
+/// ordered by file and position.
+fn source_nodes<F>(graph: &StackGraph, filter: F) -> Vec<Handle<Node>>
+where
+    F: Fn(&Node) -> bool,
+{
+    let mut nodes = graph
+        .iter_nodes()
+        .filter(|n| filter(&graph[*n]) && graph[*n].symbol().is_some())
+        .filter_map(|n| {
+            let file = graph[graph[n].file()?].name();
+            let span = &graph.source_info(n)?.span;
+            Some((file, span, n))
+        })
+        .collect::<Vec<_>>();
+    nodes.sort();
+    nodes.into_iter().map(|(_, _, n)| n).collect()
+}
+
+/// Returns the definitions that can be reached from the root node without passing through a push
+/// node.  These are the definitions that other files can refer to.
+fn exported_definitions(graph: &StackGraph) -> HashSet<Handle<Node>> {
+    let root = StackGraph::root_node();
+    let mut seen = HashSet::new();
+    let mut queue = VecDeque::from([root]);
+    let mut definitions = HashSet::new();
+    while let Some(node) = queue.pop_front() {
+        for edge in graph.outgoing_edges(node) {
+            let sink = &graph[edge.sink];
+            if edge.sink == root
+                || matches!(
+                    sink,
+                    Node::JumpTo(_) | Node::PushSymbol(_) | Node::PushScopedSymbol(_)
+                )
+            {
+                continue;
+            }
+            if sink.is_definition() {
+                definitions.insert(edge.sink);
+            }
+            if seen.insert(edge.sink) {
+                queue.push_back(edge.sink);
+            }
+        }
+    }
+    definitions
+}
+
+fn print_definitions(
+    graph: &StackGraph,
+    definitions: &[Handle<Node>],
+    format: OutputFormat,
+) -> anyhow::Result<()> {
+    match format {
+        OutputFormat::Text => {
+            for definition in definitions {
+                let symbol = &graph[graph[*definition].symbol().unwrap()];
+                for location in node_locations(graph, Some(*definition)) {
+                    println!("{} {}", symbol, location);
+                }
+            }
+        }
+        OutputFormat::Json => {
+            let definitions = definitions
+                .iter()
+                .map(|definition| {
+                    json!({
+                        "symbol": graph[*definition].symbol().map(|s| graph[s].to_string()),
+                        "syntax_type": graph
+                            .source_info(*definition)
+                            .and_then(|si| si.syntax_type)
+                            .map(|st| graph[st].to_string()),
+                        "definition": node_location_json(graph, *definition),
+                    })
+                })
+                .collect::<Vec<_>>();
+            println!("{}", serde_json::to_string_pretty(&definitions)?);
+        }
+    }
+    Ok(())
+}
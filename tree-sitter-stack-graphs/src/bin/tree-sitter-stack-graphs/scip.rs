@@ -0,0 +1,266 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use prost::Message;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
+use std::collections::HashMap;
+use std::path::Path;
+
+use crate::export::ExportArgs;
+use crate::lsp::path_to_uri;
+
+const SCHEME: &str = "stack-graphs";
+
+/// The role of an occurrence that defines its symbol.
+const SYMBOL_ROLE_DEFINITION: i32 = 1;
+
+/// Ranges are given as UTF-16 code unit offsets, like in LSP.
+const POSITION_ENCODING_UTF16: i32 = 2;
+
+// The message types below mirror the subset of the SCIP protobuf schema that we produce, using
+// the field numbers of `scip.proto` from https://github.com/sourcegraph/scip.
+
+#[derive(Clone, PartialEq, Message)]
+struct Index {
+    #[prost(message, optional, tag = "1")]
+    metadata: Option<Metadata>,
+    #[prost(message, repeated, tag = "2")]
+    documents: Vec<Document>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct Metadata {
+    #[prost(int32, tag = "1")]
+    version: i32,
+    #[prost(message, optional, tag = "2")]
+    tool_info: Option<ToolInfo>,
+    #[prost(string, tag = "3")]
+    project_root: String,
+    #[prost(int32, tag = "4")]
+    text_document_encoding: i32,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct ToolInfo {
+    #[prost(string, tag = "1")]
+    name: String,
+    #[prost(string, tag = "2")]
+    version: String,
+    #[prost(string, repeated, tag = "3")]
+    arguments: Vec<String>,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct Document {
+    #[prost(string, tag = "1")]
+    relative_path: String,
+    #[prost(message, repeated, tag = "2")]
+    occurrences: Vec<Occurrence>,
+    #[prost(message, repeated, tag = "3")]
+    symbols: Vec<SymbolInformation>,
+    #[prost(int32, tag = "6")]
+    position_encoding: i32,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct Occurrence {
+    /// The start line, start character, end line, and end character of the occurrence.  The end
+    /// line is left out if it is the same as the start line.
+    #[prost(int32, repeated, tag = "1")]
+    range: Vec<i32>,
+    #[prost(string, tag = "2")]
+    symbol: String,
+    #[prost(int32, tag = "3")]
+    symbol_roles: i32,
+}
+
+#[derive(Clone, PartialEq, Message)]
+struct SymbolInformation {
+    #[prost(string, tag = "1")]
+    symbol: String,
+    #[prost(string, tag = "6")]
+    display_name: String,
+}
+
+/// Writes a SCIP index with the definitions in the graph, and the given resolved references.
+///
+/// Every definition gets a global SCIP symbol, which is made up of the path of its file, relative
+/// to the project root, as namespaces, and the symbol of the definition as a term.  Definitions
+/// of the same symbol in the same file are distinguished by a number.
+pub(crate) fn export(
+    graph: &StackGraph,
+    references: &BTreeMap<Handle<Node>, Vec<Handle<Node>>>,
+    args: &ExportArgs,
+) -> anyhow::Result<()> {
+    let project_root = args.project_root()?;
+    let mut documents = BTreeMap::<String, Document>::new();
+
+    // Assign symbols to all definitions first, so that references can be exported in any order.
+    let mut symbols = HashMap::new();
+    let mut definition_counts = HashMap::new();
+    for node in graph.iter_nodes() {
+        if !graph[node].is_definition() {
+            continue;
+        }
+        let (relative_path, symbol) = match (
+            relative_path(graph, node, &project_root),
+            graph[node].symbol(),
+        ) {
+            (Some(relative_path), Some(symbol)) => (relative_path, &graph[symbol]),
+            _ => continue,
+        };
+        let count = definition_counts
+            .entry((relative_path.clone(), symbol))
+            .or_insert(0);
+        *count += 1;
+        let scip_symbol = scip_symbol(&relative_path, symbol, *count);
+        let document = documents
+            .entry(relative_path.clone())
+            .or_insert_with(|| new_document(relative_path));
+        document.symbols.push(SymbolInformation {
+            symbol: scip_symbol.clone(),
+            display_name: symbol.to_string(),
+        });
+        if let Some(range) = range(graph, node) {
+            document.occurrences.push(Occurrence {
+                range,
+                symbol: scip_symbol.clone(),
+                symbol_roles: SYMBOL_ROLE_DEFINITION,
+            });
+        }
+        symbols.insert(node, scip_symbol);
+    }
+
+    for (reference, definitions) in references {
+        let relative_path = match relative_path(graph, *reference, &project_root) {
+            Some(relative_path) => relative_path,
+            None => continue,
+        };
+        let range = match range(graph, *reference) {
+            Some(range) => range,
+            None => continue,
+        };
+        let document = documents
+            .entry(relative_path.clone())
+            .or_insert_with(|| new_document(relative_path));
+        for definition in definitions {
+            if let Some(symbol) = symbols.get(definition) {
+                document.occurrences.push(Occurrence {
+                    range: range.clone(),
+                    symbol: symbol.clone(),
+                    symbol_roles: 0,
+                });
+            }
+        }
+    }
+
+    let index = Index {
+        metadata: Some(Metadata {
+            version: 0,
+            tool_info: Some(ToolInfo {
+                name: env!("CARGO_PKG_NAME").to_string(),
+                version: env!("CARGO_PKG_VERSION").to_string(),
+                arguments: std::env::args().skip(1).collect(),
+            }),
+            project_root: path_to_uri(&project_root.to_string_lossy()),
+            text_document_encoding: 0,
+        }),
+        documents: documents.into_values().collect(),
+    };
+    std::fs::write(&args.output, index.encode_to_vec())
+        .with_context(|| format!("Failed to write {}", args.output.display()))?;
+    println!(
+        "{} documents written to {}",
+        index.documents.len(),
+        args.output.display()
+    );
+    Ok(())
+}
+
+fn new_document(relative_path: String) -> Document {
+    Document {
+        relative_path,
+        occurrences: Vec::new(),
+        symbols: Vec::new(),
+        position_encoding: POSITION_ENCODING_UTF16,
+    }
+}
+
+/// Returns the path of the file of a node relative to the project root, using `/` as separator.
+/// Nodes in files outside of the project root, such as builtins, have no relative path.
+pub(crate) fn relative_path(
+    graph: &StackGraph,
+    node: Handle<Node>,
+    project_root: &Path,
+) -> Option<String> {
+    relative_file_path(graph, graph[node].file()?, project_root)
+}
+
+/// Returns the path of a file relative to the project root, using `/` as separator, or `None` if
+/// the file is outside of the project root.
+pub(crate) fn relative_file_path(
+    graph: &StackGraph,
+    file: Handle<File>,
+    project_root: &Path,
+) -> Option<String> {
+    let relative_path = Path::new(graph[file].name())
+        .strip_prefix(project_root)
+        .ok()?;
+    let components = relative_path
+        .components()
+        .map(|c| c.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>();
+    Some(components.join("/"))
+}
+
+fn range(graph: &StackGraph, node: Handle<Node>) -> Option<Vec<i32>> {
+    let span = &graph.source_info(node)?.span;
+    let start_line = span.start.line as i32;
+    let start_character = span.start.column.utf16_offset as i32;
+    let end_line = span.end.line as i32;
+    let end_character = span.end.column.utf16_offset as i32;
+    if start_line == end_line {
+        Some(vec![start_line, start_character, end_character])
+    } else {
+        Some(vec![start_line, start_character, end_line, end_character])
+    }
+}
+
+/// Returns the SCIP symbol of the `count`th definition of a symbol in a file.  The package
+/// manager, name, and version are not known, and are left empty.
+fn scip_symbol(relative_path: &str, symbol: &str, count: usize) -> String {
+    let mut result = format!("{} . . . ", SCHEME);
+    for component in relative_path.split('/') {
+        result.push_str(&escape_name(component));
+        result.push('/');
+    }
+    result.push_str(&escape_name(symbol));
+    if count > 1 {
+        result.push_str(&format!("({})", count));
+    }
+    result.push('.');
+    result
+}
+
+/// Escapes a name in a SCIP symbol.  Names that contain characters other than identifier
+/// characters are surrounded by backticks, in which backticks are doubled.
+fn escape_name(name: &str) -> String {
+    if !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_alphanumeric() || c == '_' || c == '+' || c == '-' || c == '$')
+    {
+        name.to_string()
+    } else {
+        format!("`{}`", name.replace('`', "``"))
+    }
+}
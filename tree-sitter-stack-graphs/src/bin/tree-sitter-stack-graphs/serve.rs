@@ -0,0 +1,365 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use anyhow::Context as _;
+use lsp_positions::Position;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::proto;
+use stack_graphs::storage::FileStatus;
+use stack_graphs::storage::SQLiteReader;
+use std::collections::BTreeMap;
+use std::net::SocketAddr;
+use std::net::TcpListener;
+#[cfg(unix)]
+use std::os::unix::fs::FileTypeExt as _;
+#[cfg(unix)]
+use std::os::unix::net::UnixListener;
+use std::path::PathBuf;
+use std::sync::Arc;
+use std::sync::Mutex;
+use std::time::Duration;
+
+use crate::database::DatabaseArgs;
+use crate::grpc;
+use crate::http;
+use crate::index;
+use crate::index::files_with_changed_rules;
+use crate::index::IndexThreads;
+use crate::index::IndexTotals;
+use crate::loader::LoaderArgs;
+use crate::query::find_incoming_calls;
+use crate::query::find_outgoing_calls;
+use crate::query::find_results;
+use crate::query::find_target_definitions;
+use crate::query::SourcePosition;
+
+/// Run a server that indexes files and answers queries
+///
+/// The server keeps running, so that clients, such as CI systems and web backends, can index
+/// files and query the database without starting a new process for every request.  Graphs and
+/// paths that are loaded from the database for a query are kept in memory for later queries,
+/// until the files they were loaded from change, and the stack graph construction rules that are
+/// loaded for an indexing request are kept for later requests, until they change.  The server checks regularly, and before every indexing request,
+/// whether the stack graph construction rules changed, and if they did, indexes the files in the
+/// database that were indexed with the old rules again.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    loader: LoaderArgs,
+
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Serve gRPC requests on the given address, e.g., 127.0.0.1:50051.  The service is defined
+    /// in proto/query_service.proto.
+    #[clap(long, value_name = "ADDRESS", required_unless_present_any = &["http", "socket"])]
+    grpc: Option<SocketAddr>,
+
+    /// Serve HTTP requests on the given address, e.g., 127.0.0.1:8080.  The /definition,
+    /// /references, /incoming-calls, and /outgoing-calls endpoints take path, line, and col
+    /// parameters, and the /files endpoint lists the status of the files in the database.  All
+    /// endpoints return JSON.
+    #[clap(long, value_name = "ADDRESS")]
+    http: Option<SocketAddr>,
+
+    /// Serve HTTP requests on a Unix domain socket at the given path, with the same endpoints as
+    /// --http.  This is the fastest way for local tools to query the database, and access is
+    /// controlled by the permissions of the socket.  A socket left behind by an earlier server is
+    /// replaced.  Only supported on Unix.
+    #[clap(long, value_name = "SOCKET_PATH")]
+    socket: Option<PathBuf>,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        // Create a loader up front, so that configuration errors are reported before any request
+        // is accepted.
+        self.loader.new_loader()?;
+        let service = Arc::new(QueryService {
+            loader: self.loader.clone(),
+            database: self.database.clone(),
+            rules_hash: Mutex::new(self.loader.rules_hash()?),
+            index_threads: Arc::new(IndexThreads::new()),
+            readers: ReaderPool::new(self.database.clone()),
+        });
+        {
+            let service = service.clone();
+            std::thread::spawn(move || loop {
+                std::thread::sleep(RULES_CHECK_INTERVAL);
+                if let Err(err) = service.reindex_if_rules_changed() {
+                    log::warn!("Failed to index files with changed rules: {:#}", err);
+                }
+            });
+        }
+        let mut http_servers = Vec::new();
+        if let Some(address) = self.http {
+            let listener = TcpListener::bind(address)
+                .with_context(|| format!("Failed to listen on {}", address))?;
+            println!("Serving HTTP requests on {}", address);
+            let service = service.clone();
+            http_servers.push(std::thread::spawn(move || http::serve(service, listener)));
+        }
+        #[cfg(not(unix))]
+        if self.socket.is_some() {
+            return Err(anyhow!(
+                "Unix domain sockets are not supported on this platform"
+            ));
+        }
+        #[cfg(unix)]
+        if let Some(path) = &self.socket {
+            let listener = bind_socket(path)?;
+            println!("Serving HTTP requests on {}", path.display());
+            let service = service.clone();
+            http_servers.push(std::thread::spawn(move || {
+                http::serve_unix(service, listener)
+            }));
+        }
+        if let Some(address) = self.grpc {
+            let runtime = tokio::runtime::Builder::new_multi_thread()
+                .enable_all()
+                .build()?;
+            println!("Serving gRPC requests on {}", address);
+            runtime.block_on(grpc::serve(service, address))?;
+        }
+        for http_server in http_servers {
+            http_server
+                .join()
+                .map_err(|_| anyhow!("HTTP server panicked"))??;
+        }
+        Ok(())
+    }
+}
+
+/// How often the server checks whether the stack graph construction rules changed.
+const RULES_CHECK_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Binds a Unix domain socket at the given path, removing a socket that an earlier server left
+/// behind.  Other files are never removed.
+#[cfg(unix)]
+fn bind_socket(path: &std::path::Path) -> anyhow::Result<UnixListener> {
+    if let Ok(metadata) = std::fs::symlink_metadata(path) {
+        if metadata.file_type().is_socket() {
+            std::fs::remove_file(path)
+                .with_context(|| format!("Failed to remove {}", path.display()))?;
+        }
+    }
+    UnixListener::bind(path).with_context(|| format!("Failed to listen on {}", path.display()))
+}
+
+/// Indexes files and answers queries on behalf of the server.  Queries are answered concurrently,
+/// but only one indexing request is handled at a time, because they write to the database.
+/// Queries can be answered while an indexing request is writing to the database.
+pub(crate) struct QueryService {
+    loader: LoaderArgs,
+    database: DatabaseArgs,
+    /// The hash of the stack graph construction rules that the files in the database were indexed
+    /// with.  Its lock is held while indexing.
+    rules_hash: Mutex<String>,
+    /// The threads that index files, which keep the loaded rules between indexing requests.
+    index_threads: Arc<IndexThreads>,
+    readers: ReaderPool,
+}
+
+impl QueryService {
+    /// Indexes the given files and directories.  Files that have not changed since they were
+    /// last indexed are skipped, unless `force` is set.  If the rules changed, the files that were
+    /// indexed with the old rules are indexed first.
+    pub(crate) fn index(&self, paths: Vec<PathBuf>, force: bool) -> anyhow::Result<IndexTotals> {
+        let mut rules_hash = self.rules_hash.lock().unwrap();
+        self.reindex_with_changed_rules(&mut rules_hash)?;
+        self.index_command(paths, force).index()
+    }
+
+    /// Indexes the files that were indexed with other stack graph construction rules again, if
+    /// the rules changed.
+    fn reindex_if_rules_changed(&self) -> anyhow::Result<()> {
+        let mut rules_hash = self.rules_hash.lock().unwrap();
+        self.reindex_with_changed_rules(&mut rules_hash)
+    }
+
+    fn reindex_with_changed_rules(&self, rules_hash: &mut String) -> anyhow::Result<()> {
+        let new_rules_hash = self.loader.rules_hash()?;
+        if new_rules_hash == *rules_hash {
+            return Ok(());
+        }
+        println!("Stack graph construction rules changed, indexing files again");
+        let paths = files_with_changed_rules(&self.loader, &self.database)?;
+        if !paths.is_empty() {
+            self.index_command(paths, false).index()?;
+        }
+        *rules_hash = new_rules_hash;
+        Ok(())
+    }
+
+    fn index_command(&self, paths: Vec<PathBuf>, force: bool) -> index::Command {
+        index::Command::new(self.loader.clone(), self.database.clone(), paths, force)
+            .with_index_threads(self.index_threads.clone())
+    }
+
+    /// Finds the definitions of the references at a position, or the references to the
+    /// definitions at the position, grouped by reference.  The definitions of every reference are
+    /// ordered by descending ranking score.  Shadowed results are left out, as are nodes without
+    /// source information.
+    pub(crate) fn find(
+        &self,
+        position: &SourcePosition,
+        find_references: bool,
+    ) -> anyhow::Result<Vec<proto::QueryResult>> {
+        let (path, source_position) = read_position(position)?;
+        let mut reader = self.readers.get()?;
+        let results =
+            find_results(&mut reader, &path, source_position, find_references)?.unwrap_or_default();
+        let (graph, _, _) = reader.get();
+
+        let mut definitions_by_reference =
+            BTreeMap::<Handle<Node>, Vec<(Handle<Node>, f64)>>::new();
+        for result in results.iter().filter(|r| !r.shadowed) {
+            definitions_by_reference
+                .entry(result.reference)
+                .or_default()
+                .push((result.definition, result.score));
+        }
+        let results = definitions_by_reference
+            .into_iter()
+            .filter_map(|(reference, mut definitions)| {
+                definitions.sort_by(|(_, a), (_, b)| b.total_cmp(a));
+                let (definitions, scores) = definitions
+                    .into_iter()
+                    .filter_map(|(definition, score)| {
+                        Some((node_location(graph, definition)?, score))
+                    })
+                    .unzip();
+                Some(proto::QueryResult {
+                    reference: Some(node_location(graph, reference)?),
+                    definitions,
+                    scores,
+                })
+            })
+            .collect();
+        self.readers.put(reader);
+        Ok(results)
+    }
+
+    /// Finds the calls to the definitions at a position, or to the definitions of the reference at
+    /// the position, if `incoming` is set, and the calls that they make otherwise.  Every call
+    /// site is returned as the reference of a result.  Its definition is the definition that makes
+    /// the call, for incoming calls, or the definition that is called, for outgoing calls.  Call
+    /// sites outside of any definition have no calling definition.  The results have no ranking
+    /// scores.
+    pub(crate) fn calls(
+        &self,
+        position: &SourcePosition,
+        incoming: bool,
+    ) -> anyhow::Result<Vec<proto::QueryResult>> {
+        let (path, source_position) = read_position(position)?;
+        let mut reader = self.readers.get()?;
+        let definitions =
+            find_target_definitions(&mut reader, &path, source_position)?.unwrap_or_default();
+        let mut calls = Vec::new();
+        for definition in definitions {
+            if incoming {
+                calls.extend(find_incoming_calls(&mut reader, definition)?);
+            } else {
+                calls.extend(find_outgoing_calls(&mut reader, definition)?);
+            }
+        }
+        let (graph, _, _) = reader.get();
+
+        let mut results = Vec::new();
+        for call in calls {
+            let definition = if incoming {
+                call.caller
+            } else {
+                Some(call.callee)
+            };
+            for call_site in call.call_sites {
+                let reference = match node_location(graph, call_site) {
+                    Some(reference) => reference,
+                    None => continue,
+                };
+                results.push(proto::QueryResult {
+                    reference: Some(reference),
+                    definitions: definition
+                        .and_then(|d| node_location(graph, d))
+                        .into_iter()
+                        .collect(),
+                    scores: Vec::new(),
+                });
+            }
+        }
+        self.readers.put(reader);
+        Ok(results)
+    }
+
+    /// Returns the status of all files in the database.
+    pub(crate) fn status(&self) -> anyhow::Result<Vec<FileStatus>> {
+        let reader = self.readers.get()?;
+        let status = reader.status_all()?;
+        self.readers.put(reader);
+        Ok(status)
+    }
+}
+
+/// A pool of database readers, which are reused across queries, so that queries do not have to
+/// open the database, and load graphs and paths that earlier queries loaded already.  Readers
+/// discard their data when any of the files they loaded was changed since they were last used.
+struct ReaderPool {
+    database: DatabaseArgs,
+    idle: Mutex<Vec<SQLiteReader>>,
+}
+
+impl ReaderPool {
+    /// The maximum number of idle readers that are kept.  Readers that are returned to a full
+    /// pool are closed, which bounds the memory used for loaded data.
+    const MAX_IDLE: usize = 4;
+
+    fn new(database: DatabaseArgs) -> ReaderPool {
+        ReaderPool {
+            database,
+            idle: Mutex::new(Vec::new()),
+        }
+    }
+
+    /// Returns an idle reader, or opens a new one if there is none.
+    fn get(&self) -> anyhow::Result<SQLiteReader> {
+        let reader = self.idle.lock().unwrap().pop();
+        match reader {
+            Some(mut reader) => {
+                reader.clear_if_changed()?;
+                Ok(reader)
+            }
+            None => self.database.open_reader(),
+        }
+    }
+
+    /// Returns a reader to the pool after a query that succeeded.
+    fn put(&self, reader: SQLiteReader) {
+        let mut idle = self.idle.lock().unwrap();
+        if idle.len() < Self::MAX_IDLE {
+            idle.push(reader);
+        }
+    }
+}
+
+/// Returns the canonical file name of a source position, which is the name it is stored under in
+/// the database, and the position in the content of the file.
+fn read_position(position: &SourcePosition) -> anyhow::Result<(String, Position)> {
+    let path = position.canonical_path()?;
+    let source = std::fs::read_to_string(&path)
+        .with_context(|| format!("Failed to read {}", position.path.display()))?;
+    let source_position = position.to_position(&source)?;
+    Ok((path.to_string_lossy().to_string(), source_position))
+}
+
+fn node_location(graph: &StackGraph, node: Handle<Node>) -> Option<proto::Location> {
+    let file = graph[node].file()?;
+    let source_info = graph.source_info(node)?;
+    Some(proto::Location::new(graph[file].name(), &source_info.span))
+}
@@ -0,0 +1,203 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use serde_json::json;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::File;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use stack_graphs::graph::Symbol;
+use std::collections::BTreeMap;
+use std::collections::HashSet;
+
+use crate::database::DatabaseArgs;
+use crate::query::node_location_json;
+use crate::query::node_locations;
+use crate::OutputFormat;
+
+/// Show statistics about the stack graphs in a database
+///
+/// The statistics show how the graph construction rules scale: the number of nodes of every kind,
+/// the number of edges, and the number of distinct symbols, for every file and in total, followed
+/// by the largest files and the scopes with the most outgoing edges.
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Number of largest files and scopes to show.
+    #[clap(long, default_value = "10")]
+    top: usize,
+
+    /// Output format.  The text format shows one line per file.  The JSON format includes the
+    /// node counts by kind for every file, and the location of the largest scopes.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let mut reader = self.database.open_reader()?;
+        reader.load_all()?;
+        let (graph, _, _) = reader.get();
+
+        let mut files = graph
+            .iter_files()
+            .map(|file| (file, GraphStats::for_file(graph, file)))
+            .collect::<Vec<_>>();
+        files.sort_by(|(a, _), (b, _)| graph[*a].name().cmp(graph[*b].name()));
+        let mut total = GraphStats::default();
+        for (_, stats) in &files {
+            total.add(stats);
+        }
+
+        let mut largest_files = files.iter().collect::<Vec<_>>();
+        largest_files.sort_by_key(|(_, stats)| std::cmp::Reverse(stats.node_count));
+        largest_files.truncate(self.top);
+        let largest_scopes = largest_scopes(graph, self.top);
+
+        match self.format {
+            OutputFormat::Text => {
+                for (file, stats) in &files {
+                    println!("{}: {}", graph[*file], stats.summary());
+                }
+                println!("total: {}", total.summary());
+                for (kind, count) in &total.nodes_by_kind {
+                    println!("    {} {} nodes", count, kind);
+                }
+                println!("largest files:");
+                for (file, stats) in &largest_files {
+                    println!("    {} {} nodes", graph[*file], stats.node_count);
+                }
+                println!("largest scopes:");
+                for (scope, edge_count) in &largest_scopes {
+                    let location = node_locations(graph, Some(*scope))
+                        .into_iter()
+                        .next()
+                        .unwrap_or_else(|| graph[*scope].display(graph).to_string());
+                    println!("    {} {} edges", location, edge_count);
+                }
+            }
+            OutputFormat::Json => {
+                let stats = json!({
+                    "files": files
+                        .iter()
+                        .map(|(file, stats)| {
+                            let mut json = stats.to_json();
+                            json["file"] = json!(graph[*file].name());
+                            json
+                        })
+                        .collect::<Vec<_>>(),
+                    "total": total.to_json(),
+                    "largest_files": largest_files
+                        .iter()
+                        .map(|(file, stats)| {
+                            json!({
+                                "file": graph[*file].name(),
+                                "nodes": stats.node_count,
+                            })
+                        })
+                        .collect::<Vec<_>>(),
+                    "largest_scopes": largest_scopes
+                        .iter()
+                        .map(|(scope, edge_count)| {
+                            json!({
+                                "scope": node_location_json(graph, *scope),
+                                "local_id": graph[*scope].id().local_id(),
+                                "edges": edge_count,
+                            })
+                        })
+                        .collect::<Vec<_>>(),
+                });
+                println!("{}", serde_json::to_string_pretty(&stats)?);
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Node, edge, and symbol counts of a file, or of all files together.
+#[derive(Default)]
+struct GraphStats {
+    node_count: usize,
+    nodes_by_kind: BTreeMap<&'static str, usize>,
+    edge_count: usize,
+    symbols: HashSet<Handle<Symbol>>,
+}
+
+impl GraphStats {
+    /// Counts the nodes that belong to the file, and the edges that start at them.
+    fn for_file(graph: &StackGraph, file: Handle<File>) -> GraphStats {
+        let mut stats = GraphStats::default();
+        for node in graph.nodes_for_file(file) {
+            stats.node_count += 1;
+            *stats
+                .nodes_by_kind
+                .entry(node_kind(&graph[node]))
+                .or_default() += 1;
+            stats.edge_count += graph.outgoing_edges(node).count();
+            if let Some(symbol) = graph[node].symbol() {
+                stats.symbols.insert(symbol);
+            }
+        }
+        stats
+    }
+
+    fn add(&mut self, other: &GraphStats) {
+        self.node_count += other.node_count;
+        for (kind, count) in &other.nodes_by_kind {
+            *self.nodes_by_kind.entry(*kind).or_default() += count;
+        }
+        self.edge_count += other.edge_count;
+        self.symbols.extend(other.symbols.iter().copied());
+    }
+
+    fn summary(&self) -> String {
+        format!(
+            "{} nodes, {} edges, {} symbols",
+            self.node_count,
+            self.edge_count,
+            self.symbols.len()
+        )
+    }
+
+    fn to_json(&self) -> serde_json::Value {
+        json!({
+            "nodes": self.node_count,
+            "nodes_by_kind": self.nodes_by_kind,
+            "edges": self.edge_count,
+            "symbols": self.symbols.len(),
+        })
+    }
+}
+
+/// Returns the kind of a node, as it is named in the graph DSL.
+pub(crate) fn node_kind(node: &Node) -> &'static str {
+    match node {
+        Node::DropScopes(_) => "drop_scopes",
+        Node::JumpTo(_) => "jump_to",
+        Node::PopScopedSymbol(_) => "pop_scoped_symbol",
+        Node::PopSymbol(_) => "pop_symbol",
+        Node::PushScopedSymbol(_) => "push_scoped_symbol",
+        Node::PushSymbol(_) => "push_symbol",
+        Node::Root(_) => "root",
+        Node::Scope(_) => "scope",
+    }
+}
+
+/// Returns the scope nodes with the most outgoing edges, which are the most expensive to visit
+/// during path finding, largest first.
+fn largest_scopes(graph: &StackGraph, top: usize) -> Vec<(Handle<Node>, usize)> {
+    let mut scopes = graph
+        .iter_nodes()
+        .filter(|n| matches!(graph[*n], Node::Scope(_)))
+        .map(|n| (n, graph.outgoing_edges(n).count()))
+        .collect::<Vec<_>>();
+    scopes.sort_by_key(|(n, edge_count)| (std::cmp::Reverse(*edge_count), *n));
+    scopes.truncate(top);
+    scopes
+}
@@ -0,0 +1,223 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use colored::Colorize as _;
+use serde_json::json;
+use stack_graphs::storage::FileCoverage;
+use stack_graphs::storage::FileStatus;
+use std::time::SystemTime;
+use std::time::UNIX_EPOCH;
+
+use crate::database::DatabaseArgs;
+use crate::OutputFormat;
+
+/// Show the status of the files in a database
+#[derive(clap::Parser)]
+pub struct Command {
+    #[clap(flatten)]
+    database: DatabaseArgs,
+
+    /// Only show files that failed to index.
+    #[clap(long)]
+    failures_only: bool,
+
+    /// Show how well the references in every file resolve, and how many definitions it contains
+    /// per thousand lines, instead of the indexing status, followed by the totals over all files.
+    /// The resolved references are only known for files that were indexed with --resolve.
+    #[clap(long, conflicts_with = "failures_only")]
+    coverage: bool,
+
+    /// Output format.  The JSON format lists the status of every file, including the phase,
+    /// class, message, and location of failures.  With --coverage, it lists the coverage of every
+    /// file, and the totals.
+    #[clap(long, arg_enum, default_value = "text")]
+    format: OutputFormat,
+}
+
+impl Command {
+    pub fn run(&self) -> anyhow::Result<()> {
+        let db = self.database.open_reader()?;
+        if self.coverage {
+            return self.print_coverage(db.coverage_all()?);
+        }
+        if self.format == OutputFormat::Json {
+            let statuses = db
+                .status_all()?
+                .iter()
+                .filter(|s| !self.failures_only || !s.is_success())
+                .map(status_json)
+                .collect::<Vec<_>>();
+            println!("{}", serde_json::to_string_pretty(&statuses)?);
+            return Ok(());
+        }
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map(|d| d.as_secs())
+            .unwrap_or_default();
+        let mut indexed = 0;
+        let mut failed = 0;
+        for status in db.status_all()? {
+            if status.is_success() {
+                indexed += 1;
+                if !self.failures_only {
+                    self.print_success(&status, now);
+                }
+            } else {
+                failed += 1;
+                self.print_failure(&status, now);
+            }
+        }
+        println!("{} indexed, {} failed", indexed, failed);
+        Ok(())
+    }
+
+    fn print_coverage(&self, coverage: Vec<FileCoverage>) -> anyhow::Result<()> {
+        let total = total_coverage(&coverage);
+        match self.format {
+            OutputFormat::Text => {
+                for file in &coverage {
+                    println!("{}: {}", file.path, format_coverage(file));
+                }
+                println!("total: {}", format_coverage(&total));
+                let unresolved = coverage
+                    .iter()
+                    .filter(|c| c.resolved_count.is_none())
+                    .count();
+                if unresolved > 0 {
+                    println!(
+                        "{} files have no stored resolutions, index them with --resolve",
+                        unresolved
+                    );
+                }
+            }
+            OutputFormat::Json => {
+                let coverage = json!({
+                    "files": coverage.iter().map(coverage_json).collect::<Vec<_>>(),
+                    "total": coverage_json(&total),
+                });
+                println!("{}", serde_json::to_string_pretty(&coverage)?);
+            }
+        }
+        Ok(())
+    }
+
+    fn print_success(&self, status: &FileStatus, now: u64) {
+        println!(
+            "{} {}: {} nodes, {} paths, indexed {} ({})",
+            "✓".green(),
+            status.path,
+            status.node_count,
+            status.path_count,
+            format_age(now, status.indexed_at),
+            status.info,
+        );
+    }
+
+    fn print_failure(&self, status: &FileStatus, now: u64) {
+        let failure = match &status.error {
+            Some(failure) => failure,
+            None => return,
+        };
+        println!(
+            "{} {}{}: {} while {}, {}: {}",
+            "✗".red(),
+            status.path,
+            failure
+                .location
+                .as_ref()
+                .map_or(String::default(), |l| format!(":{}", l)),
+            failure.kind,
+            failure.phase,
+            format_age(now, status.indexed_at),
+            failure.message,
+        );
+    }
+}
+
+pub(crate) fn status_json(status: &FileStatus) -> serde_json::Value {
+    let failure = status.error.as_ref().map(|failure| {
+        json!({
+            "phase": failure.phase,
+            "kind": failure.kind,
+            "message": failure.message,
+            "location": failure.location,
+        })
+    });
+    json!({
+        "path": status.path,
+        "project": status.project,
+        "tag": status.tag,
+        "indexed_at": status.indexed_at,
+        "info": status.info,
+        "node_count": status.node_count,
+        "path_count": status.path_count,
+        "failure": failure,
+    })
+}
+
+/// Returns the sum of the coverage of all files.  The number of resolved references is only known
+/// if it is known for every file.
+fn total_coverage(coverage: &[FileCoverage]) -> FileCoverage {
+    FileCoverage {
+        path: String::new(),
+        reference_count: coverage.iter().map(|c| c.reference_count).sum(),
+        resolved_count: coverage.iter().map(|c| c.resolved_count).sum(),
+        definition_count: coverage.iter().map(|c| c.definition_count).sum(),
+        line_count: coverage.iter().map(|c| c.line_count).sum(),
+    }
+}
+
+fn format_coverage(coverage: &FileCoverage) -> String {
+    let references = match (coverage.resolved_count, coverage.resolved_percentage()) {
+        (Some(resolved_count), Some(percentage)) => format!(
+            "{} of {} references resolved ({:.1}%)",
+            resolved_count, coverage.reference_count, percentage
+        ),
+        _ => format!("{} references", coverage.reference_count),
+    };
+    let definitions = match coverage.definitions_per_kloc() {
+        Some(definitions_per_kloc) => format!(
+            "{} definitions ({:.1} per KLoC)",
+            coverage.definition_count, definitions_per_kloc
+        ),
+        None => format!("{} definitions", coverage.definition_count),
+    };
+    format!("{}, {}", references, definitions)
+}
+
+fn coverage_json(coverage: &FileCoverage) -> serde_json::Value {
+    json!({
+        "path": coverage.path,
+        "reference_count": coverage.reference_count,
+        "resolved_count": coverage.resolved_count,
+        "resolved_percentage": coverage.resolved_percentage(),
+        "definition_count": coverage.definition_count,
+        "line_count": coverage.line_count,
+        "definitions_per_kloc": coverage.definitions_per_kloc(),
+    })
+}
+
+/// Formats the time between two timestamps, given in seconds since the Unix epoch, in the largest
+/// unit that fits.
+fn format_age(now: u64, then: u64) -> String {
+    let age = now.saturating_sub(then);
+    let (amount, unit) = if age < 60 {
+        (age, "second")
+    } else if age < 60 * 60 {
+        (age / 60, "minute")
+    } else if age < 24 * 60 * 60 {
+        (age / (60 * 60), "hour")
+    } else {
+        (age / (24 * 60 * 60), "day")
+    };
+    format!(
+        "{} {}{} ago",
+        amount,
+        unit,
+        if amount == 1 { "" } else { "s" }
+    )
+}
@@ -0,0 +1,181 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::anyhow;
+use anyhow::Context as _;
+use clap::Args;
+use clap::ValueHint;
+use lsp_positions::Span;
+use serde_json::json;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Edge;
+use stack_graphs::graph::File;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::HashMap;
+use std::collections::HashSet;
+use std::path::Path;
+use std::path::PathBuf;
+
+use crate::export::ExportArgs;
+use crate::scip::relative_file_path;
+use crate::stats::node_kind;
+
+#[derive(Args)]
+pub(crate) struct SubgraphArgs {
+    #[clap(flatten)]
+    pub(crate) export: ExportArgs,
+
+    /// Only export the nodes of the given source file, and the edges between them, which keeps
+    /// the exported graph small.  Can be given multiple times.  Defaults to all files in the
+    /// database.
+    #[clap(long = "file", value_name = "SOURCE_PATH", value_hint = ValueHint::FilePath, parse(from_os_str))]
+    pub(crate) files: Vec<PathBuf>,
+}
+
+/// The nodes and edges of a stack graph that are exported.
+pub(crate) struct Subgraph {
+    /// The nodes, ordered by file and local ID.  The root and jump to scope nodes are only
+    /// included if they are connected to any of the other nodes.
+    pub(crate) nodes: Vec<Handle<Node>>,
+    /// The edges between the nodes, ordered by source and sink.
+    pub(crate) edges: Vec<Edge>,
+}
+
+impl Subgraph {
+    pub(crate) fn new(graph: &StackGraph, args: &SubgraphArgs) -> anyhow::Result<Subgraph> {
+        let files = args
+            .files
+            .iter()
+            .map(|path| {
+                let path = std::fs::canonicalize(path)
+                    .with_context(|| format!("Failed to resolve {}", path.display()))?;
+                graph
+                    .get_file(&path.to_string_lossy())
+                    .ok_or_else(|| anyhow!("{} is not in the database", path.display()))
+            })
+            .collect::<anyhow::Result<HashSet<Handle<File>>>>()?;
+        let in_files = |node: Handle<Node>| match graph[node].file() {
+            Some(file) => files.is_empty() || files.contains(&file),
+            None => false,
+        };
+        let is_singleton = |node: Handle<Node>| graph[node].file().is_none();
+        let included = |node: Handle<Node>| in_files(node) || is_singleton(node);
+
+        let mut nodes = graph
+            .iter_nodes()
+            .filter(|n| in_files(*n))
+            .collect::<Vec<_>>();
+        let mut edges = Vec::new();
+        let mut singletons = HashSet::new();
+        for source in graph.iter_nodes() {
+            for edge in graph.outgoing_edges(source) {
+                if !included(edge.source) || !included(edge.sink) {
+                    continue;
+                }
+                for node in [edge.source, edge.sink] {
+                    if is_singleton(node) {
+                        singletons.insert(node);
+                    }
+                }
+                edges.push(edge);
+            }
+        }
+        nodes.extend(singletons);
+
+        let key = |node: Handle<Node>| {
+            let id = graph[node].id();
+            (id.file().map(|f| graph[f].name()), id.local_id())
+        };
+        nodes.sort_by(|a, b| key(*a).cmp(&key(*b)));
+        edges.sort_by(|a, b| (key(a.source), key(a.sink)).cmp(&(key(b.source), key(b.sink))));
+        Ok(Subgraph { nodes, edges })
+    }
+
+    /// Returns identifiers for the nodes, which are unique within the subgraph.
+    pub(crate) fn node_ids(&self) -> HashMap<Handle<Node>, String> {
+        self.nodes
+            .iter()
+            .enumerate()
+            .map(|(i, node)| (*node, format!("n{}", i)))
+            .collect()
+    }
+}
+
+/// Returns a short description of a node, with its kind and symbol, but without its file and
+/// local ID.
+pub(crate) fn node_label(graph: &StackGraph, node: Handle<Node>) -> String {
+    match &graph[node] {
+        Node::DropScopes(_) => "drop scopes".to_string(),
+        Node::JumpTo(_) => "jump to scope".to_string(),
+        Node::PopScopedSymbol(node) if node.is_definition => {
+            format!("definition {}()", graph[node.symbol])
+        }
+        Node::PopScopedSymbol(node) => format!("pop {}()", graph[node.symbol]),
+        Node::PopSymbol(node) if node.is_definition => format!("definition {}", graph[node.symbol]),
+        Node::PopSymbol(node) => format!("pop {}", graph[node.symbol]),
+        Node::PushScopedSymbol(node) if node.is_reference => {
+            format!("reference {}()", graph[node.symbol])
+        }
+        Node::PushScopedSymbol(node) => format!("push {}()", graph[node.symbol]),
+        Node::PushSymbol(node) if node.is_reference => format!("reference {}", graph[node.symbol]),
+        Node::PushSymbol(node) => format!("push {}", graph[node.symbol]),
+        Node::Root(_) => "root".to_string(),
+        Node::Scope(node) if node.is_exported => "exported scope".to_string(),
+        Node::Scope(_) => "scope".to_string(),
+    }
+}
+
+/// Returns the properties of a node, as name and value pairs.  Properties that do not apply to
+/// the node, or are not known, are left out.  Files are named relative to the project root, if
+/// they are inside of it.
+pub(crate) fn node_properties(
+    graph: &StackGraph,
+    node: Handle<Node>,
+    project_root: &Path,
+) -> Vec<(&'static str, serde_json::Value)> {
+    let mut properties = vec![
+        ("kind", json!(node_kind(&graph[node]))),
+        ("label", json!(node_label(graph, node))),
+    ];
+    if let Some(symbol) = graph[node].symbol() {
+        properties.push(("symbol", json!(&graph[symbol])));
+    }
+    let id = graph[node].id();
+    if let Some(file) = id.file() {
+        let name = relative_file_path(graph, file, project_root)
+            .unwrap_or_else(|| graph[file].name().to_string());
+        properties.push(("file", json!(name)));
+    }
+    properties.push(("local_id", json!(id.local_id())));
+    if let Some(source_info) = graph.source_info(node) {
+        if source_info.span != Span::default() {
+            let start = &source_info.span.start;
+            properties.push(("line", json!(start.line + 1)));
+            properties.push(("column", json!(start.column.grapheme_offset + 1)));
+        }
+        if let Some(syntax_type) = source_info.syntax_type {
+            properties.push(("syntax_type", json!(&graph[syntax_type])));
+        }
+        if source_info.definiens_span != Span::default() {
+            let start = &source_info.definiens_span.start;
+            properties.push(("definiens_line", json!(start.line + 1)));
+            properties.push(("definiens_column", json!(start.column.grapheme_offset + 1)));
+        }
+    }
+    match &graph[node] {
+        Node::PopScopedSymbol(node) => {
+            properties.push(("is_definition", json!(node.is_definition)))
+        }
+        Node::PopSymbol(node) => properties.push(("is_definition", json!(node.is_definition))),
+        Node::PushScopedSymbol(node) => properties.push(("is_reference", json!(node.is_reference))),
+        Node::PushSymbol(node) => properties.push(("is_reference", json!(node.is_reference))),
+        Node::Scope(node) => properties.push(("is_exported", json!(node.is_exported))),
+        _ => {}
+    }
+    properties
+}
@@ -0,0 +1,164 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use stack_graphs::arena::Handle;
+use stack_graphs::graph::Node;
+use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
+use std::collections::BTreeSet;
+use std::io::BufWriter;
+use std::io::Write;
+use std::path::Path;
+
+use crate::export::ExportArgs;
+use crate::scip::relative_path;
+
+/// A definition that is written to a tags file.
+#[derive(Eq, Ord, PartialEq, PartialOrd)]
+struct Tag {
+    name: String,
+    relative_path: String,
+    /// The 0-based line of the definition.
+    line: usize,
+    /// The byte offset of the start of the line in the file.
+    line_offset: usize,
+    /// The text of the line up to and including the definition, if it is known.
+    pattern: Option<String>,
+    kind: Option<String>,
+}
+
+/// Returns the definitions in the graph that have a name and a location in the project, sorted by
+/// name, file, and line.  Names that contain tabs or line breaks cannot be represented in tags
+/// files, and are skipped.
+fn collect_tags(graph: &StackGraph, args: &ExportArgs) -> anyhow::Result<BTreeSet<Tag>> {
+    let project_root = args.project_root()?;
+    let mut tags = BTreeSet::new();
+    for node in graph.iter_nodes() {
+        if !graph[node].is_definition() {
+            continue;
+        }
+        if let Some(tag) = tag(graph, node, &project_root) {
+            tags.insert(tag);
+        }
+    }
+    Ok(tags)
+}
+
+fn tag(graph: &StackGraph, node: Handle<Node>, project_root: &Path) -> Option<Tag> {
+    let name = &graph[graph[node].symbol()?];
+    if name.contains(|c| c == '\t' || c == '\n' || c == '\r') {
+        return None;
+    }
+    let relative_path = relative_path(graph, node, project_root)?;
+    let source_info = graph.source_info(node)?;
+    let span = &source_info.span;
+    let pattern = source_info
+        .containing_line
+        .into_option()
+        .map(|line| &graph[line])
+        .and_then(|line| {
+            // The pattern ends after the definition, if it ends on the same line.
+            let end = if span.end.line == span.start.line {
+                span.end.column.utf8_offset
+            } else {
+                line.len()
+            };
+            line.get(..end)
+        })
+        .map(|pattern| pattern.to_string());
+    Some(Tag {
+        name: name.to_string(),
+        relative_path,
+        line: span.start.line,
+        line_offset: span.start.containing_line.start,
+        pattern,
+        kind: source_info.syntax_type.map(|s| graph[s].to_string()),
+    })
+}
+
+/// Writes the definitions in the graph as a ctags file, in the extended format.  Definitions are
+/// located by line number, and their syntax type, if any, is given as their kind.
+pub(crate) fn export_ctags(graph: &StackGraph, args: &ExportArgs) -> anyhow::Result<()> {
+    let tags = collect_tags(graph, args)?;
+    let output = std::fs::File::create(&args.output)
+        .with_context(|| format!("Failed to create {}", args.output.display()))?;
+    let mut output = BufWriter::new(output);
+    writeln!(output, "!_TAG_FILE_FORMAT\t2\t/extended format/")?;
+    writeln!(
+        output,
+        "!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/"
+    )?;
+    writeln!(output, "!_TAG_PROGRAM_NAME\t{}\t//", env!("CARGO_PKG_NAME"))?;
+    writeln!(
+        output,
+        "!_TAG_PROGRAM_VERSION\t{}\t//",
+        env!("CARGO_PKG_VERSION")
+    )?;
+    for tag in &tags {
+        write!(
+            output,
+            "{}\t{}\t{};\"\tline:{}",
+            tag.name,
+            tag.relative_path,
+            tag.line + 1,
+            tag.line + 1
+        )?;
+        if let Some(kind) = &tag.kind {
+            write!(output, "\tkind:{}", kind)?;
+        }
+        writeln!(output)?;
+    }
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", args.output.display()))?;
+    println!("{} tags written to {}", tags.len(), args.output.display());
+    Ok(())
+}
+
+/// Writes the definitions in the graph as an etags file, as used by Emacs.  Every file gets its
+/// own section, which lists its definitions by line.
+pub(crate) fn export_etags(graph: &StackGraph, args: &ExportArgs) -> anyhow::Result<()> {
+    let tags = collect_tags(graph, args)?;
+    let mut tags_by_file = BTreeMap::<&str, Vec<&Tag>>::new();
+    for tag in &tags {
+        tags_by_file
+            .entry(&tag.relative_path)
+            .or_default()
+            .push(tag);
+    }
+    let output = std::fs::File::create(&args.output)
+        .with_context(|| format!("Failed to create {}", args.output.display()))?;
+    let mut output = BufWriter::new(output);
+    for (relative_path, mut file_tags) in tags_by_file {
+        file_tags.sort_by(|a, b| (a.line, &a.name).cmp(&(b.line, &b.name)));
+        // The header of a section contains the size of the section, so we write it to a buffer
+        // first.
+        let mut section = String::new();
+        for tag in file_tags {
+            section.push_str(&format!(
+                "{}\u{7f}{}\u{1}{},{}\n",
+                tag.pattern.as_ref().unwrap_or(&tag.name),
+                tag.name,
+                tag.line + 1,
+                tag.line_offset
+            ));
+        }
+        write!(
+            output,
+            "\u{c}\n{},{}\n{}",
+            relative_path,
+            section.len(),
+            section
+        )?;
+    }
+    output
+        .flush()
+        .with_context(|| format!("Failed to write {}", args.output.display()))?;
+    println!("{} tags written to {}", tags.len(), args.output.display());
+    Ok(())
+}
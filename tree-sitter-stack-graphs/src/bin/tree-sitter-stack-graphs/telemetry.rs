@@ -0,0 +1,58 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use anyhow::Context as _;
+use opentelemetry::sdk::trace;
+use opentelemetry::sdk::Resource;
+use opentelemetry::KeyValue;
+use opentelemetry_otlp::WithExportConfig as _;
+use tracing_subscriber::layer::SubscriberExt as _;
+use tracing_subscriber::util::SubscriberInitExt as _;
+
+/// Exports the tracing spans of the program to an OpenTelemetry collector, using OTLP over gRPC.
+/// Spans are exported in batches, on a runtime of their own, and the spans that have not been
+/// exported yet are exported when the exporter is dropped.
+pub(crate) struct Telemetry {
+    runtime: tokio::runtime::Runtime,
+}
+
+impl Telemetry {
+    pub(crate) fn start(endpoint: &str) -> anyhow::Result<Telemetry> {
+        let runtime = tokio::runtime::Builder::new_multi_thread()
+            .worker_threads(1)
+            .enable_all()
+            .build()?;
+        // The exporter connects to the collector, and spawns the batch processor, on the runtime
+        // that is current when it is installed.
+        let _guard = runtime.enter();
+        let tracer = opentelemetry_otlp::new_pipeline()
+            .tracing()
+            .with_exporter(
+                opentelemetry_otlp::new_exporter()
+                    .tonic()
+                    .with_endpoint(endpoint),
+            )
+            .with_trace_config(trace::config().with_resource(Resource::new(vec![
+                KeyValue::new("service.name", env!("CARGO_PKG_NAME")),
+                KeyValue::new("service.version", env!("CARGO_PKG_VERSION")),
+            ])))
+            .install_batch(opentelemetry::runtime::Tokio)
+            .with_context(|| format!("Failed to export traces to {}", endpoint))?;
+        tracing_subscriber::registry()
+            .with(tracing_opentelemetry::layer().with_tracer(tracer))
+            .try_init()
+            .context("Failed to install tracing subscriber")?;
+        Ok(Telemetry { runtime })
+    }
+}
+
+impl Drop for Telemetry {
+    fn drop(&mut self) {
+        let _guard = self.runtime.enter();
+        opentelemetry::global::shutdown_tracer_provider();
+    }
+}
@@ -15,12 +15,14 @@ use stack_graphs::graph::File;
 use stack_graphs::graph::StackGraph;
 use stack_graphs::json::Filter;
 use stack_graphs::paths::Paths;
+use std::collections::HashSet;
 use std::ffi::OsStr;
 use std::ffi::OsString;
 use std::path::Path;
 use std::path::PathBuf;
+use thiserror::Error;
 use tree_sitter_graph::parse_error::TreeWithParseErrorVec;
-use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::go;
 use tree_sitter_stack_graphs::loader::Loader;
 use tree_sitter_stack_graphs::test::Test;
 use tree_sitter_stack_graphs::test::TestFragment;
@@ -30,6 +32,7 @@ use tree_sitter_stack_graphs::StackGraphLanguage;
 use walkdir::WalkDir;
 
 use crate::loader::LoaderArgs;
+use crate::path_exists;
 use crate::MAX_PARSE_ERRORS;
 
 /// Flag to control output
@@ -48,6 +51,32 @@ impl OutputMode {
     }
 }
 
+/// Error returned when some test assertions failed.  This is distinguished from other errors,
+/// such as tests that cannot be parsed, so that we can report them with a different exit code.
+#[derive(Debug, Error)]
+#[error("{failure_count} of {assertion_count} assertion(s) in {test_count} test(s) failed")]
+pub struct TestFailures {
+    pub test_count: usize,
+    pub assertion_count: usize,
+    pub failure_count: usize,
+}
+
+/// Totals over all tests that were run.
+#[derive(Default)]
+struct TestTotals {
+    test_count: usize,
+    assertion_count: usize,
+    failure_count: usize,
+}
+
+impl TestTotals {
+    fn add(&mut self, other: TestTotals) {
+        self.test_count += other.test_count;
+        self.assertion_count += other.assertion_count;
+        self.failure_count += other.failure_count;
+    }
+}
+
 /// Run tests
 #[derive(clap::Parser)]
 #[clap(after_help = r#"PATH SPECIFICATIONS:
@@ -67,6 +96,11 @@ impl OutputMode {
     Note that on Windows the path specification must be valid Unicode, but all valid
     paths (including ones that are not valid Unicode) are accepted as arguments, and
     placeholders are correctly subtituted for all paths.
+
+EXIT STATUS:
+    0   all assertions passed
+    1   one or more assertions failed
+    2   tests could not be run, e.g. because of invalid arguments or test files
 "#)]
 pub struct Command {
     #[clap(flatten)]
@@ -84,10 +118,19 @@ pub struct Command {
     #[clap(long)]
     hide_failure_errors: bool,
 
+    /// Show the outcome of every assertion, instead of only failing ones.
+    #[clap(long)]
+    show_assertions: bool,
+
     /// Show ignored files in output.
     #[clap(long)]
     show_ignored: bool,
 
+    /// Fail tests if the stack graph construction rules set attributes that graph nodes or edges
+    /// do not accept, which are usually typos, instead of only logging a warning.
+    #[clap(long)]
+    strict_attributes: bool,
+
     /// Save graph for tests matching output mode.
     /// Takes an optional path specification argument for the output file.
     /// [default: %n.graph.json]
@@ -133,20 +176,31 @@ pub struct Command {
     /// Controls when graphs, paths, or visualization are saved.
     #[clap(long, arg_enum, default_value_t = OutputMode::OnFailure)]
     output_mode: OutputMode,
-}
 
-fn path_exists(path: &OsStr) -> anyhow::Result<PathBuf> {
-    let path = PathBuf::from(path);
-    if !path.exists() {
-        return Err(anyhow!("path does not exist"));
-    }
-    Ok(path)
+    /// Compare the graph of each test against a snapshot file, and fail the test if they differ.
+    /// Snapshots that do not exist yet are created.
+    /// Takes an optional path specification argument for the snapshot file.
+    /// [default: %r/%d/%n%e.snapshot]
+    #[clap(
+        long,
+        short = 'S',
+        value_name = "PATH_SPEC",
+        min_values = 0,
+        max_values = 1,
+        require_equals = true,
+        default_missing_value = "%r/%d/%n%e.snapshot"
+    )]
+    snapshot: Option<PathSpec>,
+
+    /// Overwrite existing snapshots with the current graphs, instead of comparing against them.
+    #[clap(long, requires = "snapshot")]
+    update: bool,
 }
 
 impl Command {
     pub fn run(&self) -> anyhow::Result<()> {
         let mut loader = self.loader.new_loader()?;
-        let mut total_failure_count = 0;
+        let mut totals = TestTotals::default();
         for test_path in &self.tests {
             if test_path.is_dir() {
                 let test_root = test_path;
@@ -157,24 +211,27 @@ impl Command {
                     .filter(|e| e.file_type().is_file())
                 {
                     let test_path = test_entry.path();
-                    total_failure_count +=
-                        self.run_test_with_context(test_root, test_path, &mut loader)?;
+                    totals.add(self.run_test_with_context(test_root, test_path, &mut loader)?);
                 }
             } else {
                 let test_root = test_path.parent().unwrap();
-                total_failure_count +=
-                    self.run_test_with_context(test_root, test_path, &mut loader)?;
+                totals.add(self.run_test_with_context(test_root, test_path, &mut loader)?);
             }
         }
 
-        if total_failure_count > 0 {
-            return Err(anyhow!(
-                "{} assertion{} failed",
-                total_failure_count,
-                if total_failure_count == 1 { "" } else { "s" }
-            ));
+        if totals.failure_count > 0 {
+            return Err(TestFailures {
+                test_count: totals.test_count,
+                assertion_count: totals.assertion_count,
+                failure_count: totals.failure_count,
+            }
+            .into());
         }
 
+        println!(
+            "{} assertion(s) in {} test(s) passed",
+            totals.assertion_count, totals.test_count
+        );
         Ok(())
     }
 
@@ -184,7 +241,7 @@ impl Command {
         test_root: &Path,
         test_path: &Path,
         loader: &mut Loader,
-    ) -> anyhow::Result<usize> {
+    ) -> anyhow::Result<TestTotals> {
         self.run_test(test_root, test_path, loader)
             .with_context(|| format!("Error running test {}", test_path.display()))
     }
@@ -195,7 +252,7 @@ impl Command {
         test_root: &Path,
         test_path: &Path,
         loader: &mut Loader,
-    ) -> anyhow::Result<usize> {
+    ) -> anyhow::Result<TestTotals> {
         let source = String::from_utf8(std::fs::read(test_path)?)?;
         let sgl = match loader.load_for_file(test_path, Some(&source))? {
             Some(sgl) => sgl,
@@ -203,9 +260,10 @@ impl Command {
                 if self.show_ignored {
                     println!("{} {}", "⦵".dimmed(), test_path.display());
                 }
-                return Ok(0);
+                return Ok(TestTotals::default());
             }
         };
+        sgl.set_strict_attributes(self.strict_attributes);
         let default_fragment_path = test_path.strip_prefix(test_root).unwrap();
         let mut test = Test::from_source(&test_path, &source, default_fragment_path)?;
         self.load_builtins_into(sgl, &mut test.graph)
@@ -227,9 +285,25 @@ impl Command {
             )?;
         }
         let result = test.run();
-        let success = self.handle_result(test_path, &result)?;
+        let mut assertion_count = result.count();
+        let mut failure_count = result.failure_count();
+        let mut success = self.handle_result(test_path, &result)?;
+        let files = test.fragments.iter().map(|f| f.file).collect::<Vec<_>>();
+        if let Some(path) = self
+            .snapshot
+            .as_ref()
+            .map(|spec| spec.format(test_root, test_path))
+        {
+            let snapshot = test
+                .graph
+                .to_snapshot(&|_: &StackGraph, h: &Handle<File>| files.contains(h));
+            assertion_count += 1;
+            if !self.check_snapshot(&path, &snapshot, success)? {
+                failure_count += 1;
+                success = false;
+            }
+        }
         if self.output_mode.test(!success) {
-            let files = test.fragments.iter().map(|f| f.file).collect::<Vec<_>>();
             self.save_output(
                 test_root,
                 test_path,
@@ -239,7 +313,11 @@ impl Command {
                 success,
             )?;
         }
-        Ok(result.failure_count())
+        Ok(TestTotals {
+            test_count: 1,
+            assertion_count,
+            failure_count,
+        })
     }
 
     fn load_builtins_into(
@@ -260,7 +338,8 @@ impl Command {
         test_fragment: &TestFragment,
         graph: &mut StackGraph,
     ) -> anyhow::Result<()> {
-        let mut globals = Variables::new();
+        let mut globals = self.loader.globals();
+        go::add_import_path_global(&mut globals, Path::new(graph[test_fragment.file].name()));
         match sgl.build_stack_graph_into(
             graph,
             test_fragment.file,
@@ -316,7 +395,14 @@ impl Command {
                 result.count()
             );
         }
-        if !success && !self.hide_failure_errors {
+        if self.show_assertions {
+            for success in result.successes_iter() {
+                println!("  {} {}", "✓".green(), success);
+            }
+            for failure in result.failures_iter() {
+                println!("  {} {}", "✗".red(), failure);
+            }
+        } else if !success && !self.hide_failure_errors {
             for failure in result.failures_iter() {
                 println!("  {}", failure);
             }
@@ -324,6 +410,44 @@ impl Command {
         Ok(success)
     }
 
+    /// Compares the snapshot of a test graph against the snapshot file, or writes the snapshot
+    /// file if it does not exist or we are asked to update it.  Returns whether the snapshots
+    /// matched.
+    fn check_snapshot(&self, path: &Path, snapshot: &str, success: bool) -> anyhow::Result<bool> {
+        if self.update || !path.exists() {
+            if let Some(dir) = path.parent() {
+                std::fs::create_dir_all(dir)?;
+            }
+            std::fs::write(&path, snapshot)
+                .with_context(|| format!("Unable to write snapshot {}", path.display()))?;
+            if !success || !self.hide_passing {
+                println!("  Snapshot: {} (updated)", path.display());
+            }
+            return Ok(true);
+        }
+        let expected = std::fs::read_to_string(&path)
+            .with_context(|| format!("Unable to read snapshot {}", path.display()))?;
+        if expected == snapshot {
+            if !success || !self.hide_passing {
+                println!("  Snapshot: {}", path.display());
+            }
+            return Ok(true);
+        }
+        println!("  {} {}", "Snapshot differs:".red(), path.display());
+        if !self.hide_failure_errors {
+            // Snapshots are sorted, so comparing the sets of lines gives a readable difference.
+            let expected_lines = expected.lines().collect::<HashSet<_>>();
+            let actual_lines = snapshot.lines().collect::<HashSet<_>>();
+            for line in expected.lines().filter(|l| !actual_lines.contains(l)) {
+                println!("    - {}", line.trim_start());
+            }
+            for line in snapshot.lines().filter(|l| !expected_lines.contains(l)) {
+                println!("    + {}", line.trim_start());
+            }
+        }
+        Ok(false)
+    }
+
     fn save_output(
         &self,
         test_root: &Path,
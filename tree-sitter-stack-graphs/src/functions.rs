@@ -8,6 +8,7 @@
 //! Define tree-sitter-graph functions
 
 pub use path::add_path_functions;
+pub use syntax::add_syntax_functions;
 
 pub mod path {
     use std::path::Component;
@@ -156,3 +157,214 @@ pub mod path {
         ret
     }
 }
+
+pub mod syntax {
+    use tree_sitter_graph::functions::Function;
+    use tree_sitter_graph::functions::Functions;
+    use tree_sitter_graph::functions::Parameters;
+    use tree_sitter_graph::graph::Graph;
+    use tree_sitter_graph::graph::Value;
+    use tree_sitter_graph::ExecutionError;
+
+    pub fn add_syntax_functions(functions: &mut Functions) {
+        functions.add("check".into(), Check);
+        functions.add("doc-comment".into(), DocComment);
+        functions.add("fail-at".into(), FailAt);
+        functions.add("node-description".into(), NodeDescription);
+        functions.add("node-has-error".into(), NodeHasError);
+    }
+
+    /// Checks a condition about a syntax node, and fails with the given message and the location
+    /// of the node if it does not hold.  Returns the syntax node otherwise, so that the check can
+    /// be part of the expression that uses the node.
+    struct Check;
+
+    impl Function for Check {
+        fn call(
+            &mut self,
+            graph: &mut Graph,
+            _source: &str,
+            parameters: &mut dyn Parameters,
+        ) -> Result<Value, ExecutionError> {
+            let condition = parameters.param()?.as_boolean()?;
+            let node_value = parameters.param()?;
+            let node = graph[node_value.as_syntax_node_ref()?];
+            let message = parameters.param()?.into_string()?;
+            parameters.finish()?;
+
+            if !condition {
+                return Err(located_error("check", node, &message));
+            }
+            Ok(node_value)
+        }
+    }
+
+    /// Always fails with the given message and the location of the given syntax node.  This
+    /// reports syntax that the rules do not support.
+    struct FailAt;
+
+    impl Function for FailAt {
+        fn call(
+            &mut self,
+            graph: &mut Graph,
+            _source: &str,
+            parameters: &mut dyn Parameters,
+        ) -> Result<Value, ExecutionError> {
+            let node = graph[parameters.param()?.into_syntax_node_ref()?];
+            let message = parameters.param()?.into_string()?;
+            parameters.finish()?;
+
+            Err(located_error("fail-at", node, &message))
+        }
+    }
+
+    /// Returns an error of a function, with the given message, followed by the type and location
+    /// of the syntax node that caused it.  Lines and columns start at 1.
+    fn located_error(function: &str, node: tree_sitter::Node, message: &str) -> ExecutionError {
+        let position = node.start_position();
+        ExecutionError::FunctionFailed(
+            function.to_string(),
+            format!(
+                "{} at {} {}:{}",
+                message,
+                node.kind(),
+                position.row + 1,
+                position.column + 1
+            ),
+        )
+    }
+
+    /// Returns the doc comments of a syntax node, i.e., the text of the comments that directly
+    /// precede it, without blank lines in between, and with their comment markers removed.
+    /// Comments are the previous siblings whose type ends in `comment`.  An optional second
+    /// parameter gives a prefix that doc comments must start with.  Returns `#null` if the node
+    /// has no doc comments.
+    struct DocComment;
+
+    impl Function for DocComment {
+        fn call(
+            &mut self,
+            graph: &mut Graph,
+            source: &str,
+            parameters: &mut dyn Parameters,
+        ) -> Result<Value, ExecutionError> {
+            let node = graph[parameters.param()?.into_syntax_node_ref()?];
+            let prefix = match parameters.param() {
+                Ok(prefix) => Some(prefix.into_string()?),
+                Err(_) => None,
+            };
+            parameters.finish()?;
+
+            let mut comments = Vec::new();
+            let mut next = node;
+            while let Some(sibling) = next.prev_sibling() {
+                if !sibling.kind().ends_with("comment")
+                    || sibling.end_position().row + 1 < next.start_position().row
+                {
+                    break;
+                }
+                let text = &source[sibling.byte_range()];
+                if let Some(prefix) = &prefix {
+                    if !text.starts_with(prefix.as_str()) {
+                        break;
+                    }
+                }
+                comments.push(strip_comment_markers(text));
+                next = sibling;
+            }
+            if comments.is_empty() {
+                return Ok(Value::Null);
+            }
+            comments.reverse();
+            Ok(comments.join("\n").into())
+        }
+    }
+
+    /// Removes the comment markers from the text of a comment.  Block comments lose their
+    /// delimiters and the leading `*` of every line.  Line comments lose the leading run of
+    /// punctuation that most languages use as comment markers, such as `//`, `///`, `#`, `--`,
+    /// or `;`.  A single space following a marker is removed as well.
+    fn strip_comment_markers(text: &str) -> String {
+        if let Some(block) = text.strip_prefix("/*") {
+            let block = block.trim_start_matches(|c| c == '*' || c == '!');
+            let block = block.strip_suffix("*/").unwrap_or(block);
+            return block
+                .lines()
+                .map(|line| {
+                    let line = line.trim_start();
+                    let line = line.strip_prefix('*').unwrap_or(line);
+                    line.strip_prefix(' ').unwrap_or(line).trim_end()
+                })
+                .collect::<Vec<_>>()
+                .join("\n")
+                .trim_matches('\n')
+                .to_string();
+        }
+        text.lines()
+            .map(|line| {
+                let line = line
+                    .trim_start()
+                    .trim_start_matches(|c| matches!(c, '/' | '#' | '-' | ';' | '!' | '%'));
+                line.strip_prefix(' ').unwrap_or(line).trim_end()
+            })
+            .collect::<Vec<_>>()
+            .join("\n")
+    }
+
+    /// The maximum number of characters of source text that `node-description` includes.
+    const DESCRIPTION_TEXT_LENGTH: usize = 40;
+
+    /// Returns a description of a syntax node for debugging, with its type, the start of its
+    /// source text, and its location, e.g., ``identifier `handle_error` at 61:6``.  Only the
+    /// first line of the text is included, and long text is truncated.  Lines and columns start
+    /// at 1.
+    struct NodeDescription;
+
+    impl Function for NodeDescription {
+        fn call(
+            &mut self,
+            graph: &mut Graph,
+            source: &str,
+            parameters: &mut dyn Parameters,
+        ) -> Result<Value, ExecutionError> {
+            let node = graph[parameters.param()?.into_syntax_node_ref()?];
+            parameters.finish()?;
+
+            let text = &source[node.byte_range()];
+            let first_line = text.lines().next().unwrap_or("");
+            let mut excerpt = first_line
+                .chars()
+                .take(DESCRIPTION_TEXT_LENGTH)
+                .collect::<String>();
+            if excerpt.len() < text.len() {
+                excerpt.push('…');
+            }
+            let position = node.start_position();
+            Ok(format!(
+                "{} `{}` at {}:{}",
+                node.kind(),
+                excerpt,
+                position.row + 1,
+                position.column + 1
+            )
+            .into())
+        }
+    }
+
+    /// Returns whether a syntax node is, or contains, a syntax error.
+    struct NodeHasError;
+
+    impl Function for NodeHasError {
+        fn call(
+            &mut self,
+            graph: &mut Graph,
+            _source: &str,
+            parameters: &mut dyn Parameters,
+        ) -> Result<Value, ExecutionError> {
+            let node = graph[parameters.param()?.into_syntax_node_ref()?];
+            parameters.finish()?;
+
+            Ok(node.has_error().into())
+        }
+    }
+}
@@ -0,0 +1,300 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines how the import paths and dependencies of Go packages are found
+//!
+//! The import path of a Go package does not follow from the path of its files alone, but also
+//! from the `module` directive of the `go.mod` file of its module.  Because the stack graph of a
+//! file must only depend on the inputs that the caller provides, the rules for Go do not read
+//! `go.mod` files themselves.  Instead, the caller computes the import path of every Go file with
+//! [`import_path`][], and provides it to the rules in the global variable named by
+//! [`IMPORT_PATH_VAR`][].  Tools that store stack graphs must treat the import path as an input
+//! of the file, so that its stack graph is built again when the `go.mod` file changes.
+//!
+//! Files in the module cache get the import path that is encoded in their path, so the modules
+//! that a project requires can be indexed into databases of their own, which are consulted as
+//! dependencies of the project's database.  The [`requirements`][] function reads the `require`
+//! directives of a `go.mod` file, and [`required_module_dirs`][] locates the required modules in
+//! the module cache, which is found by [`module_cache`][].  Modules that are not worth indexing
+//! completely can be indexed as stubs instead, whose source is returned by [`stub_source`][].
+//!
+//! The functions are defined here, instead of in the crate with the rules for Go, so that tools
+//! that load the rules at runtime, such as the `tree-sitter-stack-graphs` command, can use them.
+//!
+//! [`import_path`]: fn.import_path.html
+//! [`IMPORT_PATH_VAR`]: constant.IMPORT_PATH_VAR.html
+//! [`module_cache`]: fn.module_cache.html
+//! [`required_module_dirs`]: fn.required_module_dirs.html
+//! [`requirements`]: fn.requirements.html
+//! [`stub_source`]: fn.stub_source.html
+
+use std::path::Component;
+use std::path::Path;
+use std::path::PathBuf;
+use tree_sitter::Tree;
+use tree_sitter_graph::Variables;
+
+/// The name of the global variable that contains the import path of the package of a Go file.
+pub const IMPORT_PATH_VAR: &str = "GO_IMPORT_PATH";
+
+/// The import path of the package that declares the predeclared identifiers of Go, such as `len`
+/// and `error`.
+pub const BUILTINS_IMPORT_PATH: &str = "builtin";
+
+/// The file extension of Go source files.
+const FILE_TYPE: &str = "go";
+
+/// Returns the import path of the package that contains the Go source file at the given path.
+///
+/// The `module` directive of the nearest `go.mod` file gives the import path of the module root,
+/// and the directory of the file relative to the module root is appended to it.  Files in the
+/// module cache, such as `$GOPATH/pkg/mod/example.com/foo@v1.2.3/bar/bar.go`, get the import path
+/// that is encoded in their path, here `example.com/foo/bar`.  Files outside of a module use their
+/// directory as import path.
+pub fn import_path(file_path: &Path) -> String {
+    let dir = file_path.parent().unwrap_or(Path::new(""));
+    if let Some(import_path) = module_cache_import_path(dir) {
+        return import_path;
+    }
+    for module_root in dir.ancestors() {
+        // Relative paths end with an empty ancestor, which would find a go.mod file in the
+        // current directory instead.
+        if module_root.as_os_str().is_empty() {
+            break;
+        }
+        let go_mod = match std::fs::read_to_string(module_root.join("go.mod")) {
+            Ok(go_mod) => go_mod,
+            Err(_) => continue,
+        };
+        if let Some(module_path) = module_path(&go_mod) {
+            let package_dir = dir.strip_prefix(module_root).unwrap_or(Path::new(""));
+            return join_import_path(module_path, package_dir);
+        }
+    }
+    join_import_path("", dir)
+}
+
+/// Returns the import path of the file at the given path, if it is a Go source file.
+pub fn import_path_for_file(file_path: &Path) -> Option<String> {
+    if file_path.extension().map_or(true, |ext| ext != FILE_TYPE) {
+        return None;
+    }
+    Some(import_path(file_path))
+}
+
+/// Adds the import path of the file at the given path to the global variables, if it is a Go
+/// source file.  An import path that the caller already set is kept.
+pub fn add_import_path_global(globals: &mut Variables, file_path: &Path) {
+    if let Some(import_path) = import_path_for_file(file_path) {
+        let _ = globals.add(IMPORT_PATH_VAR.into(), import_path.into());
+    }
+}
+
+/// Returns the root directory of the module that contains the given path, i.e., the nearest
+/// directory that contains a `go.mod` file, starting at the path itself.
+pub fn module_root(path: &Path) -> Option<PathBuf> {
+    path.ancestors()
+        // Relative paths end with an empty ancestor, which would find a go.mod file in the
+        // current directory instead.
+        .take_while(|dir| !dir.as_os_str().is_empty())
+        .find(|dir| dir.join("go.mod").is_file())
+        .map(Path::to_path_buf)
+}
+
+/// A module that is required by a `go.mod` file.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct Requirement {
+    /// The module path, such as `example.com/shapes`.
+    pub path: String,
+    /// The required version, such as `v1.2.3`.
+    pub version: String,
+}
+
+/// Returns the modules that are required by the `require` directives of a `go.mod` file, in the
+/// order in which they appear.  Both single requirements and requirement blocks are supported.
+/// Indirect requirements are included, because the packages of direct dependencies refer to them.
+pub fn requirements(go_mod: &str) -> Vec<Requirement> {
+    let mut requirements = Vec::new();
+    let mut in_block = false;
+    for line in go_mod.lines() {
+        let line = line.split("//").next().unwrap().trim();
+        let spec = if in_block {
+            if line == ")" {
+                in_block = false;
+                continue;
+            }
+            line
+        } else if let Some(rest) = line.strip_prefix("require") {
+            let rest = rest.trim();
+            if rest == "(" {
+                in_block = true;
+                continue;
+            }
+            rest
+        } else {
+            continue;
+        };
+        let mut parts = spec.split_whitespace();
+        if let (Some(path), Some(version)) = (parts.next(), parts.next()) {
+            requirements.push(Requirement {
+                path: path.trim_matches('"').to_string(),
+                version: version.trim_matches('"').to_string(),
+            });
+        }
+    }
+    requirements
+}
+
+/// Returns the module cache, i.e., the directory that the `go` command downloads modules to.  This
+/// is `$GOMODCACHE` if it is set, or `pkg/mod` in the first directory of `$GOPATH`, which defaults
+/// to `$HOME/go`.
+pub fn module_cache() -> Option<PathBuf> {
+    if let Some(dir) = std::env::var_os("GOMODCACHE").filter(|d| !d.is_empty()) {
+        return Some(PathBuf::from(dir));
+    }
+    let gopath = match std::env::var_os("GOPATH").filter(|p| !p.is_empty()) {
+        Some(gopath) => std::env::split_paths(&gopath).next()?,
+        None => PathBuf::from(std::env::var_os("HOME")?).join("go"),
+    };
+    Some(gopath.join("pkg").join("mod"))
+}
+
+/// Returns the directories in the given module cache of the modules that are required by the
+/// `go.mod` file in the given module root.  Modules that have not been downloaded to the module
+/// cache are left out.
+pub fn required_module_dirs(
+    module_root: &Path,
+    module_cache: &Path,
+) -> std::io::Result<Vec<PathBuf>> {
+    let go_mod = std::fs::read_to_string(module_root.join("go.mod"))?;
+    let dirs = requirements(&go_mod)
+        .into_iter()
+        .map(|r| {
+            module_cache.join(format!(
+                "{}@{}",
+                escape_module_path(&r.path),
+                escape_module_path(&r.version)
+            ))
+        })
+        .filter(|dir| dir.is_dir())
+        .collect();
+    Ok(dirs)
+}
+
+/// Returns the source of a Go file without the bodies of its functions and methods, given its
+/// syntax tree.  The stack graph of the result only contains the top-level declarations of the
+/// file, which other packages can refer to, and not the local variables and references in the
+/// bodies, which are most of a typical stack graph.  The bodies are replaced by whitespace, so
+/// that the declarations keep their positions in the file.
+pub fn stub_source(tree: &Tree, source: &str) -> String {
+    let mut stub = source.as_bytes().to_vec();
+    let root = tree.root_node();
+    let mut cursor = root.walk();
+    for declaration in root.named_children(&mut cursor) {
+        if !matches!(
+            declaration.kind(),
+            "function_declaration" | "method_declaration"
+        ) {
+            continue;
+        }
+        let body = match declaration.child_by_field_name("body") {
+            Some(body) if body.end_byte() > body.start_byte() + 1 => body,
+            _ => continue,
+        };
+        // Keep the braces and line breaks of the body, and replace every other byte by a space,
+        // so that the result is still valid UTF-8.
+        for b in &mut stub[body.start_byte() + 1..body.end_byte() - 1] {
+            if *b != b'\n' && *b != b'\r' {
+                *b = b' ';
+            }
+        }
+    }
+    String::from_utf8(stub).expect("Stub source is not valid UTF-8")
+}
+
+/// Returns the module path from the `module` directive of a `go.mod` file.
+fn module_path(go_mod: &str) -> Option<&str> {
+    go_mod.lines().find_map(|line| {
+        let line = line.split("//").next().unwrap().trim();
+        let module_path = line.strip_prefix("module")?;
+        if !module_path.starts_with(char::is_whitespace) {
+            return None;
+        }
+        Some(module_path.trim().trim_matches('"'))
+    })
+}
+
+/// Returns the import path of a package directory in the module cache, i.e., below `pkg/mod`.
+/// The directory of the module contains its version after an `@`, and upper case letters in the
+/// path are escaped as `!` followed by the lower case letter.
+fn module_cache_import_path(dir: &Path) -> Option<String> {
+    let components = dir
+        .components()
+        .filter_map(|c| match c {
+            Component::Normal(c) => c.to_str(),
+            _ => None,
+        })
+        .collect::<Vec<_>>();
+    let start = components
+        .windows(2)
+        .rposition(|w| w[0] == "pkg" && w[1] == "mod")?
+        + 2;
+    let components = &components[start..];
+    // Downloaded archives are kept in pkg/mod/cache, which does not contain any packages.
+    if components.first() == Some(&"cache") || !components.iter().any(|c| c.contains('@')) {
+        return None;
+    }
+    let import_path = components
+        .iter()
+        .map(|c| c.split('@').next().unwrap())
+        .map(unescape_module_path)
+        .collect::<Vec<_>>()
+        .join("/");
+    Some(import_path)
+}
+
+fn unescape_module_path(component: &str) -> String {
+    let mut result = String::with_capacity(component.len());
+    let mut upper = false;
+    for c in component.chars() {
+        if c == '!' {
+            upper = true;
+        } else if upper {
+            result.extend(c.to_uppercase());
+            upper = false;
+        } else {
+            result.push(c);
+        }
+    }
+    result
+}
+
+/// Joins a module path and a relative package directory into an import path, which always uses
+/// `/` as separator.
+fn join_import_path(module_path: &str, package_dir: &Path) -> String {
+    std::iter::once(module_path)
+        .chain(package_dir.components().filter_map(|c| match c {
+            Component::Normal(c) => c.to_str(),
+            _ => None,
+        }))
+        .filter(|c| !c.is_empty())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+fn escape_module_path(path: &str) -> String {
+    let mut result = String::with_capacity(path.len());
+    for c in path.chars() {
+        if c.is_ascii_uppercase() {
+            result.push('!');
+            result.push(c.to_ascii_lowercase());
+        } else {
+            result.push(c);
+        }
+    }
+    result
+}
@@ -0,0 +1,149 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+//! Defines data types for finding code in another language that is embedded in a source file
+//!
+//! Examples of such _injected_ code are JavaScript in the `<script>` elements of an HTML file, or
+//! SQL in the string literals of a Python file.  The injected regions of a file are found using a
+//! tree-sitter query, in the same format as the `queries/injections.scm` files that tree-sitter
+//! uses for syntax highlighting.  Every match of the query must capture the injected region as
+//! `@injection.content`.  The language of the region is either captured as
+//! `@injection.language`, or set with `(#set! injection.language "NAME")`.  The older capture
+//! names `@content` and `@language` are supported as well.
+//!
+//! The stack graph for an injected region is built into the file that contains it, using the
+//! source of the whole file in which everything outside of the region is replaced by whitespace.
+//! This way, the positions of the nodes that are created for the region are positions in the
+//! containing file.  The nodes of the region get local IDs that follow the ones of the nodes
+//! that are already in the file, so that they do not collide with the nodes of the containing
+//! file, or of other regions.
+
+use std::ops::Range;
+use tree_sitter::Query;
+use tree_sitter::QueryCursor;
+use tree_sitter::Tree;
+
+static CONTENT_CAPTURES: [&'static str; 2] = ["injection.content", "content"];
+static LANGUAGE_CAPTURES: [&'static str; 2] = ["injection.language", "language"];
+static LANGUAGE_PROPERTY: &'static str = "injection.language";
+
+/// A query that finds the injected regions in the syntax tree of a source file
+pub struct InjectionQuery {
+    query: Query,
+    content_capture: Option<u32>,
+    language_capture: Option<u32>,
+}
+
+impl InjectionQuery {
+    /// Creates a new injection query for the given language from a query string.
+    pub fn from_str(
+        language: tree_sitter::Language,
+        source: &str,
+    ) -> Result<InjectionQuery, tree_sitter::QueryError> {
+        let query = Query::new(language, source)?;
+        let capture_index = |names: &[&str]| {
+            query
+                .capture_names()
+                .iter()
+                .position(|name| names.contains(&name.as_str()))
+                .map(|index| index as u32)
+        };
+        let content_capture = capture_index(&CONTENT_CAPTURES);
+        let language_capture = capture_index(&LANGUAGE_CAPTURES);
+        Ok(InjectionQuery {
+            query,
+            content_capture,
+            language_capture,
+        })
+    }
+
+    /// Returns the names of the languages that the query can find, if all of them are set with the
+    /// `injection.language` property.  Returns `None` if the query captures the language, in which
+    /// case any language may be found.
+    pub fn fixed_languages(&self) -> Option<Vec<String>> {
+        if self.language_capture.is_some() {
+            return None;
+        }
+        let mut languages = (0..self.query.pattern_count())
+            .flat_map(|pattern_index| self.query.property_settings(pattern_index))
+            .filter(|p| p.key.as_ref() == LANGUAGE_PROPERTY)
+            .filter_map(|p| p.value.as_ref())
+            .map(|value| value.to_string())
+            .collect::<Vec<_>>();
+        languages.sort();
+        languages.dedup();
+        Some(languages)
+    }
+
+    /// Returns the injected regions in the given syntax tree.  Matches without a content capture,
+    /// or without a language, are skipped.
+    pub fn find_injections(&self, tree: &Tree, source: &str) -> Vec<Injection> {
+        let content_capture = match self.content_capture {
+            Some(content_capture) => content_capture,
+            None => return Vec::new(),
+        };
+        let mut cursor = QueryCursor::new();
+        let mut injections = Vec::new();
+        for mat in cursor.matches(&self.query, tree.root_node(), source.as_bytes()) {
+            let language = self
+                .language_capture
+                .and_then(|index| mat.nodes_for_capture_index(index).next())
+                .and_then(|node| node.utf8_text(source.as_bytes()).ok())
+                .map(|text| text.to_string())
+                .or_else(|| {
+                    self.query
+                        .property_settings(mat.pattern_index)
+                        .iter()
+                        .find(|p| p.key.as_ref() == LANGUAGE_PROPERTY)
+                        .and_then(|p| p.value.as_ref())
+                        .map(|value| value.to_string())
+                });
+            let language = match language {
+                Some(language) => language,
+                None => continue,
+            };
+            for node in mat.nodes_for_capture_index(content_capture) {
+                injections.push(Injection {
+                    language: language.clone(),
+                    byte_range: node.byte_range(),
+                    host_kind: node.kind().to_string(),
+                });
+            }
+        }
+        injections
+    }
+}
+
+/// A region of a source file that contains code in another language
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct Injection {
+    /// The name of the language of the injected code, e.g., `javascript` or `sql`
+    pub language: String,
+    /// The byte range of the injected code in the containing file
+    pub byte_range: Range<usize>,
+    /// The kind of the syntax node that contains the injected code, e.g., `raw_text`
+    pub host_kind: String,
+}
+
+impl Injection {
+    /// Returns the source of the injected code, given the source of the containing file.  All
+    /// content outside of the injected region is replaced by spaces, except for line breaks, so
+    /// that byte offsets and line numbers in the result are the same as in the containing file.
+    pub fn source(&self, host_source: &str) -> String {
+        let mut source = String::with_capacity(host_source.len());
+        for (offset, ch) in host_source.char_indices() {
+            if self.byte_range.contains(&offset) {
+                source.push(ch);
+            } else if ch == '\n' || ch == '\r' {
+                source.push(ch);
+            } else {
+                (0..ch.len_utf8()).for_each(|_| source.push(' '));
+            }
+        }
+        source
+    }
+}
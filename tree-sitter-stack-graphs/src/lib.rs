@@ -125,6 +125,29 @@
 //! Nodes of type `scope` allow an optional `is_exported` attribute, that is required to use the scope
 //! in a `push_scoped_symbol` node.
 //!
+//! Most definitions and references are named by a single syntax node.  For those, the
+//! `node_definition` and `node_reference` attributes are shorthands, whose value is that syntax
+//! node.  The `node_definition` attribute makes the node a `pop_symbol` definition node, and the
+//! `node_reference` attribute makes it a `push_symbol` reference node.  The symbol is the source
+//! text of the syntax node, and the syntax node is also the `source_node`, unless these are given
+//! explicitly.  The following two stanzas create the same nodes:
+//!
+//! ``` skip
+//! (identifier) @id {
+//!   node def
+//!   attr (def) node_definition = @id
+//!   node ref
+//!   attr (ref) node_reference = @id
+//! }
+//!
+//! (identifier) @id {
+//!   node def
+//!   attr (def) type = "pop_symbol", symbol = (source-text @id), is_definition, source_node = @id
+//!   node ref
+//!   attr (ref) type = "push_symbol", symbol = (source-text @id), is_reference, source_node = @id
+//! }
+//! ```
+//!
 //!
 //! ### Annotating nodes with location information
 //!
@@ -145,6 +168,19 @@
 //! (the entirety of the function definition) and for the _name_ of the definition (the content of
 //! the function's `name`).
 //!
+//! Nodes can also have a `syntax_type` attribute, a string that describes the kind of syntax entity
+//! the node represents, such as `function` or `class`, which tools use to show or filter
+//! definitions.  Definition nodes can have a `definiens_node` attribute, whose value is the syntax
+//! node of what is being defined, such as the body of a function.  Its location is stored with the
+//! node, just like the location of the `source_node`.  Both attributes may be `#null`.
+//!
+//! ``` skip
+//! (function_definition name: (identifier) @id body: (_) @body) @func {
+//!   node def
+//!   attr (def) node_definition = @id, syntax_type = "function", definiens_node = @body
+//! }
+//! ```
+//!
 //! ### Connecting stack graph nodes with edges
 //!
 //! To connect two stack graph nodes, use the `edge` statement to add an edge between them:
@@ -188,6 +224,43 @@
 //! }
 //! ```
 //!
+//! ### Referring to the file
+//!
+//! Besides `FILE_PATH`, which is described under [working with paths](#working-with-paths), the
+//! syntax node at the root of the file's syntax tree is available in the global variable
+//! `SYNTAX_ROOT_NODE`, so that stanzas for nested syntax nodes can refer to the whole file without
+//! having to pass it down through scoped variables.  If the language has a name, such as the scope
+//! of its tree-sitter grammar, it is available in the global variable `LANGUAGE`, see
+//! [`StackGraphLanguage::set_name`][].  Like all global variables, they must be declared before
+//! they are used:
+//!
+//! ``` skip
+//! global SYNTAX_ROOT_NODE
+//!
+//! (import_statement) @import {
+//!   edge @import.imports -> SYNTAX_ROOT_NODE.module_scope
+//! }
+//! ```
+//!
+//! ### Documenting definitions
+//!
+//! Definition nodes can have a `documentation` attribute, whose value is shown to the user in
+//! things like hover popups.  Its value is a string, or `#null` if there is no documentation.  The
+//! `doc-comment` function implements a heuristic that works for most languages: it returns the
+//! comments directly preceding a syntax node, i.e., its previous siblings whose type ends in
+//! `comment`, without blank lines in between, and with the comment markers removed.  An optional
+//! second argument gives a prefix that doc comments start with, such as `"///"` or `"/**"`, so
+//! that ordinary comments are skipped.  Languages that document definitions differently, such as
+//! Python docstrings, can compute the value from the syntax tree instead:
+//!
+//! ``` skip
+//! (function_definition name: (identifier) @id) @func {
+//!   node def
+//!   attr (def) type = "pop_symbol", symbol = (source-text @id), source_node = @func, is_definition
+//!   attr (def) documentation = (doc-comment @func "///")
+//! }
+//! ```
+//!
 //! ### Attaching debug information to nodes
 //!
 //! It is possible to attach extra information to nodes for debugging purposes.  This is done by adding
@@ -208,6 +281,11 @@
 //!
 //! Built-in path functions are available to compute symbols that depend on path information, such as
 //! module names or imports. The path of the file is provided in the global variable `FILE_PATH`.
+//! Tools that index whole projects can also provide the root directory of the project that contains
+//! the file in the global variable `ROOT_PATH`, see [`ROOT_PATH_VAR`][].  Module names computed
+//! relative to the root are the same in every checkout of the project, and let references resolve
+//! across several projects that are indexed together.  Rules that use `ROOT_PATH` must declare it,
+//! like any other global variable, and can only be used with tools that provide it.
 //!
 //! The following path functions are available:
 //! - `path-dir`: get the path consisting of all but the last component of the argument path, or `#null` if it ends in root
@@ -249,6 +327,71 @@
 //! }
 //! ```
 //!
+//! ### Working with syntax errors
+//!
+//! By default, source files that contain syntax errors are rejected.  If a language is configured
+//! to build stack graphs for such files anyway (see [`SyntaxErrorPolicy`][]), the rules are
+//! executed against a syntax tree that contains `ERROR` and `MISSING` nodes.  The `node-has-error`
+//! function returns whether a syntax node is, or contains, a syntax error, so that rules can avoid
+//! creating misleading nodes for broken code.  The following example only exports the definitions
+//! of functions without syntax errors:
+//!
+//! ``` skip
+//! (function_definition name:(identifier)@name)@fun {
+//!   node def
+//!   attr (def) type = "pop_symbol", symbol = (source-text @name), source_node = @fun
+//!   attr (def) is_definition = (not (node-has-error @fun))
+//! }
+//! ```
+//!
+//! ### Debugging rules
+//!
+//! The `node-description` function describes a syntax node with its type, the start of its source
+//! text, and its location, which is more useful than the type alone when printing nodes while
+//! developing rules:
+//!
+//! ``` skip
+//! (call function:(identifier)@name) {
+//!   print (node-description @name)
+//! }
+//! ```
+//!
+//! This prints, e.g., ``identifier `handle_error` at 61:6``.
+//!
+//! The `check` and `fail-at` functions make the build fail with an error that includes the type
+//! and location of a syntax node.  `check` takes a condition, a syntax node, and a message, and
+//! fails if the condition is false.  Otherwise it returns the syntax node, so that the check can be
+//! part of the expression that uses the node.  `fail-at` takes a syntax node and a message, and
+//! always fails, which is useful for syntax that the rules do not support.  Because rules are
+//! evaluated lazily, these functions must be part of a value that ends up in the graph:
+//!
+//! ``` skip
+//! (import_from_statement module_name:(_)@name) {
+//!   node ref
+//!   attr (ref) node_reference = (check (eq (node-type @name) "dotted_name") @name "expected module name")
+//! }
+//! ```
+//!
+//! This fails with, e.g., ``expected module name at relative_import 3:6``.
+//!
+//! ### Determinism
+//!
+//! None of the built-in functions that are available to the rules read the file system, the
+//! environment, or the clock, and the path functions only manipulate path strings.  With only
+//! these functions, the stack graph of a file only depends on its source, its path, the global
+//! variables that the caller provides, and the graph construction rules, which is what allows
+//! tools to store the results of a file, and reuse them until any of these inputs change.
+//!
+//! This does not hold for functions that callers add to a language with
+//! [`StackGraphLanguage::functions_mut`][], which can compute their result from anything.  Inputs
+//! that are not part of a file, such as the import path of a Go package, which depends on the
+//! `go.mod` file of its module, should instead be computed by the caller and provided as global
+//! variables, so that tools can include them in what they compare.  See the [`go`][] module for
+//! an example.
+//!
+//! [`StackGraphLanguage::functions_mut`]: struct.StackGraphLanguage.html#method.functions_mut
+//! [`go`]: go/index.html
+//!
 //! ## Using this crate from Rust
 //!
 //! If you need very fine-grained control over how to use the resulting stack graphs, you can
@@ -291,19 +434,49 @@
 //! # Ok(())
 //! # }
 //! ```
+//!
+//! ## Building stack graphs concurrently
+//!
+//! A [`StackGraph`][stack_graphs::graph::StackGraph] cannot be modified from several threads at
+//! once, and a [`StackGraphLanguage`][] holds a parser, so it cannot be shared between threads
+//! either.  To build the stack graphs of many files in parallel, give every thread its own
+//! language, and build every file into a stack graph of its own.  The finished stack graphs can
+//! then be sent to a single thread, which combines them using
+//! [`StackGraph::add_from_graph`][stack_graphs::graph::StackGraph::add_from_graph], or stores
+//! them in a database.  Because every file is built on a single thread, the result does not depend
+//! on the number of threads, and no locking is needed while the rules are executed.  The `index`
+//! command of the `tree-sitter-stack-graphs` program works this way.
+//!
+//! ## Tracing
+//!
+//! Building a stack graph is instrumented with [`tracing`][] spans, for parsing the source file,
+//! executing the graph construction rules, and loading the stack graph.  The span for loading the
+//! stack graph records the number of nodes and edges that were created.  Spans are only recorded
+//! if the application installs a tracing subscriber, such as one that exports them to
+//! OpenTelemetry.
+//!
+//! [`tracing`]: https://docs.rs/tracing/
 
 use controlled_option::ControlledOption;
 use lazy_static::lazy_static;
 use lsp_positions::SpanCalculator;
 use stack_graphs::arena::Handle;
+use stack_graphs::cancellation::CancellationError;
+use stack_graphs::cancellation::CancellationFlag;
+use stack_graphs::cancellation::NoCancellation;
 use stack_graphs::graph::File;
 use stack_graphs::graph::Node;
 use stack_graphs::graph::NodeID;
 use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
 use std::collections::HashSet;
+use std::time::Duration;
+use std::time::Instant;
 use thiserror::Error;
 use tree_sitter::Parser;
+use tree_sitter::Tree;
 use tree_sitter_graph::functions::Functions;
+use tree_sitter_graph::graph::Edge as GraphEdge;
 use tree_sitter_graph::graph::Graph;
 use tree_sitter_graph::graph::GraphNode;
 use tree_sitter_graph::graph::GraphNodeRef;
@@ -313,7 +486,12 @@ use tree_sitter_graph::parse_error::TreeWithParseErrorVec;
 use tree_sitter_graph::ExecutionConfig;
 use tree_sitter_graph::Variables;
 
+use crate::injection::Injection;
+use crate::injection::InjectionQuery;
+
 pub mod functions;
+pub mod go;
+pub mod injection;
 pub mod loader;
 pub mod test;
 
@@ -327,26 +505,46 @@ static SCOPE_TYPE: &'static str = "scope";
 
 // Node attribute names
 static DEBUG_ATTR_PREFIX: &'static str = "debug_";
+static DEFINIENS_NODE_ATTR: &'static str = "definiens_node";
+static DOCUMENTATION_ATTR: &'static str = "documentation";
 static IS_DEFINITION_ATTR: &'static str = "is_definition";
 static IS_EXPORTED_ATTR: &'static str = "is_exported";
 static IS_ENDPOINT_ATTR: &'static str = "is_endpoint";
 static IS_REFERENCE_ATTR: &'static str = "is_reference";
+static NODE_DEFINITION_ATTR: &'static str = "node_definition";
+static NODE_REFERENCE_ATTR: &'static str = "node_reference";
 static SCOPE_ATTR: &'static str = "scope";
 static SOURCE_NODE_ATTR: &'static str = "source_node";
 static SYMBOL_ATTR: &'static str = "symbol";
+static SYNTAX_TYPE_ATTR: &'static str = "syntax_type";
 static TYPE_ATTR: &'static str = "type";
 
 // Expected attributes per node type
 lazy_static! {
     static ref DROP_SCOPES_ATTRS: HashSet<&'static str> = HashSet::from([TYPE_ATTR]);
-    static ref POP_SCOPED_SYMBOL_ATTRS: HashSet<&'static str> =
-        HashSet::from([TYPE_ATTR, SYMBOL_ATTR, IS_DEFINITION_ATTR]);
-    static ref POP_SYMBOL_ATTRS: HashSet<&'static str> =
-        HashSet::from([TYPE_ATTR, SYMBOL_ATTR, IS_DEFINITION_ATTR]);
+    static ref POP_SCOPED_SYMBOL_ATTRS: HashSet<&'static str> = HashSet::from([
+        TYPE_ATTR,
+        SYMBOL_ATTR,
+        IS_DEFINITION_ATTR,
+        DOCUMENTATION_ATTR,
+        DEFINIENS_NODE_ATTR
+    ]);
+    static ref POP_SYMBOL_ATTRS: HashSet<&'static str> = HashSet::from([
+        TYPE_ATTR,
+        SYMBOL_ATTR,
+        IS_DEFINITION_ATTR,
+        DOCUMENTATION_ATTR,
+        DEFINIENS_NODE_ATTR,
+        NODE_DEFINITION_ATTR
+    ]);
     static ref PUSH_SCOPED_SYMBOL_ATTRS: HashSet<&'static str> =
         HashSet::from([TYPE_ATTR, SYMBOL_ATTR, SCOPE_ATTR, IS_REFERENCE_ATTR]);
-    static ref PUSH_SYMBOL_ATTRS: HashSet<&'static str> =
-        HashSet::from([TYPE_ATTR, SYMBOL_ATTR, IS_REFERENCE_ATTR]);
+    static ref PUSH_SYMBOL_ATTRS: HashSet<&'static str> = HashSet::from([
+        TYPE_ATTR,
+        SYMBOL_ATTR,
+        IS_REFERENCE_ATTR,
+        NODE_REFERENCE_ATTR
+    ]);
     static ref SCOPE_ATTRS: HashSet<&'static str> =
         HashSet::from([TYPE_ATTR, IS_EXPORTED_ATTR, IS_ENDPOINT_ATTR]);
 }
@@ -357,14 +555,61 @@ static PRECEDENCE_ATTR: &'static str = "precedence";
 // Global variables
 static ROOT_NODE_VAR: &'static str = "ROOT_NODE";
 static JUMP_TO_SCOPE_NODE_VAR: &'static str = "JUMP_TO_SCOPE_NODE";
-static FILE_PATH_VAR: &'static str = "FILE_PATH";
+/// The name of the global variable that holds the path of the file, which is always provided.
+pub static FILE_PATH_VAR: &'static str = "FILE_PATH";
+static SYNTAX_ROOT_NODE_VAR: &'static str = "SYNTAX_ROOT_NODE";
+static LANGUAGE_VAR: &'static str = "LANGUAGE";
+/// The name of the global variable that holds the root directory of the project that contains the
+/// file, if the caller provides it.
+pub static ROOT_PATH_VAR: &'static str = "ROOT_PATH";
+
+/// Returns whether the global variable with the given name is set for every file when a stack
+/// graph is built, in which case callers cannot set it.
+pub fn is_reserved_global(name: &str) -> bool {
+    [
+        ROOT_NODE_VAR,
+        JUMP_TO_SCOPE_NODE_VAR,
+        FILE_PATH_VAR,
+        SYNTAX_ROOT_NODE_VAR,
+        LANGUAGE_VAR,
+    ]
+    .contains(&name)
+}
 
 /// Holds information about how to construct stack graphs for a particular language
 pub struct StackGraphLanguage {
+    language: tree_sitter::Language,
+    name: Option<String>,
     parser: Parser,
     tsg: tree_sitter_graph::ast::File,
     functions: Functions,
     builtins: StackGraph,
+    injections: Option<InjectionQuery>,
+    syntax_error_policy: SyntaxErrorPolicy,
+    keep_partial_graphs: bool,
+    strict_attributes: bool,
+    globals: BTreeMap<String, String>,
+}
+
+/// Determines how stack graphs are built for source files that contain syntax errors
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum SyntaxErrorPolicy {
+    /// Fail with [`LoadError::ParseErrors`][].  This is the default.
+    ///
+    /// [`LoadError::ParseErrors`]: enum.LoadError.html#variant.ParseErrors
+    Fail,
+    /// Execute the graph construction rules against the whole syntax tree, but leave out the
+    /// stack graph nodes whose source node is part of a syntax error, together with their edges.
+    OmitErrors,
+    /// Execute the graph construction rules against the whole syntax tree, and keep all of the
+    /// stack graph nodes they create.
+    BestEffort,
+}
+
+impl Default for SyntaxErrorPolicy {
+    fn default() -> SyntaxErrorPolicy {
+        SyntaxErrorPolicy::Fail
+    }
 }
 
 impl StackGraphLanguage {
@@ -378,10 +623,17 @@ impl StackGraphLanguage {
         let mut parser = Parser::new();
         parser.set_language(language)?;
         Ok(StackGraphLanguage {
+            language,
+            name: None,
             parser,
             tsg,
             functions: Self::default_functions(),
             builtins: StackGraph::new(),
+            injections: None,
+            syntax_error_policy: SyntaxErrorPolicy::Fail,
+            keep_partial_graphs: false,
+            strict_attributes: false,
+            globals: BTreeMap::new(),
         })
     }
 
@@ -395,23 +647,70 @@ impl StackGraphLanguage {
         parser.set_language(language)?;
         let tsg = tree_sitter_graph::ast::File::from_str(language, tsg_source)?;
         Ok(StackGraphLanguage {
+            language,
+            name: None,
             parser,
             tsg,
             functions: Self::default_functions(),
             builtins: StackGraph::new(),
+            injections: None,
+            syntax_error_policy: SyntaxErrorPolicy::Fail,
+            keep_partial_graphs: false,
+            strict_attributes: false,
+            globals: BTreeMap::new(),
         })
     }
 
     fn default_functions() -> tree_sitter_graph::functions::Functions {
         let mut functions = tree_sitter_graph::functions::Functions::stdlib();
         crate::functions::add_path_functions(&mut functions);
+        crate::functions::add_syntax_functions(&mut functions);
         functions
     }
 
+    /// Returns the tree-sitter language that this stack graph language is defined for.
+    pub fn language(&self) -> tree_sitter::Language {
+        self.language
+    }
+
+    /// Returns the name of this stack graph language, if it has one.
+    pub fn name(&self) -> Option<&str> {
+        self.name.as_deref()
+    }
+
+    /// Sets the name of this stack graph language, such as its tree-sitter scope.  The name is
+    /// available to the graph construction rules in the global variable `LANGUAGE`.
+    pub fn set_name(&mut self, name: String) {
+        self.name = Some(name);
+    }
+
+    /// Sets a global variable that is passed to the graph construction rules of every file, unless
+    /// the caller sets a variable with the same name.  This lets configurations set the options of
+    /// a language, e.g., which variant of the language the rules support.  Reserved variables,
+    /// see [`is_reserved_global`][], cannot be set.
+    pub fn set_global(&mut self, name: String, value: String) -> Result<(), LoadError> {
+        if is_reserved_global(&name) {
+            return Err(LoadError::ReservedGlobal(name));
+        }
+        self.globals.insert(name, value);
+        Ok(())
+    }
+
     pub fn functions_mut(&mut self) -> &mut tree_sitter_graph::functions::Functions {
         &mut self.functions
     }
 
+    /// Returns whether the graph construction rules of this language declare the global variable
+    /// with the given name.  Rules can only read the global variables they declare, so callers
+    /// can use this to find out whether the stack graph of a file depends on a variable, such as
+    /// `FILE_PATH`.
+    pub fn declares_global(&self, name: &str) -> bool {
+        self.tsg
+            .globals
+            .iter()
+            .any(|global| global.name.as_str() == name)
+    }
+
     pub fn builtins(&self) -> &StackGraph {
         &self.builtins
     }
@@ -419,6 +718,71 @@ impl StackGraphLanguage {
     pub fn builtins_mut(&mut self) -> &mut StackGraph {
         &mut self.builtins
     }
+
+    /// Returns how stack graphs are built for source files that contain syntax errors.
+    pub fn syntax_error_policy(&self) -> SyntaxErrorPolicy {
+        self.syntax_error_policy
+    }
+
+    /// Sets how stack graphs are built for source files that contain syntax errors.
+    pub fn set_syntax_error_policy(&mut self, policy: SyntaxErrorPolicy) {
+        self.syntax_error_policy = policy;
+    }
+
+    /// Sets whether the stack graph nodes and edges that were created before the graph
+    /// construction rules failed are kept.  If they are, they are loaded into the stack graph,
+    /// skipping any nodes that are incomplete, and the build fails with
+    /// [`LoadError::PartialGraph`][], so that callers can decide whether to use the partial graph.
+    /// By default, nothing is loaded into the stack graph if the rules fail.
+    ///
+    /// [`LoadError::PartialGraph`]: enum.LoadError.html#variant.PartialGraph
+    pub fn set_keep_partial_graphs(&mut self, keep: bool) {
+        self.keep_partial_graphs = keep;
+    }
+
+    /// Sets whether unexpected attributes on graph nodes and edges make the build fail with
+    /// [`LoadError::UnexpectedAttribute`][], instead of only logging a warning.  Every node type
+    /// accepts a fixed set of attributes, in addition to `source_node`, `syntax_type`, and
+    /// `debug_*` attributes, and edges only accept `precedence`.  Strict attributes catch typos in
+    /// attribute names, which would otherwise silently produce incorrect graphs.
+    ///
+    /// [`LoadError::UnexpectedAttribute`]: enum.LoadError.html#variant.UnexpectedAttribute
+    pub fn set_strict_attributes(&mut self, strict: bool) {
+        self.strict_attributes = strict;
+    }
+
+    /// Sets the query that finds regions of code in other languages in source files of this
+    /// language.  See the [`injection`][] module for the format of the query.
+    ///
+    /// [`injection`]: injection/index.html
+    pub fn set_injections_query(&mut self, query_source: &str) -> Result<(), LanguageError> {
+        self.injections = Some(InjectionQuery::from_str(self.language, query_source)?);
+        Ok(())
+    }
+
+    /// Returns whether an injections query is set for this language.
+    pub fn has_injections_query(&self) -> bool {
+        self.injections.is_some()
+    }
+
+    /// Returns the regions of code in other languages in a source file of this language.  Returns
+    /// no regions if no injections query is set.  The stack graph for each region can be built
+    /// into the same file, by passing the [`Injection::source`][] of the region to the
+    /// [`build_stack_graph_into`][] method of the language of the region.
+    ///
+    /// [`Injection::source`]: injection/struct.Injection.html#method.source
+    /// [`build_stack_graph_into`]: #method.build_stack_graph_into
+    pub fn find_injections(&mut self, source: &str) -> Result<Vec<Injection>, LoadError> {
+        let injections = match &self.injections {
+            Some(injections) => injections,
+            None => return Ok(Vec::new()),
+        };
+        let tree = self
+            .parser
+            .parse(source, None)
+            .ok_or(LoadError::ParseError)?;
+        Ok(injections.find_injections(&tree, source))
+    }
 }
 
 /// An error that can occur while loading in the TSG stack graph construction rules for a language
@@ -428,13 +792,17 @@ pub enum LanguageError {
     LanguageError(#[from] tree_sitter::LanguageError),
     #[error(transparent)]
     ParseError(#[from] tree_sitter_graph::ParseError),
+    #[error(transparent)]
+    QueryError(#[from] tree_sitter::QueryError),
 }
 
 impl StackGraphLanguage {
     /// Executes the graph construction rules for this language against a source file, creating new
     /// nodes and edges in `stack_graph`.  Any new nodes that we create will belong to `file`.
     /// (The source file must be implemented in this language, otherwise you'll probably get a
-    /// parse error.)
+    /// parse error.)  If `file` already contains nodes, e.g., because the stack graph of the
+    /// code that an injected region is embedded in was built into it, the new nodes get local IDs
+    /// that follow the existing ones.
     pub fn build_stack_graph_into(
         &mut self,
         stack_graph: &mut StackGraph,
@@ -442,41 +810,214 @@ impl StackGraphLanguage {
         source: &str,
         globals: &mut Variables,
     ) -> Result<(), LoadError> {
+        self.build_stack_graph_into_with_cancellation(
+            stack_graph,
+            file,
+            source,
+            globals,
+            &NoCancellation,
+        )?;
+        Ok(())
+    }
+
+    /// Executes the graph construction rules for this language against a source file, like
+    /// [`build_stack_graph_into`][].  The cancellation flag is checked between the phases of the
+    /// construction, and while the stack graph is loaded, and [`LoadError::Cancelled`][] is
+    /// returned as soon as it indicates that construction should be cancelled.  Parsing and the
+    /// execution of the graph construction rules cannot be interrupted, so the flag is only
+    /// checked after each of them finishes.  Returns statistics about the construction.
+    ///
+    /// [`build_stack_graph_into`]: #method.build_stack_graph_into
+    /// [`LoadError::Cancelled`]: enum.LoadError.html#variant.Cancelled
+    pub fn build_stack_graph_into_with_cancellation(
+        &mut self,
+        stack_graph: &mut StackGraph,
+        file: Handle<File>,
+        source: &str,
+        globals: &mut Variables,
+        cancellation_flag: &dyn CancellationFlag,
+    ) -> Result<BuildStats, LoadError> {
+        cancellation_flag.check("parsing source")?;
+        let start = Instant::now();
+        let tree = self.parse(source, None)?;
+        let parse_time = start.elapsed();
+        cancellation_flag.check("parsing source")?;
+
+        match self.build_stack_graph_from_tree_into_with_cancellation(
+            stack_graph,
+            file,
+            &tree,
+            source,
+            globals,
+            cancellation_flag,
+        ) {
+            Ok(mut stats) => {
+                stats.parse_time = parse_time;
+                Ok(stats)
+            }
+            Err(LoadError::PartialGraph(err, mut stats)) => {
+                stats.parse_time = parse_time;
+                Err(LoadError::PartialGraph(err, stats))
+            }
+            Err(err) => Err(err),
+        }
+    }
+
+    /// Parses a source file in this language, and returns its syntax tree.  Fails with
+    /// [`LoadError::ParseErrors`][] if the source contains syntax errors, unless the
+    /// [syntax error policy][`SyntaxErrorPolicy`] allows them.
+    ///
+    /// If `old_tree` is given, it must be the tree of a previous version of the source, which has
+    /// been updated with [`Tree::edit`][] for every change that was made to the source since.
+    /// Parts of the old tree that are not affected by the changes are reused, which makes parsing
+    /// much faster for small changes, such as the ones made in an editor.
+    ///
+    /// [`LoadError::ParseErrors`]: enum.LoadError.html#variant.ParseErrors
+    /// [`Tree::edit`]: https://docs.rs/tree-sitter/*/tree_sitter/struct.Tree.html#method.edit
+    pub fn parse(&mut self, source: &str, old_tree: Option<&Tree>) -> Result<Tree, LoadError> {
+        let _span = tracing::info_span!("parse", bytes = source.len()).entered();
         let tree = self
             .parser
-            .parse(source, None)
+            .parse(source, old_tree)
             .ok_or(LoadError::ParseError)?;
-
+        if self.syntax_error_policy != SyntaxErrorPolicy::Fail {
+            return Ok(tree);
+        }
         let parse_errors = ParseError::into_all(tree);
         if parse_errors.errors().len() > 0 {
             return Err(LoadError::ParseErrors(parse_errors));
         }
-        let tree = parse_errors.into_tree();
+        Ok(parse_errors.into_tree())
+    }
 
+    /// Executes the graph construction rules for this language against the syntax tree of a
+    /// source file, which was returned by [`parse`][], creating new nodes and edges in
+    /// `stack_graph`, like [`build_stack_graph_into_with_cancellation`][].  Together with the
+    /// `old_tree` argument of [`parse`][], this lets callers reparse a changed file incrementally.
+    /// The graph construction rules are always executed against the whole tree, because their
+    /// results can depend on any part of it, so the stack graph of a changed file must be built
+    /// into a stack graph that does not contain the previous version of the file.
+    ///
+    /// [`parse`]: #method.parse
+    /// [`build_stack_graph_into_with_cancellation`]: #method.build_stack_graph_into_with_cancellation
+    pub fn build_stack_graph_from_tree_into_with_cancellation(
+        &mut self,
+        stack_graph: &mut StackGraph,
+        file: Handle<File>,
+        tree: &Tree,
+        source: &str,
+        globals: &mut Variables,
+        cancellation_flag: &dyn CancellationFlag,
+    ) -> Result<BuildStats, LoadError> {
+        let mut stats = BuildStats::default();
+        stats.has_syntax_errors = tree.root_node().has_error();
         let mut graph = Graph::new();
         globals
             .add(ROOT_NODE_VAR.into(), graph.add_graph_node().into())
-            .expect("Failed to set ROOT_NODE");
+            .map_err(|_| LoadError::ReservedGlobal(ROOT_NODE_VAR.into()))?;
         globals
             .add(JUMP_TO_SCOPE_NODE_VAR.into(), graph.add_graph_node().into())
-            .expect("Failed to set JUMP_TO_SCOPE_NODE");
+            .map_err(|_| LoadError::ReservedGlobal(JUMP_TO_SCOPE_NODE_VAR.into()))?;
         globals
             .add(
                 FILE_PATH_VAR.into(),
                 format!("{}", &stack_graph[file]).into(),
             )
-            .expect("Failed to set FILE_PATH");
+            .map_err(|_| LoadError::ReservedGlobal(FILE_PATH_VAR.into()))?;
+        globals
+            .add(
+                SYNTAX_ROOT_NODE_VAR.into(),
+                graph.add_syntax_node(tree.root_node()).into(),
+            )
+            .map_err(|_| LoadError::ReservedGlobal(SYNTAX_ROOT_NODE_VAR.into()))?;
+        if let Some(name) = &self.name {
+            globals
+                .add(LANGUAGE_VAR.into(), name.as_str().into())
+                .map_err(|_| LoadError::ReservedGlobal(LANGUAGE_VAR.into()))?;
+        }
+        for (name, value) in &self.globals {
+            // Variables that the caller sets take precedence.
+            let _ = globals.add(name.as_str().into(), value.as_str().into());
+        }
+        let start = Instant::now();
         let mut config = ExecutionConfig::new(&mut self.functions, &globals)
             .lazy(true)
             .debug_attributes(
                 [DEBUG_ATTR_PREFIX, "tsg_location"].concat().as_str().into(),
                 [DEBUG_ATTR_PREFIX, "tsg_variable"].concat().as_str().into(),
             );
-        self.tsg
-            .execute_into(&mut graph, &tree, source, &mut config)?;
+        let execution_result = {
+            let _span = tracing::info_span!("execute_rules", file = %stack_graph[file]).entered();
+            self.tsg.execute_into(&mut graph, tree, source, &mut config)
+        };
+        stats.execution_time = start.elapsed();
+        let execution_error = match execution_result {
+            Ok(()) => None,
+            Err(err) if self.keep_partial_graphs => Some(err),
+            Err(err) => return Err(err.into()),
+        };
+        cancellation_flag.check("executing graph construction rules")?;
 
-        let mut loader = StackGraphLoader::new(stack_graph, file, &graph, source);
-        loader.load()
+        let start = Instant::now();
+        let span = tracing::info_span!(
+            "load_graph",
+            file = %stack_graph[file],
+            nodes = tracing::field::Empty,
+            edges = tracing::field::Empty,
+        )
+        .entered();
+        let omit_errors = self.syntax_error_policy == SyntaxErrorPolicy::OmitErrors;
+        let mut loader = StackGraphLoader::new(
+            stack_graph,
+            file,
+            &graph,
+            source,
+            omit_errors,
+            execution_error.is_some(),
+            self.strict_attributes,
+            cancellation_flag,
+        );
+        loader.load()?;
+        stats.load_time = start.elapsed();
+        stats.node_count = loader.node_count;
+        stats.edge_count = loader.edge_count;
+        span.record("nodes", &stats.node_count);
+        span.record("edges", &stats.edge_count);
+        if let Some(err) = execution_error {
+            return Err(LoadError::PartialGraph(err, stats));
+        }
+        Ok(stats)
+    }
+}
+
+/// Statistics about the construction of the stack graph for a source file
+#[derive(Clone, Debug, Default)]
+pub struct BuildStats {
+    /// The time spent parsing the source file
+    pub parse_time: Duration,
+    /// The time spent executing the graph construction rules
+    pub execution_time: Duration,
+    /// The time spent loading the constructed graph into the stack graph
+    pub load_time: Duration,
+    /// The number of stack graph nodes that were created
+    pub node_count: usize,
+    /// The number of stack graph edges that were created
+    pub edge_count: usize,
+    /// Whether the source file contains syntax errors.  This can only be the case if the
+    /// [syntax error policy][`SyntaxErrorPolicy`] allows them.
+    pub has_syntax_errors: bool,
+}
+
+impl BuildStats {
+    /// Adds the statistics of another stack graph construction to these, e.g., to combine the
+    /// statistics of a file and of the code that is injected into it, or of many files.
+    pub fn add(&mut self, other: &BuildStats) {
+        self.parse_time += other.parse_time;
+        self.execution_time += other.execution_time;
+        self.load_time += other.load_time;
+        self.node_count += other.node_count;
+        self.edge_count += other.edge_count;
+        self.has_syntax_errors |= other.has_syntax_errors;
     }
 }
 
@@ -495,14 +1036,31 @@ pub enum LoadError {
     UnknownNodeType(String),
     #[error("Unknown symbol type {0}")]
     UnknownSymbolType(String),
+    /// A graph node or edge has an attribute that its type does not accept.  Only reported if
+    /// attributes are strict, see [`StackGraphLanguage::set_strict_attributes`][].
+    ///
+    /// [`StackGraphLanguage::set_strict_attributes`]: struct.StackGraphLanguage.html#method.set_strict_attributes
+    #[error("Unexpected attribute ‘{0}’ on {1}")]
+    UnexpectedAttribute(String, String),
     #[error(transparent)]
     ExecutionError(#[from] tree_sitter_graph::ExecutionError),
+    /// The graph construction rules failed, but the nodes and edges that were created before
+    /// they did were loaded into the stack graph.  See
+    /// [`StackGraphLanguage::set_keep_partial_graphs`][].
+    ///
+    /// [`StackGraphLanguage::set_keep_partial_graphs`]: struct.StackGraphLanguage.html#method.set_keep_partial_graphs
+    #[error("{0} (partial graph kept)")]
+    PartialGraph(tree_sitter_graph::ExecutionError, BuildStats),
     #[error("Error parsing source")]
     ParseError,
     #[error("Error parsing source")]
     ParseErrors(TreeWithParseErrorVec),
     #[error("Error converting shorthand ‘{0}’ on {1} with value {2}")]
     ConversionError(String, String, String),
+    #[error(transparent)]
+    Cancelled(#[from] CancellationError),
+    #[error("Global variable {0} is reserved, and cannot be set by the caller")]
+    ReservedGlobal(String),
 }
 
 struct StackGraphLoader<'a> {
@@ -511,6 +1069,28 @@ struct StackGraphLoader<'a> {
     graph: &'a Graph<'a>,
     source: &'a str,
     span_calculator: SpanCalculator<'a>,
+    /// Whether nodes whose source node is part of a syntax error are left out.
+    omit_errors: bool,
+    /// Whether the graph is incomplete, because the graph construction rules failed.  Nodes and
+    /// edges that cannot be loaded are skipped, instead of failing the load.
+    partial: bool,
+    /// Whether unexpected attributes fail the load, instead of being logged.
+    strict_attributes: bool,
+    cancellation_flag: &'a dyn CancellationFlag,
+    /// The local ID of the first node that is loaded.  Nodes that already exist in the file keep
+    /// their IDs, so that several graphs can be loaded into the same file.
+    first_local_id: u32,
+    node_count: usize,
+    edge_count: usize,
+}
+
+/// Returns the source node of a graph node, which is given by its `source_node` attribute, or
+/// else by its `node_definition` or `node_reference` attribute.
+fn source_node_attribute(node: &GraphNode) -> Option<&Value> {
+    node.attributes
+        .get(SOURCE_NODE_ATTR)
+        .or_else(|| node.attributes.get(NODE_DEFINITION_ATTR))
+        .or_else(|| node.attributes.get(NODE_REFERENCE_ATTR))
 }
 
 impl<'a> StackGraphLoader<'a> {
@@ -519,14 +1099,26 @@ impl<'a> StackGraphLoader<'a> {
         file: Handle<File>,
         graph: &'a Graph<'a>,
         source: &'a str,
+        omit_errors: bool,
+        partial: bool,
+        strict_attributes: bool,
+        cancellation_flag: &'a dyn CancellationFlag,
     ) -> Self {
         let span_calculator = SpanCalculator::new(source);
+        let first_local_id = stack_graph.new_node_id(file).local_id();
         StackGraphLoader {
             stack_graph,
             file,
             graph,
             source,
             span_calculator,
+            omit_errors,
+            partial,
+            strict_attributes,
+            cancellation_flag,
+            first_local_id,
+            node_count: 0,
+            edge_count: 0,
         }
     }
 }
@@ -537,17 +1129,18 @@ impl<'a> StackGraphLoader<'a> {
         // two DSL nodes that we create are the proxies for the stack graph's “root” and “jump to
         // scope” nodes.)
         for node_ref in self.graph.iter_nodes().skip(2) {
+            self.cancellation_flag.check("loading graph nodes")?;
             let node = &self.graph[node_ref];
-            let handle = match get_node_type(node)? {
-                NodeType::DropScopes => self.load_drop_scopes(node_ref),
-                NodeType::PopScopedSymbol => self.load_pop_scoped_symbol(node, node_ref)?,
-                NodeType::PopSymbol => self.load_pop_symbol(node, node_ref)?,
-                NodeType::PushScopedSymbol => self.load_push_scoped_symbol(node, node_ref)?,
-                NodeType::PushSymbol => self.load_push_symbol(node, node_ref)?,
-                NodeType::Scope => self.load_scope(node, node_ref)?,
-            };
-            self.load_span(node, handle)?;
-            self.load_debug_info(node, handle)?;
+            if self.omit_errors && self.is_in_syntax_error(node)? {
+                continue;
+            }
+            match self.load_node(node, node_ref) {
+                Ok(()) => self.node_count += 1,
+                // The rules of partial graphs may have failed before all attributes of a node
+                // were set, so we skip nodes that cannot be loaded.
+                Err(_) if self.partial => continue,
+                Err(err) => return Err(err),
+            }
         }
 
         // Then add stack graph edges for each TSG edge.  Note that we _don't_ skip(2) here because
@@ -555,18 +1148,37 @@ impl<'a> StackGraphLoader<'a> {
         // (Technically the caller could add outgoing nodes from “jump to scope” as well, but those
         // are invalid according to the stack graph semantics and will never be followed.
         for source_ref in self.graph.iter_nodes() {
+            self.cancellation_flag.check("loading graph edges")?;
             let source = &self.graph[source_ref];
             let source_node_id = self.node_id_for_graph_node(source_ref);
-            let source_handle = self.stack_graph.node_for_id(source_node_id).unwrap();
+            // Nodes are only missing if they were omitted because of syntax errors, in which case
+            // their edges are omitted as well.
+            let source_handle = match self.stack_graph.node_for_id(source_node_id) {
+                Some(source_handle) => source_handle,
+                None => continue,
+            };
             for (sink_ref, edge) in source.iter_edges() {
+                match self.verify_edge_attributes(edge) {
+                    Ok(()) => {}
+                    Err(_) if self.partial => continue,
+                    Err(err) => return Err(err),
+                }
                 let precedence = match edge.attributes.get(PRECEDENCE_ATTR) {
-                    Some(precedence) => precedence.as_integer()? as i32,
+                    Some(precedence) => match precedence.as_integer() {
+                        Ok(precedence) => precedence as i32,
+                        Err(_) if self.partial => continue,
+                        Err(err) => return Err(err.into()),
+                    },
                     None => 0,
                 };
                 let sink_node_id = self.node_id_for_graph_node(sink_ref);
-                let sink_handle = self.stack_graph.node_for_id(sink_node_id).unwrap();
+                let sink_handle = match self.stack_graph.node_for_id(sink_node_id) {
+                    Some(sink_handle) => sink_handle,
+                    None => continue,
+                };
                 self.stack_graph
                     .add_edge(source_handle, sink_handle, precedence);
+                self.edge_count += 1;
             }
         }
 
@@ -586,6 +1198,12 @@ enum NodeType {
 fn get_node_type(node: &GraphNode) -> Result<NodeType, LoadError> {
     let node_type = match node.attributes.get(TYPE_ATTR) {
         Some(node_type) => node_type.as_str()?,
+        None if node.attributes.get(NODE_DEFINITION_ATTR).is_some() => {
+            return Ok(NodeType::PopSymbol)
+        }
+        None if node.attributes.get(NODE_REFERENCE_ATTR).is_some() => {
+            return Ok(NodeType::PushSymbol)
+        }
         None => return Ok(NodeType::Scope),
     };
     if node_type == DROP_SCOPES_TYPE {
@@ -613,13 +1231,52 @@ impl<'a> StackGraphLoader<'a> {
         } else if index == 1 {
             NodeID::jump_to()
         } else {
-            NodeID::new_in_file(self.file, (node_ref.index() as u32) - 2)
+            NodeID::new_in_file(self.file, self.first_local_id + (index as u32) - 2)
+        }
+    }
+
+    /// Returns whether the source node of a graph node is part of a syntax error, i.e., whether it,
+    /// or any of its ancestors, is an `ERROR` or `MISSING` node.  Nodes without a source node are
+    /// never part of a syntax error.
+    fn is_in_syntax_error(&self, node: &GraphNode) -> Result<bool, LoadError> {
+        let mut syntax_node = match source_node_attribute(node) {
+            Some(source_node) => Some(self.graph[source_node.as_syntax_node_ref()?]),
+            None => return Ok(false),
+        };
+        while let Some(current) = syntax_node {
+            if current.is_error() || current.is_missing() {
+                return Ok(true);
+            }
+            syntax_node = current.parent();
         }
+        Ok(false)
     }
 
-    fn load_drop_scopes(&mut self, node_ref: GraphNodeRef) -> Handle<Node> {
+    fn load_node(&mut self, node: &GraphNode, node_ref: GraphNodeRef) -> Result<(), LoadError> {
+        let handle = match get_node_type(node)? {
+            NodeType::DropScopes => self.load_drop_scopes(node, node_ref)?,
+            NodeType::PopScopedSymbol => self.load_pop_scoped_symbol(node, node_ref)?,
+            NodeType::PopSymbol => self.load_pop_symbol(node, node_ref)?,
+            NodeType::PushScopedSymbol => self.load_push_scoped_symbol(node, node_ref)?,
+            NodeType::PushSymbol => self.load_push_symbol(node, node_ref)?,
+            NodeType::Scope => self.load_scope(node, node_ref)?,
+        };
+        self.load_span(node, handle)?;
+        self.load_syntax_type(node, handle)?;
+        self.load_definiens(node, handle)?;
+        self.load_documentation(node, handle)?;
+        self.load_debug_info(node, handle)?;
+        Ok(())
+    }
+
+    fn load_drop_scopes(
+        &mut self,
+        node: &GraphNode,
+        node_ref: GraphNodeRef,
+    ) -> Result<Handle<Node>, LoadError> {
         let id = self.node_id_for_graph_node(node_ref);
-        self.stack_graph.add_drop_scopes_node(id).unwrap()
+        self.verify_attributes(node, DROP_SCOPES_TYPE, &DROP_SCOPES_ATTRS)?;
+        Ok(self.stack_graph.add_drop_scopes_node(id).unwrap())
     }
 
     fn load_pop_scoped_symbol(
@@ -634,7 +1291,7 @@ impl<'a> StackGraphLoader<'a> {
         let symbol = self.stack_graph.add_symbol(&symbol);
         let id = self.node_id_for_graph_node(node_ref);
         let is_definition = self.load_flag(node, IS_DEFINITION_ATTR)?;
-        self.verify_attributes(node, POP_SCOPED_SYMBOL_TYPE, &POP_SCOPED_SYMBOL_ATTRS);
+        self.verify_attributes(node, POP_SCOPED_SYMBOL_TYPE, &POP_SCOPED_SYMBOL_ATTRS)?;
         Ok(self
             .stack_graph
             .add_pop_scoped_symbol_node(id, symbol, is_definition)
@@ -646,14 +1303,12 @@ impl<'a> StackGraphLoader<'a> {
         node: &GraphNode,
         node_ref: GraphNodeRef,
     ) -> Result<Handle<Node>, LoadError> {
-        let symbol = match node.attributes.get(SYMBOL_ATTR) {
-            Some(symbol) => self.load_symbol(symbol)?,
-            None => return Err(LoadError::MissingSymbol(node_ref)),
-        };
+        let symbol = self.load_node_symbol(node, node_ref, NODE_DEFINITION_ATTR)?;
         let symbol = self.stack_graph.add_symbol(&symbol);
         let id = self.node_id_for_graph_node(node_ref);
-        let is_definition = self.load_flag(node, IS_DEFINITION_ATTR)?;
-        self.verify_attributes(node, POP_SYMBOL_TYPE, &POP_SYMBOL_ATTRS);
+        let is_definition = self.load_flag(node, IS_DEFINITION_ATTR)?
+            || node.attributes.get(NODE_DEFINITION_ATTR).is_some();
+        self.verify_attributes(node, POP_SYMBOL_TYPE, &POP_SYMBOL_ATTRS)?;
         Ok(self
             .stack_graph
             .add_pop_symbol_node(id, symbol, is_definition)
@@ -676,7 +1331,7 @@ impl<'a> StackGraphLoader<'a> {
             None => return Err(LoadError::MissingScope(node_ref)),
         };
         let is_reference = self.load_flag(node, IS_REFERENCE_ATTR)?;
-        self.verify_attributes(node, PUSH_SCOPED_SYMBOL_TYPE, &PUSH_SCOPED_SYMBOL_ATTRS);
+        self.verify_attributes(node, PUSH_SCOPED_SYMBOL_TYPE, &PUSH_SCOPED_SYMBOL_ATTRS)?;
         Ok(self
             .stack_graph
             .add_push_scoped_symbol_node(id, symbol, scope, is_reference)
@@ -688,14 +1343,12 @@ impl<'a> StackGraphLoader<'a> {
         node: &GraphNode,
         node_ref: GraphNodeRef,
     ) -> Result<Handle<Node>, LoadError> {
-        let symbol = match node.attributes.get(SYMBOL_ATTR) {
-            Some(symbol) => self.load_symbol(symbol)?,
-            None => return Err(LoadError::MissingSymbol(node_ref)),
-        };
+        let symbol = self.load_node_symbol(node, node_ref, NODE_REFERENCE_ATTR)?;
         let symbol = self.stack_graph.add_symbol(&symbol);
         let id = self.node_id_for_graph_node(node_ref);
-        let is_reference = self.load_flag(node, IS_REFERENCE_ATTR)?;
-        self.verify_attributes(node, PUSH_SYMBOL_TYPE, &PUSH_SYMBOL_ATTRS);
+        let is_reference = self.load_flag(node, IS_REFERENCE_ATTR)?
+            || node.attributes.get(NODE_REFERENCE_ATTR).is_some();
+        self.verify_attributes(node, PUSH_SYMBOL_TYPE, &PUSH_SYMBOL_ATTRS)?;
         Ok(self
             .stack_graph
             .add_push_symbol_node(id, symbol, is_reference)
@@ -710,10 +1363,30 @@ impl<'a> StackGraphLoader<'a> {
         let id = self.node_id_for_graph_node(node_ref);
         let is_exported =
             self.load_flag(node, IS_EXPORTED_ATTR)? || self.load_flag(node, IS_ENDPOINT_ATTR)?;
-        self.verify_attributes(node, SCOPE_TYPE, &SCOPE_ATTRS);
+        self.verify_attributes(node, SCOPE_TYPE, &SCOPE_ATTRS)?;
         Ok(self.stack_graph.add_scope_node(id, is_exported).unwrap())
     }
 
+    /// Returns the symbol of a pop or push symbol node, which is given by its `symbol` attribute,
+    /// or else is the source text of the syntax node in the given shorthand attribute.
+    fn load_node_symbol(
+        &self,
+        node: &GraphNode,
+        node_ref: GraphNodeRef,
+        shorthand_attribute: &str,
+    ) -> Result<String, LoadError> {
+        if let Some(symbol) = node.attributes.get(SYMBOL_ATTR) {
+            return self.load_symbol(symbol);
+        }
+        match node.attributes.get(shorthand_attribute) {
+            Some(syntax_node) => {
+                let syntax_node = &self.graph[syntax_node.as_syntax_node_ref()?];
+                Ok(self.source[syntax_node.byte_range()].to_string())
+            }
+            None => Err(LoadError::MissingSymbol(node_ref)),
+        }
+    }
+
     fn load_symbol(&self, value: &Value) -> Result<String, LoadError> {
         match value {
             Value::Integer(i) => Ok(i.to_string()),
@@ -732,7 +1405,7 @@ impl<'a> StackGraphLoader<'a> {
     }
 
     fn load_span(&mut self, node: &GraphNode, node_handle: Handle<Node>) -> Result<(), LoadError> {
-        let source_node = match node.attributes.get(SOURCE_NODE_ATTR) {
+        let source_node = match source_node_attribute(node) {
             Some(source_node) => &self.graph[source_node.as_syntax_node_ref()?],
             None => return Ok(()),
         };
@@ -745,6 +1418,48 @@ impl<'a> StackGraphLoader<'a> {
         Ok(())
     }
 
+    fn load_syntax_type(
+        &mut self,
+        node: &GraphNode,
+        node_handle: Handle<Node>,
+    ) -> Result<(), LoadError> {
+        let syntax_type = match node.attributes.get(SYNTAX_TYPE_ATTR) {
+            Some(Value::Null) | None => return Ok(()),
+            Some(syntax_type) => syntax_type.as_str()?,
+        };
+        let syntax_type = self.stack_graph.add_string(syntax_type);
+        self.stack_graph.source_info_mut(node_handle).syntax_type = Some(syntax_type);
+        Ok(())
+    }
+
+    fn load_definiens(
+        &mut self,
+        node: &GraphNode,
+        node_handle: Handle<Node>,
+    ) -> Result<(), LoadError> {
+        let definiens_node = match node.attributes.get(DEFINIENS_NODE_ATTR) {
+            Some(Value::Null) | None => return Ok(()),
+            Some(definiens_node) => &self.graph[definiens_node.as_syntax_node_ref()?],
+        };
+        let span = self.span_calculator.for_node(definiens_node);
+        self.stack_graph.source_info_mut(node_handle).definiens_span = span;
+        Ok(())
+    }
+
+    fn load_documentation(
+        &mut self,
+        node: &GraphNode,
+        node_handle: Handle<Node>,
+    ) -> Result<(), LoadError> {
+        let documentation = match node.attributes.get(DOCUMENTATION_ATTR) {
+            Some(Value::Null) | None => return Ok(()),
+            Some(documentation) => documentation.as_str()?,
+        };
+        let documentation = self.stack_graph.add_string(documentation);
+        self.stack_graph.source_info_mut(node_handle).documentation = Some(documentation);
+        Ok(())
+    }
+
     fn load_debug_info(
         &mut self,
         node: &GraphNode,
@@ -772,15 +1487,44 @@ impl<'a> StackGraphLoader<'a> {
         node: &GraphNode,
         node_type: &str,
         allowed_attributes: &HashSet<&'static str>,
-    ) {
+    ) -> Result<(), LoadError> {
         for (id, _) in node.attributes.iter() {
             let id = id.as_str();
             if !allowed_attributes.contains(id)
                 && id != SOURCE_NODE_ATTR
+                && id != SYNTAX_TYPE_ATTR
                 && !id.starts_with(DEBUG_ATTR_PREFIX)
             {
-                eprintln!("Unexpected attribute {} on node of type {}", id, node_type);
+                self.unexpected_attribute(id, &format!("node of type {}", node_type))?;
+            }
+        }
+        Ok(())
+    }
+
+    fn verify_edge_attributes(&self, edge: &GraphEdge) -> Result<(), LoadError> {
+        for (id, _) in edge.attributes.iter() {
+            let id = id.as_str();
+            if id != PRECEDENCE_ATTR && !id.starts_with(DEBUG_ATTR_PREFIX) {
+                self.unexpected_attribute(id, "edge")?;
             }
         }
+        Ok(())
+    }
+
+    /// Reports an unexpected attribute, which fails the load if attributes are strict.
+    fn unexpected_attribute(&self, id: &str, owner: &str) -> Result<(), LoadError> {
+        if self.strict_attributes {
+            return Err(LoadError::UnexpectedAttribute(
+                id.to_string(),
+                owner.to_string(),
+            ));
+        }
+        log::warn!(
+            "Unexpected attribute {} on {} in {}",
+            id,
+            owner,
+            self.stack_graph[self.file],
+        );
+        Ok(())
     }
 }
@@ -17,12 +17,30 @@
 //! at all, an error is raised. Otherwise, a language matching the file path and content is returned, if
 //! it exists among the discovered languages.
 //!
+//! Instead of searching paths, the loader can also be created from a list of language
+//! configurations, which each name a grammar directory, and may override the scope, file types,
+//! and TSG file of the languages found there.  Language configurations can also list interpreters,
+//! so that script files without a known extension are recognized by their shebang line.
+//!
+//! A language configuration can also name a compiled grammar, i.e., a shared library with a `.so`,
+//! `.dylib`, or `.dll` extension, instead of a grammar directory.  The library is loaded at runtime,
+//! so that languages can be added without recompiling.  Because a compiled grammar does not come
+//! with a tree-sitter configuration, its file types must be listed in the language configuration.
+//!
+//! Languages can find code in other languages that is embedded in their source files, using the
+//! `queries/injections.scm` file of their grammar.  The loader finds the languages of such code
+//! by name, using the injection regex of the tree-sitter configuration, or the file types.
+//!
 //! Previously loaded languages are cached in the loader, so subsequent loads are fast.
 
+use anyhow::anyhow;
 use anyhow::Context;
 use itertools::Itertools;
+use libloading::Library;
+use libloading::Symbol;
 use regex::Regex;
 use stack_graphs::graph::StackGraph;
+use std::collections::BTreeMap;
 use std::collections::HashMap;
 use std::ffi::OsStr;
 use std::path::Path;
@@ -35,12 +53,14 @@ use tree_sitter_loader::Config as TsConfig;
 use tree_sitter_loader::LanguageConfiguration;
 use tree_sitter_loader::Loader as TsLoader;
 
+use crate::go;
 use crate::StackGraphLanguage;
 
 pub struct Loader {
     loader: SupplementedTsLoader,
     paths: Vec<PathBuf>,
     scope: Option<String>,
+    configs: HashMap<PathBuf, LanguageConfig>,
     tsg: Box<dyn Fn(Language) -> anyhow::Result<Option<TsgFile>>>,
     cache: Vec<(Language, StackGraphLanguage)>,
 }
@@ -55,6 +75,24 @@ impl Loader {
             loader: SupplementedTsLoader::new()?,
             paths,
             scope,
+            configs: HashMap::new(),
+            tsg: Box::new(tsg),
+            cache: Vec::new(),
+        })
+    }
+
+    pub fn from_language_configs(
+        configs: Vec<LanguageConfig>,
+        tsg: impl Fn(Language) -> anyhow::Result<Option<TsgFile>> + 'static,
+    ) -> Result<Self, LoadError> {
+        Ok(Self {
+            loader: SupplementedTsLoader::new()?,
+            paths: configs.iter().map(|c| c.grammar.clone()).collect(),
+            scope: None,
+            configs: configs
+                .into_iter()
+                .map(|c| (c.grammar.clone(), c))
+                .collect(),
             tsg: Box::new(tsg),
             cache: Vec::new(),
         })
@@ -69,19 +107,25 @@ impl Loader {
             loader: SupplementedTsLoader::new()?,
             paths: Self::config_paths(config)?,
             scope,
+            configs: HashMap::new(),
             tsg: Box::new(tsg),
             cache: Vec::new(),
         })
     }
 
+    /// Returns the grammar directories in the parser directories of the tree-sitter
+    /// configuration, which are searched by loaders created with [`from_config`][].
+    ///
+    /// [`from_config`]: #method.from_config
     // Adopted from tree_sitter_loader::Loader::load
-    fn config_paths(config: &TsConfig) -> anyhow::Result<Vec<PathBuf>> {
+    pub fn config_paths(config: &TsConfig) -> anyhow::Result<Vec<PathBuf>> {
         if config.parser_directories.is_empty() {
-            eprintln!("Warning: You have not configured any parser directories!");
-            eprintln!("Please run `tree-sitter init-config` and edit the resulting");
-            eprintln!("configuration file to indicate where we should look for");
-            eprintln!("language grammars.");
-            eprintln!("");
+            log::warn!(
+                "You have not configured any parser directories! \
+                Please run `tree-sitter init-config` and edit the resulting \
+                configuration file to indicate where we should look for \
+                language grammars."
+            );
         }
         let mut paths = Vec::new();
         for parser_container_dir in &config.parser_directories {
@@ -109,6 +153,105 @@ impl Loader {
             Some(selected_language) => selected_language.clone(),
             None => return Ok(None),
         };
+        self.load_language(language).map(Some)
+    }
+
+    /// Loads the language for code that is injected into a source file, such as JavaScript in an
+    /// HTML file.  The name of the injected language, which comes from an injections query, is
+    /// matched against the injection regex of the tree-sitter configuration of every language, and
+    /// against its file types.
+    pub fn load_for_injection(
+        &mut self,
+        name: &str,
+    ) -> Result<Option<&mut StackGraphLanguage>, LoadError> {
+        match self.select_language_for_injection(name)? {
+            Some(language) => self.load_language(language).map(Some),
+            None => Ok(None),
+        }
+    }
+
+    /// Returns the paths of the files that the stack graph construction rules for the given file
+    /// are loaded from, or `None` if no language matches the file.  These are the TSG, builtins,
+    /// and injections files of its language, and the compiled grammar if the language is loaded
+    /// from one, and the same files of every language that may be injected into the file.  If
+    /// the injections query of the language captures the name of the injected language, the files
+    /// of all languages are included, because any of them may be injected.  TSG files that are
+    /// returned by the function that the loader was created with are not included.
+    pub fn rule_paths_for_file(
+        &mut self,
+        path: &Path,
+        content: Option<&str>,
+    ) -> Result<Option<Vec<PathBuf>>, LoadError> {
+        let language = match self.select_language_for_file(path, content)? {
+            Some(language) => language.clone(),
+            None => return Ok(None),
+        };
+        let mut rule_paths = language.rule_paths();
+        let injected_languages = match self.load_language(language)?.injections.as_ref() {
+            Some(injections) => injections.fixed_languages(),
+            None => Some(Vec::new()),
+        };
+        let injected_languages = match injected_languages {
+            Some(names) => {
+                let mut languages = Vec::new();
+                for name in names {
+                    languages.extend(self.select_language_for_injection(&name)?);
+                }
+                languages
+            }
+            None => self.all_languages()?,
+        };
+        for language in injected_languages {
+            rule_paths.extend(language.rule_paths());
+        }
+        rule_paths.sort();
+        rule_paths.dedup();
+        Ok(Some(rule_paths))
+    }
+
+    // Select the language for injected code with the given name
+    fn select_language_for_injection(
+        &mut self,
+        name: &str,
+    ) -> Result<Option<SupplementedLanguage>, LoadError> {
+        for path in self.paths.clone() {
+            let config = self.configs.get(&path);
+            let scope = config
+                .and_then(|c| c.scope.as_deref())
+                .or(self.scope.as_deref());
+            let languages = match self.loader.languages_at_path(&path, scope, config) {
+                Ok(languages) => languages,
+                Err(err) => return Err(LoadError::Other(err)),
+            };
+            if let Some(language) = languages.into_iter().find(|l| l.matches_injection(name)) {
+                return Ok(Some(language.clone()));
+            }
+        }
+        Ok(None)
+    }
+
+    // Return all languages that the loader can load
+    fn all_languages(&mut self) -> Result<Vec<SupplementedLanguage>, LoadError> {
+        let mut all_languages = Vec::new();
+        for path in self.paths.clone() {
+            let config = self.configs.get(&path);
+            let scope = config
+                .and_then(|c| c.scope.as_deref())
+                .or(self.scope.as_deref());
+            let languages = match self.loader.languages_at_path(&path, scope, config) {
+                Ok(languages) => languages,
+                Err(err) => return Err(LoadError::Other(err)),
+            };
+            all_languages.extend(languages.into_iter().cloned());
+        }
+        Ok(all_languages)
+    }
+
+    // Load the stack graph language for the given language, or return it from the cache
+    fn load_language(
+        &mut self,
+        language: SupplementedLanguage,
+    ) -> Result<&mut StackGraphLanguage, LoadError> {
         // the borrow checker is a hard master...
         let index = self.cache.iter().position(|e| &e.0 == &language.language);
         let index = match index {
@@ -117,14 +260,23 @@ impl Loader {
                 let tsg = self.load_tsg_for_language(&language)?;
                 let mut sgl =
                     StackGraphLanguage::new(language.language, tsg).map_err(LoadError::other)?;
+                // The globals are set first, because the builtins are built with them.
+                for (name, value) in &language.globals {
+                    sgl.set_global(name.clone(), value.clone())
+                        .map_err(LoadError::other)?;
+                }
                 self.load_builtins(&language, &mut sgl)?;
+                self.load_injections_query(&language, &mut sgl)?;
+                if let Some(scope) = &language.scope {
+                    sgl.set_name(scope.clone());
+                }
                 self.cache.push((language.language, sgl));
 
                 self.cache.len() - 1
             }
         };
         let sgl = &mut self.cache[index].1;
-        Ok(Some(sgl))
+        Ok(sgl)
     }
 
     // Select language for the given file, considering paths and scope fields
@@ -170,8 +322,13 @@ impl Loader {
         file_path: &Path,
         file_content: Option<&str>,
     ) -> Result<Option<&SupplementedLanguage>, LoadError> {
-        let scope = self.scope.as_deref();
-        let languages = self.loader.languages_at_path(language_path, scope)?;
+        let config = self.configs.get(language_path);
+        let scope = config
+            .and_then(|c| c.scope.as_deref())
+            .or(self.scope.as_deref());
+        let languages = self
+            .loader
+            .languages_at_path(language_path, scope, config)?;
         if languages.is_empty() {
             return Err(LoadError::NoLanguagesFound(format!(
                 "at {}{}",
@@ -193,7 +350,10 @@ impl Loader {
             return Ok(tsg);
         }
 
-        let tsg_path = language.root_path.join("queries/stack-graphs.tsg");
+        let tsg_path = match &language.tsg_path {
+            Some(tsg_path) => tsg_path.clone(),
+            None => language.root_path.join("queries/stack-graphs.tsg"),
+        };
         if tsg_path.exists() {
             let tsg_source = std::fs::read(tsg_path.clone())
                 .with_context(|| format!("Failed to read {}", tsg_path.display()))?;
@@ -220,6 +380,11 @@ impl Loader {
                     .with_context(|| format!("Failed to read {}", path.display()))?;
                 let source = String::from_utf8(source).map_err(LoadError::other)?;
                 let mut globals = Variables::new();
+                // The path of the builtins file does not determine the package of Go builtins.
+                if ext == "go" {
+                    let _ =
+                        globals.add(go::IMPORT_PATH_VAR.into(), go::BUILTINS_IMPORT_PATH.into());
+                }
                 sgl.build_stack_graph_into(&mut graph, file, &source, &mut globals)
                     .map_err(LoadError::other)?;
             }
@@ -227,6 +392,50 @@ impl Loader {
         sgl.builtins_mut().add_from_graph(&graph).unwrap();
         Ok(())
     }
+
+    fn load_injections_query(
+        &self,
+        language: &SupplementedLanguage,
+        sgl: &mut StackGraphLanguage,
+    ) -> Result<(), LoadError> {
+        let path = language.root_path.join("queries/injections.scm");
+        if path.exists() {
+            let source = std::fs::read_to_string(&path)
+                .with_context(|| format!("Failed to read {}", path.display()))?;
+            sgl.set_injections_query(&source)
+                .with_context(|| format!("Failed to parse {}", path.display()))?;
+        }
+        Ok(())
+    }
+}
+
+/// Configuration of a language that is loaded from a grammar directory or a compiled grammar.
+/// Fields that are not set use the values from the grammar's tree-sitter configuration.
+#[derive(Clone, Debug, Default)]
+pub struct LanguageConfig {
+    /// The directory containing the tree-sitter grammar, or a shared library containing a
+    /// compiled grammar.
+    pub grammar: PathBuf,
+    /// The name of the language in a compiled grammar, which is used to find its
+    /// `tree_sitter_NAME` function.  If not set, the name is derived from the file name of the
+    /// library, e.g., `java` for `libtree-sitter-java.so`.
+    pub name: Option<String>,
+    /// The scope of the language to use, if the grammar defines several languages.
+    pub scope: Option<String>,
+    /// The file extensions of the files that use this language.
+    pub file_types: Option<Vec<String>>,
+    /// The interpreters that select this language when they appear in the shebang line of a file,
+    /// such as `python3` for `#!/usr/bin/env python3`.
+    pub interpreters: Vec<String>,
+    /// The TSG file to use for stack graph construction, instead of the one in the grammar's
+    /// `queries` directory.
+    pub tsg: Option<PathBuf>,
+    /// Global variables for the stack graph construction rules of the language, which are used
+    /// unless the caller sets variables with the same names.  See
+    /// [`StackGraphLanguage::set_global`][].
+    ///
+    /// [`StackGraphLanguage::set_global`]: ../struct.StackGraphLanguage.html#method.set_global
+    pub globals: BTreeMap<String, String>,
 }
 
 #[derive(Debug, Error)]
@@ -270,14 +479,32 @@ impl SupplementedTsLoader {
         &mut self,
         path: &Path,
         scope: Option<&str>,
+        config: Option<&LanguageConfig>,
     ) -> anyhow::Result<Vec<&SupplementedLanguage>> {
         if !self.1.contains_key(path) {
-            let languages = self.0.languages_at_path(&path)?;
-            let configurations = self.0.find_language_configurations_at_path(&path)?;
+            let languages = if is_grammar_library(path) {
+                let config = config.ok_or_else(|| {
+                    anyhow!(
+                        "Compiled grammar {} must be loaded from a language configuration",
+                        path.display()
+                    )
+                })?;
+                vec![SupplementedLanguage::from_library(path, config)?]
+            } else {
+                let languages = self.0.languages_at_path(&path)?;
+                let configurations = self.0.find_language_configurations_at_path(&path)?;
+                languages
+                    .into_iter()
+                    .zip(configurations.into_iter())
+                    .map(SupplementedLanguage::from)
+                    .map(|language| match config {
+                        Some(config) => language.with_config(config),
+                        None => language,
+                    })
+                    .collect::<Vec<_>>()
+            };
             let languages = languages
                 .into_iter()
-                .zip(configurations.into_iter())
-                .map(SupplementedLanguage::from)
                 .filter(|language| scope.map_or(true, |scope| language.matches_scope(scope)))
                 .collect::<Vec<_>>();
             self.1.insert(path.to_path_buf(), languages);
@@ -291,22 +518,112 @@ struct SupplementedLanguage {
     pub language: Language,
     pub scope: Option<String>,
     pub content_regex: Option<Regex>,
+    pub injection_regex: Option<Regex>,
     pub file_types: Vec<String>,
     pub root_path: PathBuf,
+    pub tsg_path: Option<PathBuf>,
+    pub library_path: Option<PathBuf>,
+    pub interpreters: Vec<String>,
+    pub globals: BTreeMap<String, String>,
 }
 
 impl SupplementedLanguage {
+    // Load a compiled grammar from a shared library.  Everything but the grammar itself comes from
+    // the language configuration.
+    pub fn from_library(path: &Path, config: &LanguageConfig) -> anyhow::Result<Self> {
+        let name = match &config.name {
+            Some(name) => name.clone(),
+            None => library_language_name(path)
+                .ok_or_else(|| anyhow!("Cannot derive language name from {}", path.display()))?,
+        };
+        let symbol_name = format!("tree_sitter_{}", name.replace('-', "_"));
+        let library = unsafe { Library::new(path) }
+            .with_context(|| format!("Failed to load {}", path.display()))?;
+        let language = unsafe {
+            let language_fn: Symbol<unsafe extern "C" fn() -> Language> = library
+                .get(symbol_name.as_bytes())
+                .with_context(|| format!("Failed to find {} in {}", symbol_name, path.display()))?;
+            language_fn()
+        };
+        // The language refers to code and data in the library, so it must never be unloaded.
+        std::mem::forget(library);
+        if config.file_types.is_none() && config.interpreters.is_empty() {
+            log::warn!(
+                "Language configuration for {} lists no file types or interpreters",
+                path.display()
+            );
+        }
+        let language = Self {
+            language,
+            scope: config.scope.clone(),
+            content_regex: None,
+            injection_regex: None,
+            file_types: Vec::new(),
+            root_path: path.parent().unwrap_or(Path::new("")).to_path_buf(),
+            tsg_path: None,
+            library_path: Some(path.to_path_buf()),
+            interpreters: Vec::new(),
+            globals: BTreeMap::new(),
+        };
+        Ok(language.with_config(config))
+    }
+
+    // Apply the overrides from a language configuration
+    pub fn with_config(mut self, config: &LanguageConfig) -> Self {
+        if let Some(file_types) = &config.file_types {
+            self.file_types = file_types.clone();
+        }
+        if let Some(tsg) = &config.tsg {
+            self.tsg_path = Some(tsg.clone());
+        }
+        self.interpreters = config.interpreters.clone();
+        self.globals = config.globals.clone();
+        self
+    }
+
+    // Return the existing files that the stack graph construction rules of the language are loaded
+    // from, which must be the same files that the loader uses
+    pub fn rule_paths(&self) -> Vec<PathBuf> {
+        let queries_path = self.root_path.join("queries");
+        let tsg_path = match &self.tsg_path {
+            Some(tsg_path) => tsg_path.clone(),
+            None => queries_path.join("stack-graphs.tsg"),
+        };
+        std::iter::once(tsg_path)
+            .chain(
+                self.file_types
+                    .iter()
+                    .map(|ext| queries_path.join(format!("builtins.{}", ext))),
+            )
+            .chain(std::iter::once(queries_path.join("injections.scm")))
+            .chain(self.library_path.clone())
+            .filter(|path| path.exists())
+            .collect()
+    }
+
     pub fn matches_scope(&self, scope: &str) -> bool {
         self.scope.as_ref().map_or(false, |s| s == scope)
     }
 
+    // Check whether the language has the given injection name, using the injection regex, or the
+    // file types if there is no injection regex
+    pub fn matches_injection(&self, name: &str) -> bool {
+        match &self.injection_regex {
+            Some(injection_regex) => injection_regex.is_match(name),
+            None => self.file_types.iter().any(|ft| ft == name),
+        }
+    }
+
     // Extracted from tree_sitter_loader::Loader::language_configuration_for_file_name
     pub fn matches_file(&self, path: &Path, content: Option<&str>) -> Option<isize> {
-        // Check path extension
+        // Check path extension, or the interpreter in the shebang line
         if !path
             .extension()
             .and_then(OsStr::to_str)
             .map_or(false, |ext| self.file_types.iter().any(|ft| ft == ext))
+            && !content
+                .and_then(shebang_interpreter)
+                .map_or(false, |int| self.interpreters.iter().any(|i| i == int))
         {
             return None;
         }
@@ -351,9 +668,46 @@ impl From<(Language, &LanguageConfiguration<'_>)> for SupplementedLanguage {
         Self {
             scope: config.scope.clone(),
             content_regex: config.content_regex.clone(),
+            injection_regex: config.injection_regex.clone(),
             file_types: config.file_types.clone(),
             root_path: config.root_path.clone(),
+            tsg_path: None,
+            library_path: None,
+            interpreters: Vec::new(),
+            globals: BTreeMap::new(),
             language,
         }
     }
 }
+
+// Returns the name of the interpreter in the shebang line of the given content, if there is one.
+// Interpreters that are started using `env` are recognized as well.
+fn shebang_interpreter(content: &str) -> Option<&str> {
+    let first_line = content.lines().next()?.strip_prefix("#!")?;
+    let mut args = first_line.split_whitespace();
+    let command = args.next()?;
+    let command = command.rsplit('/').next()?;
+    if command == "env" {
+        return args.find(|arg| !arg.starts_with('-') && !arg.contains('='));
+    }
+    Some(command)
+}
+
+// Returns whether the given grammar path is a shared library containing a compiled grammar.
+fn is_grammar_library(path: &Path) -> bool {
+    path.extension()
+        .and_then(OsStr::to_str)
+        .map_or(false, |ext| ["so", "dylib", "dll"].contains(&ext))
+}
+
+// Derives the language name from the file name of a compiled grammar, by removing the `lib` and
+// `tree-sitter-` prefixes, e.g., `java` for `libtree-sitter-java.so`.
+fn library_language_name(path: &Path) -> Option<String> {
+    let stem = path.file_stem()?.to_str()?;
+    let stem = stem.strip_prefix("lib").unwrap_or(stem);
+    let stem = stem.strip_prefix("tree-sitter-").unwrap_or(stem);
+    if stem.is_empty() {
+        return None;
+    }
+    Some(stem.to_string())
+}
@@ -27,6 +27,24 @@
 //! Consecutive lines with assertions all apply to the last source line without an assertion.
 //! In the example, both assertions refer to positions on line 3.
 //!
+//! Besides `defined`, which checks where references resolve to, assertions can check which
+//! symbols are defined or referenced at a position. The assertion `defines` lists the symbols,
+//! separated by commas, that must be defined at the position, while `refs` lists the symbols
+//! that must be referenced there:
+//!
+//! ``` skip
+//! x = 42
+//! # ^ defines: x
+//! print(x)
+//! #     ^ refs: x
+//! ```
+//!
+//! In all cases, the assertion fails if any listed item is missing, or if any item is found that
+//! was not listed.
+//!
+//! Only lines with a caret followed by one of these assertion names are assertion lines.  Other
+//! lines that contain a caret, such as `{k ^ m: 1}` in Python, are regular source lines.
+//!
 //! ## Fragments for multi-file testing
 //!
 //! Test files may also consist of multiple fragments, which are treated as separate files in the
@@ -46,7 +64,20 @@
 //! to a fragment.
 //!
 //! Any content before the first fragment header of the file is ignored, and will not be part of the test.
+//!
+//! ## Testing rules from Rust
+//!
+//! Tests do not have to be files.  Rule authors can write unit tests for individual stanzas by
+//! creating a test from an inline snippet, building its stack graphs, and running it:
+//!
+//! ``` skip
+//! let mut test = Test::from_source(path, "x = 1\nprint(x)\n#     ^ defined: 1\n", path)?;
+//! test.build_stack_graphs(&mut language)?;
+//! assert_eq!(0, test.run().failure_count());
+//! ```
 
+use anyhow::anyhow;
+use anyhow::Context as _;
 use itertools::Itertools;
 use lazy_static::lazy_static;
 use lsp_positions::Position;
@@ -62,17 +93,22 @@ use stack_graphs::graph::File;
 use stack_graphs::graph::Node;
 use stack_graphs::graph::SourceInfo;
 use stack_graphs::graph::StackGraph;
+use stack_graphs::graph::Symbol;
 use stack_graphs::paths::Paths;
 use std::collections::HashMap;
 use std::path::Path;
 use std::path::PathBuf;
 use thiserror::Error;
+use tree_sitter_graph::Variables;
+
+use crate::go;
+use crate::LoadError;
+use crate::StackGraphLanguage;
 
 lazy_static! {
     static ref PATH_REGEX: Regex = Regex::new(r#"---\s*path:\s*([^\s]+)\s*---"#).unwrap();
     static ref ASSERTION_REGEX: Regex =
-        Regex::new(r#"(\^)\s*defined:\s*(\d+(?:\s*,\s*\d+)*)?"#).unwrap();
-    static ref LINE_NUMBER_REGEX: Regex = Regex::new(r#"\d+"#).unwrap();
+        Regex::new(r#"(\^)\s*(defined|defines|refs):\s*([^\s,]+(?:\s*,\s*[^\s,]+)*)?"#).unwrap();
 }
 
 /// An error that can occur while parsing tests
@@ -81,6 +117,7 @@ pub enum TestError {
     AssertionRefersToNonSourceLine,
     DuplicatePath(String),
     InvalidColumn(usize, usize, usize),
+    InvalidLineNumber(usize, String),
 }
 
 impl std::fmt::Display for TestError {
@@ -97,6 +134,12 @@ impl std::fmt::Display for TestError {
                 column + 1,
                 regular_line + 1
             ),
+            Self::InvalidLineNumber(assertion_line, value) => write!(
+                f,
+                "Assertion on line {} has invalid line number {}",
+                assertion_line + 1,
+                value
+            ),
         }
     }
 }
@@ -181,7 +224,7 @@ impl Test {
         }
 
         for fragment in &mut fragments {
-            fragment.parse_assertions(|line| line_files[line])?;
+            fragment.parse_assertions(&mut graph, |line| line_files[line])?;
         }
 
         Ok(Self {
@@ -213,7 +256,7 @@ impl Test {
 
 impl TestFragment {
     /// Parse assertions in the source.
-    fn parse_assertions<F>(&mut self, line_file: F) -> Result<(), TestError>
+    fn parse_assertions<F>(&mut self, graph: &mut StackGraph, line_file: F) -> Result<(), TestError>
     where
         F: Fn(usize) -> Option<Handle<File>>,
     {
@@ -234,7 +277,14 @@ impl TestFragment {
                 let last_regular_line_number = last_regular_line_number.unwrap();
 
                 let carret_match = m.get(1).unwrap();
-                let line_numbers_match = m.get(2);
+                let kind_match = m.get(2).unwrap();
+                let values = m
+                    .get(3)
+                    .map(|m| m.as_str())
+                    .unwrap_or("")
+                    .split(',')
+                    .map(|v| v.trim())
+                    .filter(|v| !v.is_empty());
 
                 let column_utf8_offset = carret_match.start();
                 let column_grapheme_offset = current_line_span_calculator
@@ -262,16 +312,36 @@ impl TestFragment {
                     position,
                 };
 
-                let mut targets = Vec::new();
-                for line in LINE_NUMBER_REGEX
-                    .find_iter(line_numbers_match.map(|m| m.as_str()).unwrap_or(""))
-                {
-                    let line = line.as_str().parse::<usize>().unwrap() - 1;
-                    let file = line_file(line).ok_or(TestError::AssertionRefersToNonSourceLine)?;
-                    targets.push(AssertionTarget { file, line });
-                }
-
-                self.assertions.push(Assertion::Defined { source, targets });
+                let assertion = match kind_match.as_str() {
+                    "defined" => {
+                        let mut targets = Vec::new();
+                        for value in values {
+                            let line = match value.parse::<usize>() {
+                                Ok(line) if line > 0 => line - 1,
+                                _ => {
+                                    return Err(TestError::InvalidLineNumber(
+                                        current_line_number,
+                                        value.to_string(),
+                                    ))
+                                }
+                            };
+                            let file =
+                                line_file(line).ok_or(TestError::AssertionRefersToNonSourceLine)?;
+                            targets.push(AssertionTarget { file, line });
+                        }
+                        Assertion::Defined { source, targets }
+                    }
+                    "defines" => {
+                        let symbols = Self::parse_symbols(graph, values);
+                        Assertion::Defines { source, symbols }
+                    }
+                    "refs" => {
+                        let symbols = Self::parse_symbols(graph, values);
+                        Assertion::Refers { source, symbols }
+                    }
+                    _ => unreachable!(),
+                };
+                self.assertions.push(assertion);
             } else {
                 // regular source line
                 last_regular_line = Some(current_line);
@@ -281,25 +351,32 @@ impl TestFragment {
 
         Ok(())
     }
+
+    fn parse_symbols<'a, I>(graph: &mut StackGraph, values: I) -> Vec<Handle<Symbol>>
+    where
+        I: Iterator<Item = &'a str>,
+    {
+        values.map(|value| graph.add_symbol(value)).collect()
+    }
 }
 
 /// Result of running a stack graph test.
 #[derive(Debug, Clone)]
 pub struct TestResult {
-    success_count: usize,
+    successes: Vec<TestSuccess>,
     failures: Vec<TestFailure>,
 }
 
 impl TestResult {
     fn new() -> Self {
         Self {
+            successes: Vec::new(),
             failures: Vec::new(),
-            success_count: 0,
         }
     }
 
-    fn add_success(&mut self) {
-        self.success_count += 1;
+    fn add_success(&mut self, success: TestSuccess) {
+        self.successes.push(success);
     }
 
     fn add_failure(&mut self, reason: TestFailure) {
@@ -308,7 +385,11 @@ impl TestResult {
 
     /// Number of successfull assertions.
     pub fn success_count(&self) -> usize {
-        self.success_count
+        self.successes.len()
+    }
+
+    pub fn successes_iter(&self) -> std::slice::Iter<'_, TestSuccess> {
+        self.successes.iter()
     }
 
     /// Number of failed assertions.
@@ -330,6 +411,25 @@ impl TestResult {
     }
 }
 
+/// Description of a successful assertion.
+#[derive(Debug, Clone)]
+pub struct TestSuccess {
+    pub path: PathBuf,
+    pub position: Position,
+}
+
+impl std::fmt::Display for TestSuccess {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "{}:{}:{}: assertion passed",
+            self.path.display(),
+            self.position.line + 1,
+            self.position.column.grapheme_offset + 1
+        )
+    }
+}
+
 /// Description of test failures.
 // This mirrors AssertionError, but provides cleaner error messages. The underlying
 // assertions report errors in terms of the virtual files in the test. This type
@@ -349,6 +449,18 @@ pub enum TestFailure {
         missing_lines: Vec<usize>,
         unexpected_lines: HashMap<String, Vec<Option<usize>>>,
     },
+    IncorrectDefinedSymbols {
+        path: PathBuf,
+        position: Position,
+        missing_symbols: Vec<String>,
+        unexpected_symbols: Vec<String>,
+    },
+    IncorrectReferencedSymbols {
+        path: PathBuf,
+        position: Position,
+        missing_symbols: Vec<String>,
+        unexpected_symbols: Vec<String>,
+    },
 }
 
 impl std::fmt::Display for TestFailure {
@@ -410,11 +522,118 @@ impl std::fmt::Display for TestFailure {
                 }
                 Ok(())
             }
+            Self::IncorrectDefinedSymbols {
+                path,
+                position,
+                missing_symbols,
+                unexpected_symbols,
+            } => {
+                write!(
+                    f,
+                    "{}:{}:{}: ",
+                    path.display(),
+                    position.line + 1,
+                    position.column.grapheme_offset + 1
+                )?;
+                write!(f, "definition(s)")?;
+                Self::fmt_symbols(f, missing_symbols, unexpected_symbols)
+            }
+            Self::IncorrectReferencedSymbols {
+                path,
+                position,
+                missing_symbols,
+                unexpected_symbols,
+            } => {
+                write!(
+                    f,
+                    "{}:{}:{}: ",
+                    path.display(),
+                    position.line + 1,
+                    position.column.grapheme_offset + 1
+                )?;
+                write!(f, "reference(s)")?;
+                Self::fmt_symbols(f, missing_symbols, unexpected_symbols)
+            }
+        }
+    }
+}
+
+impl TestFailure {
+    fn fmt_symbols(
+        f: &mut std::fmt::Formatter<'_>,
+        missing_symbols: &[String],
+        unexpected_symbols: &[String],
+    ) -> std::fmt::Result {
+        if !missing_symbols.is_empty() {
+            write!(
+                f,
+                " missing expected symbol(s) {}",
+                missing_symbols
+                    .iter()
+                    .map(|s| format!("‘{}’", s))
+                    .format(", ")
+            )?;
+        }
+        if !unexpected_symbols.is_empty() {
+            write!(
+                f,
+                " found unexpected symbol(s) {}",
+                unexpected_symbols
+                    .iter()
+                    .map(|s| format!("‘{}’", s))
+                    .format(", ")
+            )?;
         }
+        Ok(())
     }
 }
 
 impl Test {
+    /// Builds the stack graphs of all test fragments, using the given language.  The builtins of
+    /// the language are not added to the test graph, and every fragment is built without global
+    /// variables besides the ones that are always provided, and the import path of Go fragments,
+    /// see the [`go`][] module.
+    ///
+    /// [`go`]: ../go/index.html
+    pub fn build_stack_graphs(&mut self, sgl: &mut StackGraphLanguage) -> Result<(), LoadError> {
+        for fragment in &self.fragments {
+            let mut globals = Variables::new();
+            go::add_import_path_global(&mut globals, Path::new(self.graph[fragment.file].name()));
+            sgl.build_stack_graph_into(
+                &mut self.graph,
+                fragment.file,
+                &fragment.source,
+                &mut globals,
+            )?;
+        }
+        Ok(())
+    }
+
+    /// Creates a test from the test file at the given path, adds the builtins of the given
+    /// language to its stack graph, builds the stack graphs of its fragments, and runs it.
+    /// Returns an error listing the failed assertions, if there are any.
+    pub fn run_file(path: &Path, sgl: &mut StackGraphLanguage) -> anyhow::Result<()> {
+        let source = std::fs::read_to_string(path)?;
+        let mut test = Self::from_source(path, &source, path)?;
+        test.graph
+            .add_from_graph(sgl.builtins())
+            .map_err(|_| anyhow!("Duplicate builtins file"))?;
+        test.build_stack_graphs(sgl)?;
+        let result = test.run();
+        let failures = result
+            .failures_iter()
+            .map(|f| f.to_string())
+            .collect::<Vec<_>>();
+        if !failures.is_empty() {
+            return Err(anyhow!(
+                "{} assertions failed:\n  {}",
+                failures.len(),
+                failures.join("\n  ")
+            ));
+        }
+        Ok(())
+    }
+
     /// Run the test. It is the responsibility of the caller to ensure that
     /// the stack graph has been constructed for the test fragments before running
     /// the test.
@@ -426,7 +645,10 @@ impl Test {
                     .run(&self.graph, &mut self.paths)
                     .map_or_else(|e| self.from_error(e), |v| Ok(v))
                 {
-                    Ok(_) => result.add_success(),
+                    Ok(_) => result.add_success(TestSuccess {
+                        path: self.path.clone(),
+                        position: assertion.source().position.clone(),
+                    }),
                     Err(f) => result.add_failure(f),
                 }
             }
@@ -489,9 +711,38 @@ impl Test {
                     unexpected_lines,
                 })
             }
+            AssertionError::IncorrectDefinedSymbols {
+                source,
+                missing_symbols,
+                unexpected_symbols,
+            } => Err(TestFailure::IncorrectDefinedSymbols {
+                path: self.path.clone(),
+                position: source.position,
+                missing_symbols: self.symbol_names(missing_symbols),
+                unexpected_symbols: self.symbol_names(unexpected_symbols),
+            }),
+            AssertionError::IncorrectReferencedSymbols {
+                source,
+                missing_symbols,
+                unexpected_symbols,
+            } => Err(TestFailure::IncorrectReferencedSymbols {
+                path: self.path.clone(),
+                position: source.position,
+                missing_symbols: self.symbol_names(missing_symbols),
+                unexpected_symbols: self.symbol_names(unexpected_symbols),
+            }),
         }
     }
 
+    /// Returns the sorted names of the given symbols.
+    fn symbol_names(&self, symbols: Vec<Handle<Symbol>>) -> Vec<String> {
+        symbols
+            .into_iter()
+            .map(|s| self.graph[s].to_string())
+            .sorted()
+            .collect()
+    }
+
     /// Get source info for a node, using a heuristic to rule default null source info results.
     fn get_source_info(&self, node: Handle<Node>) -> Option<&SourceInfo> {
         self.graph.source_info(node).filter(|si| {
@@ -502,3 +753,59 @@ impl Test {
         })
     }
 }
+
+/// Runs all test files in a directory whose extension is one of the given file types, with
+/// [`Test::run_file`][], and returns an error listing the tests that failed.  The language of each
+/// test file is returned by `language_for_file`.  This is meant for the integration tests of
+/// language crates, which can run all tests in their `test` directory with:
+///
+/// ``` skip
+/// #[test]
+/// fn bundled_rules_pass_all_tests() -> anyhow::Result<()> {
+///     let test_dir = Path::new(env!("CARGO_MANIFEST_DIR")).join("test");
+///     run_test_dir(&test_dir, FILE_TYPES, |_| language())
+/// }
+/// ```
+///
+/// [`Test::run_file`]: struct.Test.html#method.run_file
+pub fn run_test_dir<F, E>(
+    test_dir: &Path,
+    file_types: &[&str],
+    mut language_for_file: F,
+) -> anyhow::Result<()>
+where
+    F: FnMut(&Path) -> Result<StackGraphLanguage, E>,
+    E: Into<anyhow::Error>,
+{
+    let mut test_paths = std::fs::read_dir(test_dir)
+        .with_context(|| format!("Cannot read test directory {}", test_dir.display()))?
+        .map(|e| e.map(|e| e.path()))
+        .collect::<Result<Vec<_>, _>>()
+        .with_context(|| format!("Cannot read test directory {}", test_dir.display()))?;
+    test_paths.retain(|p| {
+        p.extension().map_or(false, |e| {
+            file_types.contains(&e.to_string_lossy().as_ref())
+        })
+    });
+    test_paths.sort();
+    if test_paths.is_empty() {
+        return Err(anyhow!("No tests found in {}", test_dir.display()));
+    }
+    let mut failures = Vec::new();
+    for test_path in test_paths {
+        let result = language_for_file(&test_path)
+            .map_err(Into::into)
+            .and_then(|mut sgl| Test::run_file(&test_path, &mut sgl));
+        if let Err(err) = result {
+            failures.push(format!("{}: {:#}", test_path.display(), err));
+        }
+    }
+    if !failures.is_empty() {
+        return Err(anyhow!(
+            "{} tests failed:\n{}",
+            failures.len(),
+            failures.join("\n")
+        ));
+    }
+    Ok(())
+}
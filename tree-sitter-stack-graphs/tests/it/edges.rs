@@ -8,6 +8,7 @@
 use std::collections::BTreeSet;
 
 use pretty_assertions::assert_eq;
+use stack_graphs::cancellation::NoCancellation;
 use stack_graphs::graph::StackGraph;
 use tree_sitter_graph::Variables;
 use tree_sitter_stack_graphs::LoadError;
@@ -119,3 +120,52 @@ fn can_create_edges_to_singleton_nodes() {
         ],
     );
 }
+
+#[test]
+fn build_reports_node_and_edge_counts() {
+    let tsg = r#"
+      (identifier) @id {
+         node source
+         attr (source) type = "pop_symbol", symbol = (source-text @id), is_definition
+         node sink
+         attr (sink) type = "push_symbol", symbol = (source-text @id), is_reference
+         edge source -> sink
+      }
+    "#;
+    let python = "a + b";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    let stats = language
+        .build_stack_graph_into_with_cancellation(
+            &mut graph,
+            file,
+            python,
+            &mut globals,
+            &NoCancellation,
+        )
+        .expect("Could not load stack graph");
+    assert_eq!(4, stats.node_count);
+    assert_eq!(2, stats.edge_count);
+}
+
+#[test]
+fn build_fails_if_reserved_global_is_set() {
+    let tsg = r#"
+      (identifier) @id {
+         node source
+         attr (source) type = "pop_symbol", symbol = (source-text @id), is_definition
+      }
+    "#;
+    let python = "a";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    globals
+        .add("FILE_PATH".into(), "other.py".into())
+        .expect("Could not set global");
+    let result = language.build_stack_graph_into(&mut graph, file, python, &mut globals);
+    assert!(matches!(result, Err(LoadError::ReservedGlobal(name)) if name == "FILE_PATH"));
+}
@@ -0,0 +1,188 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::graph::StackGraph;
+use tree_sitter::Parser;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::injection::Injection;
+use tree_sitter_stack_graphs::injection::InjectionQuery;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+fn find_injections(python_source: &str, query_source: &str) -> Vec<Injection> {
+    let query = InjectionQuery::from_str(tree_sitter_python::language(), query_source)
+        .expect("Could not parse query");
+    let mut parser = Parser::new();
+    parser.set_language(tree_sitter_python::language()).unwrap();
+    let tree = parser.parse(python_source, None).unwrap();
+    query.find_injections(&tree, python_source)
+}
+
+#[test]
+fn can_find_injections_with_language_property() {
+    let query = r#"
+      ((string) @injection.content
+       (#set! injection.language "sql"))
+    "#;
+    let python = "x = \"select\"\n";
+    assert_eq!(
+        vec![Injection {
+            language: "sql".to_string(),
+            byte_range: 4..12,
+            host_kind: "string".to_string(),
+        }],
+        find_injections(python, query)
+    );
+}
+
+#[test]
+fn can_find_injections_with_language_capture() {
+    let query = r#"
+      (call
+        function: (identifier) @injection.language
+        arguments: (argument_list (string) @injection.content))
+    "#;
+    let python = "sql(\"select\")\n";
+    assert_eq!(
+        vec![Injection {
+            language: "sql".to_string(),
+            byte_range: 4..12,
+            host_kind: "string".to_string(),
+        }],
+        find_injections(python, query)
+    );
+}
+
+#[test]
+fn can_find_injections_with_older_capture_names() {
+    let query = r#"
+      (call
+        function: (identifier) @language
+        arguments: (argument_list (string) @content))
+    "#;
+    let python = "sql(\"select\")\n";
+    assert_eq!(
+        vec![Injection {
+            language: "sql".to_string(),
+            byte_range: 4..12,
+            host_kind: "string".to_string(),
+        }],
+        find_injections(python, query)
+    );
+}
+
+#[test]
+fn skips_injections_without_language() {
+    let query = r#"
+      (string) @injection.content
+    "#;
+    let python = "x = \"select\"\n";
+    assert_eq!(Vec::<Injection>::new(), find_injections(python, query));
+}
+
+#[test]
+fn skips_queries_without_content_capture() {
+    let query = r#"
+      ((string) @string
+       (#set! injection.language "sql"))
+    "#;
+    let python = "x = \"select\"\n";
+    assert_eq!(Vec::<Injection>::new(), find_injections(python, query));
+}
+
+#[test]
+fn can_list_languages_set_by_query() {
+    let query = r#"
+      ((string) @injection.content
+       (#set! injection.language "sql"))
+      ((comment) @injection.content
+       (#set! injection.language "markdown"))
+      ((identifier) @injection.content
+       (#set! injection.language "sql"))
+    "#;
+    let query = InjectionQuery::from_str(tree_sitter_python::language(), query)
+        .expect("Could not parse query");
+    assert_eq!(
+        Some(vec!["markdown".to_string(), "sql".to_string()]),
+        query.fixed_languages()
+    );
+}
+
+#[test]
+fn cannot_list_languages_captured_by_query() {
+    let query = r#"
+      (call
+        function: (identifier) @injection.language
+        arguments: (argument_list (string) @injection.content))
+    "#;
+    let query = InjectionQuery::from_str(tree_sitter_python::language(), query)
+        .expect("Could not parse query");
+    assert_eq!(None, query.fixed_languages());
+}
+
+#[test]
+fn injection_source_keeps_offsets_and_lines() {
+    let injection = Injection {
+        language: "sql".to_string(),
+        byte_range: 5..13,
+        host_kind: "string".to_string(),
+    };
+    let host = "é = \"select\"\r\nx\n";
+    let source = injection.source(host);
+    assert_eq!("     \"select\"\r\n \n", source);
+    assert_eq!(host.len(), source.len());
+}
+
+#[test]
+fn can_build_injections_into_host_file() {
+    let tsg = r#"
+      (identifier) @id {
+         node result
+         attr (result) type = "pop_symbol", symbol = (source-text @id), is_definition
+      }
+    "#;
+    let query = r#"
+      ((expression_statement) @injection.content
+       (#set! injection.language "python"))
+    "#;
+    let python = "a\nb\n";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    language.set_injections_query(query).unwrap();
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    language
+        .build_stack_graph_into(&mut graph, file, python, &mut Variables::new())
+        .expect("Could not build host graph");
+    let injections = language
+        .find_injections(python)
+        .expect("Could not find injections");
+    assert_eq!(2, injections.len());
+    for injection in &injections {
+        language
+            .build_stack_graph_into(
+                &mut graph,
+                file,
+                &injection.source(python),
+                &mut Variables::new(),
+            )
+            .expect("Could not build injected graph");
+    }
+    let actual_nodes = graph
+        .iter_nodes()
+        .skip(2) // skip root and jump-to-scope nodes
+        .map(|handle| graph[handle].display(&graph).to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(
+        vec![
+            "[test.py(0) definition a]",
+            "[test.py(1) definition b]",
+            "[test.py(2) definition a]",
+            "[test.py(3) definition b]",
+        ],
+        actual_nodes
+    );
+}
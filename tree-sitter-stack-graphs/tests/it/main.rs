@@ -6,5 +6,8 @@
 // ------------------------------------------------------------------------------------------------
 
 mod edges;
+mod injections;
 mod nodes;
+mod partial_graphs;
+mod syntax_errors;
 mod test;
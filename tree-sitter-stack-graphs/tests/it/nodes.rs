@@ -56,6 +56,33 @@ fn cannot_create_definition_node_without_symbol() {
     assert!(matches!(result, Err(LoadError::MissingSymbol(_))));
 }
 
+#[test]
+fn can_create_definition_and_reference_nodes_with_shorthands() {
+    let tsg = r#"
+      (identifier) @id {
+         node def
+         attr (def) node_definition = @id
+         node ref
+         attr (ref) node_reference = @id
+      }
+    "#;
+    let python = "  a";
+    let graph = build_stack_graph(python, tsg).expect("Could not load stack graph");
+    let nodes = graph.iter_nodes().skip(2).collect::<Vec<_>>();
+    let actual = nodes
+        .iter()
+        .map(|handle| graph[*handle].display(&graph).to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(
+        vec!["[test.py(0) definition a]", "[test.py(1) reference a]"],
+        actual
+    );
+    for node in nodes {
+        let span = &graph.source_info(node).unwrap().span;
+        assert_eq!(2, span.start.column.utf8_offset);
+    }
+}
+
 #[test]
 fn can_create_drop_node() {
     let tsg = r#"
@@ -280,3 +307,199 @@ fn can_calculate_spans() {
     let trimmed_line = &python[source_info.span.start.trimmed_line.clone()];
     assert_eq!(trimmed_line, "a");
 }
+
+#[test]
+fn can_set_syntax_type_and_definiens() {
+    let tsg = r#"
+      (function_definition name: (identifier) @id body: (_) @body) {
+         node result
+         attr (result) node_definition = @id, syntax_type = "function", definiens_node = @body
+      }
+    "#;
+    let python = "def f(x):\n  pass\n";
+    let graph = build_stack_graph(python, tsg).unwrap();
+    let node_handle = graph.iter_nodes().nth(2).unwrap();
+    let source_info = graph.source_info(node_handle).unwrap();
+
+    let syntax_type = source_info.syntax_type.map(|s| graph[s].to_string());
+    assert_eq!(Some("function".to_string()), syntax_type);
+
+    let span = format!(
+        "{}:{}-{}:{}",
+        source_info.definiens_span.start.line,
+        source_info.definiens_span.start.column.utf8_offset,
+        source_info.definiens_span.end.line,
+        source_info.definiens_span.end.column.utf8_offset,
+    );
+    assert_eq!("1:2-1:6", span);
+}
+
+#[test]
+fn can_attach_doc_comments_to_definitions() {
+    let tsg = r#"
+      (function_definition name: (identifier) @id) @func {
+         node result
+         attr (result) type = "pop_symbol", symbol = (source-text @id), source_node = @func, is_definition
+         attr (result) documentation = (doc-comment @func)
+      }
+    "#;
+    let python = "# Unrelated.\n\n# Adds one.\n#  Returns a number.\ndef f(x):\n  pass\n";
+    let graph = build_stack_graph(python, tsg).expect("Could not load stack graph");
+    let def = graph.iter_nodes().skip(2).next().unwrap();
+    let documentation = graph.source_info(def).unwrap().documentation.unwrap();
+    assert_eq!("Adds one.\n Returns a number.", &graph[documentation]);
+}
+
+#[test]
+fn doc_comments_must_start_with_prefix() {
+    let tsg = r###"
+      (function_definition name: (identifier) @id) @func {
+         node result
+         attr (result) type = "pop_symbol", symbol = (source-text @id), source_node = @func, is_definition
+         attr (result) documentation = (doc-comment @func "##")
+      }
+    "###;
+    let python = "# Adds one.\ndef f(x):\n  pass\n";
+    let graph = build_stack_graph(python, tsg).expect("Could not load stack graph");
+    let def = graph.iter_nodes().skip(2).next().unwrap();
+    assert_eq!(None, graph.source_info(def).unwrap().documentation);
+}
+
+#[test]
+fn unexpected_attributes_fail_if_strict() {
+    let tsg = r#"
+      (identifier) {
+         node result
+         attr (result) type = "scope", is_exportd
+      }
+    "#;
+    let python = "a";
+    build_stack_graph(python, tsg).expect("Unexpected attributes should only be logged");
+
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    language.set_strict_attributes(true);
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    match language.build_stack_graph_into(&mut graph, file, python, &mut globals) {
+        Err(LoadError::UnexpectedAttribute(attribute, _)) => assert_eq!("is_exportd", attribute),
+        result => panic!(
+            "Expected unexpected attribute error, got {:?}",
+            result.err()
+        ),
+    }
+}
+
+#[test]
+fn can_describe_syntax_nodes() {
+    let tsg = r#"
+      (function_definition name: (identifier) @id) @func {
+         node result
+         attr (result) type = "pop_symbol", symbol = (node-description @id), is_definition
+         node body
+         attr (body) type = "pop_symbol", symbol = (node-description @func), is_definition
+      }
+    "#;
+    let python = "\ndef a_function_with_a_very_long_name_that_is_truncated(x):\n  pass\n";
+    let graph = build_stack_graph(python, tsg).expect("Could not load stack graph");
+    let symbols = graph
+        .iter_nodes()
+        .skip(2)
+        .map(|handle| graph[graph[handle].symbol().unwrap()].to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(
+        vec![
+            "identifier `a_function_with_a_very_long_name_that_is…` at 2:5",
+            "function_definition `def a_function_with_a_very_long_name_tha…` at 2:1",
+        ],
+        symbols
+    );
+}
+
+#[test]
+fn can_refer_to_syntax_root_node_and_language() {
+    let tsg = r#"
+      global SYNTAX_ROOT_NODE
+      global LANGUAGE
+
+      (identifier) @id {
+         node result
+         attr (result) type = "pop_symbol", symbol = (node-type SYNTAX_ROOT_NODE), is_definition
+         node language
+         attr (language) type = "pop_symbol", symbol = LANGUAGE, is_definition
+      }
+    "#;
+    let python = "a";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    language.set_name("source.python".to_string());
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    language
+        .build_stack_graph_into(&mut graph, file, python, &mut globals)
+        .expect("Could not load stack graph");
+    let symbols = graph
+        .iter_nodes()
+        .skip(2)
+        .map(|handle| graph[graph[handle].symbol().unwrap()].to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(vec!["module", "source.python"], symbols);
+}
+
+#[test]
+fn can_set_globals_of_language() {
+    let tsg = r#"
+      global VARIANT
+      global DIALECT
+
+      (identifier) @id {
+         node variant
+         attr (variant) type = "pop_symbol", symbol = VARIANT, is_definition
+         node dialect
+         attr (dialect) type = "pop_symbol", symbol = DIALECT, is_definition
+      }
+    "#;
+    let python = "a";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), tsg).unwrap();
+    language
+        .set_global("VARIANT".to_string(), "strict".to_string())
+        .expect("Could not set global");
+    language
+        .set_global("DIALECT".to_string(), "default".to_string())
+        .expect("Could not set global");
+    assert!(matches!(
+        language.set_global("FILE_PATH".to_string(), "other.py".to_string()),
+        Err(LoadError::ReservedGlobal(name)) if name == "FILE_PATH"
+    ));
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    globals
+        .add("DIALECT".into(), "custom".into())
+        .expect("Could not set global");
+    language
+        .build_stack_graph_into(&mut graph, file, python, &mut globals)
+        .expect("Could not load stack graph");
+    let symbols = graph
+        .iter_nodes()
+        .skip(2)
+        .map(|handle| graph[graph[handle].symbol().unwrap()].to_string())
+        .collect::<Vec<_>>();
+    assert_eq!(vec!["strict", "custom"], symbols);
+}
+
+#[test]
+fn failed_checks_report_syntax_node() {
+    let tsg = r#"
+      (import_from_statement module_name: (_) @name) {
+         node ref
+         attr (ref) node_reference = (check (eq (node-type @name) "dotted_name") @name "expected module name")
+      }
+    "#;
+    check_stack_graph_node("from a import b", tsg, &["[test.py(0) reference a]"]);
+    let err = match build_stack_graph("from .a import b", tsg) {
+        Err(LoadError::ExecutionError(err)) => err,
+        result => panic!("Expected execution error, got {:?}", result.map(|_| ())),
+    };
+    assert!(format!("{:?}", err).contains("expected module name at relative_import 1:6"));
+}
@@ -0,0 +1,67 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::cancellation::NoCancellation;
+use stack_graphs::graph::StackGraph;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::BuildStats;
+use tree_sitter_stack_graphs::LoadError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+
+/// Creates a node for every identifier, and then fails, because the edge refers to an undefined
+/// scoped variable.
+const TSG: &str = r#"
+  (identifier) @id {
+     node @id.node
+     attr (@id.node) type = "push_symbol", symbol = (source-text @id)
+  }
+  (module) @mod {
+     edge @mod.undefined -> @mod.undefined
+  }
+"#;
+
+fn build_stack_graph(
+    python_source: &str,
+    keep_partial_graphs: bool,
+) -> (StackGraph, Result<BuildStats, LoadError>) {
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), TSG).unwrap();
+    language.set_keep_partial_graphs(keep_partial_graphs);
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    let result = language.build_stack_graph_into_with_cancellation(
+        &mut graph,
+        file,
+        python_source,
+        &mut globals,
+        &NoCancellation,
+    );
+    (graph, result)
+}
+
+fn node_count(graph: &StackGraph) -> usize {
+    graph.iter_nodes().skip(2).count() // skip root and jump-to-scope nodes
+}
+
+#[test]
+fn discards_graph_if_rules_fail() {
+    let (graph, result) = build_stack_graph("a", false);
+    assert!(matches!(result, Err(LoadError::ExecutionError(_))));
+    assert_eq!(0, node_count(&graph));
+}
+
+#[test]
+fn can_keep_partial_graph_if_rules_fail() {
+    let (graph, result) = build_stack_graph("a", true);
+    let stats = match result {
+        Err(LoadError::PartialGraph(_, stats)) => stats,
+        _ => panic!("Expected partial graph"),
+    };
+    assert_eq!(1, stats.node_count);
+    assert_eq!(1, node_count(&graph));
+}
@@ -0,0 +1,93 @@
+// -*- coding: utf-8 -*-
+// ------------------------------------------------------------------------------------------------
+// Copyright © 2022, stack-graphs authors.
+// Licensed under either of Apache License, Version 2.0, or MIT license, at your option.
+// Please see the LICENSE-APACHE or LICENSE-MIT files in this distribution for license details.
+// ------------------------------------------------------------------------------------------------
+
+use pretty_assertions::assert_eq;
+use stack_graphs::graph::StackGraph;
+use tree_sitter_graph::Variables;
+use tree_sitter_stack_graphs::LoadError;
+use tree_sitter_stack_graphs::StackGraphLanguage;
+use tree_sitter_stack_graphs::SyntaxErrorPolicy;
+
+/// Creates a node for every identifier, and for every syntax error.  The `$` is not valid Python,
+/// and ends up in an `ERROR` node.
+const TSG: &str = r#"
+  (identifier) @id {
+     node result
+     attr (result) type = "push_symbol", symbol = (source-text @id), source_node = @id
+  }
+  (ERROR) @error {
+     node result
+     attr (result) type = "push_symbol", symbol = "error", source_node = @error
+  }
+"#;
+const PYTHON: &str = "a\n$\n";
+
+fn build_stack_graph(
+    python_source: &str,
+    tsg_source: &str,
+    policy: SyntaxErrorPolicy,
+) -> Result<StackGraph, LoadError> {
+    let mut language =
+        StackGraphLanguage::from_str(tree_sitter_python::language(), tsg_source).unwrap();
+    language.set_syntax_error_policy(policy);
+    let mut graph = StackGraph::new();
+    let file = graph.get_or_create_file("test.py");
+    let mut globals = Variables::new();
+    language.build_stack_graph_into(&mut graph, file, python_source, &mut globals)?;
+    Ok(graph)
+}
+
+fn symbols(graph: &StackGraph) -> Vec<String> {
+    graph
+        .iter_nodes()
+        .filter_map(|handle| graph[handle].symbol())
+        .map(|symbol| graph[symbol].to_string())
+        .collect()
+}
+
+#[test]
+fn fails_on_syntax_errors_by_default() {
+    let result = build_stack_graph(PYTHON, TSG, SyntaxErrorPolicy::default());
+    assert!(matches!(result, Err(LoadError::ParseErrors(_))));
+}
+
+#[test]
+fn can_omit_nodes_in_syntax_errors() {
+    let graph = build_stack_graph(PYTHON, TSG, SyntaxErrorPolicy::OmitErrors)
+        .expect("Could not load stack graph");
+    assert_eq!(vec!["a"], symbols(&graph));
+}
+
+#[test]
+fn can_keep_nodes_in_syntax_errors() {
+    let graph = build_stack_graph(PYTHON, TSG, SyntaxErrorPolicy::BestEffort)
+        .expect("Could not load stack graph");
+    assert!(symbols(&graph).contains(&"error".to_string()));
+}
+
+#[test]
+fn can_check_for_syntax_errors_in_rules() {
+    let tsg = r#"
+      (module) @mod {
+         node result
+         attr (result) is_exported = (node-has-error @mod)
+      }
+    "#;
+    for (python, expected) in [
+        ("a\n", "[test.py(0) scope]"),
+        (PYTHON, "[test.py(0) exported scope]"),
+    ] {
+        let graph = build_stack_graph(python, tsg, SyntaxErrorPolicy::BestEffort)
+            .expect("Could not load stack graph");
+        let actual_nodes = graph
+            .iter_nodes()
+            .skip(2) // skip root and jump-to-scope nodes
+            .map(|handle| graph[handle].display(&graph).to_string())
+            .collect::<Vec<_>>();
+        assert_eq!(vec![expected], actual_nodes);
+    }
+}
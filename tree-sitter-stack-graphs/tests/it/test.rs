@@ -172,6 +172,18 @@ fn test_fragment_can_have_same_name_as_test() {
     check_test(&PathBuf::from("test.py"), python, &TSG, 1, 0);
 }
 
+#[test]
+fn can_build_stack_graphs_of_inline_test() {
+    let python = "x = 1\ny = x\n#   ^ defined: 1\n";
+    let mut language = StackGraphLanguage::from_str(tree_sitter_python::language(), &TSG).unwrap();
+    let mut test = Test::from_source(&PATH, python, &PATH).expect("Could not parse test");
+    test.build_stack_graphs(&mut language)
+        .expect("Could not load stack graph");
+    let results = test.run();
+    assert_eq!(1, results.success_count());
+    assert_eq!(0, results.failure_count());
+}
+
 #[test]
 fn test_cannot_assert_on_first_line() {
     let python = r#"
@@ -194,3 +206,50 @@ fn test_cannot_assert_before_first_fragment() {
         panic!("Parsing test unexpectedly succeeded.");
     }
 }
+
+#[test]
+fn test_can_assert_defined_symbols() {
+    let python = r#"
+      x = 1;
+    # ^ defines: x
+      y = x;
+    # ^ defines: y
+    # ^ defines: x
+    "#;
+    check_test(&PATH, python, &TSG, 2, 1);
+}
+
+#[test]
+fn test_can_assert_referenced_symbols() {
+    let python = r#"
+      x = 1;
+      y = x;
+      #   ^ refs: x
+      #   ^ refs: x, y
+      #   ^ refs: y
+    "#;
+    check_test(&PATH, python, &TSG, 1, 2);
+}
+
+#[test]
+fn test_treats_other_carets_as_source() {
+    let python = r#"
+      x = 1;
+      {k ^ m: 1};
+    # ^ declared: x
+    "#;
+    let test = Test::from_source(&PATH, python, &PATH).expect("Could not parse test");
+    assert_eq!(0, test.fragments[0].assertions.len());
+}
+
+#[test]
+fn test_cannot_assert_invalid_line_number() {
+    let python = r#"
+      x = 1;
+        x;
+      # ^ defined: one
+    "#;
+    if let Ok(_) = Test::from_source(&PATH, python, &PATH) {
+        panic!("Parsing test unexpectedly succeeded.");
+    }
+}